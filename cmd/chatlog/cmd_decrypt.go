@@ -1,9 +1,13 @@
 package chatlog
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"os/signal"
 
 	"github.com/sjzar/chatlog/internal/chatlog"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt"
 
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
@@ -16,6 +20,7 @@ func init() {
 	decryptCmd.Flags().StringVarP(&decryptDataDir, "data-dir", "d", "", "data dir")
 	decryptCmd.Flags().StringVarP(&decryptDatakey, "data-key", "k", "", "data key")
 	decryptCmd.Flags().StringVarP(&decryptWorkDir, "work-dir", "w", "", "work dir")
+	decryptCmd.Flags().BoolVarP(&decryptEncryptWorkDir, "encrypt-work-dir", "", false, "encrypt work dir with a passphrase")
 }
 
 var (
@@ -24,6 +29,8 @@ var (
 	decryptDataDir  string
 	decryptDatakey  string
 	decryptWorkDir  string
+
+	decryptEncryptWorkDir bool
 )
 
 var decryptCmd = &cobra.Command{
@@ -33,8 +40,14 @@ var decryptCmd = &cobra.Command{
 
 		cmdConf := getDecryptConfig()
 
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer cancel()
+
+		enablePassphrasePrompt()
 		m := chatlog.New()
-		if err := m.CommandDecrypt("", cmdConf); err != nil {
+		err := m.CommandDecrypt(ctx, "", cmdConf, printDecryptProgress)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
 			log.Err(err).Msg("failed to decrypt")
 			return
 		}
@@ -59,5 +72,18 @@ func getDecryptConfig() map[string]any {
 	if decryptVer != 0 {
 		cmdConf["version"] = decryptVer
 	}
+	if decryptEncryptWorkDir {
+		cmdConf["encrypt_work_dir"] = true
+	}
 	return cmdConf
 }
+
+// printDecryptProgress 在标准错误输出的同一行刷新解密进度
+func printDecryptProgress(p decrypt.BatchProgress) {
+	percent := 100.0
+	if p.TotalPages > 0 {
+		percent = float64(p.Pages) * 100 / float64(p.TotalPages)
+	}
+	fmt.Fprintf(os.Stderr, "\rdecrypting %d/%d files, %d/%d pages (%.1f%%), %.1f MB/s   ",
+		p.Files, p.TotalFiles, p.Pages, p.TotalPages, percent, p.Throughput/1024/1024)
+}
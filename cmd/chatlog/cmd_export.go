@@ -0,0 +1,123 @@
+package chatlog
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/sjzar/chatlog/internal/chatlog"
+	"github.com/sjzar/chatlog/internal/chatlog/export"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.Flags().StringSliceVarP(&exportTalkers, "talker", "t", nil, "talker id or name, comma separated; empty for all sessions")
+	exportCmd.Flags().StringVarP(&exportTime, "time", "T", "all", "time range, e.g. 2024-01-01~2024-01-31, last-7d, all")
+	exportCmd.Flags().StringVarP(&exportFormat, "format", "f", export.FormatTXT, "output format: "+strings.Join(export.Formats, ", "))
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "o", "export", "output dir")
+	exportCmd.Flags().BoolVarP(&exportMedia, "media", "m", false, "copy image/video/voice/file beside the exported files (default for html)")
+	exportCmd.Flags().StringVarP(&exportHost, "host", "", "", "http server address used in media links when media are not copied, e.g. https://example.com:5030 (http when no scheme is given)")
+	exportCmd.Flags().StringVarP(&exportPlatform, "platform", "p", "", "platform")
+	exportCmd.Flags().IntVarP(&exportVer, "version", "v", 0, "version")
+	exportCmd.Flags().StringVarP(&exportDataDir, "data-dir", "d", "", "data dir")
+	exportCmd.Flags().StringVarP(&exportImgKey, "img-key", "i", "", "img key")
+	exportCmd.Flags().StringVarP(&exportWorkDir, "work-dir", "w", "", "work dir")
+}
+
+var (
+	exportTalkers  []string
+	exportTime     string
+	exportFormat   string
+	exportOutput   string
+	exportMedia    bool
+	exportHost     string
+	exportPlatform string
+	exportVer      int
+	exportDataDir  string
+	exportImgKey   string
+	exportWorkDir  string
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export chat history to files",
+	Example: `  chatlog export -t wxid_xxx -T 2024-01-01~2024-03-31 -f markdown -o ./out
+  chatlog export -t 123456@chatroom -f html -m`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		start, end, ok := util.TimeRangeOf(exportTime)
+		if !ok {
+			log.Error().Msgf("invalid time range: %s", exportTime)
+			return
+		}
+		if export.NormalizeFormat(exportFormat) == "" {
+			log.Error().Msgf("unsupported format: %s, available: %s", exportFormat, strings.Join(export.Formats, ", "))
+			return
+		}
+
+		opts := export.Options{
+			Talkers:   exportTalkers,
+			Start:     start,
+			End:       end,
+			Format:    exportFormat,
+			OutputDir: exportOutput,
+			Media:     exportMedia,
+			Host:      exportHost,
+		}
+		// HTML 默认生成包含媒体文件的离线目录
+		if !cmd.Flags().Changed("media") && export.NormalizeFormat(exportFormat) == export.FormatHTML {
+			opts.Media = true
+		}
+
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer cancel()
+
+		enablePassphrasePrompt()
+		m := chatlog.New()
+		results, err := m.CommandExport(ctx, "", getExportConfig(), opts)
+		for _, r := range results {
+			line := fmt.Sprintf("%s\t%d messages", r.Path, r.Count)
+			if opts.Media {
+				line += fmt.Sprintf(", %d media", r.Media)
+				if r.MediaMissing > 0 {
+					line += fmt.Sprintf(" (%d missing)", r.MediaMissing)
+				}
+			}
+			fmt.Println(line)
+		}
+		if err != nil {
+			log.Err(err).Msg("failed to export")
+			return
+		}
+		fmt.Printf("export success, %d conversations\n", len(results))
+	},
+}
+
+func getExportConfig() map[string]any {
+	cmdConf := make(map[string]any)
+	if len(exportDataDir) != 0 {
+		cmdConf["data_dir"] = exportDataDir
+	}
+	if len(exportImgKey) != 0 {
+		cmdConf["img_key"] = exportImgKey
+	}
+	if len(exportWorkDir) != 0 {
+		cmdConf["work_dir"] = exportWorkDir
+	}
+	if len(exportPlatform) != 0 {
+		cmdConf["platform"] = exportPlatform
+	}
+	if exportVer != 0 {
+		cmdConf["version"] = exportVer
+	}
+	if Debug {
+		cmdConf["debug"] = true
+	}
+	return cmdConf
+}
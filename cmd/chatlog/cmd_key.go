@@ -1,9 +1,13 @@
 package chatlog
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"os/signal"
 
 	"github.com/sjzar/chatlog/internal/chatlog"
+	"github.com/sjzar/chatlog/internal/wechat/key"
 
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
@@ -14,17 +18,32 @@ func init() {
 	keyCmd.Flags().IntVarP(&keyPID, "pid", "p", 0, "pid")
 	keyCmd.Flags().BoolVarP(&keyForce, "force", "f", false, "force")
 	keyCmd.Flags().BoolVarP(&keyShowXorKey, "xor-key", "x", false, "show xor key")
+	keyCmd.Flags().StringVarP(&keyFromDump, "from-dump", "", "", "search keys in a memory dump file instead of a running process")
+	keyCmd.Flags().StringVarP(&keyDB, "db", "", "", "encrypted db to validate the data key, used with --from-dump")
+	keyCmd.Flags().StringVarP(&keyDatDir, "dat-dir", "", "", "dir with encrypted .dat images to validate the image key, used with --from-dump")
+	keyCmd.Flags().StringVarP(&keyPlatform, "platform", "", "", "platform of the dump, only darwin is supported")
 }
 
 var (
 	keyPID        int
 	keyForce      bool
 	keyShowXorKey bool
+	keyFromDump   string
+	keyDB         string
+	keyDatDir     string
+	keyPlatform   string
 )
 var keyCmd = &cobra.Command{
 	Use:   "key",
 	Short: "key",
+	Example: `  chatlog key
+  chatlog key --from-dump wechat.bin --db message_0.db --platform darwin --dat-dir ./msg/attach`,
 	Run: func(cmd *cobra.Command, args []string) {
+		if len(keyFromDump) != 0 {
+			runKeyFromDump()
+			return
+		}
+
 		m := chatlog.New()
 		ret, err := m.CommandKey("", keyPID, keyForce, keyShowXorKey)
 		if err != nil {
@@ -34,3 +53,35 @@ var keyCmd = &cobra.Command{
 		fmt.Println(ret)
 	},
 }
+
+func runKeyFromDump() {
+	if len(keyPlatform) == 0 {
+		log.Error().Msg("--platform is required with --from-dump")
+		return
+	}
+	if keyPlatform != "darwin" {
+		log.Error().Msgf("--from-dump only supports darwin dumps, got %s", keyPlatform)
+		return
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	m := chatlog.New()
+	ret, err := m.CommandKeyFromDump(ctx, keyPlatform, keyFromDump, keyDB, keyDatDir, keyShowXorKey, printDumpProgress)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		log.Err(err).Msg("failed to get key from dump")
+		return
+	}
+	fmt.Println(ret)
+}
+
+// printDumpProgress 在标准错误输出的同一行刷新搜索进度
+func printDumpProgress(p key.DumpProgress) {
+	percent := 100.0
+	if p.Total > 0 {
+		percent = float64(p.Scanned) * 100 / float64(p.Total)
+	}
+	fmt.Fprintf(os.Stderr, "\rsearching %d/%d MB (%.1f%%)   ", p.Scanned>>20, p.Total>>20, percent)
+}
@@ -0,0 +1,108 @@
+package chatlog
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/sjzar/chatlog/internal/chatlog"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+func init() {
+	rootCmd.AddCommand(mcpCmd)
+	mcpCmd.PersistentPreRun = initMCPLog
+	mcpCmd.Flags().StringVarP(&mcpPlatform, "platform", "p", "", "platform")
+	mcpCmd.Flags().IntVarP(&mcpVer, "version", "v", 0, "version")
+	mcpCmd.Flags().StringVarP(&mcpDataDir, "data-dir", "d", "", "data dir")
+	mcpCmd.Flags().StringVarP(&mcpDataKey, "data-key", "k", "", "data key")
+	mcpCmd.Flags().StringVarP(&mcpImgKey, "img-key", "i", "", "img key")
+	mcpCmd.Flags().StringVarP(&mcpWorkDir, "work-dir", "w", "", "work dir")
+	mcpCmd.Flags().BoolVarP(&mcpAutoDecrypt, "auto-decrypt", "", false, "auto decrypt, requires data dir and data key")
+	mcpCmd.Flags().StringVarP(&mcpLogFile, "log-file", "", "", "write logs to this file only instead of stderr")
+}
+
+var (
+	mcpPlatform    string
+	mcpVer         int
+	mcpDataDir     string
+	mcpDataKey     string
+	mcpImgKey      string
+	mcpWorkDir     string
+	mcpAutoDecrypt bool
+	mcpLogFile     string
+)
+
+var mcpCmd = &cobra.Command{
+	Use:   "mcp",
+	Short: "Serve MCP over stdio",
+	Long: `Serve the MCP tools, resources and prompts over stdin/stdout, for MCP hosts that launch a subprocess.
+stdout carries only the MCP protocol; logs are written to stderr or --log-file.`,
+	Example: `  chatlog mcp -w /path/to/work-dir
+  chatlog mcp -d /path/to/data-dir -k <data-key> -w /path/to/work-dir --auto-decrypt --log-file chatlog-mcp.log`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer cancel()
+
+		m := chatlog.New()
+		if err := m.CommandMCP(ctx, "", getMCPConfig()); err != nil && ctx.Err() == nil {
+			log.Err(err).Msg("failed to serve mcp")
+			os.Exit(1)
+		}
+	},
+}
+
+// initMCPLog 日志不能写入 stdout，指定 --log-file 时只写入该文件
+func initMCPLog(cmd *cobra.Command, args []string) {
+	if len(mcpLogFile) == 0 {
+		initLog(cmd, args)
+		return
+	}
+
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	if Debug {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	}
+
+	var w io.Writer = os.Stderr
+	if err := util.PrepareDir(filepath.Dir(mcpLogFile)); err == nil {
+		if f, err := os.OpenFile(mcpLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644); err == nil {
+			w = f
+		}
+	}
+	log.Logger = log.Output(util.NewPlainLogWriter(w, w != os.Stderr))
+}
+
+func getMCPConfig() map[string]any {
+	cmdConf := make(map[string]any)
+	if len(mcpDataDir) != 0 {
+		cmdConf["data_dir"] = mcpDataDir
+	}
+	if len(mcpDataKey) != 0 {
+		cmdConf["data_key"] = mcpDataKey
+	}
+	if len(mcpImgKey) != 0 {
+		cmdConf["img_key"] = mcpImgKey
+	}
+	if len(mcpWorkDir) != 0 {
+		cmdConf["work_dir"] = mcpWorkDir
+	}
+	if len(mcpPlatform) != 0 {
+		cmdConf["platform"] = mcpPlatform
+	}
+	if mcpVer != 0 {
+		cmdConf["version"] = mcpVer
+	}
+	cmdConf["auto_decrypt"] = mcpAutoDecrypt
+	if Debug {
+		cmdConf["debug"] = true
+	}
+	return cmdConf
+}
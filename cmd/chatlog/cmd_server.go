@@ -11,7 +11,7 @@ func init() {
 	rootCmd.AddCommand(serverCmd)
 	serverCmd.PersistentPreRun = initLog
 	serverCmd.PersistentFlags().BoolVar(&Debug, "debug", false, "debug")
-	serverCmd.Flags().StringVarP(&serverAddr, "addr", "a", "", "server address")
+	serverCmd.Flags().StringVarP(&serverAddr, "addr", "a", "", "server address, or unix:/path/to/chatlog.sock")
 	serverCmd.Flags().StringVarP(&serverPlatform, "platform", "p", "", "platform")
 	serverCmd.Flags().IntVarP(&serverVer, "version", "v", 0, "version")
 	serverCmd.Flags().StringVarP(&serverDataDir, "data-dir", "d", "", "data dir")
@@ -19,6 +19,10 @@ func init() {
 	serverCmd.Flags().StringVarP(&serverImgKey, "img-key", "i", "", "img key")
 	serverCmd.Flags().StringVarP(&serverWorkDir, "work-dir", "w", "", "work dir")
 	serverCmd.Flags().BoolVarP(&serverAutoDecrypt, "auto-decrypt", "", true, "auto decrypt")
+	serverCmd.Flags().BoolVarP(&serverTLS, "tls", "", false, "serve https, with a self-signed cert in work dir if no cert given")
+	serverCmd.Flags().StringVarP(&serverTLSCert, "tls-cert", "", "", "tls cert file")
+	serverCmd.Flags().StringVarP(&serverTLSKey, "tls-key", "", "", "tls key file")
+	serverCmd.Flags().BoolVarP(&serverEncryptWorkDir, "encrypt-work-dir", "", false, "encrypt work dir with a passphrase")
 }
 
 var (
@@ -30,6 +34,11 @@ var (
 	serverPlatform    string
 	serverVer         int
 	serverAutoDecrypt bool
+	serverTLS         bool
+	serverTLSCert     string
+	serverTLSKey      string
+
+	serverEncryptWorkDir bool
 )
 
 var serverCmd = &cobra.Command{
@@ -40,6 +49,7 @@ var serverCmd = &cobra.Command{
 		cmdConf := getServerConfig()
 		log.Info().Msgf("server cmd config: %+v", cmdConf)
 
+		enablePassphrasePrompt()
 		m := chatlog.New()
 		if err := m.CommandHTTPServer("", cmdConf); err != nil {
 			log.Err(err).Msg("failed to start server")
@@ -72,6 +82,16 @@ func getServerConfig() map[string]any {
 		cmdConf["version"] = serverVer
 	}
 	cmdConf["auto_decrypt"] = serverAutoDecrypt
+	if serverTLS {
+		cmdConf["tls.enabled"] = true
+	}
+	if len(serverTLSCert) != 0 {
+		cmdConf["tls.cert_file"] = serverTLSCert
+		cmdConf["tls.key_file"] = serverTLSKey
+	}
+	if serverEncryptWorkDir {
+		cmdConf["encrypt_work_dir"] = true
+	}
 	if Debug {
 		cmdConf["debug"] = true
 	}
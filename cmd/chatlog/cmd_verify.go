@@ -0,0 +1,75 @@
+package chatlog
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/signal"
+
+	"github.com/sjzar/chatlog/internal/chatlog"
+	"github.com/sjzar/chatlog/internal/chatlog/verify"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().StringVarP(&verifyPlatform, "platform", "p", "", "platform")
+	verifyCmd.Flags().StringVarP(&verifyDataDir, "data-dir", "d", "", "data dir, check encrypted databases and stale copies when set with --data-key")
+	verifyCmd.Flags().StringVarP(&verifyDatakey, "data-key", "k", "", "data key")
+	verifyCmd.Flags().StringVarP(&verifyWorkDir, "work-dir", "w", "", "work dir")
+	verifyCmd.Flags().BoolVar(&verifyQuick, "quick", false, "use PRAGMA quick_check instead of integrity_check")
+}
+
+var (
+	verifyPlatform string
+	verifyDataDir  string
+	verifyDatakey  string
+	verifyWorkDir  string
+	verifyQuick    bool
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "verify decrypted databases and print a JSON report",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer cancel()
+
+		enablePassphrasePrompt()
+		m := chatlog.New()
+		report, err := m.CommandVerify(ctx, "", getVerifyConfig(), verify.Options{Quick: verifyQuick})
+		if err != nil {
+			log.Err(err).Msg("failed to verify")
+			os.Exit(2)
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Err(err).Msg("failed to write report")
+			os.Exit(2)
+		}
+		if !report.OK {
+			os.Exit(1)
+		}
+	},
+}
+
+func getVerifyConfig() map[string]any {
+	cmdConf := make(map[string]any)
+	if len(verifyDataDir) != 0 {
+		cmdConf["data_dir"] = verifyDataDir
+	}
+	if len(verifyDatakey) != 0 {
+		cmdConf["data_key"] = verifyDatakey
+	}
+	if len(verifyWorkDir) != 0 {
+		cmdConf["work_dir"] = verifyWorkDir
+	}
+	if len(verifyPlatform) != 0 {
+		cmdConf["platform"] = verifyPlatform
+	}
+	return cmdConf
+}
@@ -0,0 +1,43 @@
+package chatlog
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/term"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/atrest"
+)
+
+// enablePassphrasePrompt 未设置环境变量时从终端输入工作目录口令
+// 只用于命令行，TUI 与 stdio MCP 占用了标准输入，只能通过环境变量提供口令
+func enablePassphrasePrompt() {
+	atrest.Prompt = promptPassphrase
+}
+
+func promptPassphrase(workDir string, confirm bool) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.ErrWorkDirPassphraseRequired
+	}
+
+	fmt.Fprintf(os.Stderr, "passphrase for encrypted work dir %s: ", workDir)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "confirm passphrase: ")
+		again, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		if string(again) != string(passphrase) {
+			return "", fmt.Errorf("passphrases do not match")
+		}
+	}
+	return string(passphrase), nil
+}
@@ -31,6 +31,9 @@ func IsCLIInvocation(args []string) bool {
 		"key":        {},
 		"decrypt":    {},
 		"dumpmemory": {},
+		"export":     {},
+		"verify":     {},
+		"mcp":        {},
 		"version":    {},
 		"help":       {},
 		"completion": {},
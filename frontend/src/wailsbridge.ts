@@ -54,6 +54,13 @@ export type WebhookItem = {
     sender: string;
     keyword: string;
     disabled: boolean;
+    events?: string[];
+    secret?: string;
+    headers?: Record<string, string>;
+    timeoutMs?: number;
+    mode?: string;
+    preset?: string;
+    template?: string;
 };
 
 export type AIProvider = {
@@ -122,6 +122,13 @@ export namespace main {
 	    sender: string;
 	    keyword: string;
 	    disabled: boolean;
+	    events?: string[];
+	    secret?: string;
+	    headers?: Record<string, string>;
+	    timeoutMs?: number;
+	    mode?: string;
+	    preset?: string;
+	    template?: string;
 	
 	    static createFrom(source: any = {}) {
 	        return new WebhookItem(source);
@@ -136,6 +143,13 @@ export namespace main {
 	        this.sender = source["sender"];
 	        this.keyword = source["keyword"];
 	        this.disabled = source["disabled"];
+	        this.events = source["events"];
+	        this.secret = source["secret"];
+	        this.headers = source["headers"];
+	        this.timeoutMs = source["timeoutMs"];
+	        this.mode = source["mode"];
+	        this.preset = source["preset"];
+	        this.template = source["template"];
 	    }
 	}
 	export class WebhookConfig {
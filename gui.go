@@ -92,6 +92,14 @@ type WebhookItem struct {
 	Sender      string `json:"sender"`
 	Keyword     string `json:"keyword"`
 	Disabled    bool   `json:"disabled"`
+
+	Events    []string          `json:"events,omitempty"`
+	Secret    string            `json:"secret,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	TimeoutMs int64             `json:"timeoutMs,omitempty"`
+	Mode      string            `json:"mode,omitempty"`
+	Preset    string            `json:"preset,omitempty"`
+	Template  string            `json:"template,omitempty"`
 }
 
 type WebhookConfig struct {
@@ -341,6 +349,13 @@ func (a *App) GetWebhookConfig() (WebhookConfig, error) {
 			Sender:      it.Sender,
 			Keyword:     it.Keyword,
 			Disabled:    it.Disabled,
+			Events:      it.Events,
+			Secret:      it.Secret,
+			Headers:     it.Headers,
+			TimeoutMs:   it.TimeoutMs,
+			Mode:        it.Mode,
+			Preset:      it.Preset,
+			Template:    it.Template,
 		})
 	}
 	return WebhookConfig{Host: hook.Host, DelayMs: hook.DelayMs, Items: items}, nil
@@ -369,6 +384,13 @@ func (a *App) SetWebhookConfig(cfg WebhookConfig) error {
 			Sender:      strings.TrimSpace(it.Sender),
 			Keyword:     strings.TrimSpace(it.Keyword),
 			Disabled:    it.Disabled,
+			Events:      it.Events,
+			Secret:      it.Secret,
+			Headers:     it.Headers,
+			TimeoutMs:   it.TimeoutMs,
+			Mode:        strings.TrimSpace(it.Mode),
+			Preset:      strings.TrimSpace(it.Preset),
+			Template:    it.Template,
 		})
 	}
 
@@ -378,6 +400,10 @@ func (a *App) SetWebhookConfig(cfg WebhookConfig) error {
 		hook = nil
 	} else {
 		hook = &conf.Webhook{Host: host, DelayMs: cfg.DelayMs, Items: items}
+		// 界面未提供的配置沿用原值
+		if prev := ctx.GetWebhook(); prev != nil {
+			hook.MaxAttempts = prev.MaxAttempts
+		}
 	}
 
 	if err := a.mgr.SetWebhook(hook); err != nil {
@@ -8,6 +8,10 @@ type AppConfig struct {
 	History        []ProcessConfig `mapstructure:"history" json:"history"`
 	Webhook        *Webhook        `mapstructure:"webhook" json:"webhook"`
 	AIProviders    []*AIProvider   `mapstructure:"ai_providers" json:"ai_providers"`
+	Auth           *Auth           `mapstructure:"auth" json:"auth"`
+	Policy         *Policy         `mapstructure:"policy" json:"policy"`
+	TLS            *TLS            `mapstructure:"tls" json:"tls"`
+	EncryptWorkDir bool            `mapstructure:"encrypt_work_dir" json:"encrypt_work_dir"`
 }
 
 var AppDefaults = map[string]any{}
@@ -0,0 +1,60 @@
+package conf
+
+import (
+	"crypto/subtle"
+	"slices"
+)
+
+// 访问令牌的权限范围
+const (
+	ScopeMessages = "messages" // 聊天记录、最近会话、搜索与统计
+	ScopeContacts = "contacts" // 联系人与群聊
+	ScopeMedia    = "media"    // 图片、视频、文件、语音等多媒体内容
+	ScopeMCP      = "mcp"      // MCP 服务
+	ScopeAdmin    = "admin"    // 全部权限，包括 webhook 投递记录等管理接口
+)
+
+// Auth HTTP 与 MCP 服务的访问控制，未配置任何令牌时不启用认证
+type Auth struct {
+	Tokens []*Token `mapstructure:"tokens" json:"tokens"`
+}
+
+type Token struct {
+	Name   string   `mapstructure:"name" json:"name"`
+	Token  string   `mapstructure:"token" json:"-"`
+	Scopes []string `mapstructure:"scopes" json:"scopes"`
+	// Talkers 允许访问的会话，可使用 ID、昵称或备注名，为空时不限制
+	Talkers  []string `mapstructure:"talkers" json:"talkers,omitempty"`
+	Disabled bool     `mapstructure:"disabled" json:"disabled,omitempty"`
+}
+
+// Enabled 是否配置了可用的令牌
+func (a *Auth) Enabled() bool {
+	if a == nil {
+		return false
+	}
+	return slices.ContainsFunc(a.Tokens, func(t *Token) bool {
+		return t != nil && !t.Disabled && t.Token != ""
+	})
+}
+
+// Lookup 查找与 value 匹配的可用令牌，找不到时返回 nil
+func (a *Auth) Lookup(value string) *Token {
+	if a == nil || value == "" {
+		return nil
+	}
+	for _, t := range a.Tokens {
+		if t == nil || t.Disabled || t.Token == "" {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(value)) == 1 {
+			return t
+		}
+	}
+	return nil
+}
+
+// HasScope admin 拥有全部权限
+func (t *Token) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope) || slices.Contains(t.Scopes, ScopeAdmin)
+}
@@ -0,0 +1,79 @@
+package conf
+
+import "testing"
+
+func TestAuthLookup(t *testing.T) {
+	auth := &Auth{Tokens: []*Token{
+		nil,
+		{Name: "empty", Token: ""},
+		{Name: "disabled", Token: "old", Disabled: true},
+		{Name: "reader", Token: "read-token", Scopes: []string{ScopeMessages}},
+	}}
+	tests := []struct {
+		name  string
+		auth  *Auth
+		value string
+		want  string // 匹配的令牌名称，为空表示未匹配
+	}{
+		{"match", auth, "read-token", "reader"},
+		{"wrong value", auth, "read-token2", ""},
+		{"prefix", auth, "read", ""},
+		{"disabled", auth, "old", ""},
+		{"empty value", auth, "", ""},
+		{"nil auth", nil, "read-token", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.auth.Lookup(tt.value)
+			name := ""
+			if got != nil {
+				name = got.Name
+			}
+			if name != tt.want {
+				t.Errorf("Lookup(%q) = %q, want %q", tt.value, name, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthEnabled(t *testing.T) {
+	tests := []struct {
+		name string
+		auth *Auth
+		want bool
+	}{
+		{"nil", nil, false},
+		{"no tokens", &Auth{}, false},
+		{"only disabled", &Auth{Tokens: []*Token{nil, {Token: "a", Disabled: true}, {Token: ""}}}, false},
+		{"enabled", &Auth{Tokens: []*Token{{Token: "a"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.auth.Enabled(); got != tt.want {
+				t.Errorf("Enabled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenHasScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []string
+		scope  string
+		want   bool
+	}{
+		{"granted", []string{ScopeMessages, ScopeMedia}, ScopeMedia, true},
+		{"not granted", []string{ScopeMessages}, ScopeContacts, false},
+		{"admin", []string{ScopeAdmin}, ScopeMCP, true},
+		{"no scopes", nil, ScopeMessages, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := &Token{Scopes: tt.scopes}
+			if got := token.HasScope(tt.scope); got != tt.want {
+				t.Errorf("HasScope(%s) = %v, want %v", tt.scope, got, tt.want)
+			}
+		})
+	}
+}
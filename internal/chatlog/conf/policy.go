@@ -0,0 +1,29 @@
+package conf
+
+// 内置的敏感信息检测器
+const (
+	DetectorMobile   = "mobile"    // 中国大陆手机号
+	DetectorIDCard   = "id_card"   // 居民身份证号，校验末位校验码
+	DetectorBankCard = "bank_card" // 银行卡号，校验 Luhn 校验位
+)
+
+// PolicyLabelPrefix 联系人标签条目的前缀，如 "label:同事"
+const PolicyLabelPrefix = "label:"
+
+// Policy 通过 HTTP API 与 MCP 对外提供数据时的会话过滤与内容脱敏规则，不影响 webhook 与导出
+type Policy struct {
+	// Allow 只提供这些会话，为空时不限制；Deny 中的会话始终不提供
+	// 会话可使用 ID、昵称或备注名，不支持联系人标签（以 PolicyLabelPrefix 开头的条目会被拒绝）
+	Allow []string `mapstructure:"allow" json:"allow,omitempty"`
+	Deny  []string `mapstructure:"deny" json:"deny,omitempty"`
+
+	Detectors []string      `mapstructure:"detectors" json:"detectors,omitempty"`
+	Redact    []*RedactRule `mapstructure:"redact" json:"redact,omitempty"`
+}
+
+// RedactRule 正则脱敏规则，Replace 支持 $1 等分组引用，为空时替换为 ***
+type RedactRule struct {
+	Name    string `mapstructure:"name" json:"name"`
+	Pattern string `mapstructure:"pattern" json:"pattern"`
+	Replace string `mapstructure:"replace" json:"replace"`
+}
@@ -19,6 +19,12 @@ type ServerConfig struct {
 	AutoDecrypt bool     `mapstructure:"auto_decrypt"`
 	Debug       bool     `mapstructure:"debug"`
 	Webhook     *Webhook `mapstructure:"webhook"`
+	Auth        *Auth    `mapstructure:"auth"`
+	Policy      *Policy  `mapstructure:"policy"`
+	TLS         *TLS     `mapstructure:"tls"`
+
+	// EncryptWorkDir 解密结果使用口令重新加密后写入工作目录，见 atrest 包
+	EncryptWorkDir bool `mapstructure:"encrypt_work_dir"`
 }
 
 var ServerDefaults = map[string]any{
@@ -72,6 +78,22 @@ func (c *ServerConfig) GetWebhook() *Webhook {
 	return c.Webhook
 }
 
+func (c *ServerConfig) GetAuth() *Auth {
+	return c.Auth
+}
+
+func (c *ServerConfig) GetPolicy() *Policy {
+	return c.Policy
+}
+
+func (c *ServerConfig) GetTLS() *TLS {
+	return c.TLS
+}
+
+func (c *ServerConfig) GetEncryptWorkDir() bool {
+	return c.EncryptWorkDir
+}
+
 func (c *ServerConfig) GetDebug() bool {
 	return c.Debug
 }
@@ -0,0 +1,16 @@
+package conf
+
+// UnixAddrPrefix http_addr 以该前缀开头时监听 unix domain socket，如 unix:/run/chatlog.sock
+const UnixAddrPrefix = "unix:"
+
+// TLS HTTPS 配置，未指定证书时使用工作目录中自动生成的自签名证书
+type TLS struct {
+	Enabled  bool   `mapstructure:"enabled" json:"enabled"`
+	CertFile string `mapstructure:"cert_file" json:"cert_file,omitempty"`
+	KeyFile  string `mapstructure:"key_file" json:"key_file,omitempty"`
+}
+
+// IsEnabled 开启了 HTTPS 或配置了证书
+func (t *TLS) IsEnabled() bool {
+	return t != nil && (t.Enabled || t.CertFile != "")
+}
@@ -1,17 +1,35 @@
 package conf
 
 type Webhook struct {
-	Host    string         `mapstructure:"host" json:"host"`
-	DelayMs int64          `mapstructure:"delay_ms" json:"delay_ms"`
-	Items   []*WebhookItem `mapstructure:"items" json:"items"`
+	Host        string         `mapstructure:"host" json:"host"`
+	DelayMs     int64          `mapstructure:"delay_ms" json:"delay_ms"`
+	MaxAttempts int            `mapstructure:"max_attempts" json:"max_attempts,omitempty"` // 单次投递最大尝试次数，默认 10
+	Items       []*WebhookItem `mapstructure:"items" json:"items"`
 }
 
 type WebhookItem struct {
 	Description string `mapstructure:"description" json:"description"`
-	Type        string `mapstructure:"type" json:"type"`
+	Type        string `mapstructure:"type" json:"type"` // message（默认）、contact、chatroom、session
 	URL         string `mapstructure:"url" json:"url"`
 	Talker      string `mapstructure:"talker" json:"talker"`
 	Sender      string `mapstructure:"sender" json:"sender"`
 	Keyword     string `mapstructure:"keyword" json:"keyword"`
 	Disabled    bool   `mapstructure:"disabled" json:"disabled"`
+
+	// Events 关注的事件，为空时推送该类型的全部事件，仅对 contact、chatroom、session 类型生效
+	Events []string `mapstructure:"events" json:"events,omitempty"`
+
+	// Secret 签名密钥，非空时请求附带 X-Chatlog-Timestamp 与 X-Chatlog-Signature
+	Secret string `mapstructure:"secret" json:"secret,omitempty"`
+	// Headers 附加的固定请求头，如 Authorization、X-Api-Key
+	Headers map[string]string `mapstructure:"headers" json:"headers,omitempty"`
+	// TimeoutMs 单次请求超时，默认 10 秒
+	TimeoutMs int64 `mapstructure:"timeout_ms" json:"timeout_ms,omitempty"`
+
+	// Mode 投递模式，batch（默认）每次触发合并为一个请求，message 每条消息（事件）一个请求
+	Mode string `mapstructure:"mode" json:"mode,omitempty"`
+	// Preset 内置请求体模板：default、feishu、dingtalk、wecom、slack
+	Preset string `mapstructure:"preset" json:"preset,omitempty"`
+	// Template 自定义请求体模板（Go text/template），优先于 Preset，渲染结果需为 JSON
+	Template string `mapstructure:"template" json:"template,omitempty"`
 }
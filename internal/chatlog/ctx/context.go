@@ -283,6 +283,22 @@ func (c *Context) SetWebhook(hook *conf.Webhook) error {
 	return nil
 }
 
+func (c *Context) GetAuth() *conf.Auth {
+	return c.conf.Auth
+}
+
+func (c *Context) GetPolicy() *conf.Policy {
+	return c.conf.Policy
+}
+
+func (c *Context) GetTLS() *conf.TLS {
+	return c.conf.TLS
+}
+
+func (c *Context) GetEncryptWorkDir() bool {
+	return c.conf.EncryptWorkDir
+}
+
 func (c *Context) GetAIProviders() []*conf.AIProvider {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
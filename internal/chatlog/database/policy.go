@@ -0,0 +1,272 @@
+package database
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sjzar/chatlog/internal/chatlog/conf"
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// policy 由 conf.Policy 编译得到，在数据交给 HTTP 与 MCP 之前过滤会话并脱敏内容
+type policy struct {
+	allow []string
+	deny  []string
+	rules []*redactRule
+}
+
+type redactRule struct {
+	re      *regexp.Regexp
+	replace string
+	valid   func(match string) bool // 内置检测器用于排除误报，为 nil 时匹配即替换
+}
+
+// 内置检测器，身份证号先于银行卡号匹配，避免 18 位身份证号被当作银行卡号
+var detectors = map[string]*redactRule{
+	conf.DetectorIDCard: {
+		re:      regexp.MustCompile(`\b[1-9]\d{5}(?:18|19|20)\d{2}(?:0[1-9]|1[0-2])(?:0[1-9]|[12]\d|3[01])\d{3}[\dXx]\b`),
+		replace: "[身份证号]",
+		valid:   validIDCard,
+	},
+	conf.DetectorBankCard: {
+		re:      regexp.MustCompile(`\b[1-9]\d{3}(?:[ -]?\d{4}){3}(?:[ -]?\d{1,3})?\b`),
+		replace: "[银行卡号]",
+		valid:   validLuhn,
+	},
+	conf.DetectorMobile: {
+		re:      regexp.MustCompile(`(?:\+86[ -]?|\b)1[3-9]\d[ -]?\d{4}[ -]?\d{4}\b`),
+		replace: "[手机号]",
+	},
+}
+
+var detectorOrder = []string{conf.DetectorIDCard, conf.DetectorBankCard, conf.DetectorMobile}
+
+// 只对这些 Contents 字段脱敏，md5、path 等字段用于定位多媒体内容，不做处理
+var redactContentKeys = []string{"title", "desc", "url", "label", "cityname"}
+
+// newPolicy 编译策略，未配置任何规则时返回 nil
+func newPolicy(c *conf.Policy) (*policy, error) {
+	if c == nil {
+		return nil, nil
+	}
+	// 微信 4.x 的联系人标签保存在联系人的 extra_buffer（protobuf）中，无法可靠解析，明确拒绝而不是静默地匹配不到任何会话
+	for _, talker := range append(append([]string{}, c.Allow...), c.Deny...) {
+		if strings.HasPrefix(talker, conf.PolicyLabelPrefix) {
+			return nil, fmt.Errorf("policy does not support contact labels: %s, list the talkers by ID, nickname or remark instead", talker)
+		}
+	}
+	p := &policy{allow: c.Allow, deny: c.Deny}
+
+	enabled := make(map[string]bool)
+	for _, name := range c.Detectors {
+		if _, ok := detectors[name]; !ok {
+			return nil, fmt.Errorf("unknown policy detector: %s", name)
+		}
+		enabled[name] = true
+	}
+	for _, name := range detectorOrder {
+		if enabled[name] {
+			p.rules = append(p.rules, detectors[name])
+		}
+	}
+
+	for i, r := range c.Redact {
+		if r == nil || r.Pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact rule %d (%s): %w", i, r.Name, err)
+		}
+		replace := r.Replace
+		if replace == "" {
+			replace = "***"
+		}
+		p.rules = append(p.rules, &redactRule{re: re, replace: replace})
+	}
+
+	if len(p.allow) == 0 && len(p.deny) == 0 && len(p.rules) == 0 {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// talkerFilter 返回会话是否可以对外提供，未配置 allow/deny 时返回 nil
+// 每次调用时重新解析昵称与备注名，使新增的联系人与群聊也能匹配
+func (s *Service) talkerFilter() func(talker string) bool {
+	allow, deny, ok := s.talkerSets()
+	if !ok {
+		return nil
+	}
+	return func(talker string) bool {
+		return !deny[talker] && (allow == nil || allow[talker])
+	}
+}
+
+// messageFilter 返回读取消息时交给数据源的过滤条件，没有任何条件时返回 nil
+// 数据源跳过 allow/deny 之外的会话，并在匹配关键词之前脱敏，关键词无法匹配到被脱敏的内容
+// allowed 为调用方额外限制的会话（如令牌可以访问的会话），为 nil 时不限制
+func (s *Service) messageFilter(allowed func(talker string) bool) *model.MessageFilter {
+	f := &model.MessageFilter{Talker: allowed}
+	if policyAllowed := s.talkerFilter(); policyAllowed != nil {
+		f.Talker = policyAllowed
+		if allowed != nil {
+			f.Talker = func(talker string) bool { return policyAllowed(talker) && allowed(talker) }
+		}
+	}
+	if p := s.policy; p != nil && len(p.rules) > 0 {
+		f.Redact = p.redactMessage
+	}
+	if f.Talker == nil && f.Redact == nil {
+		return nil
+	}
+	return f
+}
+
+// talkerSets 解析 allow/deny 中的会话，allow 为 nil 表示不限制，未配置 allow/deny 时 ok 为 false
+func (s *Service) talkerSets() (allow, deny map[string]bool, ok bool) {
+	p := s.policy
+	if p == nil || (len(p.allow) == 0 && len(p.deny) == 0) {
+		return nil, nil, false
+	}
+	resolve := func(list []string) map[string]bool {
+		ret := make(map[string]bool)
+		for _, talker := range list {
+			ret[talker] = true
+		}
+		for _, talker := range s.db.ResolveTalkers(strings.Join(list, ",")) {
+			ret[talker] = true
+		}
+		return ret
+	}
+
+	if len(p.allow) > 0 {
+		allow = resolve(p.allow)
+	}
+	return allow, resolve(p.deny), true
+}
+
+// CheckMedia 检查媒体文件是否属于可以对外提供的会话，path 为数据目录下的相对路径
+// 只有图片按会话保存（见 model.MediaTalkerHash），配置了 allow/deny 时视频、文件和语音无法确定所属会话，一律拒绝
+func (s *Service) CheckMedia(path string) error {
+	allow, deny, ok := s.talkerSets()
+	if !ok {
+		return nil
+	}
+	hash, ok := model.MediaTalkerHash(path)
+	if !ok {
+		return errors.MediaDenied(path)
+	}
+	match := func(set map[string]bool) bool {
+		for talker := range set {
+			if model.TalkerHash(talker) == hash {
+				return true
+			}
+		}
+		return false
+	}
+	if match(deny) || (allow != nil && !match(allow)) {
+		return errors.MediaDenied(path)
+	}
+	return nil
+}
+
+// checkTalker 检查逗号分隔的会话是否都可以对外提供
+func (s *Service) checkTalker(talker string) error {
+	allowed := s.talkerFilter()
+	if allowed == nil || talker == "" {
+		return nil
+	}
+	for _, t := range s.db.ResolveTalkers(talker) {
+		if !allowed(t) {
+			return errors.TalkerDenied(t)
+		}
+	}
+	return nil
+}
+
+func (p *policy) redact(text string) string {
+	if p == nil || text == "" {
+		return text
+	}
+	for _, rule := range p.rules {
+		if rule.valid == nil {
+			text = rule.re.ReplaceAllString(text, rule.replace)
+			continue
+		}
+		text = rule.re.ReplaceAllStringFunc(text, func(match string) string {
+			if rule.valid(match) {
+				return rule.replace
+			}
+			return match
+		})
+	}
+	return text
+}
+
+// redactMessage 脱敏消息内容，包括引用消息与合并转发中的文本
+func (p *policy) redactMessage(m *model.Message) {
+	if p == nil || len(p.rules) == 0 || m == nil {
+		return
+	}
+	m.Content = p.redact(m.Content)
+	for _, key := range redactContentKeys {
+		if value, ok := m.Contents[key].(string); ok {
+			m.Contents[key] = p.redact(value)
+		}
+	}
+	if refer, ok := m.Contents["refer"].(*model.Message); ok {
+		p.redactMessage(refer)
+	}
+	if recordInfo, ok := m.Contents["recordInfo"].(*model.RecordInfo); ok {
+		p.redactRecordInfo(recordInfo)
+	}
+}
+
+func (p *policy) redactRecordInfo(r *model.RecordInfo) {
+	r.Title = p.redact(r.Title)
+	r.Desc = p.redact(r.Desc)
+	r.Info = p.redact(r.Info)
+	for i := range r.DataList.DataItems {
+		item := &r.DataList.DataItems[i]
+		item.DataDesc = p.redact(item.DataDesc)
+		item.DataTitle = p.redact(item.DataTitle)
+		item.Link = p.redact(item.Link)
+		if item.RecordXML != nil {
+			p.redactRecordInfo(&item.RecordXML.RecordInfo)
+		}
+	}
+}
+
+// validIDCard 校验 18 位身份证号的末位校验码
+func validIDCard(s string) bool {
+	weights := []int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+	sum := 0
+	for i, w := range weights {
+		sum += int(s[i]-'0') * w
+	}
+	return strings.ToUpper(s[17:]) == string("10X98765432"[sum%11])
+}
+
+// validLuhn 校验银行卡号的 Luhn 校验位，忽略空格与连字符
+func validLuhn(s string) bool {
+	sum, double := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/sjzar/chatlog/internal/chatlog/conf"
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+func TestMessageFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  *conf.Policy
+		allowed func(talker string) bool
+		content string
+		want    string
+		isNil   bool
+	}{
+		{"no policy", nil, nil, "13800001234", "13800001234", true},
+		{"mobile", &conf.Policy{Detectors: []string{conf.DetectorMobile}}, nil, "电话 13800001234", "电话 [手机号]", false},
+		{"redact rule", &conf.Policy{Redact: []*conf.RedactRule{{Pattern: `secret\d+`}}}, nil, "secret42", "***", false},
+		{"allowed only", nil, func(string) bool { return true }, "13800001234", "13800001234", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := newPolicy(tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &Service{policy: p}
+			f := s.messageFilter(tt.allowed)
+			if (f == nil) != tt.isNil {
+				t.Fatalf("messageFilter() = %v, want nil %v", f, tt.isNil)
+			}
+			m := &model.Message{Content: tt.content, Contents: map[string]interface{}{}}
+			f.Apply(m)
+			if m.Content != tt.want {
+				t.Errorf("Apply() content = %q, want %q", m.Content, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidIDCard(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"11010519491231002X", true},
+		{"11010519491231002x", true},
+		{"440300199001011238", true},
+		{"440300199001011239", false},
+		{"110105194912310021", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := validIDCard(tt.input); got != tt.want {
+				t.Errorf("validIDCard(%s) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidLuhn(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"4111111111111111", true},
+		{"4111111111111112", false},
+		{"6222021234567890128", true},
+		{"6222 0212 3456 7890 128", true},
+		{"6222-0212-3456-7890-128", true},
+		{"6222021234567890", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := validLuhn(tt.input); got != tt.want {
+				t.Errorf("validLuhn(%s) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	p, err := newPolicy(&conf.Policy{Detectors: []string{conf.DetectorMobile, conf.DetectorIDCard, conf.DetectorBankCard}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"mobile", "电话 13800001234", "电话 [手机号]"},
+		{"mobile with country code", "+86 138-0000-1234", "[手机号]"},
+		{"id card", "身份证 11010519491231002X", "身份证 [身份证号]"},
+		{"invalid id card kept", "编号 110105194912310021", "编号 110105194912310021"},
+		{"bank card", "卡号 6222 0212 3456 7890 128", "卡号 [银行卡号]"},
+		{"invalid bank card kept", "订单 4111111111111112", "订单 4111111111111112"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.redact(tt.input); got != tt.want {
+				t.Errorf("redact(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
@@ -2,8 +2,8 @@ package database
 
 import (
 	"context"
-	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -12,8 +12,11 @@ import (
 	"github.com/sjzar/chatlog/internal/chatlog/conf"
 	"github.com/sjzar/chatlog/internal/chatlog/messageview"
 	"github.com/sjzar/chatlog/internal/chatlog/webhook"
+	"github.com/sjzar/chatlog/internal/errors"
 	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt"
 	"github.com/sjzar/chatlog/internal/wechatdb"
+	"github.com/sjzar/chatlog/pkg/util"
 )
 
 const (
@@ -26,7 +29,9 @@ const (
 type Service struct {
 	State         int
 	StateMsg      string
+	progress      atomic.Pointer[decrypt.BatchProgress]
 	conf          Config
+	policy        *policy
 	db            *wechatdb.DB
 	webhook       *webhook.Service
 	webhookCancel context.CancelFunc
@@ -34,6 +39,9 @@ type Service struct {
 	messageLogCb  func(event fsnotify.Event) error
 	sessionLogMu  sync.Mutex
 	sessionLogSeq map[string]int
+
+	listenerMu       sync.RWMutex
+	sessionListeners []func(session *model.Session)
 }
 
 type webhookRegistration struct {
@@ -45,6 +53,7 @@ type Config interface {
 	GetWorkDir() string
 	GetPlatform() string
 	GetWebhook() *conf.Webhook
+	GetPolicy() *conf.Policy
 }
 
 func NewService(conf Config) *Service {
@@ -56,6 +65,12 @@ func NewService(conf Config) *Service {
 }
 
 func (s *Service) Start() error {
+	policy, err := newPolicy(s.conf.GetPolicy())
+	if err != nil {
+		return err
+	}
+	s.policy = policy
+
 	db, err := wechatdb.New(s.conf.GetWorkDir(), s.conf.GetPlatform())
 	if err != nil {
 		return err
@@ -75,10 +90,7 @@ func (s *Service) Stop() error {
 	}
 	s.SetInit()
 	s.db = nil
-	if s.webhookCancel != nil {
-		s.webhookCancel()
-		s.webhookCancel = nil
-	}
+	s.stopWebhook()
 	return nil
 }
 
@@ -87,9 +99,20 @@ func (s *Service) SetInit() {
 }
 
 func (s *Service) SetDecrypting() {
+	s.progress.Store(nil)
 	s.State = StateDecrypting
 }
 
+// SetDecryptProgress 记录解密进度，解密期间通过 HTTP 接口返回
+func (s *Service) SetDecryptProgress(p decrypt.BatchProgress) {
+	s.progress.Store(&p)
+}
+
+// DecryptProgress 返回最近一次记录的解密进度，尚未开始解密时返回 nil
+func (s *Service) DecryptProgress() *decrypt.BatchProgress {
+	return s.progress.Load()
+}
+
 func (s *Service) SetReady() {
 	s.State = StateReady
 }
@@ -103,21 +126,127 @@ func (s *Service) GetDB() *wechatdb.DB {
 	return s.db
 }
 
-func (s *Service) GetMessages(start, end time.Time, talker string, sender string, keyword string, limit, offset int) (*wechatdb.GetMessagesResp, error) {
-	return s.db.GetMessages(start, end, talker, sender, keyword, limit, offset)
+// 以下查询对外提供数据，按 policy 过滤会话并脱敏内容
+
+func (s *Service) GetMessages(start, end time.Time, talker string, sender string, keyword string, cursor string, limit, offset int) (*wechatdb.GetMessagesResp, error) {
+	if err := s.checkTalker(talker); err != nil {
+		return nil, err
+	}
+	return s.db.GetMessagesWithFilter(start, end, talker, sender, keyword, s.messageFilter(nil), cursor, limit, offset)
+}
+
+func (s *Service) StreamMessages(ctx context.Context, start, end time.Time, talker string, sender string, keyword string, cursor string, limit, offset int, fn func(*model.Message) error) (*wechatdb.StreamMessagesResp, error) {
+	if err := s.checkTalker(talker); err != nil {
+		return nil, err
+	}
+	return s.db.StreamMessagesWithFilter(ctx, start, end, talker, sender, keyword, s.messageFilter(nil), cursor, limit, offset, fn)
+}
+
+func (s *Service) GetMessageContext(talker string, seqs []int64, before, after int) (*wechatdb.GetMessageContextResp, error) {
+	if err := s.checkTalker(talker); err != nil {
+		return nil, err
+	}
+	resp, err := s.db.GetMessageContext(talker, seqs, before, after)
+	if err != nil {
+		return nil, err
+	}
+	for _, window := range resp.Items {
+		for _, m := range window.Messages {
+			s.policy.redactMessage(m)
+		}
+	}
+	return resp, nil
+}
+
+func (s *Service) GetMessageStats(start, end time.Time, talker string, top int) (*model.MessageStats, error) {
+	if err := s.checkTalker(talker); err != nil {
+		return nil, err
+	}
+	stats, err := s.db.GetMessageStats(start, end, talker, top)
+	if err != nil {
+		return nil, err
+	}
+	for _, share := range append(stats.Links, stats.Files...) {
+		share.Title = s.policy.redact(share.Title)
+		share.URL = s.policy.redact(share.URL)
+	}
+	return stats, nil
+}
+
+func (s *Service) ResolveTalkers(talker string) []string {
+	return s.db.ResolveTalkers(talker)
+}
+
+func (s *Service) GetMessagesCount(ctx context.Context, start, end time.Time, talker string, sender string) (int, error) {
+	if err := s.checkTalker(talker); err != nil {
+		return 0, err
+	}
+	return s.db.GetMessagesCount(ctx, start, end, talker, sender)
+}
+
+// SearchMessages 跨会话搜索消息，allowed 为调用方额外限制的会话，为 nil 时只按 policy 过滤
+// 会话过滤与脱敏都在搜索时进行，不可访问的会话不占用搜索上限，关键词也无法匹配到被脱敏的内容
+func (s *Service) SearchMessages(start, end time.Time, keyword string, sender string, allowed func(talker string) bool, cursor string, limit, offset int) (*wechatdb.SearchMessagesResp, error) {
+	return s.db.SearchMessagesWithFilter(start, end, keyword, sender, s.messageFilter(allowed), cursor, limit, offset)
 }
 
 func (s *Service) GetContacts(key string, isInChatRoom, limit, offset int) (*wechatdb.GetContactsResp, error) {
-	return s.db.GetContacts(key, isInChatRoom, limit, offset)
+	allowed := s.talkerFilter()
+	if allowed == nil {
+		return s.db.GetContacts(key, isInChatRoom, limit, offset)
+	}
+	resp, err := s.db.GetContacts(key, isInChatRoom, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	items := util.Filter(resp.Items, func(c *model.Contact) bool { return allowed(c.UserName) })
+	return &wechatdb.GetContactsResp{Total: len(items), Items: util.Page(items, limit, offset)}, nil
+}
+
+func (s *Service) GetContact(userName string) (*model.Contact, error) {
+	contact, err := s.db.GetContact(userName)
+	if err != nil {
+		return nil, err
+	}
+	if contact != nil {
+		if err := s.checkTalker(contact.UserName); err != nil {
+			return nil, err
+		}
+	}
+	return contact, nil
 }
 
 func (s *Service) GetChatRooms(key string, limit, offset int) (*wechatdb.GetChatRoomsResp, error) {
-	return s.db.GetChatRooms(key, limit, offset)
+	allowed := s.talkerFilter()
+	if allowed == nil {
+		return s.db.GetChatRooms(key, limit, offset)
+	}
+	resp, err := s.db.GetChatRooms(key, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	items := util.Filter(resp.Items, func(c *model.ChatRoom) bool { return allowed(c.Name) })
+	return &wechatdb.GetChatRoomsResp{Total: len(items), Items: util.Page(items, limit, offset)}, nil
 }
 
 // GetSession retrieves session information
 func (s *Service) GetSessions(key string, limit, offset int) (*wechatdb.GetSessionsResp, error) {
-	return s.db.GetSessions(key, limit, offset)
+	var resp *wechatdb.GetSessionsResp
+	var err error
+	if allowed := s.talkerFilter(); allowed != nil {
+		resp, err = s.db.GetSessions(key, 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		items := util.Filter(resp.Items, func(session *model.Session) bool { return allowed(session.TopicID) })
+		resp = &wechatdb.GetSessionsResp{Total: len(items), Items: util.Page(items, limit, offset)}
+	} else if resp, err = s.db.GetSessions(key, limit, offset); err != nil {
+		return nil, err
+	}
+	for _, session := range resp.Items {
+		session.Content = s.policy.redact(session.Content)
+	}
+	return resp, nil
 }
 
 func (s *Service) GetMedia(_type string, key string) (*model.Media, error) {
@@ -160,10 +289,7 @@ func (s *Service) initWebhook() error {
 
 func (s *Service) ReloadWebhook() error {
 	s.clearWebhookCallbacks()
-	if s.webhookCancel != nil {
-		s.webhookCancel()
-		s.webhookCancel = nil
-	}
+	s.stopWebhook()
 	s.webhook = webhook.New(s.conf)
 	if s.db == nil {
 		return nil
@@ -179,10 +305,26 @@ func (s *Service) Close() {
 	if s.db != nil {
 		s.db.Close()
 	}
+	s.stopWebhook()
+}
+
+// stopWebhook 停止 webhook 投递，等待正在进行的投递结束，未完成的投递保留在 outbox 中
+func (s *Service) stopWebhook() {
 	if s.webhookCancel != nil {
 		s.webhookCancel()
 		s.webhookCancel = nil
 	}
+	if s.webhook != nil {
+		s.webhook.Wait()
+	}
+}
+
+// GetWebhookDeliveries 返回各 webhook 的投递状态
+func (s *Service) GetWebhookDeliveries(status string, limit int, withBody bool) []*webhook.OutboxStatus {
+	if s.webhook == nil {
+		return []*webhook.OutboxStatus{}
+	}
+	return s.webhook.Deliveries(status, limit, withBody)
 }
 
 // CloseDB closes a specific database file connection
@@ -295,8 +437,8 @@ func (s *Service) onMessageEvent(event fsnotify.Event) error {
 		}
 		return nil
 	}
+	var updated []*model.Session
 	s.sessionLogMu.Lock()
-	defer s.sessionLogMu.Unlock()
 	for _, session := range resp.Items {
 		if session == nil || session.TopicID == "" {
 			continue
@@ -307,6 +449,7 @@ func (s *Service) onMessageEvent(event fsnotify.Event) error {
 			continue
 		}
 		s.sessionLogSeq[session.TopicID] = current
+		updated = append(updated, session)
 		log.Info().Msgf(
 			"📨 message: talker=%s sender=%s content=%s",
 			messageview.SessionTalkerName(session),
@@ -314,9 +457,35 @@ func (s *Service) onMessageEvent(event fsnotify.Event) error {
 			session.Content,
 		)
 	}
+	s.sessionLogMu.Unlock()
+
+	// policy 排除的会话不通知外部客户端
+	if allowed := s.talkerFilter(); allowed != nil {
+		updated = util.Filter(updated, func(session *model.Session) bool { return allowed(session.TopicID) })
+	}
+	for _, session := range updated {
+		session.Content = s.policy.redact(session.Content)
+	}
+
+	s.listenerMu.RLock()
+	listeners := s.sessionListeners
+	s.listenerMu.RUnlock()
+	for _, session := range updated {
+		for _, fn := range listeners {
+			fn(session)
+		}
+	}
 	return nil
 }
 
+// OnSessionUpdate 注册新消息回调，消息观察者发现会话有新消息时以该会话调用 fn
+// 回调在文件监听的 goroutine 中执行，不应阻塞
+func (s *Service) OnSessionUpdate(fn func(session *model.Session)) {
+	s.listenerMu.Lock()
+	defer s.listenerMu.Unlock()
+	s.sessionListeners = append(s.sessionListeners, fn)
+}
+
 func sessionKey(session *model.Session) int {
 	if session == nil {
 		return 0
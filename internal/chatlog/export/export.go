@@ -0,0 +1,260 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/internal/wechatdb"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+// 支持的导出格式
+const (
+	FormatTXT      = "txt"
+	FormatCSV      = "csv"
+	FormatJSON     = "json"
+	FormatNDJSON   = "ndjson"
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+)
+
+// Formats 全部支持的导出格式
+var Formats = []string{FormatTXT, FormatCSV, FormatJSON, FormatNDJSON, FormatMarkdown, FormatHTML}
+
+// Source 导出所需的数据接口，wechatdb.DB 与 database.Service 均满足
+type Source interface {
+	StreamMessages(ctx context.Context, start, end time.Time, talker string, sender string, keyword string, cursor string, limit, offset int, fn func(*model.Message) error) (*wechatdb.StreamMessagesResp, error)
+	GetSessions(key string, limit, offset int) (*wechatdb.GetSessionsResp, error)
+	GetMedia(_type string, key string) (*model.Media, error)
+	GetContact(userName string) (*model.Contact, error)
+	GetSelfSmallHeadImgUrl() string
+}
+
+// Options 导出参数
+type Options struct {
+	Talkers   []string  // 会话列表，为空时导出全部会话
+	Start     time.Time // 时间范围
+	End       time.Time
+	Format    string // 导出格式
+	OutputDir string // 输出目录
+	Media     bool   // 是否复制消息引用的图片、视频、语音和文件
+	Host      string // 未复制媒体时，消息中媒体链接使用的 HTTP 服务地址，可以带协议，见 model.MediaURL
+	Private   bool   // 导出的文件仅当前用户可读写，工作目录已加密时使用
+}
+
+// Result 单个会话的导出结果
+type Result struct {
+	Talker       string `json:"talker"`
+	TalkerName   string `json:"talkerName"`
+	Path         string `json:"path"`
+	Count        int    `json:"count"`
+	Media        int    `json:"media"`
+	MediaMissing int    `json:"mediaMissing"`
+}
+
+// Exporter 将会话导出为离线文件
+type Exporter struct {
+	src     Source
+	dataDir string
+}
+
+// New 创建导出器，dataDir 为微信数据目录，复制媒体文件时使用
+func New(src Source, dataDir string) *Exporter {
+	return &Exporter{
+		src:     src,
+		dataDir: dataDir,
+	}
+}
+
+// Export 按会话逐个导出，每个会话生成一个文件，媒体文件复制到同名的 _files 目录中
+func (e *Exporter) Export(ctx context.Context, opts Options) ([]*Result, error) {
+	opts.Format = NormalizeFormat(opts.Format)
+	if opts.Format == "" {
+		return nil, fmt.Errorf("unsupported format, available: %s", strings.Join(Formats, ", "))
+	}
+	if opts.Media && e.dataDir == "" {
+		return nil, fmt.Errorf("dataDir is required to export media")
+	}
+	if err := util.PrepareDir(opts.OutputDir); err != nil {
+		return nil, err
+	}
+
+	talkers := opts.Talkers
+	if len(talkers) == 0 {
+		resp, err := e.src.GetSessions("", 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, session := range resp.Items {
+			talkers = append(talkers, session.TopicID)
+		}
+	}
+
+	results := make([]*Result, 0, len(talkers))
+	names := make(map[string]int)
+	for _, talker := range talkers {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+
+		// 同名文件追加序号，避免相互覆盖
+		base := FileName(talker)
+		if n := names[base]; n > 0 {
+			names[base]++
+			base = fmt.Sprintf("%s_%d", base, n)
+		} else {
+			names[base] = 1
+		}
+
+		result, err := e.exportTalker(ctx, talker, base, opts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return results, err
+			}
+			log.Err(err).Msgf("export %s failed", talker)
+			continue
+		}
+		if result.Count == 0 {
+			log.Debug().Msgf("no messages for %s, skipped", talker)
+			continue
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func (e *Exporter) exportTalker(ctx context.Context, talker, base string, opts Options) (*Result, error) {
+	path := filepath.Join(opts.OutputDir, base+"."+Ext(opts.Format))
+	tmpPath := path + ".tmp"
+
+	f, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, fileMode(opts.Private))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(tmpPath)
+	}()
+
+	result := &Result{Talker: talker, Path: path}
+
+	var media *mediaExporter
+	if opts.Media {
+		media = newMediaExporter(e.src, e.dataDir, opts.OutputDir, base+"_files", opts.Private)
+	}
+
+	buf := bufio.NewWriter(f)
+	var w writer
+	if opts.Format == FormatHTML {
+		w = newHTMLWriter(ctx, buf, opts.Host, e.src, media)
+	} else {
+		w = newWriter(opts.Format, buf, opts.Host)
+	}
+	conv := &Conversation{
+		Talker: talker,
+		Start:  opts.Start,
+		End:    opts.End,
+	}
+
+	started := false
+	_, err = e.src.StreamMessages(ctx, opts.Start, opts.End, talker, "", "", "", 0, 0, func(m *model.Message) error {
+		if !started {
+			// 会话名称取自第一条消息，talker 可能是备注或昵称
+			conv.Talker = m.Talker
+			conv.TalkerName = m.TalkerName
+			conv.IsChatRoom = m.IsChatRoom
+			if err := w.Begin(conv); err != nil {
+				return err
+			}
+			started = true
+		}
+		if media != nil {
+			media.Export(ctx, m)
+		}
+		result.Count++
+		return w.Write(m)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !started {
+		return result, nil
+	}
+	if err := w.End(); err != nil {
+		return nil, err
+	}
+	if err := buf.Flush(); err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return nil, err
+	}
+
+	result.Talker = conv.Talker
+	result.TalkerName = conv.TalkerName
+	if media != nil {
+		result.Media = media.copied
+		result.MediaMissing = media.missing
+	}
+	return result, nil
+}
+
+// NormalizeFormat 规范化格式名称，不支持的格式返回空字符串
+func NormalizeFormat(format string) string {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "txt", "text":
+		return FormatTXT
+	case "csv":
+		return FormatCSV
+	case "json":
+		return FormatJSON
+	case "ndjson", "jsonl":
+		return FormatNDJSON
+	case "markdown", "md":
+		return FormatMarkdown
+	case "html", "htm":
+		return FormatHTML
+	}
+	return ""
+}
+
+// Ext 返回导出格式对应的文件扩展名
+func Ext(format string) string {
+	switch format {
+	case FormatMarkdown:
+		return "md"
+	default:
+		return format
+	}
+}
+
+// FileName 将会话 ID 转换为可用作文件名的字符串
+func FileName(talker string) string {
+	name := strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		if r < 0x20 {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(talker))
+	name = strings.Trim(name, ". ")
+	if name == "" {
+		name = "_"
+	}
+	return name
+}
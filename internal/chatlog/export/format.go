@@ -0,0 +1,216 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+// Conversation 导出文件对应的会话信息
+type Conversation struct {
+	Talker     string    `json:"talker"`
+	TalkerName string    `json:"talkerName"`
+	IsChatRoom bool      `json:"isChatRoom"`
+	Start      time.Time `json:"-"`
+	End        time.Time `json:"-"`
+}
+
+// Title 会话标题，优先使用会话名称
+func (c *Conversation) Title() string {
+	if c.TalkerName != "" {
+		return c.TalkerName
+	}
+	return c.Talker
+}
+
+// writer 将单个会话的消息按指定格式写出
+type writer interface {
+	Begin(conv *Conversation) error
+	Write(m *model.Message) error
+	End() error
+}
+
+func newWriter(format string, w io.Writer, host string) writer {
+	switch format {
+	case FormatCSV:
+		return &csvWriter{w: csv.NewWriter(w), host: host}
+	case FormatJSON:
+		return &jsonWriter{w: w}
+	case FormatNDJSON:
+		return &ndjsonWriter{w: w}
+	case FormatMarkdown:
+		return &markdownWriter{w: w, host: host}
+	default:
+		return &textWriter{w: w, host: host}
+	}
+}
+
+// Content 返回消息的文本内容，已导出的媒体使用相对路径链接
+func Content(m *model.Message, host string) string {
+	if rel := localPath(m); rel != "" {
+		link := escapePath(rel)
+		switch m.Type {
+		case model.MessageTypeImage:
+			return fmt.Sprintf("![图片](%s)", link)
+		case model.MessageTypeVideo:
+			return fmt.Sprintf("![视频](%s)", link)
+		case model.MessageTypeVoice:
+			return fmt.Sprintf("[语音](%s)", link)
+		case model.MessageTypeShare:
+			return fmt.Sprintf("[文件|%s](%s)", m.Contents["title"], link)
+		}
+	}
+	m.SetContent("host", host)
+	return m.PlainTextContent()
+}
+
+// senderLabel 发送人显示名称，格式与 HTTP 接口的文本输出一致
+func senderLabel(m *model.Message) string {
+	sender := m.Sender
+	if m.IsSelf {
+		sender = "我"
+	}
+	if m.SenderName != "" {
+		return m.SenderName + "(" + sender + ")"
+	}
+	return sender
+}
+
+// escapePath 对相对路径的每一段进行 URL 转义
+func escapePath(rel string) string {
+	parts := strings.Split(rel, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// textWriter 纯文本，与 /api/v1/chatlog 的默认输出一致
+type textWriter struct {
+	w          io.Writer
+	host       string
+	timeFormat string
+}
+
+func (t *textWriter) Begin(conv *Conversation) error {
+	t.timeFormat = util.PerfectTimeFormat(conv.Start, conv.End)
+	return nil
+}
+
+func (t *textWriter) Write(m *model.Message) error {
+	_, err := fmt.Fprintf(t.w, "%s %s\n%s\n\n", senderLabel(m), m.Time.Format(t.timeFormat), Content(m, t.host))
+	return err
+}
+
+func (t *textWriter) End() error {
+	return nil
+}
+
+// csvWriter CSV，列与 /api/v1/chatlog?format=csv 一致
+type csvWriter struct {
+	w    *csv.Writer
+	host string
+}
+
+func (c *csvWriter) Begin(conv *Conversation) error {
+	return c.w.Write([]string{"Time", "SenderName", "Sender", "TalkerName", "Talker", "Content"})
+}
+
+func (c *csvWriter) Write(m *model.Message) error {
+	row := m.CSV(c.host)
+	row[len(row)-1] = Content(m, c.host)
+	return c.w.Write(row)
+}
+
+func (c *csvWriter) End() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonWriter 单个 JSON 文档，包含会话信息与消息列表
+type jsonWriter struct {
+	w     io.Writer
+	enc   *json.Encoder
+	count int
+}
+
+func (j *jsonWriter) Begin(conv *Conversation) error {
+	j.enc = json.NewEncoder(j.w)
+	j.enc.SetEscapeHTML(false)
+
+	header := &bytes.Buffer{}
+	enc := json.NewEncoder(header)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(conv); err != nil {
+		return err
+	}
+	// 去掉结尾的 }，在同一对象中继续写入消息列表
+	_, err := fmt.Fprintf(j.w, "%s,\"items\":[\n", bytes.TrimRight(header.Bytes(), "}\n"))
+	return err
+}
+
+func (j *jsonWriter) Write(m *model.Message) error {
+	if j.count > 0 {
+		if _, err := io.WriteString(j.w, ","); err != nil {
+			return err
+		}
+	}
+	j.count++
+	return j.enc.Encode(m)
+}
+
+func (j *jsonWriter) End() error {
+	_, err := fmt.Fprintf(j.w, "],\"total\":%d}\n", j.count)
+	return err
+}
+
+// ndjsonWriter 每行一个 model.Message
+type ndjsonWriter struct {
+	enc *json.Encoder
+	w   io.Writer
+}
+
+func (n *ndjsonWriter) Begin(conv *Conversation) error {
+	n.enc = json.NewEncoder(n.w)
+	n.enc.SetEscapeHTML(false)
+	return nil
+}
+
+func (n *ndjsonWriter) Write(m *model.Message) error {
+	return n.enc.Encode(m)
+}
+
+func (n *ndjsonWriter) End() error {
+	return nil
+}
+
+// markdownWriter Markdown，媒体以相对路径链接，图片可直接预览
+type markdownWriter struct {
+	w    io.Writer
+	host string
+}
+
+func (md *markdownWriter) Begin(conv *Conversation) error {
+	_, err := fmt.Fprintf(md.w, "# %s\n\n> %s · %s ~ %s\n\n",
+		conv.Title(), conv.Talker, conv.Start.Format("2006-01-02 15:04:05"), conv.End.Format("2006-01-02 15:04:05"))
+	return err
+}
+
+func (md *markdownWriter) Write(m *model.Message) error {
+	// 行尾两个空格表示换行，保留消息中的换行
+	content := strings.ReplaceAll(strings.TrimRight(Content(m, md.host), "\n"), "\n", "  \n")
+	_, err := fmt.Fprintf(md.w, "**%s** `%s`\n\n%s\n\n", senderLabel(m), m.Time.Format("2006-01-02 15:04:05"), content)
+	return err
+}
+
+func (md *markdownWriter) End() error {
+	return nil
+}
@@ -0,0 +1,386 @@
+package export
+
+import (
+	"context"
+	_ "embed"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+//go:embed html.tmpl
+var htmlTemplateText string
+
+var htmlTemplate = template.Must(template.New("html").Parse(htmlTemplateText))
+
+// htmlWriter 以聊天界面的样式渲染会话，生成可离线打开的静态页面
+// 复制媒体时图片、语音、视频、头像和表情都保存在导出目录中，页面只引用相对路径
+type htmlWriter struct {
+	ctx   context.Context
+	w     io.Writer
+	host  string
+	src   Source
+	media *mediaExporter
+
+	conv    *Conversation
+	avatars map[string]htmlAvatar
+	lastDay string
+	count   int
+}
+
+type htmlPage struct {
+	Title     string
+	Talker    string
+	Range     string
+	Count     int
+	Generated string
+}
+
+type htmlMessage struct {
+	Seq      int64
+	Day      string
+	Time     string
+	Self     bool
+	System   bool
+	ShowName bool
+	Name     string
+	Avatar   htmlAvatar
+	Body     *htmlBody
+	Quote    *htmlQuote
+}
+
+type htmlAvatar struct {
+	Src     string
+	Initial string
+}
+
+// htmlBody 消息气泡内容，Kind 决定渲染方式
+type htmlBody struct {
+	Kind   string // text, image, emoji, video, voice, file, link, location, record
+	Text   string
+	Src    string
+	Title  string
+	Desc   string
+	URL    string
+	Record *htmlRecord
+}
+
+// IsMedia 图片、表情、视频不显示气泡背景
+func (b *htmlBody) IsMedia() bool {
+	return b.Src != "" && (b.Kind == "image" || b.Kind == "emoji" || b.Kind == "video")
+}
+
+// htmlQuote 引用消息
+type htmlQuote struct {
+	Name string
+	Text string
+	Src  string
+}
+
+// htmlRecord 合并转发、笔记、群公告中的聊天记录
+type htmlRecord struct {
+	Items []*htmlRecordItem
+}
+
+type htmlRecordItem struct {
+	Name string
+	Time string
+	Body *htmlBody
+}
+
+func newHTMLWriter(ctx context.Context, w io.Writer, host string, src Source, media *mediaExporter) *htmlWriter {
+	return &htmlWriter{
+		ctx:     ctx,
+		w:       w,
+		host:    host,
+		src:     src,
+		media:   media,
+		avatars: make(map[string]htmlAvatar),
+	}
+}
+
+func (h *htmlWriter) Begin(conv *Conversation) error {
+	h.conv = conv
+	return htmlTemplate.ExecuteTemplate(h.w, "head", h.page())
+}
+
+func (h *htmlWriter) Write(m *model.Message) error {
+	h.count++
+
+	msg := &htmlMessage{
+		Seq:  m.Seq,
+		Time: m.Time.Format("15:04:05"),
+		Self: m.IsSelf,
+		Name: m.SenderName,
+	}
+	if msg.Name == "" {
+		msg.Name = m.Sender
+	}
+	if day := m.Time.Format("2006-01-02"); day != h.lastDay {
+		msg.Day = day
+		h.lastDay = day
+	}
+
+	switch {
+	case m.Type == model.MessageTypeSystem,
+		m.Type == model.MessageTypeShare && m.SubType == model.MessageSubTypePat:
+		msg.System = true
+		msg.Body = &htmlBody{Kind: "text", Text: m.PlainTextContent()}
+	default:
+		msg.ShowName = m.IsChatRoom && !m.IsSelf
+		msg.Avatar = h.avatar(m)
+		msg.Body = h.body(m)
+		msg.Quote = h.quote(m)
+	}
+
+	return htmlTemplate.ExecuteTemplate(h.w, "message", msg)
+}
+
+func (h *htmlWriter) End() error {
+	return htmlTemplate.ExecuteTemplate(h.w, "foot", h.page())
+}
+
+func (h *htmlWriter) page() *htmlPage {
+	return &htmlPage{
+		Title:     h.conv.Title(),
+		Talker:    h.conv.Talker,
+		Range:     h.conv.Start.Format("2006-01-02 15:04") + " ~ " + h.conv.End.Format("2006-01-02 15:04"),
+		Count:     h.count,
+		Generated: time.Now().Format("2006-01-02 15:04:05"),
+	}
+}
+
+// avatar 发送人头像，复制媒体时下载到导出目录，失败时显示名称首字
+func (h *htmlWriter) avatar(m *model.Message) htmlAvatar {
+	key := m.Sender
+	if m.IsSelf {
+		key = ""
+	}
+	if a, ok := h.avatars[key]; ok {
+		return a
+	}
+
+	var a htmlAvatar
+	name := []rune(m.SenderName)
+	if len(name) == 0 {
+		name = []rune(m.Sender)
+	}
+	if len(name) > 0 {
+		a.Initial = string(name[0])
+	}
+
+	var url string
+	if m.IsSelf {
+		url = h.src.GetSelfSmallHeadImgUrl()
+	} else if contact, err := h.src.GetContact(m.Sender); err == nil {
+		url = contact.SmallHeadImgUrl
+	}
+	if h.media != nil {
+		a.Src, _ = h.media.Download(h.ctx, "avatar", url)
+	} else {
+		a.Src = url
+	}
+
+	h.avatars[key] = a
+	return a
+}
+
+func (h *htmlWriter) body(m *model.Message) *htmlBody {
+	str := func(key string) string {
+		s, _ := m.Contents[key].(string)
+		return s
+	}
+
+	switch m.Type {
+	case model.MessageTypeText:
+		return &htmlBody{Kind: "text", Text: m.Content}
+	case model.MessageTypeImage:
+		return &htmlBody{Kind: "image", Src: h.mediaSrc(m)}
+	case model.MessageTypeVideo:
+		return &htmlBody{Kind: "video", Src: h.mediaSrc(m)}
+	case model.MessageTypeVoice:
+		return &htmlBody{Kind: "voice", Src: h.mediaSrc(m)}
+	case model.MessageTypeAnimation:
+		src := str("cdnurl")
+		if h.media != nil {
+			src, _ = h.media.Download(h.ctx, "emoji", src)
+		}
+		return &htmlBody{Kind: "emoji", Src: src}
+	case model.MessageTypeLocation:
+		return &htmlBody{Kind: "location", Title: str("label"), Desc: str("cityname")}
+	case model.MessageTypeShare:
+		switch m.SubType {
+		case model.MessageSubTypeFile:
+			return &htmlBody{Kind: "file", Title: str("title"), Src: h.mediaSrc(m)}
+		case model.MessageSubTypeText:
+			return &htmlBody{Kind: "link", Title: str("title"), URL: str("desc")}
+		case model.MessageSubTypeLink, model.MessageSubTypeLink2, model.MessageSubTypeMusic,
+			model.MessageSubTypeMiniProgram, model.MessageSubTypeMiniProgram2, model.MessageSubTypeChannel:
+			if str("title") == "" {
+				break
+			}
+			return &htmlBody{Kind: "link", Title: str("title"), Desc: str("desc"), URL: str("url")}
+		case model.MessageSubTypeMergeForward, model.MessageSubTypeNote, model.MessageSubTypeChatRoomNotice:
+			if info, ok := m.Contents["recordInfo"].(*model.RecordInfo); ok {
+				return h.record(info, str("title"), recordLabel(m.SubType))
+			}
+		case model.MessageSubTypeQuote:
+			return &htmlBody{Kind: "text", Text: m.Content}
+		}
+	}
+	return &htmlBody{Kind: "text", Text: strings.TrimRight(Content(m, h.host), "\n")}
+}
+
+// mediaSrc 媒体链接，优先使用导出目录中的文件，否则指向 HTTP 服务
+func (h *htmlWriter) mediaSrc(m *model.Message) string {
+	if rel := localPath(m); rel != "" {
+		return rel
+	}
+	_type, keys := mediaKeys(m)
+	return h.remoteSrc(_type, keys...)
+}
+
+func (h *htmlWriter) remoteSrc(_type string, keys ...string) string {
+	if h.host == "" || len(keys) == 0 || keys[0] == "" {
+		return ""
+	}
+	return model.MediaURL(h.host, _type, strings.Join(keys, ","))
+}
+
+// resolve 复制聊天记录、引用消息中的媒体文件
+func (h *htmlWriter) resolve(_type, key, title string) string {
+	if key == "" {
+		return ""
+	}
+	if h.media != nil {
+		if rel, ok := h.media.Resolve(h.ctx, _type, []string{key}, title); ok {
+			return rel
+		}
+	}
+	return h.remoteSrc(_type, key)
+}
+
+// quote 引用消息的发送人和内容摘要，图片引用附带缩略图
+func (h *htmlWriter) quote(m *model.Message) *htmlQuote {
+	if m.Type != model.MessageTypeShare || m.SubType != model.MessageSubTypeQuote {
+		return nil
+	}
+	refer, ok := m.Contents["refer"].(*model.Message)
+	if !ok {
+		return nil
+	}
+
+	q := &htmlQuote{Name: refer.SenderName, Text: summary(refer)}
+	if q.Name == "" {
+		q.Name = refer.Sender
+	}
+	if refer.Type == model.MessageTypeImage {
+		md5, _ := refer.Contents["md5"].(string)
+		q.Src = h.resolve("image", md5, "")
+	}
+	return q
+}
+
+// record 展开聊天记录，嵌套的聊天记录递归展开
+func (h *htmlWriter) record(info *model.RecordInfo, title, label string) *htmlBody {
+	if title == "" {
+		title = info.Title
+	}
+	if title == "" {
+		title = strings.TrimSpace(strings.ReplaceAll(info.Desc, "\n", " "))
+		if runes := []rune(title); len(runes) > 40 {
+			title = string(runes[:40]) + "…"
+		}
+	}
+	if title == "" {
+		title = label
+	}
+
+	body := &htmlBody{Kind: "record", Title: title, Record: &htmlRecord{}}
+	for _, item := range info.DataList.DataItems {
+		var b *htmlBody
+		switch item.DataType {
+		case "1":
+			b = &htmlBody{Kind: "text", Text: item.DataDesc}
+		case "2":
+			b = &htmlBody{Kind: "image", Src: h.resolve("image", item.FullMD5, "")}
+		case "4":
+			b = &htmlBody{Kind: "video", Src: h.resolve("video", item.FullMD5, "")}
+		case "5":
+			b = &htmlBody{Kind: "link", Title: item.DataTitle, Desc: item.DataDesc, URL: item.Link}
+		case "6":
+			b = &htmlBody{Kind: "location", Title: item.Location.PoiName, Desc: item.Location.Label}
+		case "8":
+			// 笔记的第一条是 htm 数据，跳过
+			if item.DataFmt == ".htm" {
+				continue
+			}
+			b = &htmlBody{Kind: "file", Title: item.DataTitle, Src: h.resolve("file", item.FullMD5, item.DataTitle)}
+		case "17":
+			if item.RecordXML == nil {
+				b = &htmlBody{Kind: "text", Text: "[聊天记录]"}
+				break
+			}
+			b = h.record(&item.RecordXML.RecordInfo, item.DataTitle, "聊天记录")
+		case "22":
+			b = &htmlBody{Kind: "text", Text: "[视频号]" + strings.TrimSpace(item.DataDesc)}
+		case "23":
+			b = &htmlBody{Kind: "text", Text: "[视频号直播]" + strings.TrimSpace(item.DataDesc)}
+		case "32":
+			b = &htmlBody{Kind: "link", Title: item.DataTitle, Desc: item.DataDesc, URL: item.StreamWebURL}
+		case "37":
+			b = &htmlBody{Kind: "text", Text: "[动画表情]"}
+		default:
+			b = &htmlBody{Kind: "text", Text: item.DataDesc}
+		}
+		body.Record.Items = append(body.Record.Items, &htmlRecordItem{
+			Name: item.SourceName,
+			Time: item.SourceTime,
+			Body: b,
+		})
+	}
+	return body
+}
+
+func recordLabel(subType int64) string {
+	switch subType {
+	case model.MessageSubTypeNote:
+		return "笔记"
+	case model.MessageSubTypeChatRoomNotice:
+		return "群公告"
+	default:
+		return "聊天记录"
+	}
+}
+
+// summary 引用消息的单行摘要
+func summary(m *model.Message) string {
+	switch m.Type {
+	case model.MessageTypeText:
+		return m.Content
+	case model.MessageTypeImage:
+		return "[图片]"
+	case model.MessageTypeVoice:
+		return "[语音]"
+	case model.MessageTypeVideo:
+		return "[视频]"
+	case model.MessageTypeCard:
+		return "[名片]"
+	case model.MessageTypeAnimation:
+		return "[动画表情]"
+	case model.MessageTypeLocation:
+		return "[位置]"
+	case model.MessageTypeShare:
+		if m.SubType == model.MessageSubTypeQuote && m.Content != "" {
+			return m.Content
+		}
+		if title, _ := m.Contents["title"].(string); title != "" {
+			return "[分享]" + title
+		}
+		return "[分享]"
+	}
+	return m.Content
+}
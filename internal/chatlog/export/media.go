@@ -0,0 +1,312 @@
+package export
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/pkg/util/dat2img"
+	"github.com/sjzar/chatlog/pkg/util/silk"
+)
+
+// ContentLocalPath 导出后媒体文件相对于导出文件的路径，写入 Message.Contents
+const ContentLocalPath = "localpath"
+
+const (
+	// downloadTimeout 单个远程图片的下载超时时间
+	downloadTimeout = 10 * time.Second
+
+	// downloadMaxSize 单个远程图片的大小上限
+	downloadMaxSize = 10 << 20
+)
+
+// mediaExporter 将单个会话引用的媒体文件复制到导出目录
+// 图片解码为普通图片格式，语音转换为 mp3，视频和文件原样复制
+type mediaExporter struct {
+	src     Source
+	dataDir string
+	outDir  string
+	relDir  string
+	private bool
+
+	done    map[string]string // 媒体 key -> 相对路径，同一媒体只处理一次，失败时为空
+	copied  int
+	missing int
+}
+
+func newMediaExporter(src Source, dataDir, outDir, relDir string, private bool) *mediaExporter {
+	return &mediaExporter{
+		src:     src,
+		dataDir: dataDir,
+		outDir:  outDir,
+		relDir:  relDir,
+		private: private,
+		done:    make(map[string]string),
+	}
+}
+
+// Export 复制消息引用的媒体文件，成功后在消息中记录相对路径
+func (e *mediaExporter) Export(ctx context.Context, m *model.Message) {
+	_type, keys := mediaKeys(m)
+	title, _ := m.Contents["title"].(string)
+	if rel, ok := e.Resolve(ctx, _type, keys, title); ok {
+		m.SetContent(ContentLocalPath, rel)
+	}
+}
+
+// Resolve 复制指定的媒体文件并返回相对路径，title 为文件消息的原始文件名
+func (e *mediaExporter) Resolve(ctx context.Context, _type string, keys []string, title string) (string, bool) {
+	if _type == "" || len(keys) == 0 || ctx.Err() != nil {
+		return "", false
+	}
+
+	cacheKey := _type + ":" + keys[0]
+	if rel, ok := e.done[cacheKey]; ok {
+		return rel, rel != ""
+	}
+
+	rel, err := e.export(_type, keys, title)
+	e.done[cacheKey] = rel
+	if err != nil {
+		log.Debug().Err(err).Msgf("export %s %v failed", _type, keys)
+		e.missing++
+		return "", false
+	}
+	e.copied++
+	return rel, true
+}
+
+// Download 下载远程图片（头像、表情）到导出目录，失败时返回 false
+func (e *mediaExporter) Download(ctx context.Context, _type string, rawURL string) (string, bool) {
+	if rawURL == "" || ctx.Err() != nil {
+		return "", false
+	}
+
+	cacheKey := _type + ":" + rawURL
+	if rel, ok := e.done[cacheKey]; ok {
+		return rel, rel != ""
+	}
+
+	rel, err := e.download(ctx, _type, rawURL)
+	e.done[cacheKey] = rel
+	if err != nil {
+		log.Debug().Err(err).Msgf("download %s failed", rawURL)
+		return "", false
+	}
+	return rel, true
+}
+
+func (e *mediaExporter) download(ctx context.Context, _type string, rawURL string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, downloadTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, downloadMaxSize))
+	if err != nil {
+		return "", err
+	}
+
+	ext := "jpg"
+	switch http.DetectContentType(data) {
+	case "image/png":
+		ext = "png"
+	case "image/gif":
+		ext = "gif"
+	case "image/webp":
+		ext = "webp"
+	}
+	sum := md5.Sum([]byte(rawURL))
+	return e.write(_type, hex.EncodeToString(sum[:])+"."+ext, data)
+}
+
+func (e *mediaExporter) export(_type string, keys []string, title string) (string, error) {
+	if _type == "voice" {
+		media, err := e.src.GetMedia(_type, keys[0])
+		if err != nil {
+			return "", err
+		}
+		data, ext := media.Data, "silk"
+		if out, err := silk.Silk2MP3(media.Data); err == nil {
+			data, ext = out, "mp3"
+		}
+		return e.write(_type, keys[0]+"."+ext, data)
+	}
+
+	absPath, err := e.findFile(_type, keys)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		return "", err
+	}
+
+	id := filepath.Base(keys[0])
+	name := filepath.Base(absPath)
+	switch _type {
+	case "image":
+		name = id + filepath.Ext(absPath)
+		if strings.EqualFold(filepath.Ext(absPath), ".dat") {
+			if out, ext, err := dat2img.Dat2Image(data); err == nil {
+				data, name = out, id+"."+ext
+			}
+		}
+	case "file":
+		// 保留原始文件名，加上 md5 前缀避免重名
+		if title != "" {
+			name = FileName(title)
+		}
+		prefix := id
+		if len(prefix) > 8 {
+			prefix = prefix[:8]
+		}
+		name = prefix + "_" + name
+	}
+	return e.write(_type, name, data)
+}
+
+// findFile 在数据目录中查找媒体文件，优先使用消息中记录的路径，其次查询媒体库
+func (e *mediaExporter) findFile(_type string, keys []string) (string, error) {
+	for _, key := range keys {
+		if !strings.ContainsAny(key, `/\`) {
+			continue
+		}
+		base, err := e.dataPath(key)
+		if err != nil {
+			continue
+		}
+		suffixes := []string{""}
+		switch _type {
+		case "image":
+			suffixes = append(suffixes, "_h.dat", ".dat", "_t.dat")
+		case "video":
+			suffixes = append(suffixes, ".mp4")
+		}
+		for _, suffix := range suffixes {
+			if info, err := os.Stat(base + suffix); err == nil && !info.IsDir() {
+				return base + suffix, nil
+			}
+		}
+	}
+
+	var lastErr error
+	for _, key := range keys {
+		if strings.ContainsAny(key, `/\`) {
+			continue
+		}
+		media, err := e.src.GetMedia(_type, key)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		absPath, err := e.dataPath(media.Path)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if _, err := os.Stat(absPath); err != nil {
+			lastErr = err
+			continue
+		}
+		return absPath, nil
+	}
+	if lastErr == nil {
+		lastErr = os.ErrNotExist
+	}
+	return "", lastErr
+}
+
+// dataPath 将消息中的相对路径转换为数据目录中的路径，拒绝绝对路径和指向数据目录之外的路径
+func (e *mediaExporter) dataPath(rel string) (string, error) {
+	rel = filepath.Clean(strings.TrimPrefix(filepath.FromSlash(rel), string(filepath.Separator)))
+	if rel == "." || filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.InvalidArg("path")
+	}
+	return filepath.Join(e.dataDir, rel), nil
+}
+
+func (e *mediaExporter) write(_type, name string, data []byte) (string, error) {
+	dir := filepath.Join(e.outDir, e.relDir, _type)
+	if err := os.MkdirAll(dir, dirMode(e.private)); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), data, fileMode(e.private)); err != nil {
+		return "", err
+	}
+	return path.Join(e.relDir, _type, name), nil
+}
+
+// fileMode/dirMode 导出文件与目录的权限，private 时仅当前用户可访问
+func fileMode(private bool) os.FileMode {
+	if private {
+		return 0600
+	}
+	return 0644
+}
+
+func dirMode(private bool) os.FileMode {
+	if private {
+		return 0700
+	}
+	return 0755
+}
+
+// mediaKeys 返回消息引用的媒体类型及用于查找的 key（md5 或数据目录下的相对路径）
+func mediaKeys(m *model.Message) (string, []string) {
+	str := func(key string) string {
+		s, _ := m.Contents[key].(string)
+		return s
+	}
+
+	var _type string
+	var keys []string
+	switch {
+	case m.Type == model.MessageTypeImage:
+		_type, keys = "image", []string{str("md5"), str("path")}
+	case m.Type == model.MessageTypeVideo:
+		_type, keys = "video", []string{str("md5"), str("rawmd5"), str("path")}
+	case m.Type == model.MessageTypeVoice:
+		_type, keys = "voice", []string{str("voice")}
+	case m.Type == model.MessageTypeShare && m.SubType == model.MessageSubTypeFile:
+		_type, keys = "file", []string{str("md5")}
+	default:
+		return "", nil
+	}
+
+	result := keys[:0]
+	for _, key := range keys {
+		if key != "" {
+			result = append(result, key)
+		}
+	}
+	return _type, result
+}
+
+// localPath 返回消息中记录的媒体相对路径
+func localPath(m *model.Message) string {
+	s, _ := m.Contents[ContentLocalPath].(string)
+	return s
+}
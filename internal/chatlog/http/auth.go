@@ -0,0 +1,213 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/sjzar/chatlog/internal/chatlog/conf"
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/internal/wechatdb"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+// tokenCookie 通过 ?token= 访问时写入的 Cookie，使内置网页的后续请求无需再携带令牌
+const tokenCookie = "chatlog_token"
+
+type tokenKey struct{}
+
+// authMiddleware 校验访问令牌及其权限范围，未配置令牌时不做限制
+// 令牌可以通过 Authorization: Bearer 请求头、?token= 查询参数（用于多媒体链接）或 Cookie 传递
+func (s *Service) authMiddleware(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		auth := s.conf.GetAuth()
+		if !auth.Enabled() {
+			return
+		}
+
+		value, fromQuery := requestToken(c.Request)
+		token := auth.Lookup(value)
+		if token == nil {
+			c.Header("WWW-Authenticate", `Bearer realm="chatlog"`)
+			errors.Err(c, errors.Unauthorized())
+			c.Abort()
+			return
+		}
+		if !token.HasScope(scope) {
+			errors.Err(c, errors.Forbidden(scope))
+			c.Abort()
+			return
+		}
+		if fromQuery {
+			// 通过 HTTPS 访问时设置 Secure，浏览器不会在明文 HTTP 请求中发送令牌
+			c.SetSameSite(http.SameSiteStrictMode)
+			c.SetCookie(tokenCookie, value, 0, "/", "", c.Request.TLS != nil, true)
+		}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), tokenKey{}, token))
+	}
+}
+
+// requestToken 读取请求中的令牌，fromQuery 表示令牌来自查询参数
+func requestToken(r *http.Request) (value string, fromQuery bool) {
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:]), false
+	}
+	if value := r.URL.Query().Get("token"); value != "" {
+		return value, true
+	}
+	if cookie, err := r.Cookie(tokenCookie); err == nil {
+		return cookie.Value, false
+	}
+	return "", false
+}
+
+// tokenFrom 返回请求使用的令牌，未启用认证或不是 HTTP 请求（如 stdio）时返回 nil
+func tokenFrom(ctx context.Context) *conf.Token {
+	token, _ := ctx.Value(tokenKey{}).(*conf.Token)
+	return token
+}
+
+// checkScope 检查令牌的权限范围，MCP 的工具、资源与提示词在 mcp 权限之外还需要对应数据的权限
+func checkScope(ctx context.Context, scope string) error {
+	if token := tokenFrom(ctx); token != nil && !token.HasScope(scope) {
+		return errors.Forbidden(scope)
+	}
+	return nil
+}
+
+// toolScope 与 REST 路由分组相同，调用 MCP 工具前检查权限范围
+func toolScope(scope string, h server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if err := checkScope(ctx, scope); err != nil {
+			return errors.ErrMCPTool(err), nil
+		}
+		return h(ctx, request)
+	}
+}
+
+// resourceScope 读取 MCP 资源前检查权限范围，可用于资源与资源模板
+func resourceScope(scope string, h func(context.Context, mcp.ReadResourceRequest) ([]mcp.ResourceContents, error)) func(context.Context, mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	return func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+		if err := checkScope(ctx, scope); err != nil {
+			return nil, err
+		}
+		return h(ctx, request)
+	}
+}
+
+// promptScope 获取 MCP 提示词前检查权限范围
+func promptScope(scope string, h server.PromptHandlerFunc) server.PromptHandlerFunc {
+	return func(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		if err := checkScope(ctx, scope); err != nil {
+			return nil, err
+		}
+		return h(ctx, request)
+	}
+}
+
+// allowedTalkers 令牌允许访问的会话 ID，nil 表示不限制
+func (s *Service) allowedTalkers(ctx context.Context) map[string]bool {
+	token := tokenFrom(ctx)
+	if token == nil || len(token.Talkers) == 0 {
+		return nil
+	}
+	allowed := make(map[string]bool)
+	for _, talker := range s.db.ResolveTalkers(strings.Join(token.Talkers, ",")) {
+		allowed[talker] = true
+	}
+	return allowed
+}
+
+// checkTalker 检查逗号分隔的会话是否都在令牌允许的范围内
+func (s *Service) checkTalker(ctx context.Context, talker string) error {
+	allowed := s.allowedTalkers(ctx)
+	if allowed == nil {
+		return nil
+	}
+	talkers := s.db.ResolveTalkers(talker)
+	if len(talkers) == 0 {
+		return errors.TalkerForbidden(talker)
+	}
+	for _, t := range talkers {
+		if !allowed[t] {
+			return errors.TalkerForbidden(t)
+		}
+	}
+	return nil
+}
+
+// checkMedia 检查会话过滤策略，令牌限制了会话时，只允许访问能确定属于允许的会话的媒体文件，path 为数据目录下的相对路径
+// 只有图片按会话保存（见 model.MediaTalkerHash），视频、文件和语音无法确定所属会话，一律拒绝
+func (s *Service) checkMedia(ctx context.Context, path string) error {
+	if err := s.db.CheckMedia(path); err != nil {
+		return err
+	}
+	allowed := s.allowedTalkers(ctx)
+	if allowed == nil {
+		return nil
+	}
+	if hash, ok := model.MediaTalkerHash(path); ok {
+		for talker := range allowed {
+			if model.TalkerHash(talker) == hash {
+				return nil
+			}
+		}
+	}
+	return errors.MediaForbidden(path)
+}
+
+// 以下查询在令牌限制了会话时，读取全部结果后只保留允许访问的会话再分页
+
+func (s *Service) getContacts(ctx context.Context, key string, limit, offset int) (*wechatdb.GetContactsResp, error) {
+	allowed := s.allowedTalkers(ctx)
+	if allowed == nil {
+		return s.db.GetContacts(key, -1, limit, offset)
+	}
+	list, err := s.db.GetContacts(key, -1, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	items := util.Filter(list.Items, func(c *model.Contact) bool { return allowed[c.UserName] })
+	return &wechatdb.GetContactsResp{Total: len(items), Items: util.Page(items, limit, offset)}, nil
+}
+
+func (s *Service) getChatRooms(ctx context.Context, key string, limit, offset int) (*wechatdb.GetChatRoomsResp, error) {
+	allowed := s.allowedTalkers(ctx)
+	if allowed == nil {
+		return s.db.GetChatRooms(key, limit, offset)
+	}
+	list, err := s.db.GetChatRooms(key, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	items := util.Filter(list.Items, func(c *model.ChatRoom) bool { return allowed[c.Name] })
+	return &wechatdb.GetChatRoomsResp{Total: len(items), Items: util.Page(items, limit, offset)}, nil
+}
+
+func (s *Service) getSessions(ctx context.Context, key string, limit, offset int) (*wechatdb.GetSessionsResp, error) {
+	allowed := s.allowedTalkers(ctx)
+	if allowed == nil {
+		return s.db.GetSessions(key, limit, offset)
+	}
+	list, err := s.db.GetSessions(key, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	items := util.Filter(list.Items, func(s *model.Session) bool { return allowed[s.TopicID] })
+	return &wechatdb.GetSessionsResp{Total: len(items), Items: util.Page(items, limit, offset)}, nil
+}
+
+// searchMessages 将令牌可以访问的会话交给搜索过滤，其他会话不占用搜索的命中上限
+func (s *Service) searchMessages(ctx context.Context, start, end time.Time, keyword string, sender string, cursor string, limit, offset int) (*wechatdb.SearchMessagesResp, error) {
+	var filter func(talker string) bool
+	if allowed := s.allowedTalkers(ctx); allowed != nil {
+		filter = func(talker string) bool { return allowed[talker] }
+	}
+	return s.db.SearchMessages(start, end, keyword, sender, filter, cursor, limit, offset)
+}
@@ -0,0 +1,115 @@
+package http
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sjzar/chatlog/internal/chatlog/conf"
+	"github.com/sjzar/chatlog/internal/chatlog/database"
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+type testConfig struct {
+	workDir string
+}
+
+func (c *testConfig) GetWorkDir() string        { return c.workDir }
+func (c *testConfig) GetPlatform() string       { return "windows" }
+func (c *testConfig) GetWebhook() *conf.Webhook { return nil }
+func (c *testConfig) GetPolicy() *conf.Policy   { return nil }
+
+// newTestService 创建只包含联系人数据库的服务，wxid_a 的备注为“张三”
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	dir := t.TempDir()
+	contactDir := filepath.Join(dir, "db_storage", "contact")
+	if err := os.MkdirAll(contactDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", filepath.Join(contactDir, "contact.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{
+		`CREATE TABLE contact (username TEXT, local_type INTEGER, flag INTEGER, delete_flag INTEGER, is_in_chat_room INTEGER,
+			alias TEXT, remark TEXT, nick_name TEXT, small_head_url TEXT, big_head_url TEXT)`,
+		`CREATE TABLE chat_room (username TEXT, owner TEXT, ext_buffer BLOB)`,
+		`INSERT INTO contact VALUES ('wxid_a', 1, 3, 0, 0, '', '张三', 'zhang', '', '')`,
+		`INSERT INTO contact VALUES ('wxid_b', 1, 3, 0, 0, '', '李四', 'li', '', '')`,
+	} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	dbService := database.NewService(&testConfig{workDir: dir})
+	if err := dbService.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dbService.Stop() })
+	return &Service{db: dbService}
+}
+
+func withToken(token *conf.Token) context.Context {
+	if token == nil {
+		return context.Background()
+	}
+	return context.WithValue(context.Background(), tokenKey{}, token)
+}
+
+func TestCheckTalker(t *testing.T) {
+	s := newTestService(t)
+	restricted := &conf.Token{Talkers: []string{"张三"}}
+	tests := []struct {
+		name    string
+		token   *conf.Token
+		talker  string
+		wantErr bool
+	}{
+		{"no token", nil, "wxid_b", false},
+		{"unrestricted token", &conf.Token{}, "wxid_b", false},
+		{"allowed id", restricted, "wxid_a", false},
+		{"allowed remark", restricted, "张三", false},
+		{"denied", restricted, "wxid_b", true},
+		{"denied by nickname", restricted, "li", true},
+		{"one of many denied", restricted, "wxid_a,wxid_b", true},
+		{"empty talker", restricted, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.checkTalker(withToken(tt.token), tt.talker)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkTalker(%q) error = %v, wantErr %v", tt.talker, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckMedia(t *testing.T) {
+	s := newTestService(t)
+	restricted := &conf.Token{Talkers: []string{"张三"}}
+	tests := []struct {
+		name    string
+		token   *conf.Token
+		path    string
+		wantErr bool
+	}{
+		{"no token", nil, "msg/video/2024-01/a.mp4", false},
+		{"allowed image", restricted, "msg/attach/" + model.TalkerHash("wxid_a") + "/2024-01/Img/a.dat", false},
+		{"denied image", restricted, "msg/attach/" + model.TalkerHash("wxid_b") + "/2024-01/Img/a.dat", true},
+		{"video", restricted, "msg/video/2024-01/a.mp4", true},
+		{"file", restricted, "msg/file/2024-01/a.pdf", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.checkMedia(withToken(tt.token), tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkMedia(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package http
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/chatlog/conf"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/atrest"
+)
+
+const (
+	// 自签名证书保存在工作目录的 tls 子目录中
+	selfSignedDir      = "tls"
+	selfSignedCertFile = "cert.pem"
+	selfSignedKeyFile  = "key.pem"
+	selfSignedValidity = 365 * 24 * time.Hour
+)
+
+// listen 监听 TCP 地址或 unix:/path 形式的 unix domain socket
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, conf.UnixAddrPrefix)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	path = strings.TrimPrefix(path, "//")
+	if path == "" {
+		return nil, fmt.Errorf("empty unix socket path")
+	}
+
+	// 清理上次异常退出遗留的 socket 文件，普通文件不删除
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// 只允许当前用户连接
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// tlsConfig 返回 HTTPS 配置，未开启时返回 nil
+func (s *Service) tlsConfig() (*tls.Config, error) {
+	c := s.conf.GetTLS()
+	if !c.IsEnabled() {
+		return nil, nil
+	}
+
+	var cert tls.Certificate
+	certFile := c.CertFile
+	if certFile == "" {
+		workDir := s.conf.GetWorkDir()
+		if workDir == "" {
+			return nil, fmt.Errorf("work dir is required for self-signed certificate")
+		}
+		// 工作目录已加密时私钥加密保存
+		sealKey, err := atrest.Open(workDir)
+		if err != nil {
+			return nil, err
+		}
+		dir := filepath.Join(workDir, selfSignedDir)
+		certFile = filepath.Join(dir, selfSignedCertFile)
+		if cert, err = ensureSelfSignedCert(certFile, filepath.Join(dir, selfSignedKeyFile), s.conf.GetHTTPAddr(), sealKey); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if cert, err = tls.LoadX509KeyPair(certFile, c.KeyFile); err != nil {
+			return nil, err
+		}
+	}
+	if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+		sum := sha256.Sum256(leaf.Raw)
+		log.Info().Msgf("TLS certificate %s, expires %s, sha256 fingerprint %s", certFile, leaf.NotAfter.Format(time.DateOnly), hex.EncodeToString(sum[:]))
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// ensureSelfSignedCert 证书不存在、即将过期或不包含监听地址时重新生成
+// sealKey 不为空时私钥使用工作目录的密钥加密保存
+func ensureSelfSignedCert(certFile, keyFile, addr string, sealKey *atrest.Key) (tls.Certificate, error) {
+	hosts := certHosts(addr)
+	if cert, err := loadSelfSignedCert(certFile, keyFile, sealKey); err == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err == nil && time.Until(leaf.NotAfter) > 30*24*time.Hour && coversHosts(leaf, hosts) {
+			return cert, nil
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"chatlog"}, CommonName: "chatlog self-signed"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	if sealKey != nil {
+		if keyPEM, err = sealKey.Seal(keyPEM); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(certFile), 0700); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		return tls.Certificate{}, err
+	}
+	log.Info().Msgf("generated self-signed TLS certificate %s for %s", certFile, strings.Join(hosts, ", "))
+	return cert, nil
+}
+
+// loadSelfSignedCert 读取自签名证书，工作目录已加密时要求私钥是加密保存的，否则重新生成
+func loadSelfSignedCert(certFile, keyFile string, sealKey *atrest.Key) (tls.Certificate, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if sealKey != nil {
+		if keyPEM, err = sealKey.Unseal(keyPEM); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// certHosts 自签名证书包含的主机名，始终包含本机地址，监听具体地址时一并加入
+func certHosts(addr string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return hosts
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		return hosts
+	}
+	for _, h := range hosts {
+		if h == host {
+			return hosts
+		}
+	}
+	return append(hosts, host)
+}
+
+func coversHosts(cert *x509.Certificate, hosts []string) bool {
+	for _, host := range hosts {
+		if cert.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,18 +15,29 @@ import (
 	"github.com/sjzar/chatlog/internal/chatlog/conf"
 	"github.com/sjzar/chatlog/internal/errors"
 	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/internal/wechatdb"
 	"github.com/sjzar/chatlog/pkg/util"
 	"github.com/sjzar/chatlog/pkg/version"
 )
 
 func (s *Service) initMCPServer() {
-	s.mcpServer = server.NewMCPServer(conf.AppName, version.Version)
-	s.mcpServer.AddTool(ContactTool, s.handleMCPContact)
-	s.mcpServer.AddTool(ChatRoomTool, s.handleMCPChatRoom)
-	s.mcpServer.AddTool(RecentChatTool, s.handleMCPRecentChat)
-	s.mcpServer.AddTool(ChatLogTool, s.handleMCPChatLog)
+	hooks := &server.Hooks{}
+	s.initMCPSubscriptions(hooks)
+	s.mcpServer = server.NewMCPServer(conf.AppName, version.Version,
+		server.WithResourceCapabilities(false, false),
+		server.WithHooks(hooks),
+	)
+	s.mcpServer.AddTool(ContactTool, toolScope(conf.ScopeContacts, s.handleMCPContact))
+	s.mcpServer.AddTool(ChatRoomTool, toolScope(conf.ScopeContacts, s.handleMCPChatRoom))
+	s.mcpServer.AddTool(RecentChatTool, toolScope(conf.ScopeMessages, s.handleMCPRecentChat))
+	s.mcpServer.AddTool(ChatLogTool, toolScope(conf.ScopeMessages, s.handleMCPChatLog))
+	s.mcpServer.AddTool(SearchChatLogTool, toolScope(conf.ScopeMessages, s.handleMCPSearchChatLog))
+	s.mcpServer.AddTool(MessageContextTool, toolScope(conf.ScopeMessages, s.handleMCPMessageContext))
+	s.mcpServer.AddTool(ChatStatsTool, toolScope(conf.ScopeMessages, s.handleMCPChatStats))
 	s.mcpServer.AddTool(CurrentTimeTool, s.handleMCPCurrentTime)
-	s.mcpSSEServer = server.NewSSEServer(s.mcpServer)
+	s.initMCPResources()
+	s.initMCPPrompts()
+	s.mcpSSEServer = server.NewSSEServer(s.mcpServer, server.WithSSEContextFunc(withoutSubscribe))
 	s.mcpStreamableServer = server.NewStreamableHTTPServer(s.mcpServer)
 }
 
@@ -33,68 +45,36 @@ var ContactTool = mcp.NewTool(
 	"query_contact",
 	mcp.WithDescription(`查询用户的联系人信息。可以通过姓名、备注名或ID进行查询，返回匹配的联系人列表。当用户询问某人的联系方式、想了解联系人信息或需要查找特定联系人时使用此工具。参数为空时，将返回联系人列表`),
 	mcp.WithString("keyword", mcp.Description("联系人的搜索关键词，可以是姓名、备注名或ID。")),
+	mcp.WithNumber("limit", mcp.Description("返回结果数量，不指定时返回全部")),
+	mcp.WithNumber("offset", mcp.Description("结果偏移量，用于翻页")),
+	mcp.WithOutputSchema[ContactOutput](),
 )
 
 var ChatRoomTool = mcp.NewTool(
 	"query_chat_room",
 	mcp.WithDescription(`查询用户参与的群聊信息。可以通过群名称、群ID或相关关键词进行查询，返回匹配的群聊列表。当用户询问群聊信息、想了解某个群的详情或需要查找特定群聊时使用此工具。`),
 	mcp.WithString("keyword", mcp.Description("群聊的搜索关键词，可以是群名称、群ID或相关描述")),
+	mcp.WithNumber("limit", mcp.Description("返回结果数量，不指定时返回全部")),
+	mcp.WithNumber("offset", mcp.Description("结果偏移量，用于翻页")),
+	mcp.WithOutputSchema[ChatRoomOutput](),
 )
 
 var RecentChatTool = mcp.NewTool(
 	"query_recent_chat",
 	mcp.WithDescription(`查询最近会话列表，包括个人聊天和群聊。当用户想了解最近的聊天记录、查看最近联系过的人或群组时使用此工具。不需要参数，直接返回最近的会话列表。`),
+	mcp.WithNumber("limit", mcp.Description("返回结果数量，不指定时返回全部")),
+	mcp.WithNumber("offset", mcp.Description("结果偏移量，用于翻页")),
+	mcp.WithOutputSchema[RecentChatOutput](),
 )
 
+// chatLogMaxLimit query_chat_log 与 chatlog://chat 资源单次返回的最大消息数量
+const chatLogMaxLimit = 1000
+
 var ChatLogTool = mcp.NewTool(
 	"query_chat_log",
 	mcp.WithDescription(`检索历史聊天记录，可根据时间、对话方、发送者和关键词等条件进行精确查询。当用户需要查找特定信息或想了解与某人/某群的历史交流时使用此工具。
 
-【强制多步查询流程!】
-当查询特定话题或特定发送者发言时，必须严格按照以下流程使用，任何偏离都会导致错误的结果：
-
-步骤1: 初步定位相关消息
-- 使用keyword参数查找特定话题
-- 使用sender参数查找特定发送者的消息
-- 使用较宽时间范围初步查询
-
-步骤2: 【必须执行】针对每个关键结果点分别获取上下文
-- 必须对步骤1返回的每个时间点T1, T2, T3...分别执行独立查询（时间范围接近的消息可以合并为一个查询）
-- 每次独立查询必须移除keyword参数
-- 每次独立查询必须移除sender参数
-- 每次独立查询使用"Tn前后15-30分钟"的窄范围
-- 每次独立查询仅保留talker参数
-
-步骤3: 【必须执行】综合分析所有上下文
-- 必须等待所有步骤2的查询结果返回后再进行分析
-- 必须综合考虑所有上下文信息后再回答用户
-
-【严格执行规则！】
-- 禁止仅凭步骤1的结果直接回答用户
-- 禁止在步骤2使用过大的时间范围一次性查询所有上下文
-- 禁止跳过步骤2或步骤3
-- 必须对每个关键结果点分别执行独立的上下文查询
-
-【执行示例】
-正确流程示例:
-1. 步骤1: chatlog(time="2023-04-01~2023-04-30", talker="工作群", keyword="项目进度")
-返回结果: 4月5日、4月12日、4月20日有相关消息
-2. 步骤2:
-- 查询1: chatlog(time="2023-04-05/09:30~2023-04-05/10:30", talker="工作群") // 注意没有keyword
-- 查询2: chatlog(time="2023-04-12/14:00~2023-04-12/15:00", talker="工作群") // 注意没有keyword
-- 查询3: chatlog(time="2023-04-20/16:00~2023-04-20/17:00", talker="工作群") // 注意没有keyword
-3. 步骤3: 综合分析所有上下文后回答用户
-
-错误流程示例:
-- 仅执行步骤1后直接回答
-- 步骤2使用time="2023-04-01~2023-04-30"一次性查询
-- 步骤2仍然保留keyword或sender参数
-
-【自我检查】回答用户前必须自问:
-- 我是否对每个关键时间点都执行了独立的上下文查询?
-- 我是否在上下文查询中移除了keyword和sender参数?
-- 我是否分析了所有上下文后再回答?
-- 如果上述任一问题答案为"否"，则必须纠正流程
+查看某条消息前后的完整对话时，请使用 get_message_context 工具，传入 talker 和消息的 seq，不要使用 keyword 或 sender 参数重新查询时间范围。
 
 返回格式："昵称(ID) 时间\n消息内容\n昵称(ID) 时间\n消息内容"
 当查询多个Talker时，返回格式为："昵称(ID)\n[TalkerName(Talker)] 时间\n消息内容"
@@ -124,24 +104,63 @@ var ChatLogTool = mcp.NewTool(
 - 月份："2023-04"或"202304"`), mcp.Required()),
 	mcp.WithString("talker", mcp.Description(`指定对话方（联系人或群组）
 - 可使用ID、昵称或备注名
-- 多个对话方用","分隔，如："张三,李四,工作群"
-- 【重要】这是多步查询中唯一应保留的参数`), mcp.Required()),
+- 多个对话方用","分隔，如："张三,李四,工作群"`), mcp.Required()),
 	mcp.WithString("sender", mcp.Description(`指定群聊中的发送者
 - 仅在查询群聊记录时有效
 - 多个发送者用","分隔，如："张三,李四"
-- 可使用ID、昵称或备注名
-【重要】查询特定发送者的消息时：
-1. 第一步：使用sender参数初步定位多个相关消息时间点
-2. 后续步骤：必须移除sender参数，分别查询每个时间点前后的完整对话
-3. 错误示例：对所有找到的消息一次性查询大范围上下文
-4. 正确示例：对每个时间点T分别执行查询"T前后15-30分钟"（不带sender）`)),
+- 可使用ID、昵称或备注名`)),
 	mcp.WithString("keyword", mcp.Description(`搜索内容中的关键词
 - 支持正则表达式匹配
-- 【重要】查询特定话题时：
-1. 第一步：使用keyword参数初步定位多个相关消息时间点
-2. 后续步骤：必须移除keyword参数，分别查询每个时间点前后的完整对话
-3. 错误示例：对所有找到的关键词消息一次性查询大范围上下文
-4. 正确示例：对每个时间点T分别执行查询"T前后15-30分钟"（不带keyword）`)),
+- 命中的消息可使用 get_message_context 工具查看上下文`)),
+	mcp.WithNumber("limit", mcp.Description("返回消息数量，默认且最多 1000 条，更多消息使用 cursor 翻页")),
+	mcp.WithNumber("offset", mcp.Description("消息偏移量，用于翻页")),
+	mcp.WithString("cursor", mcp.Description("分页游标，使用上一次结果中的 nextCursor 继续查询，比 offset 翻页更高效")),
+	mcp.WithOutputSchema[ChatLogOutput](),
+)
+
+var SearchChatLogTool = mcp.NewTool(
+	"search_chat_log",
+	mcp.WithDescription(`在所有会话中搜索包含关键词的聊天记录，不需要指定对话方。当用户想知道"谁在什么时候提到过某件事"、但不确定是在哪个聊天中时使用此工具。
+返回按命中次数和时间排序的命中列表（命中次数多的在前，次数相同时较新的在前），每条包含对话方、发送者、时间和内容摘要。
+如需查看命中消息的完整上下文，请使用 get_message_context 工具，传入命中结果的 talker 和 seq，同一会话的多个命中可以一次查询。
+
+返回格式："[TalkerName(Talker)] SenderName(Sender) 时间 seq=消息序号\n内容摘要"
+结果较多时，返回内容末尾会提示总数和下一页的 cursor，结果的顺序固定，使用 cursor 翻页不会重复或遗漏。
+命中过多导致搜索提前结束（truncated）时结果不支持翻页，请缩小时间范围或增加关键词。`),
+	mcp.WithString("keyword", mcp.Description(`搜索关键词
+- 多个关键词用空格分隔，需要同时命中，如："发票 4471"
+- 英文和数字按单词前缀匹配，不区分大小写
+- 中文按子串匹配
+- 不支持正则表达式`), mcp.Required()),
+	mcp.WithString("time", mcp.Description(`限定搜索的时间范围，格式与 query_chat_log 的 time 参数相同，如："2023-04-01~2023-04-30"、"last-30d"。不指定时搜索全部时间`)),
+	mcp.WithString("sender", mcp.Description(`限定发送者，可使用ID、昵称或备注名，多个发送者用","分隔`)),
+	mcp.WithNumber("limit", mcp.Description(`返回结果数量，默认 20，最大 100`)),
+	mcp.WithString("cursor", mcp.Description("分页游标，使用上一次结果中的 nextCursor 继续查询")),
+	mcp.WithNumber("offset", mcp.Description(`结果偏移量，建议使用 cursor 翻页`)),
+	mcp.WithOutputSchema[SearchChatLogOutput](),
+)
+
+var MessageContextTool = mcp.NewTool(
+	"get_message_context",
+	mcp.WithDescription(`获取单个会话中指定消息前后的聊天记录。当通过 query_chat_log 或 search_chat_log 找到关键消息后，需要了解消息前后的完整对话时使用此工具。
+消息按 seq 顺序返回，包括指定的消息本身；传入多个 seq 时，相互重叠的上下文会合并为一段。
+
+返回格式：每段上下文以"--- 上下文 n/N ---"开头，指定的消息以">>> "开头，其余格式与 query_chat_log 相同`),
+	mcp.WithString("talker", mcp.Description(`消息所在的对话方，可使用ID、昵称或备注名，只能指定一个`), mcp.Required()),
+	mcp.WithString("seq", mcp.Description(`消息序号，即 query_chat_log 或 search_chat_log 结果中的 seq，多个序号用","分隔，最多 50 个`), mcp.Required()),
+	mcp.WithNumber("before", mcp.Description(`每条消息之前的消息数量，默认 10，最大 100`)),
+	mcp.WithNumber("after", mcp.Description(`每条消息之后的消息数量，默认 10，最大 100`)),
+	mcp.WithOutputSchema[MessageContextOutput](),
+)
+
+var ChatStatsTool = mcp.NewTool(
+	"chat_stats",
+	mcp.WithDescription(`统计单个会话在指定时间范围内的消息。当用户询问"群里谁最活跃"、"每天聊多少"、"一般几点聊天"、"大家分享了哪些链接/文件"等统计问题时使用此工具，不需要读取全部聊天记录。
+返回内容包括：每个发送人的消息数量和首末发言时间、每天和每小时的消息数量、各消息类型的数量、分享次数最多的链接和文件，以及会话的首末消息时间。`),
+	mcp.WithString("talker", mcp.Description(`指定对话方（联系人或群组），可使用ID、昵称或备注名，只能指定一个`), mcp.Required()),
+	mcp.WithString("time", mcp.Description(`统计的时间范围，格式与 query_chat_log 的 time 参数相同，如："2023-04-01~2023-04-30"、"last-30d"。不指定时统计全部时间`)),
+	mcp.WithNumber("top", mcp.Description(`返回分享次数最多的链接和文件数量，默认 10，最大 100`)),
+	mcp.WithOutputSchema[ChatStatsOutput](),
 )
 
 var CurrentTimeTool = mcp.NewTool(
@@ -153,6 +172,7 @@ var CurrentTimeTool = mcp.NewTool(
 - 需要执行依赖当前时间的计算（如"上个月5号我们有开会吗"）
 返回示例：2025-04-18T21:29:00+08:00
 注意：此工具不需要任何输入参数，直接调用即可获取当前时间。`),
+	mcp.WithOutputSchema[CurrentTimeOutput](),
 )
 
 type ContactRequest struct {
@@ -169,24 +189,23 @@ func (s *Service) handleMCPContact(ctx context.Context, request mcp.CallToolRequ
 		return errors.ErrMCPTool(err), nil
 	}
 
-	list, err := s.db.GetContacts(req.Keyword, -1, req.Limit, req.Offset)
+	list, err := s.getContacts(ctx, req.Keyword, req.Limit, req.Offset)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get contacts")
 		return errors.ErrMCPTool(err), nil
 	}
+	out := ContactOutput{
+		Items: make([]ContactItem, 0, len(list.Items)),
+		Page:  newPage(list.Total, req.Offset, len(list.Items)),
+	}
 	buf := &bytes.Buffer{}
 	buf.WriteString("UserName,Alias,Remark,NickName\n")
 	for _, contact := range list.Items {
 		buf.WriteString(fmt.Sprintf("%s,%s,%s,%s\n", contact.UserName, contact.Alias, contact.Remark, contact.NickName))
+		out.Items = append(out.Items, contactItem(contact))
 	}
-	return &mcp.CallToolResult{
-		Content: []mcp.Content{
-			mcp.TextContent{
-				Type: "text",
-				Text: buf.String(),
-			},
-		},
-	}, nil
+	buf.WriteString(out.Page.Hint())
+	return mcp.NewToolResultStructured(out, buf.String()), nil
 }
 
 type ChatRoomRequest struct {
@@ -204,24 +223,23 @@ func (s *Service) handleMCPChatRoom(ctx context.Context, request mcp.CallToolReq
 		return errors.ErrMCPTool(err), nil
 	}
 
-	list, err := s.db.GetChatRooms(req.Keyword, req.Limit, req.Offset)
+	list, err := s.getChatRooms(ctx, req.Keyword, req.Limit, req.Offset)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get chat rooms")
 		return errors.ErrMCPTool(err), nil
 	}
+	out := ChatRoomOutput{
+		Items: make([]ChatRoomItem, 0, len(list.Items)),
+		Page:  newPage(list.Total, req.Offset, len(list.Items)),
+	}
 	buf := &bytes.Buffer{}
 	buf.WriteString("Name,Remark,NickName,Owner,UserCount\n")
 	for _, chatRoom := range list.Items {
 		buf.WriteString(fmt.Sprintf("%s,%s,%s,%s,%d\n", chatRoom.Name, chatRoom.Remark, chatRoom.NickName, chatRoom.Owner, len(chatRoom.Users)))
+		out.Items = append(out.Items, chatRoomItem(chatRoom))
 	}
-	return &mcp.CallToolResult{
-		Content: []mcp.Content{
-			mcp.TextContent{
-				Type: "text",
-				Text: buf.String(),
-			},
-		},
-	}, nil
+	buf.WriteString(out.Page.Hint())
+	return mcp.NewToolResultStructured(out, buf.String()), nil
 }
 
 type RecentChatRequest struct {
@@ -239,24 +257,23 @@ func (s *Service) handleMCPRecentChat(ctx context.Context, request mcp.CallToolR
 		return errors.ErrMCPTool(err), nil
 	}
 
-	data, err := s.db.GetSessions(req.Keyword, req.Limit, req.Offset)
+	data, err := s.getSessions(ctx, req.Keyword, req.Limit, req.Offset)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get sessions")
 		return errors.ErrMCPTool(err), nil
 	}
+	out := RecentChatOutput{
+		Items: make([]SessionItem, 0, len(data.Items)),
+		Page:  newPage(data.Total, req.Offset, len(data.Items)),
+	}
 	buf := &bytes.Buffer{}
 	for _, session := range data.Items {
 		buf.WriteString(session.PlainText(120))
 		buf.WriteString("\n")
+		out.Items = append(out.Items, sessionItem(session))
 	}
-	return &mcp.CallToolResult{
-		Content: []mcp.Content{
-			mcp.TextContent{
-				Type: "text",
-				Text: buf.String(),
-			},
-		},
-	}, nil
+	buf.WriteString(out.Page.Hint())
+	return mcp.NewToolResultStructured(out, buf.String()), nil
 }
 
 type ChatLogRequest struct {
@@ -264,6 +281,7 @@ type ChatLogRequest struct {
 	Talker  string `form:"talker"`
 	Sender  string `form:"sender"`
 	Keyword string `form:"keyword"`
+	Cursor  string `form:"cursor"`
 	Limit   int    `form:"limit"`
 	Offset  int    `form:"offset"`
 	Format  string `form:"format"`
@@ -284,46 +302,262 @@ func (s *Service) handleMCPChatLog(ctx context.Context, request mcp.CallToolRequ
 		log.Error().Err(err).Msg("Failed to get messages")
 		return errors.ErrMCPTool(err), nil
 	}
-	if req.Limit < 0 {
-		req.Limit = 0
+	if req.Limit <= 0 || req.Limit > chatLogMaxLimit {
+		req.Limit = chatLogMaxLimit
 	}
 
 	if req.Offset < 0 {
 		req.Offset = 0
 	}
 
-	messages, err := s.db.GetMessages(start, end, req.Talker, req.Sender, req.Keyword, req.Limit, req.Offset)
+	if err := s.checkTalker(ctx, req.Talker); err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+
+	messages, err := s.db.GetMessages(start, end, req.Talker, req.Sender, req.Keyword, req.Cursor, req.Limit, req.Offset)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get messages")
 		return errors.ErrMCPTool(err), nil
 	}
 
+	out := ChatLogOutput{
+		Messages: make([]MessageItem, 0, len(messages.Items)),
+	}
+	if messages.Total != nil {
+		out.Page = newPage(*messages.Total, req.Offset, len(messages.Items))
+	} else {
+		// 按关键词分页查询时不统计总数，是否还有更多结果由 cursor 判断
+		out.Page = Page{Offset: req.Offset, Count: len(messages.Items)}
+	}
+	for _, m := range messages.Items {
+		out.Messages = append(out.Messages, messageItem(m))
+	}
+	if req.Cursor != "" {
+		// 使用游标翻页时 total 包含游标之前的消息，不提供 offset
+		out.Page.HasMore, out.Page.NextOffset = false, 0
+	}
+	if messages.NextCursor != "" {
+		out.Page.HasMore, out.Page.NextCursor = true, messages.NextCursor
+	}
+	text := chatLogText(messages.Items, strings.Contains(req.Talker, ","), start, end) + out.Page.Hint()
+	return mcp.NewToolResultStructured(out, text), nil
+}
+
+// messageContextText get_message_context 的文本输出，目标消息以 ">>> " 开头
+func messageContextText(resp *wechatdb.GetMessageContextResp, host string) string {
+	buf := &bytes.Buffer{}
+	if len(resp.Items) == 0 {
+		buf.WriteString("未找到指定的消息\n")
+	}
+	for i, window := range resp.Items {
+		fmt.Fprintf(buf, "--- 上下文 %d/%d ---\n", i+1, len(resp.Items))
+		for _, m := range window.Messages {
+			if window.IsTarget(m) {
+				buf.WriteString(">>> ")
+			}
+			buf.WriteString(m.PlainText(false, time.DateTime, host))
+			buf.WriteString("\n")
+		}
+	}
+	if len(resp.Missing) > 0 {
+		seqs := make([]string, 0, len(resp.Missing))
+		for _, seq := range resp.Missing {
+			seqs = append(seqs, strconv.FormatInt(seq, 10))
+		}
+		fmt.Fprintf(buf, "未找到的消息 seq：%s\n", strings.Join(seqs, ","))
+	}
+	return buf.String()
+}
+
+// chatLogText query_chat_log 的文本输出，资源与 prompt 中附带的聊天记录使用相同格式
+func chatLogText(messages []*model.Message, showChatRoom bool, start, end time.Time) string {
 	buf := &bytes.Buffer{}
-	if len(messages.Items) == 0 {
+	if len(messages) == 0 {
 		buf.WriteString("未找到符合查询条件的聊天记录")
 	}
-	for _, m := range messages.Items {
-		buf.WriteString(m.PlainText(strings.Contains(req.Talker, ","), util.PerfectTimeFormat(start, end), ""))
+	for _, m := range messages {
+		buf.WriteString(m.PlainText(showChatRoom, util.PerfectTimeFormat(start, end), ""))
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+type ChatStatsRequest struct {
+	Talker string `json:"talker"`
+	Time   string `json:"time"`
+	Top    int    `json:"top"`
+}
+
+func (s *Service) handleMCPChatStats(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+
+	var req ChatStatsRequest
+	if err := request.BindArguments(&req); err != nil {
+		log.Error().Err(err).Msg("Failed to bind arguments")
+		log.Error().Interface("request", request.GetRawArguments()).Msg("Failed to bind arguments")
+		return errors.ErrMCPTool(err), nil
+	}
+
+	if req.Time == "" {
+		req.Time = "all"
+	}
+	start, end, ok := util.TimeRangeOf(req.Time)
+	if !ok {
+		return errors.ErrMCPTool(errors.InvalidArg("time")), nil
+	}
+
+	if err := s.checkTalker(ctx, req.Talker); err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+
+	stats, err := s.db.GetMessageStats(start, end, req.Talker, statsTop(req.Top))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get message stats")
+		return errors.ErrMCPTool(err), nil
+	}
+	return mcp.NewToolResultStructured(chatStats(stats), stats.PlainText(20)), nil
+}
+
+type MessageContextRequest struct {
+	Talker string `json:"talker"`
+	Seq    any    `json:"seq"`
+	Before *int   `json:"before"`
+	After  *int   `json:"after"`
+}
+
+func (s *Service) handleMCPMessageContext(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+
+	var req MessageContextRequest
+	if err := request.BindArguments(&req); err != nil {
+		log.Error().Err(err).Msg("Failed to bind arguments")
+		log.Error().Interface("request", request.GetRawArguments()).Msg("Failed to bind arguments")
+		return errors.ErrMCPTool(err), nil
+	}
+
+	seqs, err := contextSeqs(seqArgument(req.Seq))
+	if err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+	before, after := contextDefaultSize, contextDefaultSize
+	if req.Before != nil {
+		before = *req.Before
+	}
+	if req.After != nil {
+		after = *req.After
+	}
+	before, after = contextSize(before, after)
+
+	if err := s.checkTalker(ctx, req.Talker); err != nil {
+		return errors.ErrMCPTool(err), nil
+	}
+
+	resp, err := s.db.GetMessageContext(req.Talker, seqs, before, after)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get message context")
+		return errors.ErrMCPTool(err), nil
+	}
+
+	out := MessageContextOutput{
+		Windows: make([]ContextWindowItem, 0, len(resp.Items)),
+		Missing: resp.Missing,
+	}
+	for _, window := range resp.Items {
+		item := ContextWindowItem{
+			Seqs:     window.Seqs,
+			Messages: make([]ContextMessageItem, 0, len(window.Messages)),
+		}
+		for _, m := range window.Messages {
+			item.Messages = append(item.Messages, ContextMessageItem{MessageItem: messageItem(m), IsTarget: window.IsTarget(m)})
+		}
+		out.Windows = append(out.Windows, item)
+	}
+	return mcp.NewToolResultStructured(out, messageContextText(resp, "")), nil
+}
+
+// seqArgument seq 参数可以是字符串、数字或数字数组
+func seqArgument(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatInt(int64(v), 10)
+	case []any:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			list = append(list, seqArgument(item))
+		}
+		return strings.Join(list, ",")
+	default:
+		return ""
+	}
+}
+
+type SearchChatLogRequest struct {
+	Keyword string `json:"keyword"`
+	Time    string `json:"time"`
+	Sender  string `json:"sender"`
+	Cursor  string `json:"cursor"`
+	Limit   int    `json:"limit"`
+	Offset  int    `json:"offset"`
+}
+
+func (s *Service) handleMCPSearchChatLog(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+
+	var req SearchChatLogRequest
+	if err := request.BindArguments(&req); err != nil {
+		log.Error().Err(err).Msg("Failed to bind arguments")
+		log.Error().Interface("request", request.GetRawArguments()).Msg("Failed to bind arguments")
+		return errors.ErrMCPTool(err), nil
+	}
+
+	if req.Time == "" {
+		req.Time = "all"
+	}
+	start, end, ok := util.TimeRangeOf(req.Time)
+	if !ok {
+		return errors.ErrMCPTool(errors.InvalidArg("time")), nil
+	}
+	req.Limit, req.Offset = searchPage(req.Limit, req.Offset)
+
+	resp, err := s.searchMessages(ctx, start, end, req.Keyword, req.Sender, req.Cursor, req.Limit, req.Offset)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to search messages")
+		return errors.ErrMCPTool(err), nil
+	}
+
+	out := SearchChatLogOutput{
+		Hits:      make([]SearchHitItem, 0, len(resp.Items)),
+		Page:      newPage(resp.Total, req.Offset, len(resp.Items)),
+		Truncated: resp.Truncated,
+	}
+	if req.Cursor != "" || resp.Truncated {
+		// 使用游标翻页时 total 包含游标之前的结果，搜索提前结束时结果不支持翻页，均不提供 offset
+		out.Page.HasMore, out.Page.NextOffset = false, 0
+	}
+	if resp.NextCursor != "" {
+		out.Page.HasMore, out.Page.NextCursor = true, resp.NextCursor
+	}
+	buf := &bytes.Buffer{}
+	if len(resp.Items) == 0 {
+		buf.WriteString("未找到符合查询条件的聊天记录")
+	}
+	for _, hit := range resp.Items {
+		buf.WriteString(hit.PlainText())
 		buf.WriteString("\n")
+		out.Hits = append(out.Hits, SearchHitItem{
+			MessageItem: messageItem(hit.Message),
+			Snippet:     hit.Snippet,
+			Matches:     hit.Matches,
+		})
+	}
+	buf.WriteString(strings.TrimPrefix(out.Page.Hint(), "\n"))
+	if resp.Truncated {
+		buf.WriteString("\n（命中结果较多，搜索已提前结束，结果不支持翻页，可缩小时间范围或增加关键词以获得更准确的结果）")
 	}
 
-	return &mcp.CallToolResult{
-		Content: []mcp.Content{
-			mcp.TextContent{
-				Type: "text",
-				Text: buf.String(),
-			},
-		},
-	}, nil
+	return mcp.NewToolResultStructured(out, buf.String()), nil
 }
 
 func (s *Service) handleMCPCurrentTime(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	return &mcp.CallToolResult{
-		Content: []mcp.Content{
-			mcp.TextContent{
-				Type: "text",
-				Text: model.JSONTime(time.Now().Local()).String(),
-			},
-		},
-	}, nil
+	now := time.Now().Local()
+	return mcp.NewToolResultStructured(currentTime(now), model.JSONTime(now).String()), nil
 }
@@ -0,0 +1,300 @@
+package http
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// MCP 工具的结构化输出（structuredContent），字段与 outputSchema 一一对应
+// 文本输出保留为 content，供不支持结构化输出的客户端使用
+
+// Page 分页信息
+type Page struct {
+	Total      *int   `json:"total,omitempty" jsonschema_description:"符合条件的总数，统计失败时为 0，按关键词分页查询聊天记录时不统计，省略该字段"`
+	Offset     int    `json:"offset" jsonschema_description:"本次查询的偏移量"`
+	Count      int    `json:"count" jsonschema_description:"本次返回的数量"`
+	HasMore    bool   `json:"hasMore" jsonschema_description:"是否还有更多结果"`
+	NextOffset int    `json:"nextOffset,omitempty" jsonschema_description:"查询下一页时使用的 offset"`
+	NextCursor string `json:"nextCursor,omitempty" jsonschema_description:"查询下一页时使用的 cursor，仅 query_chat_log 与 search_chat_log 返回"`
+}
+
+func newPage(total, offset, count int) Page {
+	p := Page{
+		Total:  &total,
+		Offset: offset,
+		Count:  count,
+	}
+	if count > 0 && offset+count < total {
+		p.HasMore = true
+		p.NextOffset = offset + count
+	}
+	return p
+}
+
+// Hint 还有更多结果时附加在文本输出末尾的提示
+func (p Page) Hint() string {
+	if !p.HasMore {
+		return ""
+	}
+	if p.NextCursor != "" {
+		if p.Total == nil {
+			return fmt.Sprintf("\n当前返回 %d 条，还有更多结果，使用 cursor=%s 查看更多", p.Count, p.NextCursor)
+		}
+		return fmt.Sprintf("\n共 %d 条结果，当前返回 %d 条，使用 cursor=%s 查看更多", *p.Total, p.Count, p.NextCursor)
+	}
+	return fmt.Sprintf("\n共 %d 条结果，当前显示第 %d-%d 条，使用 offset=%d 查看更多", *p.Total, p.Offset+1, p.NextOffset, p.NextOffset)
+}
+
+type ContactOutput struct {
+	Items []ContactItem `json:"items"`
+	Page  Page          `json:"page"`
+}
+
+type ContactItem struct {
+	UserName string `json:"userName" jsonschema_description:"微信 ID"`
+	Alias    string `json:"alias" jsonschema_description:"微信号"`
+	Remark   string `json:"remark" jsonschema_description:"备注名"`
+	NickName string `json:"nickName" jsonschema_description:"昵称"`
+}
+
+type ChatRoomOutput struct {
+	Items []ChatRoomItem `json:"items"`
+	Page  Page           `json:"page"`
+}
+
+type ChatRoomItem struct {
+	Name      string `json:"name" jsonschema_description:"群 ID"`
+	Remark    string `json:"remark" jsonschema_description:"备注名"`
+	NickName  string `json:"nickName" jsonschema_description:"群名称"`
+	Owner     string `json:"owner" jsonschema_description:"群主微信 ID"`
+	UserCount int    `json:"userCount" jsonschema_description:"成员数量"`
+}
+
+type RecentChatOutput struct {
+	Items []SessionItem `json:"items"`
+	Page  Page          `json:"page"`
+}
+
+type SessionItem struct {
+	Talker      string `json:"talker" jsonschema_description:"会话 ID，可作为 query_chat_log 的 talker 参数"`
+	TalkerName  string `json:"talkerName" jsonschema_description:"会话名称"`
+	IsChatRoom  bool   `json:"isChatRoom"`
+	Sender      string `json:"sender" jsonschema_description:"最后一条消息的发送人 ID"`
+	SenderName  string `json:"senderName" jsonschema_description:"最后一条消息的发送人名称"`
+	IsSelf      bool   `json:"isSelf" jsonschema_description:"最后一条消息是否为自己发送"`
+	Content     string `json:"content" jsonschema_description:"最后一条消息的内容摘要"`
+	Time        string `json:"time" jsonschema_description:"最后一条消息的时间，格式 2006-01-02 15:04:05"`
+	UnreadCount int    `json:"unreadCount" jsonschema_description:"未读消息数量"`
+	IsMentionMe bool   `json:"isMentionMe" jsonschema_description:"是否有@我的消息"`
+}
+
+type ChatLogOutput struct {
+	Messages []MessageItem `json:"messages"`
+	Page     Page          `json:"page"`
+}
+
+type MessageItem struct {
+	Seq         int64  `json:"seq" jsonschema_description:"消息序号，同一会话内递增"`
+	Time        string `json:"time" jsonschema_description:"消息时间，格式 2006-01-02 15:04:05"`
+	Talker      string `json:"talker" jsonschema_description:"会话 ID"`
+	TalkerName  string `json:"talkerName" jsonschema_description:"会话名称"`
+	IsChatRoom  bool   `json:"isChatRoom"`
+	Sender      string `json:"sender" jsonschema_description:"发送人 ID"`
+	SenderName  string `json:"senderName" jsonschema_description:"发送人名称"`
+	IsSelf      bool   `json:"isSelf" jsonschema_description:"是否为自己发送"`
+	Type        int64  `json:"type" jsonschema_description:"消息类型，1 文本，3 图片，34 语音，43 视频，47 表情，49 分享/文件，10000 系统消息"`
+	SubType     int64  `json:"subType" jsonschema_description:"消息子类型"`
+	Content     string `json:"content" jsonschema_description:"消息内容，多媒体消息为文本描述或链接"`
+	IsMentionMe bool   `json:"isMentionMe" jsonschema_description:"是否@我"`
+}
+
+type SearchChatLogOutput struct {
+	Hits      []SearchHitItem `json:"hits"`
+	Page      Page            `json:"page"`
+	Truncated bool            `json:"truncated" jsonschema_description:"命中结果较多，搜索已提前结束，此时结果不支持翻页"`
+}
+
+type SearchHitItem struct {
+	MessageItem
+	Snippet string `json:"snippet" jsonschema_description:"命中位置附近的内容摘要"`
+	Matches int    `json:"matches" jsonschema_description:"查询词的命中次数"`
+}
+
+type MessageContextOutput struct {
+	Windows []ContextWindowItem `json:"windows" jsonschema_description:"按 seq 顺序排列的上下文，相互重叠的上下文已合并"`
+	Missing []int64             `json:"missing,omitempty" jsonschema_description:"未找到的消息 seq"`
+}
+
+type ContextWindowItem struct {
+	Seqs     []int64              `json:"seqs" jsonschema_description:"此段上下文包含的指定消息 seq"`
+	Messages []ContextMessageItem `json:"messages"`
+}
+
+type ContextMessageItem struct {
+	MessageItem
+	IsTarget bool `json:"isTarget" jsonschema_description:"是否为指定的消息"`
+}
+
+type ChatStatsOutput struct {
+	Talker     string           `json:"talker" jsonschema_description:"会话 ID"`
+	TalkerName string           `json:"talkerName" jsonschema_description:"会话名称"`
+	Total      int              `json:"total" jsonschema_description:"时间范围内的消息总数"`
+	FirstTime  string           `json:"firstTime" jsonschema_description:"第一条消息的时间，没有消息时为空"`
+	LastTime   string           `json:"lastTime" jsonschema_description:"最后一条消息的时间，没有消息时为空"`
+	Senders    []SenderStatItem `json:"senders" jsonschema_description:"发送人的消息数量，按数量降序，不包括系统消息"`
+	Days       []DayStatItem    `json:"days" jsonschema_description:"每天的消息数量，按日期升序，只包含有消息的日期"`
+	Hours      []int            `json:"hours" jsonschema_description:"0-23 点每个小时的消息数量（本地时间）"`
+	Types      []TypeStatItem   `json:"types" jsonschema_description:"各消息类型的数量，按数量降序"`
+	Links      []ShareStatItem  `json:"links" jsonschema_description:"分享次数最多的链接"`
+	Files      []ShareStatItem  `json:"files" jsonschema_description:"分享次数最多的文件"`
+}
+
+type SenderStatItem struct {
+	Sender     string `json:"sender" jsonschema_description:"发送人 ID"`
+	SenderName string `json:"senderName" jsonschema_description:"发送人名称"`
+	IsSelf     bool   `json:"isSelf" jsonschema_description:"是否为自己"`
+	Count      int    `json:"count" jsonschema_description:"消息数量"`
+	FirstTime  string `json:"firstTime" jsonschema_description:"第一条消息的时间"`
+	LastTime   string `json:"lastTime" jsonschema_description:"最后一条消息的时间"`
+}
+
+type DayStatItem struct {
+	Date  string `json:"date" jsonschema_description:"日期，格式 2006-01-02"`
+	Count int    `json:"count" jsonschema_description:"消息数量"`
+}
+
+type TypeStatItem struct {
+	Type    int64  `json:"type" jsonschema_description:"消息类型，与 query_chat_log 结构化结果中的 type 相同"`
+	SubType int64  `json:"subType" jsonschema_description:"分享消息的子类型，其他类型为 0"`
+	Name    string `json:"name" jsonschema_description:"类型名称"`
+	Count   int    `json:"count" jsonschema_description:"消息数量"`
+}
+
+type ShareStatItem struct {
+	Title    string `json:"title" jsonschema_description:"标题或文件名"`
+	URL      string `json:"url,omitempty" jsonschema_description:"链接地址"`
+	MD5      string `json:"md5,omitempty" jsonschema_description:"文件 MD5"`
+	Count    int    `json:"count" jsonschema_description:"分享次数"`
+	LastTime string `json:"lastTime" jsonschema_description:"最近一次分享的时间"`
+}
+
+type CurrentTimeOutput struct {
+	Time      string `json:"time" jsonschema_description:"RFC3339 格式的本地时间"`
+	Timestamp int64  `json:"timestamp" jsonschema_description:"Unix 时间戳（秒）"`
+	Timezone  string `json:"timezone" jsonschema_description:"本地时区名称"`
+	Weekday   string `json:"weekday" jsonschema_description:"星期"`
+}
+
+func contactItem(c *model.Contact) ContactItem {
+	return ContactItem{
+		UserName: c.UserName,
+		Alias:    c.Alias,
+		Remark:   c.Remark,
+		NickName: c.NickName,
+	}
+}
+
+func chatRoomItem(c *model.ChatRoom) ChatRoomItem {
+	return ChatRoomItem{
+		Name:      c.Name,
+		Remark:    c.Remark,
+		NickName:  c.NickName,
+		Owner:     c.Owner,
+		UserCount: len(c.Users),
+	}
+}
+
+func sessionItem(s *model.Session) SessionItem {
+	return SessionItem{
+		Talker:      s.TopicID,
+		TalkerName:  s.TopicName,
+		IsChatRoom:  s.IsChatroom,
+		Sender:      s.PersonID,
+		SenderName:  s.PersonName,
+		IsSelf:      s.IsSelf,
+		Content:     s.Content,
+		Time:        s.NTime.String(),
+		UnreadCount: s.UnreadCount,
+		IsMentionMe: s.IsMentionMe,
+	}
+}
+
+// messageItem 结构化的消息，多媒体链接与文本输出一致，不包含 host
+func messageItem(m *model.Message) MessageItem {
+	m.SetContent("host", "")
+	return MessageItem{
+		Seq:         m.Seq,
+		Time:        m.Time.String(),
+		Talker:      m.Talker,
+		TalkerName:  m.TalkerName,
+		IsChatRoom:  m.IsChatRoom,
+		Sender:      m.Sender,
+		SenderName:  m.SenderName,
+		IsSelf:      m.IsSelf,
+		Type:        m.Type,
+		SubType:     m.SubType,
+		Content:     m.PlainTextContent(),
+		IsMentionMe: m.IsMentionMe,
+	}
+}
+
+func chatStats(stats *model.MessageStats) ChatStatsOutput {
+	out := ChatStatsOutput{
+		Talker:     stats.Talker,
+		TalkerName: stats.TalkerName,
+		Total:      stats.Total,
+		FirstTime:  statsTime(stats.FirstTime),
+		LastTime:   statsTime(stats.LastTime),
+		Senders:    make([]SenderStatItem, 0, len(stats.Senders)),
+		Days:       make([]DayStatItem, 0, len(stats.Days)),
+		Hours:      stats.Hours[:],
+		Types:      make([]TypeStatItem, 0, len(stats.Types)),
+		Links:      make([]ShareStatItem, 0, len(stats.Links)),
+		Files:      make([]ShareStatItem, 0, len(stats.Files)),
+	}
+	for _, sender := range stats.Senders {
+		out.Senders = append(out.Senders, SenderStatItem{
+			Sender:     sender.Sender,
+			SenderName: sender.SenderName,
+			IsSelf:     sender.IsSelf,
+			Count:      sender.Count,
+			FirstTime:  statsTime(sender.FirstTime),
+			LastTime:   statsTime(sender.LastTime),
+		})
+	}
+	for _, day := range stats.Days {
+		out.Days = append(out.Days, DayStatItem{Date: day.Key, Count: day.Count})
+	}
+	for _, t := range stats.Types {
+		out.Types = append(out.Types, TypeStatItem{Type: t.Type, SubType: t.SubType, Name: t.Name, Count: t.Count})
+	}
+	shareItem := func(share *model.ShareStat) ShareStatItem {
+		return ShareStatItem{Title: share.Title, URL: share.URL, MD5: share.MD5, Count: share.Count, LastTime: statsTime(share.LastTime)}
+	}
+	for _, link := range stats.Links {
+		out.Links = append(out.Links, shareItem(link))
+	}
+	for _, file := range stats.Files {
+		out.Files = append(out.Files, shareItem(file))
+	}
+	return out
+}
+
+func statsTime(t model.JSONTime) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.String()
+}
+
+func currentTime(now time.Time) CurrentTimeOutput {
+	name, _ := now.Zone()
+	return CurrentTimeOutput{
+		Time:      now.Format(time.RFC3339),
+		Timestamp: now.Unix(),
+		Timezone:  name,
+		Weekday:   now.Weekday().String(),
+	}
+}
@@ -0,0 +1,254 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/sjzar/chatlog/internal/chatlog/conf"
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/wechatdb"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+const (
+	// promptMaxMessages 单个 prompt 中附带的最大消息数量
+	promptMaxMessages = 3000
+
+	// promptMaxHits topic_opinions 附带的最大搜索结果数量
+	promptMaxHits = 100
+
+	// digestMaxSessions/digestMaxMessages unread_digest 附带的最大会话数量与每个会话的最大消息数量
+	digestMaxSessions = 20
+	digestMaxMessages = 50
+)
+
+var SummarizeChatPrompt = mcp.NewPrompt(
+	"summarize_chat",
+	mcp.WithPromptDescription("总结群聊或私聊在指定时间段内的讨论内容"),
+	mcp.WithArgument("talker", mcp.ArgumentDescription("对话方，可使用ID、昵称或备注名"), mcp.RequiredArgument()),
+	mcp.WithArgument("time", mcp.ArgumentDescription(`时间范围，格式与 query_chat_log 的 time 参数相同，默认 "today"`)),
+)
+
+var ActionItemsPrompt = mcp.NewPrompt(
+	"extract_action_items",
+	mcp.WithPromptDescription("从聊天记录中提取待办事项和已做出的决定"),
+	mcp.WithArgument("talker", mcp.ArgumentDescription("对话方，可使用ID、昵称或备注名"), mcp.RequiredArgument()),
+	mcp.WithArgument("time", mcp.ArgumentDescription(`时间范围，格式与 query_chat_log 的 time 参数相同，默认 "last-7d"`)),
+)
+
+var TopicOpinionsPrompt = mcp.NewPrompt(
+	"topic_opinions",
+	mcp.WithPromptDescription("整理谁在什么时候对某个话题说了什么"),
+	mcp.WithArgument("topic", mcp.ArgumentDescription("话题关键词"), mcp.RequiredArgument()),
+	mcp.WithArgument("talker", mcp.ArgumentDescription("限定对话方，为空时搜索全部会话")),
+	mcp.WithArgument("time", mcp.ArgumentDescription(`时间范围，格式与 query_chat_log 的 time 参数相同，默认 "last-30d"`)),
+)
+
+var UnreadDigestPrompt = mcp.NewPrompt(
+	"unread_digest",
+	mcp.WithPromptDescription("汇总最近一天内有未读消息的会话"),
+)
+
+func (s *Service) initMCPPrompts() {
+	s.mcpServer.AddPrompt(SummarizeChatPrompt, promptScope(conf.ScopeMessages, s.handleMCPSummarizeChatPrompt))
+	s.mcpServer.AddPrompt(ActionItemsPrompt, promptScope(conf.ScopeMessages, s.handleMCPActionItemsPrompt))
+	s.mcpServer.AddPrompt(TopicOpinionsPrompt, promptScope(conf.ScopeMessages, s.handleMCPTopicOpinionsPrompt))
+	s.mcpServer.AddPrompt(UnreadDigestPrompt, promptScope(conf.ScopeMessages, s.handleMCPUnreadDigestPrompt))
+}
+
+func (s *Service) handleMCPSummarizeChatPrompt(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+	talker, timeRange, chatLog, err := s.promptChatLog(ctx, request, "today")
+	if err != nil {
+		return nil, err
+	}
+	text := fmt.Sprintf(`请根据附带的「%s」在 %s 的聊天记录进行总结：
+1. 按话题归纳主要讨论内容，每个话题列出主要参与者和结论
+2. 标注重要的链接、文件和时间节点
+3. 最后用一两句话概括整体情况
+仅依据聊天记录作答，不要编造；信息不足时请说明。`, talker, timeRange)
+	return promptResult("总结聊天记录", text, chatLog), nil
+}
+
+func (s *Service) handleMCPActionItemsPrompt(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+	talker, timeRange, chatLog, err := s.promptChatLog(ctx, request, "last-7d")
+	if err != nil {
+		return nil, err
+	}
+	text := fmt.Sprintf(`请从附带的「%s」在 %s 的聊天记录中提取：
+1. 待办事项：以表格列出负责人、事项、截止时间、提出时间，负责人或截止时间不明确时填写"未明确"
+2. 已做出的决定：以表格列出决定内容、决策人、时间
+3. 尚未达成结论、需要跟进的问题
+仅依据聊天记录作答，不要编造。`, talker, timeRange)
+	return promptResult("提取待办事项和决定", text, chatLog), nil
+}
+
+func (s *Service) handleMCPTopicOpinionsPrompt(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+	args := request.Params.Arguments
+	topic := strings.TrimSpace(args["topic"])
+	if topic == "" {
+		return nil, errors.InvalidArg("topic")
+	}
+	talker := strings.TrimSpace(args["talker"])
+	timeRange := promptTime(args["time"], "last-30d")
+	start, end, ok := util.TimeRangeOf(timeRange)
+	if !ok {
+		return nil, errors.InvalidArg("time")
+	}
+
+	var content *mcp.TextResourceContents
+	if talker != "" {
+		if err := s.checkTalker(ctx, talker); err != nil {
+			return nil, err
+		}
+		messages, err := s.db.GetMessages(start, end, talker, "", regexp.QuoteMeta(topic), "", promptMaxMessages, 0)
+		if err != nil {
+			return nil, err
+		}
+		content = chatLogResource(chatURI(talker, timeRange), messages, strings.Contains(talker, ","), start, end)
+	} else {
+		resp, err := s.searchMessages(ctx, start, end, topic, "", "", promptMaxHits, 0)
+		if err != nil {
+			return nil, err
+		}
+		buf := &bytes.Buffer{}
+		if len(resp.Items) == 0 {
+			buf.WriteString("未找到符合查询条件的聊天记录")
+		}
+		for _, hit := range resp.Items {
+			buf.WriteString(hit.PlainText())
+			buf.WriteString("\n")
+		}
+		if len(resp.Items) < resp.Total {
+			fmt.Fprintf(buf, "（共 %d 条结果，仅附带前 %d 条）\n", resp.Total, len(resp.Items))
+		}
+		content = &mcp.TextResourceContents{
+			URI:      "chatlog://search/" + url.PathEscape(topic),
+			MIMEType: "text/plain",
+			Text:     buf.String(),
+		}
+	}
+
+	scope := "全部会话"
+	if talker != "" {
+		scope = "「" + talker + "」"
+	}
+	text := fmt.Sprintf(`附带的是%s在 %s 中提到「%s」的聊天记录。请按发言人整理各自关于该话题的观点和说法：
+1. 每位发言人列出主要观点，并注明时间和所在会话
+2. 指出发言人之间的分歧与共识
+3. 如需查看某条消息的上下文，可使用 query_chat_log 工具，指定对应的 talker 并使用该消息前后的窄时间范围查询
+仅依据聊天记录作答，不要编造。`, scope, timeRange, topic)
+	return promptResult("整理话题观点", text, content), nil
+}
+
+func (s *Service) handleMCPUnreadDigestPrompt(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+	sessions, err := s.getSessions(ctx, "", recentSessionLimit, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end := time.Now().Add(-24*time.Hour), time.Now().Add(time.Minute)
+	buf := &bytes.Buffer{}
+	count := 0
+	for _, session := range sessions.Items {
+		if session.UnreadCount == 0 || session.NTime.Time().Before(start) {
+			continue
+		}
+		if count == digestMaxSessions {
+			break
+		}
+		count++
+
+		messages, err := s.db.GetMessages(start, end, session.TopicID, "", "", "", 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		items := messages.Items
+		if n := min(session.UnreadCount, digestMaxMessages); len(items) > n {
+			items = items[len(items)-n:]
+		}
+		fmt.Fprintf(buf, "## %s(%s) %d 条未读\n", session.TopicName, session.TopicID, session.UnreadCount)
+		for _, m := range items {
+			buf.WriteString(m.PlainText(false, "01-02 15:04:05", ""))
+			buf.WriteString("\n")
+		}
+		buf.WriteString("\n")
+	}
+	if count == 0 {
+		buf.WriteString("最近一天内没有未读消息")
+	}
+
+	text := `附带的是最近一天内有未读消息的会话及其未读消息（每个会话最多附带最近的 50 条）。请生成一份未读消息摘要：
+1. 优先列出@我的消息和需要我回复或处理的事项
+2. 其余会话按重要程度排序，每个会话用一两句话概括
+3. 标注需要关注的时间节点
+仅依据聊天记录作答，不要编造。`
+	return promptResult("未读消息摘要", text, &mcp.TextResourceContents{
+		URI:      "chatlog://session/unread",
+		MIMEType: "text/plain",
+		Text:     buf.String(),
+	}), nil
+}
+
+// promptChatLog 读取 prompt 参数中 talker 与 time 指定的聊天记录
+func (s *Service) promptChatLog(ctx context.Context, request mcp.GetPromptRequest, defaultTime string) (string, string, *mcp.TextResourceContents, error) {
+	args := request.Params.Arguments
+	talker := strings.TrimSpace(args["talker"])
+	if talker == "" {
+		return "", "", nil, errors.InvalidArg("talker")
+	}
+	timeRange := promptTime(args["time"], defaultTime)
+	start, end, ok := util.TimeRangeOf(timeRange)
+	if !ok {
+		return "", "", nil, errors.InvalidArg("time")
+	}
+	if err := s.checkTalker(ctx, talker); err != nil {
+		return "", "", nil, err
+	}
+
+	messages, err := s.db.GetMessages(start, end, talker, "", "", "", promptMaxMessages, 0)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return talker, timeRange, chatLogResource(chatURI(talker, timeRange), messages, strings.Contains(talker, ","), start, end), nil
+}
+
+func promptTime(value, defaultTime string) string {
+	if value = strings.TrimSpace(value); value == "" {
+		return defaultTime
+	}
+	return value
+}
+
+func chatURI(talker, timeRange string) string {
+	return "chatlog://chat/" + url.PathEscape(talker) + "/" + url.PathEscape(timeRange)
+}
+
+// chatLogResource 聊天记录文本，格式与 query_chat_log 的输出一致，消息被截断时附加提示
+func chatLogResource(uri string, messages *wechatdb.GetMessagesResp, showChatRoom bool, start, end time.Time) *mcp.TextResourceContents {
+	text := chatLogText(messages.Items, showChatRoom, start, end)
+	if messages.Total != nil && *messages.Total > len(messages.Items) {
+		text += fmt.Sprintf("（共 %d 条消息，仅附带前 %d 条，可缩小时间范围后重试）\n", *messages.Total, len(messages.Items))
+	} else if messages.Total == nil && messages.NextCursor != "" {
+		text += fmt.Sprintf("（仅附带前 %d 条消息，可缩小时间范围后重试）\n", len(messages.Items))
+	}
+	return &mcp.TextResourceContents{
+		URI:      uri,
+		MIMEType: "text/plain",
+		Text:     text,
+	}
+}
+
+// promptResult 指令与附带的聊天记录作为两条用户消息返回
+func promptResult(description, text string, content *mcp.TextResourceContents) *mcp.GetPromptResult {
+	return mcp.NewGetPromptResult(description, []mcp.PromptMessage{
+		mcp.NewPromptMessage(mcp.RoleUser, mcp.NewTextContent(text)),
+		mcp.NewPromptMessage(mcp.RoleUser, mcp.NewEmbeddedResource(*content)),
+	})
+}
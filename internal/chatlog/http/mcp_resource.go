@@ -0,0 +1,249 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/sjzar/chatlog/internal/chatlog/conf"
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/pkg/util"
+	"github.com/sjzar/chatlog/pkg/util/dat2img"
+)
+
+const (
+	// recentSessionLimit chatlog://session/recent 返回的会话数量
+	recentSessionLimit = 100
+
+	// resourceMaxImageSize 以 blob 返回的图片大小上限
+	resourceMaxImageSize = 20 << 20
+)
+
+var RecentSessionResource = mcp.NewResource(
+	"chatlog://session/recent",
+	"最近会话",
+	mcp.WithResourceDescription("最近的会话列表，包括个人聊天和群聊，每行一个会话"),
+	mcp.WithMIMEType("text/plain"),
+)
+
+var ContactResourceTemplate = mcp.NewResourceTemplate(
+	"chatlog://contact/{+id}",
+	"联系人",
+	mcp.WithTemplateDescription("联系人信息，id 为微信 ID、备注名或昵称"),
+	mcp.WithTemplateMIMEType("application/json"),
+)
+
+var ChatRoomResourceTemplate = mcp.NewResourceTemplate(
+	"chatlog://chatroom/{+id}",
+	"群聊",
+	mcp.WithTemplateDescription("群聊信息及成员列表，id 为群 ID、群名称或备注名"),
+	mcp.WithTemplateMIMEType("application/json"),
+)
+
+var ChatResourceTemplate = mcp.NewResourceTemplate(
+	"chatlog://chat/{+talker}/{date}",
+	"聊天记录",
+	mcp.WithTemplateDescription(`与联系人或群聊在指定日期的聊天记录。talker 为 ID、昵称或备注名；date 为日期或日期范围，如 "2024-01-01"、"2024-01-01~2024-01-07"、"last-7d"。最多返回 1000 条消息，超出时可缩小时间范围或使用 query_chat_log 翻页。消息中的图片可通过 chatlog://image/{key} 读取`),
+	mcp.WithTemplateMIMEType("text/plain"),
+)
+
+var ImageResourceTemplate = mcp.NewResourceTemplate(
+	"chatlog://image/{+key}",
+	"图片",
+	mcp.WithTemplateDescription("聊天中的图片，key 为图片消息的 md5 或图片链接中的 key，返回解密后的图片内容"),
+)
+
+func (s *Service) initMCPResources() {
+	s.mcpServer.AddResource(RecentSessionResource, resourceScope(conf.ScopeMessages, s.handleMCPRecentSessionResource))
+	s.mcpServer.AddResourceTemplate(ContactResourceTemplate, resourceScope(conf.ScopeContacts, s.handleMCPContactResource))
+	s.mcpServer.AddResourceTemplate(ChatRoomResourceTemplate, resourceScope(conf.ScopeContacts, s.handleMCPChatRoomResource))
+	s.mcpServer.AddResourceTemplate(ChatResourceTemplate, resourceScope(conf.ScopeMessages, s.handleMCPChatResource))
+	s.mcpServer.AddResourceTemplate(ImageResourceTemplate, resourceScope(conf.ScopeMedia, s.handleMCPImageResource))
+}
+
+func (s *Service) handleMCPRecentSessionResource(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	data, err := s.getSessions(ctx, "", recentSessionLimit, 0)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	for _, session := range data.Items {
+		buf.WriteString(session.PlainText(120))
+		buf.WriteString("\n")
+	}
+	return []mcp.ResourceContents{
+		mcp.TextResourceContents{
+			URI:      request.Params.URI,
+			MIMEType: "text/plain",
+			Text:     buf.String(),
+		},
+	}, nil
+}
+
+func (s *Service) handleMCPContactResource(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	id := resourceArg(request, "id")
+	if id == "" {
+		return nil, errors.InvalidArg("id")
+	}
+
+	contact, err := s.db.GetContact(id)
+	if err != nil {
+		list, err := s.db.GetContacts(id, -1, 1, 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(list.Items) == 0 {
+			return nil, errors.ContactNotFound(id)
+		}
+		contact = list.Items[0]
+	}
+	if err := s.checkTalker(ctx, contact.UserName); err != nil {
+		return nil, err
+	}
+	return jsonResource(request.Params.URI, contact)
+}
+
+func (s *Service) handleMCPChatRoomResource(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	id := resourceArg(request, "id")
+	if id == "" {
+		return nil, errors.InvalidArg("id")
+	}
+
+	list, err := s.db.GetChatRooms(id, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(list.Items) == 0 {
+		return nil, errors.ChatRoomNotFound(id)
+	}
+	if err := s.checkTalker(ctx, list.Items[0].Name); err != nil {
+		return nil, err
+	}
+	return jsonResource(request.Params.URI, list.Items[0])
+}
+
+func (s *Service) handleMCPChatResource(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	talker := resourceArg(request, "talker")
+	if talker == "" {
+		return nil, errors.InvalidArg("talker")
+	}
+	start, end, ok := util.TimeRangeOf(resourceArg(request, "date"))
+	if !ok {
+		return nil, errors.InvalidArg("date")
+	}
+
+	if err := s.checkTalker(ctx, talker); err != nil {
+		return nil, err
+	}
+
+	messages, err := s.db.GetMessages(start, end, talker, "", "", "", chatLogMaxLimit, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return []mcp.ResourceContents{
+		*chatLogResource(request.Params.URI, messages, strings.Contains(talker, ","), start, end),
+	}, nil
+}
+
+func (s *Service) handleMCPImageResource(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	key := resourceArg(request, "key")
+	if key == "" {
+		return nil, errors.InvalidArg("key")
+	}
+
+	data, mimeType, err := s.readImage(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return []mcp.ResourceContents{
+		mcp.BlobResourceContents{
+			URI:      request.Params.URI,
+			MIMEType: mimeType,
+			Blob:     base64.StdEncoding.EncodeToString(data),
+		},
+	}, nil
+}
+
+// readImage 读取图片并解密 .dat 文件，key 与 /image/:key 相同，多个 key 以逗号分隔时返回第一个找到的图片
+func (s *Service) readImage(ctx context.Context, key string) ([]byte, string, error) {
+	var _err error = errors.ErrMediaNotFound
+	for _, k := range util.Str2List(key, ",") {
+		var relativePath string
+		if strings.Contains(k, "/") {
+			path, err := s.findPath("image", k)
+			if err != nil {
+				_err = err
+				continue
+			}
+			relativePath = path
+		} else {
+			media, err := s.db.GetMedia("image", k)
+			if err != nil {
+				_err = err
+				continue
+			}
+			relativePath = media.Path
+		}
+		if err := s.checkMedia(ctx, relativePath); err != nil {
+			_err = err
+			continue
+		}
+
+		absolutePath := filepath.Join(s.conf.GetDataDir(), relativePath)
+		info, err := os.Stat(absolutePath)
+		if err != nil {
+			_err = errors.ErrMediaNotFound
+			continue
+		}
+		if info.Size() > resourceMaxImageSize {
+			_err = errors.InvalidArg("key")
+			continue
+		}
+		data, err := os.ReadFile(absolutePath)
+		if err != nil {
+			_err = err
+			continue
+		}
+		if strings.EqualFold(filepath.Ext(absolutePath), ".dat") {
+			if out, _, err := dat2img.Dat2Image(data); err == nil {
+				data = out
+			}
+		}
+		return data, http.DetectContentType(data), nil
+	}
+	return nil, "", _err
+}
+
+// resourceArg 资源模板中的参数，逗号分隔的值会被解析为列表
+func resourceArg(request mcp.ReadResourceRequest, name string) string {
+	var value string
+	switch v := request.Params.Arguments[name].(type) {
+	case string:
+		value = v
+	case []string:
+		value = strings.Join(v, ",")
+	}
+	return strings.TrimSpace(value)
+}
+
+func jsonResource(uri string, v any) ([]mcp.ResourceContents, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return []mcp.ResourceContents{
+		mcp.TextResourceContents{
+			URI:      uri,
+			MIMEType: "application/json",
+			Text:     string(b),
+		},
+	}, nil
+}
@@ -0,0 +1,92 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	stdlog "log"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/chatlog/database"
+)
+
+// stdioSessionID mcp-go stdio 传输只有一个会话，ID 固定
+const stdioSessionID = "stdio"
+
+// NewMCPService 仅提供 MCP 服务，不创建 HTTP 路由，用于 stdio 传输
+func NewMCPService(conf Config, db *database.Service) *Service {
+	s := &Service{
+		conf: conf,
+		db:   db,
+	}
+	s.initMCPServer()
+	return s
+}
+
+// ServeStdio 通过 in/out 以换行分隔的 JSON-RPC 提供 MCP 服务，ctx 取消或 in 关闭时返回
+// out 只写入协议消息，日志需输出到 stderr 或文件
+func (s *Service) ServeStdio(ctx context.Context, in io.Reader, out io.Writer) error {
+	stdio := server.NewStdioServer(s.mcpServer)
+	stdio.SetErrorLogger(stdlog.New(log.Logger, "", 0))
+	w := &syncWriter{w: out}
+	return stdio.Listen(ctx, &stdioSubscribeReader{s: s, r: bufio.NewReader(in), w: w}, w)
+}
+
+// stdioSubscribeReader 逐行读取 stdio 请求，与 mcpSubscribeMiddleware 相同地处理订阅请求
+// 订阅请求的响应直接写入 w，不再转发给 MCP 服务
+type stdioSubscribeReader struct {
+	s   *Service
+	r   *bufio.Reader
+	w   io.Writer
+	buf []byte
+	err error
+}
+
+func (r *stdioSubscribeReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		var line []byte
+		line, r.err = r.r.ReadBytes('\n')
+		r.buf = r.handle(line)
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// handle 处理订阅请求并返回 nil，其他请求原样返回
+func (r *stdioSubscribeReader) handle(line []byte) []byte {
+	req, ok := parseSubscribeRequest(bytes.TrimSpace(line))
+	if !ok {
+		return line
+	}
+	err := r.s.subscribe(context.Background(), req, stdioSessionID)
+	if err != nil {
+		log.Debug().Err(err).Str("uri", req.Params.URI).Msg("subscribe resource failed")
+	}
+	b, _ := json.Marshal(req.response(err))
+	if _, err := r.w.Write(append(b, '\n')); err != nil {
+		log.Debug().Err(err).Msg("write subscribe response failed")
+	}
+	return nil
+}
+
+// syncWriter 串行写入，订阅请求的响应不会与 MCP 服务的输出交错
+// mcp-go 每条消息只调用一次 Write
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
@@ -0,0 +1,278 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/chatlog/conf"
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+const (
+	MethodResourcesSubscribe   = "resources/subscribe"
+	MethodResourcesUnsubscribe = "resources/unsubscribe"
+
+	// maxSubscriptions 单个 MCP 会话最多订阅的资源数量
+	maxSubscriptions = 100
+
+	// maxSubscribeBody resources/subscribe 请求体大小上限，超出时不做拦截
+	maxSubscribeBody = 64 << 10
+)
+
+// Subscriptions MCP 会话的资源订阅
+//
+// 支持订阅 chatlog://session/recent 与 chatlog://chat/{talker}/{date}，
+// 会话有新消息时向订阅了相关资源的客户端推送 notifications/resources/updated
+type Subscriptions struct {
+	mu       sync.RWMutex
+	sessions map[string]map[string]*subscription
+}
+
+type subscription struct {
+	uri    string
+	recent bool
+	talker []string
+	end    time.Time
+}
+
+func NewSubscriptions() *Subscriptions {
+	return &Subscriptions{
+		sessions: make(map[string]map[string]*subscription),
+	}
+}
+
+// Subscribe 记录订阅，uri 不是可订阅的资源时返回错误
+func (s *Subscriptions) Subscribe(sessionID, uri string) error {
+	sub, err := parseSubscription(uri)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subs, ok := s.sessions[sessionID]
+	if !ok {
+		subs = make(map[string]*subscription)
+		s.sessions[sessionID] = subs
+	}
+	if _, ok := subs[uri]; !ok && len(subs) >= maxSubscriptions {
+		return errors.InvalidArg("uri")
+	}
+	subs[uri] = sub
+	return nil
+}
+
+func (s *Subscriptions) Unsubscribe(sessionID, uri string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if subs, ok := s.sessions[sessionID]; ok {
+		delete(subs, uri)
+		if len(subs) == 0 {
+			delete(s.sessions, sessionID)
+		}
+	}
+}
+
+// Remove 删除 MCP 会话的全部订阅
+func (s *Subscriptions) Remove(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, sessionID)
+}
+
+// Match 返回受会话新消息影响的订阅，按 MCP 会话 ID 分组
+func (s *Subscriptions) Match(session *model.Session) map[string][]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ret := make(map[string][]string)
+	for sessionID, subs := range s.sessions {
+		for uri, sub := range subs {
+			if sub.match(session) {
+				ret[sessionID] = append(ret[sessionID], uri)
+			}
+		}
+	}
+	return ret
+}
+
+// parseSubscription 解析订阅的资源 URI
+func parseSubscription(uri string) (*subscription, error) {
+	if uri == RecentSessionResource.URI {
+		return &subscription{uri: uri, recent: true}, nil
+	}
+
+	path, ok := strings.CutPrefix(uri, "chatlog://chat/")
+	if !ok {
+		return nil, errors.InvalidArg("uri")
+	}
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		return nil, errors.InvalidArg("uri")
+	}
+	talker, err := url.PathUnescape(path[:i])
+	if err != nil || talker == "" {
+		return nil, errors.InvalidArg("uri")
+	}
+	date, err := url.PathUnescape(path[i+1:])
+	if err != nil {
+		return nil, errors.InvalidArg("uri")
+	}
+	_, end, ok := util.TimeRangeOf(date)
+	if !ok {
+		return nil, errors.InvalidArg("uri")
+	}
+	return &subscription{
+		uri:    uri,
+		talker: util.Str2List(talker, ","),
+		end:    end,
+	}, nil
+}
+
+func (s *subscription) match(session *model.Session) bool {
+	if s.recent {
+		return true
+	}
+	if session.NTime.Time().After(s.end) {
+		return false
+	}
+	for _, talker := range s.talker {
+		if talker == session.TopicID || talker == session.TopicName {
+			return true
+		}
+	}
+	return false
+}
+
+// initMCPSubscriptions 注册订阅相关的 hook 与新消息回调
+// SSE 传输只能通过事件流返回响应，无法处理 mcp-go 不支持的订阅请求，因此只向 Streamable HTTP 与 stdio 会话声明 subscribe
+func (s *Service) initMCPSubscriptions(hooks *server.Hooks) {
+	s.mcpSubscriptions = NewSubscriptions()
+	hooks.AddAfterInitialize(func(ctx context.Context, id any, message *mcp.InitializeRequest, result *mcp.InitializeResult) {
+		if result.Capabilities.Resources != nil && ctx.Value(noSubscribeKey{}) == nil {
+			result.Capabilities.Resources.Subscribe = true
+		}
+	})
+	hooks.AddOnUnregisterSession(func(ctx context.Context, session server.ClientSession) {
+		s.mcpSubscriptions.Remove(session.SessionID())
+	})
+	if s.db != nil {
+		s.db.OnSessionUpdate(s.notifyResourceUpdated)
+	}
+}
+
+// noSubscribeKey 标记不支持订阅的传输，见 withoutSubscribe
+type noSubscribeKey struct{}
+
+// withoutSubscribe SSE 传输的 context，初始化时不声明 subscribe
+func withoutSubscribe(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, noSubscribeKey{}, true)
+}
+
+func (s *Service) notifyResourceUpdated(session *model.Session) {
+	for sessionID, uris := range s.mcpSubscriptions.Match(session) {
+		for _, uri := range uris {
+			err := s.mcpServer.SendNotificationToSpecificClient(sessionID, mcp.MethodNotificationResourceUpdated, map[string]any{"uri": uri})
+			if err != nil {
+				log.Debug().Err(err).Str("session", sessionID).Str("uri", uri).Msg("send resource updated notification failed")
+			}
+		}
+	}
+}
+
+// mcpSubscribeMiddleware 处理 Streamable HTTP 的 resources/subscribe 与 resources/unsubscribe 请求
+//
+// mcp-go 不会路由这两个方法，这里记录订阅并直接返回 JSON-RPC 响应，不再转发给 MCP 服务
+func (s *Service) mcpSubscribeMiddleware(sessionID func(c *gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodPost || c.Request.Body == nil {
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSubscribeBody+1))
+		c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+		if err != nil || len(body) > maxSubscribeBody {
+			return
+		}
+
+		req, ok := parseSubscribeRequest(body)
+		if !ok {
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusOK, req.response(s.subscribe(c.Request.Context(), req, sessionID(c))))
+	}
+}
+
+type subscribeRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  struct {
+		URI string `json:"uri"`
+	} `json:"params"`
+}
+
+// parseSubscribeRequest 解析 resources/subscribe 与 resources/unsubscribe 请求，其他请求返回 false
+func parseSubscribeRequest(body []byte) (*subscribeRequest, bool) {
+	var req subscribeRequest
+	if err := json.Unmarshal(body, &req); err != nil || len(req.ID) == 0 {
+		return nil, false
+	}
+	if req.Method != MethodResourcesSubscribe && req.Method != MethodResourcesUnsubscribe {
+		return nil, false
+	}
+	return &req, true
+}
+
+// subscribe 按请求记录或取消订阅，只能订阅令牌允许访问的会话
+func (s *Service) subscribe(ctx context.Context, req *subscribeRequest, sessionID string) error {
+	if sessionID == "" {
+		return errors.InvalidArg("sessionId")
+	}
+	if err := checkScope(ctx, conf.ScopeMessages); err != nil {
+		return err
+	}
+	if req.Method == MethodResourcesUnsubscribe {
+		s.mcpSubscriptions.Unsubscribe(sessionID, req.Params.URI)
+		return nil
+	}
+	if sub, err := parseSubscription(req.Params.URI); err == nil && len(sub.talker) > 0 {
+		if err := s.checkTalker(ctx, strings.Join(sub.talker, ",")); err != nil {
+			return err
+		}
+	}
+	return s.mcpSubscriptions.Subscribe(sessionID, req.Params.URI)
+}
+
+// response 订阅请求的 JSON-RPC 响应，err 不为空时返回参数错误
+func (r *subscribeRequest) response(err error) map[string]any {
+	resp := map[string]any{
+		"jsonrpc": mcp.JSONRPC_VERSION,
+		"id":      r.ID,
+	}
+	if err != nil {
+		resp["error"] = map[string]any{
+			"code":    mcp.INVALID_PARAMS,
+			"message": err.Error(),
+		}
+	} else {
+		resp["result"] = map[string]any{}
+	}
+	return resp
+}
+
+func streamableSessionID(c *gin.Context) string {
+	return c.GetHeader(server.HeaderKeySessionID)
+}
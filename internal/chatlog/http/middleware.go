@@ -31,7 +31,11 @@ func (s *Service) checkDBStateMiddleware() gin.HandlerFunc {
 			c.Abort()
 			return
 		case database.StateDecrypting:
-			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database is decrypting, please wait"})
+			resp := gin.H{"error": "database is decrypting, please wait"}
+			if p := s.db.DecryptProgress(); p != nil {
+				resp["progress"] = p
+			}
+			c.JSON(http.StatusServiceUnavailable, resp)
 			c.Abort()
 			return
 		case database.StateError:
@@ -3,16 +3,24 @@ package http
 import (
 	"embed"
 	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"io/fs"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
 
+	"github.com/sjzar/chatlog/internal/chatlog/conf"
+	"github.com/sjzar/chatlog/internal/chatlog/verify"
+	"github.com/sjzar/chatlog/internal/chatlog/webhook"
 	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
 	"github.com/sjzar/chatlog/pkg/util"
 	"github.com/sjzar/chatlog/pkg/util/dat2img"
 	"github.com/sjzar/chatlog/pkg/util/silk"
@@ -58,33 +66,49 @@ func mustSub(fsys fs.FS, dir string) fs.FS {
 }
 
 func (s *Service) initMediaRouter() {
-	s.router.GET("/image/*key", func(c *gin.Context) { s.handleMedia(c, "image") })
-	s.router.GET("/video/*key", func(c *gin.Context) { s.handleMedia(c, "video") })
-	s.router.GET("/file/*key", func(c *gin.Context) { s.handleMedia(c, "file") })
-	s.router.GET("/voice/*key", func(c *gin.Context) { s.handleMedia(c, "voice") })
-	s.router.GET("/data/*path", s.handleMediaData)
+	media := s.router.Group("/", s.authMiddleware(conf.ScopeMedia))
+	{
+		media.GET("/image/*key", func(c *gin.Context) { s.handleMedia(c, "image") })
+		media.GET("/video/*key", func(c *gin.Context) { s.handleMedia(c, "video") })
+		media.GET("/file/*key", func(c *gin.Context) { s.handleMedia(c, "file") })
+		media.GET("/voice/*key", func(c *gin.Context) { s.handleMedia(c, "voice") })
+		media.GET("/data/*path", s.handleMediaData)
+	}
 }
 
 func (s *Service) initAPIRouter() {
 	api := s.router.Group("/api/v1", s.checkDBStateMiddleware())
 	{
-		api.GET("/chatlog", s.handleChatlog)
-		api.GET("/contact", s.handleContacts)
-		api.GET("/chatroom", s.handleChatRooms)
-		api.GET("/session", s.handleSessions)
+		messages := api.Group("", s.authMiddleware(conf.ScopeMessages))
+		messages.GET("/chatlog", s.handleChatlog)
+		messages.GET("/chatlog/context", s.handleMessageContext)
+		messages.GET("/search", s.handleSearch)
+		messages.GET("/stats", s.handleStats)
+		messages.GET("/session", s.handleSessions)
+
+		contacts := api.Group("", s.authMiddleware(conf.ScopeContacts))
+		contacts.GET("/contact", s.handleContacts)
+		contacts.GET("/chatroom", s.handleChatRooms)
+
+		admin := api.Group("", s.authMiddleware(conf.ScopeAdmin))
+		admin.GET("/webhook/deliveries", s.handleWebhookDeliveries)
+		admin.GET("/verify", s.handleVerify)
 	}
 }
 
 func (s *Service) initMCPRouter() {
-	s.router.Any("/mcp", func(c *gin.Context) {
-		s.mcpStreamableServer.ServeHTTP(c.Writer, c.Request)
-	})
-	s.router.Any("/sse", func(c *gin.Context) {
-		s.mcpSSEServer.ServeHTTP(c.Writer, c.Request)
-	})
-	s.router.Any("/message", func(c *gin.Context) {
-		s.mcpSSEServer.ServeHTTP(c.Writer, c.Request)
-	})
+	mcp := s.router.Group("/", s.authMiddleware(conf.ScopeMCP))
+	{
+		mcp.Any("/mcp", s.mcpSubscribeMiddleware(streamableSessionID), func(c *gin.Context) {
+			s.mcpStreamableServer.ServeHTTP(c.Writer, c.Request)
+		})
+		mcp.Any("/sse", func(c *gin.Context) {
+			s.mcpSSEServer.ServeHTTP(c.Writer, c.Request)
+		})
+		mcp.Any("/message", func(c *gin.Context) {
+			s.mcpSSEServer.ServeHTTP(c.Writer, c.Request)
+		})
+	}
 }
 
 // NoRoute handles 404 Not Found errors. If the request URL starts with "/api"
@@ -107,6 +131,7 @@ func (s *Service) handleChatlog(c *gin.Context) {
 		Talker  string `form:"talker"`
 		Sender  string `form:"sender"`
 		Keyword string `form:"keyword"`
+		Cursor  string `form:"cursor"`
 		Limit   int    `form:"limit"`
 		Offset  int    `form:"offset"`
 		Format  string `form:"format"`
@@ -117,10 +142,16 @@ func (s *Service) handleChatlog(c *gin.Context) {
 		return
 	}
 
+	if err := s.checkTalker(c.Request.Context(), q.Talker); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
 	var err error
 	start, end, ok := util.TimeRangeOf(q.Time)
 	if !ok {
 		errors.Err(c, errors.InvalidArg("time"))
+		return
 	}
 	if q.Limit < 0 {
 		q.Limit = 0
@@ -130,13 +161,25 @@ func (s *Service) handleChatlog(c *gin.Context) {
 		q.Offset = 0
 	}
 
-	resp, err := s.db.GetMessages(start, end, q.Talker, q.Sender, q.Keyword, q.Limit, q.Offset)
+	switch strings.ToLower(q.Format) {
+	case "ndjson", "jsonl":
+		s.streamChatlog(c, start, end, q.Talker, q.Sender, q.Keyword, q.Cursor, q.Limit, q.Offset)
+		return
+	}
+
+	resp, err := s.db.GetMessages(start, end, q.Talker, q.Sender, q.Keyword, q.Cursor, q.Limit, q.Offset)
 	if err != nil {
 		errors.Err(c, err)
 		return
 	}
 
-	c.Header("X-Total-Count", fmt.Sprintf("%d", resp.Total))
+	// 按关键词分页查询时不统计总数
+	if resp.Total != nil {
+		c.Header("X-Total-Count", fmt.Sprintf("%d", *resp.Total))
+	}
+	if resp.NextCursor != "" {
+		c.Header("X-Next-Cursor", resp.NextCursor)
+	}
 
 	switch strings.ToLower(q.Format) {
 	case "csv":
@@ -149,7 +192,7 @@ func (s *Service) handleChatlog(c *gin.Context) {
 		csvWriter := csv.NewWriter(c.Writer)
 		csvWriter.Write([]string{"Time", "SenderName", "Sender", "TalkerName", "Talker", "Content"})
 		for _, m := range resp.Items {
-			csvWriter.Write(m.CSV(c.Request.Host))
+			csvWriter.Write(m.CSV(mediaHost(c)))
 		}
 		csvWriter.Flush()
 	case "json":
@@ -163,13 +206,295 @@ func (s *Service) handleChatlog(c *gin.Context) {
 		c.Writer.Flush()
 
 		for _, m := range resp.Items {
-			c.Writer.WriteString(m.PlainText(strings.Contains(q.Talker, ","), util.PerfectTimeFormat(start, end), c.Request.Host))
+			c.Writer.WriteString(m.PlainText(strings.Contains(q.Talker, ","), util.PerfectTimeFormat(start, end), mediaHost(c)))
 			c.Writer.WriteString("\n")
 			c.Writer.Flush()
 		}
 	}
 }
 
+// chatlogTrailer NDJSON 输出的最后一行，用于确认输出完整并携带统计信息
+type chatlogTrailer struct {
+	Trailer    bool   `json:"_trailer"`
+	Count      int    `json:"count"`
+	Total      *int   `json:"total,omitempty"` // 按关键词查询且分页时不统计，省略
+	NextCursor string `json:"nextCursor,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ndjsonFlushInterval 每输出多少条消息刷新一次缓冲区
+const ndjsonFlushInterval = 100
+
+// streamChatlog 以 NDJSON 格式逐条输出消息，每行一个 model.Message，最后输出一行 trailer
+// 消息在从数据源读出时立即写出，客户端断开连接后通过请求的 context 停止查询
+func (s *Service) streamChatlog(c *gin.Context, start, end time.Time, talker, sender, keyword, cursor string, limit, offset int) {
+	ctx := c.Request.Context()
+
+	c.Writer.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	c.Writer.Header().Set("X-Content-Type-Options", "nosniff")
+	c.Status(http.StatusOK)
+
+	enc := json.NewEncoder(c.Writer)
+	enc.SetEscapeHTML(false)
+
+	written := 0
+	resp, err := s.db.StreamMessages(ctx, start, end, talker, sender, keyword, cursor, limit, offset, func(m *model.Message) error {
+		if err := enc.Encode(m); err != nil {
+			return err
+		}
+		if written++; written%ndjsonFlushInterval == 0 {
+			c.Writer.Flush()
+		}
+		return nil
+	})
+
+	// 尚未输出任何内容时按普通错误响应
+	if err != nil && !c.Writer.Written() {
+		errors.Err(c, err)
+		return
+	}
+
+	if ctx.Err() != nil {
+		// 客户端已断开，无需再输出 trailer
+		log.Debug().Err(ctx.Err()).Msg("chatlog stream canceled")
+		return
+	}
+
+	trailer := chatlogTrailer{Trailer: true}
+	if resp != nil {
+		trailer.Count = resp.Count
+		trailer.NextCursor = resp.NextCursor
+	}
+	if err != nil {
+		trailer.Error = err.Error()
+	}
+
+	// 未分页时输出的条数即为总数，否则不按关键词过滤时单独统计
+	if limit <= 0 && offset <= 0 && cursor == "" {
+		trailer.Total = &trailer.Count
+	} else if err == nil && keyword == "" {
+		if total, err := s.db.GetMessagesCount(ctx, start, end, talker, sender); err == nil {
+			trailer.Total = &total
+		} else {
+			log.Debug().Err(err).Msg("chatlog stream count failed")
+		}
+	}
+
+	enc.Encode(trailer)
+	c.Writer.Flush()
+}
+
+func (s *Service) handleSearch(c *gin.Context) {
+
+	q := struct {
+		Keyword string `form:"keyword"`
+		Time    string `form:"time"`
+		Sender  string `form:"sender"`
+		Cursor  string `form:"cursor"`
+		Limit   int    `form:"limit"`
+		Offset  int    `form:"offset"`
+		Format  string `form:"format"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	if q.Time == "" {
+		q.Time = "all"
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return
+	}
+	q.Limit, q.Offset = searchPage(q.Limit, q.Offset)
+
+	resp, err := s.searchMessages(c.Request.Context(), start, end, q.Keyword, q.Sender, q.Cursor, q.Limit, q.Offset)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	c.Header("X-Total-Count", fmt.Sprintf("%d", resp.Total))
+	c.Header("X-Search-Truncated", fmt.Sprintf("%t", resp.Truncated))
+	if resp.NextCursor != "" {
+		c.Header("X-Next-Cursor", resp.NextCursor)
+	}
+
+	switch strings.ToLower(q.Format) {
+	case "json":
+		c.JSON(http.StatusOK, resp)
+	default:
+		c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		c.Writer.Header().Set("Cache-Control", "no-cache")
+		c.Writer.Flush()
+
+		for _, hit := range resp.Items {
+			c.Writer.WriteString(hit.PlainText())
+			c.Writer.WriteString("\n")
+		}
+		c.Writer.Flush()
+	}
+}
+
+// mediaHost 返回消息中媒体链接使用的地址，包含请求的协议，见 model.MediaURL
+func mediaHost(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+const (
+	searchDefaultLimit = 20
+	searchMaxLimit     = 100
+)
+
+// searchPage 规范化搜索的分页参数
+func searchPage(limit, offset int) (int, int) {
+	if limit <= 0 {
+		limit = searchDefaultLimit
+	}
+	if limit > searchMaxLimit {
+		limit = searchMaxLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+func (s *Service) handleStats(c *gin.Context) {
+
+	q := struct {
+		Talker string `form:"talker"`
+		Time   string `form:"time"`
+		Top    int    `form:"top"`
+		Format string `form:"format"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	if q.Time == "" {
+		q.Time = "all"
+	}
+	start, end, ok := util.TimeRangeOf(q.Time)
+	if !ok {
+		errors.Err(c, errors.InvalidArg("time"))
+		return
+	}
+
+	if err := s.checkTalker(c.Request.Context(), q.Talker); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	stats, err := s.db.GetMessageStats(start, end, q.Talker, statsTop(q.Top))
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	switch strings.ToLower(q.Format) {
+	case "json":
+		c.JSON(http.StatusOK, stats)
+	default:
+		c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		c.Writer.Header().Set("Cache-Control", "no-cache")
+		c.Writer.WriteString(stats.PlainText(0))
+	}
+}
+
+const (
+	statsDefaultTop = 10
+	statsMaxTop     = 100
+)
+
+// statsTop 规范化统计结果中链接和文件的数量
+func statsTop(top int) int {
+	if top <= 0 {
+		return statsDefaultTop
+	}
+	return min(top, statsMaxTop)
+}
+
+func (s *Service) handleMessageContext(c *gin.Context) {
+
+	q := struct {
+		Talker string `form:"talker"`
+		Seq    string `form:"seq"`
+		Before int    `form:"before,default=10"`
+		After  int    `form:"after,default=10"`
+		Format string `form:"format"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	seqs, err := contextSeqs(q.Seq)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+	before, after := contextSize(q.Before, q.After)
+	if err := s.checkTalker(c.Request.Context(), q.Talker); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	resp, err := s.db.GetMessageContext(q.Talker, seqs, before, after)
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	switch strings.ToLower(q.Format) {
+	case "json":
+		c.JSON(http.StatusOK, resp)
+	default:
+		c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		c.Writer.Header().Set("Cache-Control", "no-cache")
+		c.Writer.WriteString(messageContextText(resp, mediaHost(c)))
+	}
+}
+
+const (
+	contextDefaultSize = 10
+	contextMaxSize     = 100
+	contextMaxSeqs     = 50
+)
+
+// contextSeqs 解析逗号分隔的消息 seq
+func contextSeqs(value string) ([]int64, error) {
+	list := util.Str2List(value, ",")
+	if len(list) == 0 || len(list) > contextMaxSeqs {
+		return nil, errors.InvalidArg("seq")
+	}
+	seqs := make([]int64, 0, len(list))
+	for _, item := range list {
+		seq, err := strconv.ParseInt(strings.TrimSpace(item), 10, 64)
+		if err != nil {
+			return nil, errors.InvalidArg("seq")
+		}
+		seqs = append(seqs, seq)
+	}
+	return seqs, nil
+}
+
+// contextSize 规范化上下文的消息数量
+func contextSize(before, after int) (int, int) {
+	return min(max(before, 0), contextMaxSize), min(max(after, 0), contextMaxSize)
+}
+
 func (s *Service) handleContacts(c *gin.Context) {
 
 	q := struct {
@@ -184,7 +509,7 @@ func (s *Service) handleContacts(c *gin.Context) {
 		return
 	}
 
-	list, err := s.db.GetContacts(q.Keyword, -1, q.Limit, q.Offset)
+	list, err := s.getContacts(c.Request.Context(), q.Keyword, q.Limit, q.Offset)
 	if err != nil {
 		errors.Err(c, err)
 		return
@@ -231,7 +556,7 @@ func (s *Service) handleChatRooms(c *gin.Context) {
 		return
 	}
 
-	list, err := s.db.GetChatRooms(q.Keyword, q.Limit, q.Offset)
+	list, err := s.getChatRooms(c.Request.Context(), q.Keyword, q.Limit, q.Offset)
 	if err != nil {
 		errors.Err(c, err)
 		return
@@ -282,7 +607,7 @@ func (s *Service) handleSessions(c *gin.Context) {
 		q.Limit = 50
 	}
 
-	sessions, err := s.db.GetSessions(q.Keyword, q.Limit, q.Offset)
+	sessions, err := s.getSessions(c.Request.Context(), q.Keyword, q.Limit, q.Offset)
 	if err != nil {
 		errors.Err(c, err)
 		return
@@ -321,6 +646,51 @@ func (s *Service) handleSessions(c *gin.Context) {
 	}
 }
 
+func (s *Service) handleWebhookDeliveries(c *gin.Context) {
+	q := struct {
+		Status string `form:"status"`
+		Limit  int    `form:"limit"`
+		Body   bool   `form:"body"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	switch q.Status {
+	case "", webhook.DeliveryPending, webhook.DeliveryDelivered, webhook.DeliveryDead:
+	default:
+		errors.Err(c, errors.InvalidArg("status"))
+		return
+	}
+	if q.Limit <= 0 {
+		q.Limit = 50
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items": s.db.GetWebhookDeliveries(q.Status, q.Limit, q.Body),
+	})
+}
+
+func (s *Service) handleVerify(c *gin.Context) {
+	q := struct {
+		Quick bool `form:"quick"`
+	}{}
+
+	if err := c.BindQuery(&q); err != nil {
+		errors.Err(c, err)
+		return
+	}
+
+	report, err := verify.Run(c.Request.Context(), s.conf, verify.Options{Quick: q.Quick})
+	if err != nil {
+		errors.Err(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
 func (s *Service) handleMedia(c *gin.Context, _type string) {
 	key := strings.TrimPrefix(c.Param("key"), "/")
 	if key == "" {
@@ -347,6 +717,11 @@ func (s *Service) handleMedia(c *gin.Context, _type string) {
 			_err = err
 			continue
 		}
+		// 语音没有文件路径，令牌限制了会话时同样被拒绝
+		if err := s.checkMedia(c.Request.Context(), media.Path); err != nil {
+			_err = err
+			continue
+		}
 		if c.Query("info") != "" {
 			c.JSON(http.StatusOK, media)
 			return
@@ -400,6 +775,10 @@ func (s *Service) handleMediaData(c *gin.Context) {
 		errors.Err(c, err)
 		return
 	}
+	if err := s.checkMedia(c.Request.Context(), relativePath); err != nil {
+		errors.Err(c, err)
+		return
+	}
 
 	absolutePath := filepath.Join(s.conf.GetDataDir(), relativePath)
 
@@ -2,13 +2,18 @@ package http
 
 import (
 	"context"
+	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/mark3labs/mcp-go/server"
 	"github.com/rs/zerolog/log"
 
+	"github.com/sjzar/chatlog/internal/chatlog/conf"
 	"github.com/sjzar/chatlog/internal/chatlog/database"
 	"github.com/sjzar/chatlog/internal/errors"
 )
@@ -23,11 +28,17 @@ type Service struct {
 	mcpServer           *server.MCPServer
 	mcpSSEServer        *server.SSEServer
 	mcpStreamableServer *server.StreamableHTTPServer
+	mcpSubscriptions    *Subscriptions
 }
 
 type Config interface {
 	GetHTTPAddr() string
 	GetDataDir() string
+	GetWorkDir() string
+	GetDataKey() string
+	GetPlatform() string
+	GetAuth() *conf.Auth
+	GetTLS() *conf.TLS
 }
 
 func NewService(conf Config, db *database.Service) *Service {
@@ -43,7 +54,11 @@ func NewService(conf Config, db *database.Service) *Service {
 	router.Use(
 		errors.RecoveryMiddleware(),
 		errors.ErrorHandlerMiddleware(),
-		gin.LoggerWithWriter(log.Logger, "/health"),
+		gin.LoggerWithConfig(gin.LoggerConfig{
+			Output:    log.Logger,
+			SkipPaths: []string{"/health"},
+			Formatter: logFormatter,
+		}),
 		corsMiddleware(),
 	)
 
@@ -58,34 +73,100 @@ func NewService(conf Config, db *database.Service) *Service {
 	return s
 }
 
-func (s *Service) Start() error {
+// logFormatter 与 gin 默认的日志格式相同（不输出颜色），查询参数中的令牌替换为 redacted，避免写入日志
+func logFormatter(param gin.LogFormatterParams) string {
+	if u, err := url.Parse(param.Path); err == nil {
+		if q := u.Query(); q.Has("token") {
+			q.Set("token", "redacted")
+			u.RawQuery = q.Encode()
+			param.Path = u.String()
+		}
+	}
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency,
+		param.ClientIP,
+		param.Method,
+		param.Path,
+		param.ErrorMessage,
+	)
+}
 
-	s.server = &http.Server{
-		Addr:    s.conf.GetHTTPAddr(),
-		Handler: s.router,
+func (s *Service) Start() error {
+	ln, err := s.listen()
+	if err != nil {
+		return err
 	}
 
 	go func() {
 		// Handle error from Run
-		if err := s.server.ListenAndServe(); err != nil {
+		if err := s.serve(ln); err != nil && err != http.ErrServerClosed {
 			log.Err(err).Msg("Failed to start HTTP server")
 		}
 	}()
 
-	log.Info().Msg("Starting HTTP server on " + s.conf.GetHTTPAddr())
-
 	return nil
 }
 
 func (s *Service) ListenAndServe() error {
+	ln, err := s.listen()
+	if err != nil {
+		return err
+	}
+	return s.serve(ln)
+}
+
+// listen 按配置创建监听与 http.Server，开启 HTTPS 时同时加载证书
+func (s *Service) listen() (net.Listener, error) {
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		return nil, errors.HTTPShutDown(err)
+	}
+
+	addr := s.conf.GetHTTPAddr()
+	ln, err := listen(addr)
+	if err != nil {
+		return nil, errors.HTTPShutDown(err)
+	}
 
 	s.server = &http.Server{
-		Addr:    s.conf.GetHTTPAddr(),
-		Handler: s.router,
+		Addr:      addr,
+		Handler:   s.router,
+		TLSConfig: tlsConfig,
+	}
+
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+	log.Info().Msgf("Starting HTTP server on %s (%s)", addr, scheme)
+	s.warnNoAuth()
+	return ln, nil
+}
+
+func (s *Service) serve(ln net.Listener) error {
+	if s.server.TLSConfig != nil {
+		return s.server.ServeTLS(ln, "", "")
 	}
+	return s.server.Serve(ln)
+}
 
-	log.Info().Msg("Starting HTTP server on " + s.conf.GetHTTPAddr())
-	return s.server.ListenAndServe()
+// warnNoAuth 监听非本机地址且未配置访问令牌时提示
+func (s *Service) warnNoAuth() {
+	if s.conf.GetAuth().Enabled() || strings.HasPrefix(s.conf.GetHTTPAddr(), conf.UnixAddrPrefix) {
+		return
+	}
+	host, _, err := net.SplitHostPort(s.conf.GetHTTPAddr())
+	if err == nil {
+		if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return
+		}
+	}
+	log.Warn().Msg("HTTP server is listening on a non-loopback address without access tokens, configure auth.tokens to protect chat history")
 }
 
 func (s *Service) Stop() error {
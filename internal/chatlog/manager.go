@@ -13,10 +13,16 @@ import (
 	"github.com/sjzar/chatlog/internal/chatlog/conf"
 	"github.com/sjzar/chatlog/internal/chatlog/ctx"
 	"github.com/sjzar/chatlog/internal/chatlog/database"
+	"github.com/sjzar/chatlog/internal/chatlog/export"
 	"github.com/sjzar/chatlog/internal/chatlog/http"
+	"github.com/sjzar/chatlog/internal/chatlog/verify"
 	"github.com/sjzar/chatlog/internal/chatlog/wechat"
 	iwechat "github.com/sjzar/chatlog/internal/wechat"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/atrest"
+	"github.com/sjzar/chatlog/internal/wechat/key"
 	"github.com/sjzar/chatlog/internal/wechatdb"
+	"github.com/sjzar/chatlog/internal/wechatdb/datasource"
 	"github.com/sjzar/chatlog/pkg/config"
 	"github.com/sjzar/chatlog/pkg/filemonitor"
 	"github.com/sjzar/chatlog/pkg/util"
@@ -207,6 +213,7 @@ func (m *Manager) StartService() error {
 	// 更新 xorkey
 	dat2img.SetAesKey(m.ctx.ImgKey)
 	go dat2img.ScanAndSetXorKey(m.ctx.DataDir)
+	dat2img.NoTempFiles = atrest.Enabled(m.ctx.WorkDir)
 
 	// 更新状态
 	m.ctx.SetHTTPEnabled(true)
@@ -536,7 +543,37 @@ func (m *Manager) CommandKey(configPath string, pid int, force bool, showXorKey
 	return "", fmt.Errorf("wechat process not found")
 }
 
-func (m *Manager) CommandDecrypt(configPath string, cmdConf map[string]any) error {
+// CommandKeyFromDump 从内存转储文件离线搜索密钥，不需要运行中的微信进程
+// dbPath 为用于校验数据密钥的加密数据库，datDir 不为空时使用其中的 .dat 文件同时搜索图片密钥
+func (m *Manager) CommandKeyFromDump(ctx context.Context, platform string, dumpPath string, dbPath string, datDir string, showXorKey bool, onProgress func(key.DumpProgress)) (string, error) {
+	if len(dbPath) == 0 {
+		return "", fmt.Errorf("db is required to validate the data key")
+	}
+
+	validator, err := decrypt.NewDBValidator(platform, dbPath, datDir)
+	if err != nil {
+		return "", err
+	}
+	if len(datDir) != 0 && !validator.HasImgKeyValidator() {
+		log.Warn().Msgf("no encrypted .dat file found in %s, skip image key", datDir)
+	}
+
+	dataKey, imgKey, err := key.SearchDump(ctx, platform, dumpPath, validator, len(datDir) != 0, onProgress)
+	if err != nil {
+		return "", err
+	}
+
+	result := fmt.Sprintf("Data Key: [%s]\nImage Key: [%s]", dataKey, imgKey)
+	if showXorKey && len(datDir) != 0 {
+		if b, err := dat2img.ScanAndSetXorKey(datDir); err == nil {
+			result += fmt.Sprintf("\nXor Key: [0x%X]", b)
+		}
+	}
+	return result, nil
+}
+
+// CommandDecrypt 解密所有数据库文件，onProgress 接收解密进度
+func (m *Manager) CommandDecrypt(ctx context.Context, configPath string, cmdConf map[string]any, onProgress func(decrypt.BatchProgress)) error {
 
 	var err error
 	m.sc, m.scm, err = conf.LoadServiceConfig(configPath, cmdConf)
@@ -556,11 +593,31 @@ func (m *Manager) CommandDecrypt(configPath string, cmdConf map[string]any) erro
 
 	m.wechat = wechat.NewService(m.sc)
 
-	if err := m.wechat.DecryptDBFiles(); err != nil {
+	// 开始解密前解锁工作目录，口令错误时不解密任何文件
+	if _, err := m.wechat.WorkDirKey(); err != nil {
 		return err
 	}
 
-	return nil
+	return m.wechat.DecryptDBFilesWithProgress(ctx, onProgress)
+}
+
+// CommandVerify 校验解密结果，设置了数据目录与密钥时同时校验加密数据库
+func (m *Manager) CommandVerify(ctx context.Context, configPath string, cmdConf map[string]any, opts verify.Options) (*verify.Report, error) {
+
+	var err error
+	m.sc, m.scm, err = conf.LoadServiceConfig(configPath, cmdConf)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(m.sc.GetWorkDir()) == 0 {
+		return nil, fmt.Errorf("workDir is required")
+	}
+	if _, err := atrest.Open(m.sc.GetWorkDir()); err != nil {
+		return nil, err
+	}
+
+	return verify.Run(ctx, m.sc, opts)
 }
 
 func (m *Manager) CommandHTTPServer(configPath string, cmdConf map[string]any) error {
@@ -599,6 +656,13 @@ func (m *Manager) CommandHTTPServer(configPath string, cmdConf map[string]any) e
 
 	m.wechat = wechat.NewService(m.sc)
 
+	// 启动服务前解锁工作目录，避免在后台解密或查询时才发现缺少口令
+	key, err := m.wechat.WorkDirKey()
+	if err != nil {
+		return err
+	}
+	dat2img.NoTempFiles = key != nil
+
 	m.db = database.NewService(m.sc)
 
 	// 注入 DBController，用于在解密替换文件时控制连接（锁定、关闭、解锁）
@@ -622,7 +686,7 @@ func (m *Manager) CommandHTTPServer(configPath string, cmdConf map[string]any) e
 		if entries, err := os.ReadDir(workDir); err == nil && len(entries) == 0 {
 			log.Info().Msgf("work dir is empty, decrypt data.")
 			m.db.SetDecrypting()
-			if err := m.wechat.DecryptDBFiles(); err != nil {
+			if err := m.wechat.DecryptDBFilesWithProgress(context.Background(), m.db.SetDecryptProgress); err != nil {
 				log.Info().Msgf("decrypt data failed: %v", err)
 				return
 			}
@@ -633,7 +697,7 @@ func (m *Manager) CommandHTTPServer(configPath string, cmdConf map[string]any) e
 		if err := m.db.Start(); err != nil {
 			log.Info().Msgf("start db failed, try to decrypt data.")
 			m.db.SetDecrypting()
-			if err := m.wechat.DecryptDBFiles(); err != nil {
+			if err := m.wechat.DecryptDBFilesWithProgress(context.Background(), m.db.SetDecryptProgress); err != nil {
 				log.Info().Msgf("decrypt data failed: %v", err)
 				return
 			}
@@ -649,6 +713,130 @@ func (m *Manager) CommandHTTPServer(configPath string, cmdConf map[string]any) e
 	return m.http.ListenAndServe()
 }
 
+// CommandExport 导出聊天记录到本地目录，不启动 HTTP 服务
+func (m *Manager) CommandExport(ctx context.Context, configPath string, cmdConf map[string]any, opts export.Options) ([]*export.Result, error) {
+
+	var err error
+	m.sc, m.scm, err = conf.LoadServiceConfig(configPath, cmdConf)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.sc.GetDebug() {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	}
+
+	workDir := m.sc.GetWorkDir()
+	if len(workDir) == 0 {
+		return nil, fmt.Errorf("workDir is required")
+	}
+
+	key, err := atrest.Open(workDir)
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		// 导出是用户要求的明文副本，不加密，但只允许当前用户访问
+		opts.Private = true
+		log.Warn().Msgf("work dir is encrypted, exported files in %s are NOT encrypted", opts.OutputDir)
+	}
+
+	dataDir := m.sc.GetDataDir()
+	if opts.Media && len(dataDir) == 0 {
+		return nil, fmt.Errorf("dataDir is required to export media, set --data-dir or --media=false")
+	}
+
+	// 处理图片密钥
+	if opts.Media {
+		dat2img.SetAesKey(m.sc.GetImgKey())
+		if _, err := dat2img.ScanAndSetXorKey(dataDir); err != nil {
+			log.Debug().Err(err).Msg("scan xor key failed")
+		}
+	}
+
+	// 未复制的媒体链接指向本机 HTTP 服务
+	if addr := m.sc.GetHTTPAddr(); len(opts.Host) == 0 && !strings.HasPrefix(addr, conf.UnixAddrPrefix) {
+		opts.Host = strings.Replace(addr, "0.0.0.0", "127.0.0.1", 1)
+		if m.sc.GetTLS().IsEnabled() {
+			opts.Host = "https://" + opts.Host
+		}
+	}
+
+	// 一次性导出不需要在后台建立全文索引
+	db, err := wechatdb.NewWithOptions(workDir, m.sc.GetPlatform(), datasource.Options{NoIndex: true})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	return export.New(db, dataDir).Export(ctx, opts)
+}
+
+// CommandMCP 通过 stdio 提供 MCP 服务，不启动 HTTP 服务
+func (m *Manager) CommandMCP(ctx context.Context, configPath string, cmdConf map[string]any) error {
+
+	var err error
+	m.sc, m.scm, err = conf.LoadServiceConfig(configPath, cmdConf)
+	if err != nil {
+		return err
+	}
+
+	if m.sc.GetDebug() {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	}
+
+	workDir := m.sc.GetWorkDir()
+	if len(workDir) == 0 {
+		return fmt.Errorf("workDir is required")
+	}
+
+	// stdio 占用了标准输入，加密的工作目录只能通过环境变量提供口令
+	key, err := atrest.Open(workDir)
+	if err != nil {
+		return err
+	}
+	dat2img.NoTempFiles = key != nil
+
+	// 处理图片密钥，用于读取图片资源
+	dataDir := m.sc.GetDataDir()
+	if len(dataDir) != 0 {
+		dat2img.SetAesKey(m.sc.GetImgKey())
+		go dat2img.ScanAndSetXorKey(dataDir)
+	}
+
+	m.db = database.NewService(mcpConfig{m.sc})
+
+	// 自动解密需要数据目录与数据密钥，解密后的变更会推送给订阅了资源的客户端
+	if m.sc.GetAutoDecrypt() {
+		if len(dataDir) == 0 || len(m.sc.GetDataKey()) == 0 {
+			return fmt.Errorf("dataDir and dataKey are required for auto decrypt")
+		}
+		m.wechat = wechat.NewService(m.sc)
+		m.wechat.SetDBController(m.db)
+		if err := m.wechat.StartAutoDecrypt(); err != nil {
+			return err
+		}
+		defer m.wechat.StopAutoDecrypt()
+	}
+
+	if err := m.db.Start(); err != nil {
+		return err
+	}
+	defer m.db.Stop()
+
+	log.Info().Msgf("serving mcp over stdio, work dir: %s", workDir)
+	return http.NewMCPService(m.sc, m.db).ServeStdio(ctx, os.Stdin, os.Stdout)
+}
+
+// mcpConfig stdio MCP 服务不投递 webhook，避免与同时运行的 HTTP 服务重复投递
+type mcpConfig struct {
+	*conf.ServerConfig
+}
+
+func (mcpConfig) GetWebhook() *conf.Webhook {
+	return nil
+}
+
 func (m *Manager) CheckAndSyncData() {
 	var dataKey, dataDir, workDir string
 
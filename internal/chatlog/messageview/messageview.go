@@ -76,3 +76,31 @@ func (s *DedupStore) Seen(key string) bool {
 	s.items[key] = now.Add(s.ttl)
 	return false
 }
+
+// Contains 判断 key 是否已记录且未过期，不记录 key
+func (s *DedupStore) Contains(key string) bool {
+	if key == "" {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expiry, ok := s.items[key]
+	return ok && time.Now().Before(expiry)
+}
+
+// Add 记录 key，与 Contains 配合使用，在处理成功后再记录
+func (s *DedupStore) Add(keys ...string) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for existingKey, expiry := range s.items {
+		if now.After(expiry) {
+			delete(s.items, existingKey)
+		}
+	}
+	for _, key := range keys {
+		if key != "" {
+			s.items[key] = now.Add(s.ttl)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package verify
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/atrest"
+	"github.com/sjzar/chatlog/internal/wechatdb/datasource"
+	"github.com/sjzar/chatlog/pkg/filemonitor"
+)
+
+// maxIntegrityErrors integrity_check 最多返回的错误数
+const maxIntegrityErrors = 100
+
+type Config interface {
+	GetDataDir() string
+	GetWorkDir() string
+	GetDataKey() string
+	GetPlatform() string
+}
+
+type Options struct {
+	// Quick 使用 PRAGMA quick_check 代替 integrity_check，不校验索引内容，速度更快
+	Quick bool
+}
+
+// Report 解密结果的校验报告
+type Report struct {
+	OK      bool          `json:"ok"`
+	DataDir string        `json:"dataDir,omitempty"`
+	WorkDir string        `json:"workDir"`
+	Files   []*FileReport `json:"files"`
+}
+
+// FileReport 单个数据库的校验结果
+type FileReport struct {
+	// Path 相对数据目录的路径，未设置数据目录时相对工作目录
+	Path   string        `json:"path"`
+	OK     bool          `json:"ok"`
+	Source *SourceReport `json:"source,omitempty"`
+	Output *OutputReport `json:"output"`
+}
+
+// SourceReport 加密数据库的校验结果，未设置数据目录或密钥时不校验
+type SourceReport struct {
+	Encrypted    bool      `json:"encrypted"`
+	KeyValid     bool      `json:"keyValid"`
+	Pages        int64     `json:"pages"`
+	CorruptCount int64     `json:"corruptCount"`
+	CorruptPages []int64   `json:"corruptPages,omitempty"` // 从 0 开始的页号，最多列出 decrypt.MaxCorruptPages 个
+	ModTime      time.Time `json:"modTime"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// OutputReport 工作目录中解密结果的校验结果
+type OutputReport struct {
+	Missing       bool      `json:"missing,omitempty"`
+	Stale         bool      `json:"stale,omitempty"` // 加密数据库（包括 WAL）在解密之后被修改过
+	Integrity     []string  `json:"integrity,omitempty"`
+	MissingTables []string  `json:"missingTables,omitempty"`
+	ModTime       time.Time `json:"modTime,omitzero"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// Run 校验工作目录中的解密结果
+// 设置了数据目录与密钥时，同时校验每个加密数据库的密钥与逐页 HMAC，并检查解密结果是否过期
+func Run(ctx context.Context, conf Config, opts Options) (*Report, error) {
+	report := &Report{
+		OK:      true,
+		DataDir: conf.GetDataDir(),
+		WorkDir: conf.GetWorkDir(),
+		Files:   []*FileReport{},
+	}
+
+	root := report.WorkDir
+	if len(report.DataDir) != 0 {
+		root = report.DataDir
+	}
+	dbFiles, err := listDBFiles(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var decryptor decrypt.Decryptor
+	if len(report.DataDir) != 0 && len(conf.GetDataKey()) != 0 {
+		if decryptor, err = decrypt.NewDecryptor(conf.GetPlatform()); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, dbFile := range dbFiles {
+		if ctx.Err() != nil {
+			return nil, errors.ErrDecryptOperationCanceled
+		}
+
+		rel, err := filepath.Rel(root, dbFile)
+		if err != nil {
+			return nil, err
+		}
+		tables, err := datasource.RequiredTables(conf.GetPlatform(), filepath.Base(dbFile))
+		if err != nil {
+			return nil, err
+		}
+
+		file := &FileReport{Path: filepath.ToSlash(rel)}
+		if decryptor != nil {
+			file.Source = checkSource(ctx, decryptor, dbFile, conf.GetDataKey())
+		}
+		file.Output = checkOutput(ctx, report.WorkDir, filepath.Join(report.WorkDir, rel), tables, opts)
+		if len(report.DataDir) != 0 && !file.Output.Missing {
+			file.Output.Stale = isStale(dbFile, filepath.Join(report.WorkDir, rel), file.Output.ModTime)
+		}
+
+		file.OK = file.Source.ok() && file.Output.ok()
+		report.OK = report.OK && file.OK
+		report.Files = append(report.Files, file)
+	}
+
+	return report, nil
+}
+
+func listDBFiles(root string) ([]string, error) {
+	group, err := filemonitor.NewFileGroup("verify", root, `.*\.db$`, []string{"fts"})
+	if err != nil {
+		return nil, err
+	}
+	return group.List()
+}
+
+func checkSource(ctx context.Context, d decrypt.Decryptor, dbFile, key string) *SourceReport {
+	r := &SourceReport{Encrypted: true}
+	if info, err := os.Stat(dbFile); err == nil {
+		r.ModTime = info.ModTime()
+	}
+
+	pages, err := decrypt.VerifyPages(ctx, d, dbFile, key)
+	switch {
+	case err == nil:
+		r.KeyValid = true
+		r.Pages = pages.Pages
+		r.CorruptCount = pages.CorruptCount
+		r.CorruptPages = pages.Corrupt
+	case errors.Is(err, errors.ErrAlreadyDecrypted):
+		r.Encrypted = false
+		r.KeyValid = true
+	case errors.Is(err, errors.ErrDecryptIncorrectKey):
+	default:
+		r.Error = err.Error()
+	}
+	return r
+}
+
+func (r *SourceReport) ok() bool {
+	if r == nil {
+		return true
+	}
+	return r.KeyValid && r.CorruptCount == 0 && len(r.Error) == 0
+}
+
+func checkOutput(ctx context.Context, workDir, path string, tables []string, opts Options) *OutputReport {
+	r := &OutputReport{}
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			r.Missing = true
+		} else {
+			r.Error = err.Error()
+		}
+		return r
+	}
+	r.ModTime = info.ModTime()
+
+	db, err := openOutput(workDir, path)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	defer db.Close()
+
+	pragma := "integrity_check"
+	if opts.Quick {
+		pragma = "quick_check"
+	}
+	if r.Integrity, err = integrityCheck(ctx, db, pragma); err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	if r.MissingTables, err = missingTables(ctx, db, tables); err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// openOutput 只读打开解密结果，加密的工作目录中的数据库按页解密后校验
+func openOutput(workDir, path string) (*sql.DB, error) {
+	if sealed, err := atrest.IsSealed(path); err == nil && sealed {
+		key, err := atrest.Open(workDir)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			return nil, errors.ErrWorkDirPassphraseRequired
+		}
+		return key.OpenDB(path)
+	}
+
+	// immutable 模式只读打开，不创建 WAL/SHM 文件，也不受其他连接的锁影响
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro&immutable=1", filepath.ToSlash(path)))
+	if err != nil {
+		return nil, errors.DBConnectFailed(path, err)
+	}
+	return db, nil
+}
+
+func (r *OutputReport) ok() bool {
+	return !r.Missing && !r.Stale && len(r.Integrity) == 0 && len(r.MissingTables) == 0 && len(r.Error) == 0
+}
+
+// integrityCheck 执行完整性检查，返回发现的问题，没有问题时返回 nil
+func integrityCheck(ctx context.Context, db *sql.DB, pragma string) ([]string, error) {
+	query := fmt.Sprintf("PRAGMA %s(%d)", pragma, maxIntegrityErrors)
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, errors.QueryFailed(query, err)
+	}
+	defer rows.Close()
+
+	var ret []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return nil, errors.ScanRowFailed(err)
+		}
+		if msg != "ok" {
+			ret = append(ret, msg)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.QueryFailed(query, err)
+	}
+	return ret, nil
+}
+
+// missingTables 返回 tables 中数据库不存在的表
+func missingTables(ctx context.Context, db *sql.DB, tables []string) ([]string, error) {
+	if len(tables) == 0 {
+		return nil, nil
+	}
+	query := "SELECT name FROM sqlite_master WHERE type = 'table'"
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, errors.QueryFailed(query, err)
+	}
+	defer rows.Close()
+
+	exists := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, errors.ScanRowFailed(err)
+		}
+		// SQLite 的表名不区分大小写
+		exists[strings.ToLower(name)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.QueryFailed(query, err)
+	}
+
+	var ret []string
+	for _, table := range tables {
+		if !exists[strings.ToLower(table)] {
+			ret = append(ret, table)
+		}
+	}
+	return ret, nil
+}
+
+// isStale 加密数据库或其 WAL 的修改时间晚于解密结果
+// 增量解密没有变化的页时不会修改解密结果，但会更新页清单，因此以两者中较晚的时间为准
+func isStale(dbFile string, output string, modTime time.Time) bool {
+	if info, err := os.Stat(decrypt.ManifestPath(output)); err == nil && info.ModTime().After(modTime) {
+		modTime = info.ModTime()
+	}
+	for _, path := range []string{dbFile, dbFile + "-wal"} {
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(modTime) {
+			return true
+		}
+	}
+	return false
+}
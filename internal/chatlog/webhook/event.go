@@ -0,0 +1,608 @@
+package webhook
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/chatlog/conf"
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/internal/wechatdb"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+// webhook 类型
+const (
+	TypeMessage  = "message"
+	TypeContact  = "contact"
+	TypeChatRoom = "chatroom"
+	TypeSession  = "session"
+)
+
+// 事件
+const (
+	// contact
+	EventNewFriend      = "new_friend"
+	EventRemarkChanged  = "remark_changed"
+	EventContactDeleted = "contact_deleted"
+
+	// chatroom
+	EventMemberJoined = "member_joined"
+	EventMemberLeft   = "member_left"
+	EventOwnerChanged = "owner_changed"
+	EventAnnouncement = "announcement"
+
+	// session
+	EventUnread  = "unread"
+	EventRead    = "read"
+	EventMention = "mention"
+)
+
+// TypeEvents 各类型支持的事件
+var TypeEvents = map[string][]string{
+	TypeContact:  {EventNewFriend, EventRemarkChanged, EventContactDeleted},
+	TypeChatRoom: {EventMemberJoined, EventMemberLeft, EventOwnerChanged, EventAnnouncement},
+	TypeSession:  {EventUnread, EventRead, EventMention},
+}
+
+// sessionWindow 对比会话状态时读取的最近会话数量
+const sessionWindow = 200
+
+// Event contact、chatroom、session 类型的变化事件，不同类型的详细信息放在对应字段中
+type Event struct {
+	Type       string         `json:"type"`
+	Event      string         `json:"event"`
+	Time       model.JSONTime `json:"time"`
+	Talker     string         `json:"talker"` // 联系人、群聊或会话 ID
+	TalkerName string         `json:"talkerName"`
+
+	Contact  *ContactEvent  `json:"contact,omitempty"`
+	ChatRoom *ChatRoomEvent `json:"chatRoom,omitempty"`
+	Session  *SessionEvent  `json:"session,omitempty"`
+}
+
+// ContactEvent 联系人事件：new_friend、remark_changed、contact_deleted
+type ContactEvent struct {
+	*model.Contact
+	OldRemark string `json:"oldRemark,omitempty"`
+}
+
+// ChatRoomEvent 群聊事件：member_joined、member_left、owner_changed、announcement
+type ChatRoomEvent struct {
+	Members      []model.ChatRoomUser `json:"members,omitempty"`
+	MemberCount  int                  `json:"memberCount"`
+	Owner        string               `json:"owner,omitempty"`
+	OldOwner     string               `json:"oldOwner,omitempty"`
+	Announcement string               `json:"announcement,omitempty"`
+	Message      *model.Message       `json:"message,omitempty"`
+}
+
+// SessionEvent 会话事件：unread（未读 0 → n）、read（未读 n → 0）、mention（最新消息@我）
+type SessionEvent struct {
+	Unread      int    `json:"unread"`
+	OldUnread   int    `json:"oldUnread"`
+	IsMentionMe bool   `json:"isMentionMe"`
+	Content     string `json:"content"`
+	Sender      string `json:"sender"`
+	SenderName  string `json:"senderName"`
+}
+
+// String 事件的单行摘要，用于关键词过滤和内置模板
+func (e *Event) String() string {
+	name := e.TalkerName
+	if name == "" {
+		name = e.Talker
+	}
+	switch e.Event {
+	case EventNewFriend:
+		return fmt.Sprintf("新增好友 %s(%s)", name, e.Talker)
+	case EventContactDeleted:
+		return fmt.Sprintf("删除好友 %s(%s)", name, e.Talker)
+	case EventRemarkChanged:
+		return fmt.Sprintf("好友备注修改 %s: %s → %s", e.Talker, e.Contact.OldRemark, e.Contact.Remark)
+	case EventMemberJoined:
+		return fmt.Sprintf("[%s] %s 加入群聊", name, memberNames(e.ChatRoom.Members))
+	case EventMemberLeft:
+		return fmt.Sprintf("[%s] %s 退出群聊", name, memberNames(e.ChatRoom.Members))
+	case EventOwnerChanged:
+		return fmt.Sprintf("[%s] 群主变更: %s → %s", name, e.ChatRoom.OldOwner, e.ChatRoom.Owner)
+	case EventAnnouncement:
+		return fmt.Sprintf("[%s] 群公告: %s", name, e.ChatRoom.Announcement)
+	case EventUnread:
+		return fmt.Sprintf("[%s] %d 条未读: %s", name, e.Session.Unread, e.Session.Content)
+	case EventRead:
+		return fmt.Sprintf("[%s] 已读", name)
+	case EventMention:
+		return fmt.Sprintf("[%s] 有人@我: %s", name, e.Session.Content)
+	}
+	return fmt.Sprintf("[%s] %s", name, e.Event)
+}
+
+func memberNames(members []model.ChatRoomUser) string {
+	names := make([]string, 0, len(members))
+	for _, member := range members {
+		if member.DisplayName != "" {
+			names = append(names, member.DisplayName)
+		} else {
+			names = append(names, member.UserName)
+		}
+	}
+	return strings.Join(names, "、")
+}
+
+// EventPayload contact、chatroom、session 类型的模板数据，默认请求体即 EventPayload 的 JSON 序列化
+type EventPayload struct {
+	Type          string   `json:"type"`
+	FilterTalker  string   `json:"filter_talker"`
+	FilterSender  string   `json:"filter_sender"`
+	FilterKeyword string   `json:"filter_keyword"`
+	Length        int      `json:"length"`
+	Events        []*Event `json:"events"`
+
+	// Event 逐条投递时为当前事件，合并投递时为 nil
+	Event *Event `json:"-"`
+}
+
+func (p *EventPayload) Len() int {
+	return p.Length
+}
+
+func (p *EventPayload) Split() []Batch {
+	ret := make([]Batch, 0, len(p.Events))
+	for _, event := range p.Events {
+		single := *p
+		single.Length = 1
+		single.Events = []*Event{event}
+		single.Event = event
+		ret = append(ret, &single)
+	}
+	return ret
+}
+
+// detector 对比数据库的当前状态与上次的快照，返回发生的变化
+// Detect 不修改快照，返回的 commit 将快照更新为本次读取的状态，调用方在事件写入 outbox 后调用
+type detector interface {
+	Detect(db *wechatdb.DB) (events []*Event, commit func(), err error)
+}
+
+// EventWebhook contact、chatroom、session 类型的 webhook
+// 创建时记录数据库状态，之后每次回调对比前后状态生成事件
+type EventWebhook struct {
+	conf     *conf.WebhookItem
+	detector detector
+	renderer *Renderer
+	outbox   *Outbox
+	db       *wechatdb.DB
+	mu       sync.Mutex
+}
+
+func NewEventWebhook(conf *conf.WebhookItem, db *wechatdb.DB, detector detector, renderer *Renderer, outbox *Outbox) *EventWebhook {
+	e := &EventWebhook{
+		conf:     conf,
+		detector: detector,
+		renderer: renderer,
+		outbox:   outbox,
+		db:       db,
+	}
+	// 记录初始状态
+	if _, commit, err := detector.Detect(db); err != nil {
+		log.Error().Err(err).Msgf("webhook %s init %s state failed", conf.URL, conf.Type)
+	} else {
+		commit()
+	}
+	return e
+}
+
+func (e *EventWebhook) Do(event fsnotify.Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	events, commit, err := e.detector.Detect(e.db)
+	if err != nil {
+		log.Error().Err(err).Msgf("webhook detect %s events failed", e.conf.Type)
+		return
+	}
+
+	filtered := make([]*Event, 0, len(events))
+	for _, ev := range events {
+		if matchEvent(e.conf, ev) {
+			filtered = append(filtered, ev)
+		}
+	}
+	log.Debug().Msgf("🔎 webhook %s events: detected=%d matched=%d", e.conf.Type, len(events), len(filtered))
+	if len(filtered) == 0 {
+		commit()
+		return
+	}
+
+	// 事件写入 outbox 后才更新快照，写入失败时下次触发重新检测到同样的变化
+	err = deliver(e.conf, e.renderer, e.outbox, &EventPayload{
+		Type:          e.conf.Type,
+		FilterTalker:  e.conf.Talker,
+		FilterSender:  e.conf.Sender,
+		FilterKeyword: e.conf.Keyword,
+		Length:        len(filtered),
+		Events:        filtered,
+	})
+	if err != nil {
+		return
+	}
+	commit()
+}
+
+// wantEvent 配置是否关注指定事件
+func wantEvent(item *conf.WebhookItem, event string) bool {
+	return len(item.Events) == 0 || slices.Contains(item.Events, event)
+}
+
+// matchEvent 按事件、talker、sender、keyword 过滤
+//   - talker 匹配联系人、群聊或会话的 ID 与名称
+//   - sender 匹配群成员（加入、退出）、群主、公告发布人或会话最新消息的发送人
+//   - keyword 为正则表达式，匹配事件摘要
+func matchEvent(item *conf.WebhookItem, e *Event) bool {
+	if !wantEvent(item, e.Event) {
+		return false
+	}
+	if item.Talker != "" {
+		names := []string{e.Talker, e.TalkerName}
+		if e.Contact != nil {
+			names = append(names, e.Contact.Alias, e.Contact.Remark, e.Contact.NickName)
+		}
+		if !matchAny(item.Talker, names) {
+			return false
+		}
+	}
+	if item.Sender != "" {
+		var names []string
+		switch {
+		case e.ChatRoom != nil:
+			for _, member := range e.ChatRoom.Members {
+				names = append(names, member.UserName, member.DisplayName)
+			}
+			names = append(names, e.ChatRoom.Owner, e.ChatRoom.OldOwner)
+			if e.ChatRoom.Message != nil {
+				names = append(names, e.ChatRoom.Message.Sender, e.ChatRoom.Message.SenderName)
+			}
+		case e.Session != nil:
+			names = append(names, e.Session.Sender, e.Session.SenderName)
+		}
+		if !matchAny(item.Sender, names) {
+			return false
+		}
+	}
+	if item.Keyword != "" {
+		re, err := regexp.Compile(item.Keyword)
+		if err != nil || !re.MatchString(e.String()) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchAny(filter string, names []string) bool {
+	for _, item := range util.Str2List(filter, ",") {
+		for _, name := range names {
+			if name != "" && item == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// contactDetector 对比好友列表，检测新增好友、备注修改、删除好友
+type contactDetector struct {
+	snapshot map[string]*model.Contact
+}
+
+func (d *contactDetector) Detect(db *wechatdb.DB) ([]*Event, func(), error) {
+	contacts, err := db.GetAllContacts()
+	if err != nil {
+		return nil, nil, err
+	}
+	current := make(map[string]*model.Contact, len(contacts))
+	for _, contact := range contacts {
+		// 群聊由 chatroom 类型处理
+		if strings.HasSuffix(contact.UserName, "@chatroom") {
+			continue
+		}
+		current[contact.UserName] = contact
+	}
+	prev := d.snapshot
+	commit := func() { d.snapshot = current }
+	if prev == nil {
+		return nil, commit, nil
+	}
+
+	now := model.JSONTime(time.Now())
+	newEvent := func(event string, contact *model.Contact) *Event {
+		return &Event{
+			Type:       TypeContact,
+			Event:      event,
+			Time:       now,
+			Talker:     contact.UserName,
+			TalkerName: contact.DisplayName(),
+			Contact:    &ContactEvent{Contact: contact},
+		}
+	}
+
+	events := make([]*Event, 0)
+	for _, userName := range sortedKeys(current) {
+		contact := current[userName]
+		old, ok := prev[userName]
+		switch {
+		case isFriend(contact) && (!ok || !isFriend(old)):
+			events = append(events, newEvent(EventNewFriend, contact))
+		case ok && isFriend(old) && !isFriend(contact):
+			events = append(events, newEvent(EventContactDeleted, contact))
+		case ok && isFriend(contact) && old.Remark != contact.Remark:
+			e := newEvent(EventRemarkChanged, contact)
+			e.Contact.OldRemark = old.Remark
+			events = append(events, e)
+		}
+	}
+	// 联系人记录被直接删除
+	for _, userName := range sortedKeys(prev) {
+		if old := prev[userName]; current[userName] == nil && isFriend(old) {
+			events = append(events, newEvent(EventContactDeleted, old))
+		}
+	}
+	return events, commit, nil
+}
+
+// isFriend 与通讯录查询的好友条件一致
+func isFriend(contact *model.Contact) bool {
+	if contact.DeleteFlag != 0 {
+		return false
+	}
+	switch contact.Flag {
+	case 2, 3, 2051:
+		return true
+	}
+	return false
+}
+
+// chatRoomDetector 对比群成员列表，检测成员加入、退出和群主变更
+type chatRoomDetector struct {
+	snapshot map[string]*model.ChatRoom
+}
+
+func (d *chatRoomDetector) Detect(db *wechatdb.DB) ([]*Event, func(), error) {
+	chatRooms, err := db.GetAllChatRooms()
+	if err != nil {
+		return nil, nil, err
+	}
+	current := make(map[string]*model.ChatRoom, len(chatRooms))
+	for _, chatRoom := range chatRooms {
+		current[chatRoom.Name] = chatRoom
+	}
+	prev := d.snapshot
+	commit := func() { d.snapshot = current }
+	if prev == nil {
+		return nil, commit, nil
+	}
+
+	now := model.JSONTime(time.Now())
+	events := make([]*Event, 0)
+	for _, name := range sortedKeys(current) {
+		chatRoom := current[name]
+		old, ok := prev[name]
+		// 新加入的群聊只记录状态；成员列表为空时无法对比
+		if !ok || len(old.Users) == 0 || len(chatRoom.Users) == 0 {
+			continue
+		}
+		newEvent := func(event string) *Event {
+			return &Event{
+				Type:       TypeChatRoom,
+				Event:      event,
+				Time:       now,
+				Talker:     chatRoom.Name,
+				TalkerName: chatRoom.DisplayName(),
+				ChatRoom:   &ChatRoomEvent{MemberCount: len(chatRoom.Users), Owner: chatRoom.Owner},
+			}
+		}
+
+		if joined := diffMembers(chatRoom.Users, old.Users); len(joined) > 0 {
+			e := newEvent(EventMemberJoined)
+			e.ChatRoom.Members = memberDisplayNames(db, joined)
+			events = append(events, e)
+		}
+		if left := diffMembers(old.Users, chatRoom.Users); len(left) > 0 {
+			e := newEvent(EventMemberLeft)
+			e.ChatRoom.Members = memberDisplayNames(db, left)
+			events = append(events, e)
+		}
+		if old.Owner != "" && chatRoom.Owner != "" && old.Owner != chatRoom.Owner {
+			e := newEvent(EventOwnerChanged)
+			e.ChatRoom.OldOwner = old.Owner
+			events = append(events, e)
+		}
+	}
+	return events, commit, nil
+}
+
+// diffMembers 返回在 a 中但不在 b 中的成员
+func diffMembers(a, b []model.ChatRoomUser) []model.ChatRoomUser {
+	exists := make(map[string]struct{}, len(b))
+	for _, user := range b {
+		exists[user.UserName] = struct{}{}
+	}
+	ret := make([]model.ChatRoomUser, 0)
+	for _, user := range a {
+		if _, ok := exists[user.UserName]; !ok {
+			ret = append(ret, user)
+		}
+	}
+	return ret
+}
+
+// memberDisplayNames 群昵称为空时使用联系人名称
+func memberDisplayNames(db *wechatdb.DB, users []model.ChatRoomUser) []model.ChatRoomUser {
+	for i, user := range users {
+		if user.DisplayName != "" {
+			continue
+		}
+		if contact, err := db.GetContact(user.UserName); err == nil {
+			users[i].DisplayName = contact.DisplayName()
+		}
+	}
+	return users
+}
+
+// noticeDetector 检测群公告消息，群公告以消息的形式保存在消息数据库中
+type noticeDetector struct {
+	host  string
+	since map[string]time.Time
+	seq   map[string]int64
+	start time.Time
+}
+
+func newNoticeDetector(host string) *noticeDetector {
+	return &noticeDetector{
+		host:  host,
+		since: make(map[string]time.Time),
+		seq:   make(map[string]int64),
+		start: time.Now().Add(-5 * time.Second),
+	}
+}
+
+func (d *noticeDetector) Detect(db *wechatdb.DB) ([]*Event, func(), error) {
+	sessions, err := db.GetSessions("", sessionWindow, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 本次读取到的位置，commit 时才写入 since/seq
+	since := make(map[string]time.Time)
+	seq := make(map[string]int64)
+	commit := func() {
+		maps.Copy(d.since, since)
+		maps.Copy(d.seq, seq)
+	}
+
+	events := make([]*Event, 0)
+	for _, session := range sessions.Items {
+		if !session.IsChatroom {
+			continue
+		}
+		start, ok := d.since[session.TopicID]
+		if !ok {
+			start = d.start
+		}
+		// 会话时间精确到秒
+		if session.NTime.Time().Before(start.Truncate(time.Second)) {
+			continue
+		}
+
+		messages, err := db.GetMessages(start, time.Now().Add(time.Minute*10), session.TopicID, "", "", "", 0, 0)
+		if err != nil {
+			log.Debug().Err(err).Msgf("webhook get messages of %s failed", session.TopicID)
+			continue
+		}
+		lastSeq := d.seq[session.TopicID]
+		for _, message := range messages.Items {
+			if message.Seq <= lastSeq {
+				continue
+			}
+			lastSeq = message.Seq
+			seq[session.TopicID] = message.Seq
+			since[session.TopicID] = message.Time.Time()
+			if message.Type != model.MessageTypeShare || message.SubType != model.MessageSubTypeChatRoomNotice {
+				continue
+			}
+			message.SetContent("host", d.host)
+			message.Content = message.PlainTextContent()
+			events = append(events, &Event{
+				Type:       TypeChatRoom,
+				Event:      EventAnnouncement,
+				Time:       message.Time,
+				Talker:     session.TopicID,
+				TalkerName: session.TopicName,
+				ChatRoom: &ChatRoomEvent{
+					Announcement: message.Content,
+					Message:      message,
+				},
+			})
+		}
+	}
+	return events, commit, nil
+}
+
+// sessionDetector 对比最近会话，检测未读状态和@我的变化
+type sessionDetector struct {
+	snapshot map[string]*model.Session
+	start    time.Time
+}
+
+func (d *sessionDetector) Detect(db *wechatdb.DB) ([]*Event, func(), error) {
+	sessions, err := db.GetSessions("", sessionWindow, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	current := make(map[string]*model.Session, len(sessions.Items))
+	for _, session := range sessions.Items {
+		current[session.TopicID] = session
+	}
+	prev := d.snapshot
+	if prev == nil {
+		start := time.Now().Truncate(time.Second)
+		return nil, func() { d.snapshot, d.start = current, start }, nil
+	}
+	commit := func() { d.snapshot = current }
+
+	events := make([]*Event, 0)
+	for _, session := range sessions.Items {
+		old, ok := prev[session.TopicID]
+		// 新出现在最近会话中的会话，只处理启动后有新消息的
+		if !ok {
+			if session.NTime.Time().Before(d.start) {
+				continue
+			}
+			old = &model.Session{}
+		}
+		newEvent := func(event string) *Event {
+			return &Event{
+				Type:       TypeSession,
+				Event:      event,
+				Time:       session.NTime,
+				Talker:     session.TopicID,
+				TalkerName: session.TopicName,
+				Session: &SessionEvent{
+					Unread:      session.UnreadCount,
+					OldUnread:   old.UnreadCount,
+					IsMentionMe: session.IsMentionMe,
+					Content:     session.Content,
+					Sender:      session.PersonID,
+					SenderName:  session.PersonName,
+				},
+			}
+		}
+		switch {
+		case old.UnreadCount == 0 && session.UnreadCount > 0:
+			events = append(events, newEvent(EventUnread))
+		case old.UnreadCount > 0 && session.UnreadCount == 0:
+			events = append(events, newEvent(EventRead))
+		}
+		if session.IsMentionMe && !old.IsMentionMe {
+			events = append(events, newEvent(EventMention))
+		}
+	}
+	return events, commit, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
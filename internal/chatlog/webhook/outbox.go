@@ -0,0 +1,574 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/chatlog/conf"
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/atrest"
+)
+
+// 投递状态
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+const (
+	// DefaultMaxAttempts 单条投递的最大尝试次数，超过后进入死信状态
+	DefaultMaxAttempts = 10
+
+	// retryBaseDelay/retryMaxDelay 指数退避的初始间隔与上限
+	retryBaseDelay = 2 * time.Second
+	retryMaxDelay  = 10 * time.Minute
+
+	// outboxKeepDelivered/outboxKeepDead 每个 webhook 保留的已投递和死信记录数量
+	outboxKeepDelivered = 100
+	outboxKeepDead      = 1000
+
+	// outboxDir 工作目录下保存投递记录的目录
+	outboxDir = "webhook"
+
+	// defaultRequestTimeout 未配置 timeout_ms 时的单次请求超时
+	defaultRequestTimeout = 10 * time.Second
+)
+
+// 签名请求头
+const (
+	HeaderTimestamp = "X-Chatlog-Timestamp"
+	HeaderSignature = "X-Chatlog-Signature"
+)
+
+// Delivery 一次 webhook 投递，对应 outbox 目录中的一个文件
+type Delivery struct {
+	ID             string          `json:"id"`
+	Item           string          `json:"item"`
+	URL            string          `json:"url"`
+	Status         string          `json:"status"`
+	Length         int             `json:"length"`
+	Attempts       int             `json:"attempts"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	UpdatedAt      time.Time       `json:"updatedAt"`
+	NextAttempt    time.Time       `json:"nextAttempt,omitzero"`
+	LastStatusCode int             `json:"lastStatusCode,omitempty"`
+	LastError      string          `json:"lastError,omitempty"`
+	Body           json.RawMessage `json:"body,omitempty"`
+}
+
+// OutboxStatus 单个 webhook 的投递统计
+type OutboxStatus struct {
+	Item        string      `json:"item"`
+	Type        string      `json:"type"`
+	Description string      `json:"description,omitempty"`
+	URL         string      `json:"url"`
+	Talker      string      `json:"talker,omitempty"`
+	Sender      string      `json:"sender,omitempty"`
+	Keyword     string      `json:"keyword,omitempty"`
+	Pending     int         `json:"pending"`
+	Delivered   int         `json:"delivered"`
+	Dead        int         `json:"dead"`
+	Deliveries  []*Delivery `json:"deliveries"`
+}
+
+// Outbox 单个 webhook 的持久化发件箱
+// 待投递的请求先写入磁盘再按顺序投递，失败后指数退避重试，重启后继续投递未完成的请求
+// 工作目录已加密时投递记录（包括请求体）使用工作目录的密钥加密后保存
+type Outbox struct {
+	key         string
+	dir         string
+	sealKey     *atrest.Key
+	conf        *conf.WebhookItem
+	client      *http.Client
+	maxAttempts int
+
+	mu         sync.Mutex
+	deliveries []*Delivery // 按 ID（创建时间）升序
+	lastID     int64
+
+	notify chan struct{}
+	done   chan struct{}
+}
+
+// outboxKey webhook 配置的稳定标识，配置不变时重启后使用同一个目录
+// 除筛选条件外还包括密钥、请求头和请求体模板，修改这些配置后不会投递按旧配置渲染的请求
+func outboxKey(item *conf.WebhookItem) string {
+	h := sha1.New()
+	h.Write([]byte(webhookItemSignature(item)))
+	fmt.Fprintf(h, "|%s|%s|%s|%s", item.Secret, item.Mode, item.Preset, item.Template)
+	keys := make([]string, 0, len(item.Headers))
+	for k := range item.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(h, "|%s=%s", k, item.Headers[k])
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// NewOutbox 打开（或创建）webhook 的发件箱，加载未完成的投递并启动投递协程
+func NewOutbox(ctx context.Context, workDir string, item *conf.WebhookItem, maxAttempts int) (*Outbox, error) {
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	sealKey, err := atrest.Open(workDir)
+	if err != nil {
+		return nil, err
+	}
+	key := outboxKey(item)
+	o := &Outbox{
+		key:         key,
+		dir:         filepath.Join(workDir, outboxDir, key),
+		sealKey:     sealKey,
+		conf:        item,
+		client:      &http.Client{Timeout: requestTimeout(item)},
+		maxAttempts: maxAttempts,
+		notify:      make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+	if err := os.MkdirAll(o.dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+	if n := o.count(DeliveryPending); n > 0 {
+		log.Info().Msgf("webhook %s: replay %d pending deliveries", item.URL, n)
+	}
+
+	go o.loop(ctx)
+	return o, nil
+}
+
+func (o *Outbox) load() error {
+	entries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		d, sealed, err := o.read(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			log.Warn().Err(err).Msgf("skip broken webhook delivery %s", name)
+			continue
+		}
+		// 启用加密之前保存的明文记录重新加密保存
+		if o.sealKey != nil && !sealed {
+			if err := o.save(d); err != nil {
+				return err
+			}
+		}
+		if d.Status != DeliveryPending {
+			d.Body = nil
+		}
+		o.deliveries = append(o.deliveries, d)
+		if id, err := strconv.ParseInt(d.ID, 10, 64); err == nil && id > o.lastID {
+			o.lastID = id
+		}
+	}
+	sort.Slice(o.deliveries, func(i, j int) bool {
+		return o.deliveries[i].ID < o.deliveries[j].ID
+	})
+	return nil
+}
+
+// Enqueue 持久化一次触发渲染出的全部请求，全部写入磁盘成功后返回
+// 任一请求写入失败时删除已写入的请求并返回错误，调用方可以整体重试而不会重复投递
+func (o *Outbox) Enqueue(bodies [][]byte, lengths []int) ([]*Delivery, error) {
+	o.mu.Lock()
+	now := time.Now()
+	lastID := o.lastID
+	deliveries := make([]*Delivery, 0, len(bodies))
+	for i, body := range bodies {
+		lastID = nextID(now, lastID)
+		d := &Delivery{
+			ID:        fmt.Sprintf("%020d", lastID),
+			Item:      o.key,
+			URL:       o.conf.URL,
+			Status:    DeliveryPending,
+			Length:    lengths[i],
+			CreatedAt: now,
+			UpdatedAt: now,
+			Body:      body,
+		}
+		if err := o.save(d); err != nil {
+			for _, saved := range deliveries {
+				if err := os.Remove(filepath.Join(o.dir, saved.ID+".json")); err != nil {
+					log.Debug().Err(err).Msgf("remove webhook delivery %s failed", saved.ID)
+				}
+			}
+			o.mu.Unlock()
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	o.lastID = lastID
+	o.deliveries = append(o.deliveries, deliveries...)
+	o.mu.Unlock()
+
+	o.wake()
+	return deliveries, nil
+}
+
+// Dead 直接保存一条死信，用于无法渲染的请求，body 为请求数据的默认 JSON 序列化
+func (o *Outbox) Dead(body []byte, length int, cause error) (*Delivery, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := time.Now()
+	id := nextID(now, o.lastID)
+	d := &Delivery{
+		ID:        fmt.Sprintf("%020d", id),
+		Item:      o.key,
+		URL:       o.conf.URL,
+		Status:    DeliveryDead,
+		Length:    length,
+		CreatedAt: now,
+		UpdatedAt: now,
+		LastError: cause.Error(),
+		Body:      body,
+	}
+	if err := o.save(d); err != nil {
+		return nil, err
+	}
+	o.lastID = id
+	// 已完成的投递不在内存中保留请求体
+	d.Body = nil
+	o.deliveries = append(o.deliveries, d)
+	o.prune()
+	return d, nil
+}
+
+// nextID 新投递记录的 ID，为纳秒时间戳，固定宽度保证按字符串排序即按时间排序
+func nextID(now time.Time, lastID int64) int64 {
+	id := now.UnixNano()
+	if id <= lastID {
+		id = lastID + 1
+	}
+	return id
+}
+
+func (o *Outbox) wake() {
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+}
+
+// loop 按创建顺序逐条投递，队首投递成功或进入死信后才处理下一条
+func (o *Outbox) loop(ctx context.Context) {
+	defer close(o.done)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.notify:
+		case <-timer.C:
+		}
+
+		for {
+			d := o.head()
+			if d == nil {
+				break
+			}
+			if wait := time.Until(d.NextAttempt); wait > 0 {
+				timer.Reset(wait)
+				break
+			}
+			o.attempt(ctx, d)
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}
+}
+
+// head 返回最早的待投递记录
+func (o *Outbox) head() *Delivery {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, d := range o.deliveries {
+		if d.Status == DeliveryPending {
+			return d
+		}
+	}
+	return nil
+}
+
+func (o *Outbox) attempt(ctx context.Context, d *Delivery) {
+	statusCode, err := o.post(ctx, d.Body)
+	if ctx.Err() != nil {
+		// 服务停止导致的失败不计入重试次数
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	d.Attempts++
+	d.UpdatedAt = time.Now()
+	d.LastStatusCode = statusCode
+	d.LastError = ""
+	switch {
+	case err == nil:
+		d.Status = DeliveryDelivered
+		d.NextAttempt = time.Time{}
+		log.Info().Msgf("⚡ webhook %s delivered, id=%s attempts=%d", d.URL, d.ID, d.Attempts)
+	case !retryable(statusCode) || d.Attempts >= o.maxAttempts:
+		d.Status = DeliveryDead
+		d.NextAttempt = time.Time{}
+		d.LastError = err.Error()
+		log.Error().Err(err).Msgf("webhook %s dead, id=%s attempts=%d", d.URL, d.ID, d.Attempts)
+	default:
+		d.NextAttempt = time.Now().Add(backoff(d.Attempts))
+		d.LastError = err.Error()
+		log.Warn().Err(err).Msgf("webhook %s failed, id=%s attempts=%d, retry at %s", d.URL, d.ID, d.Attempts, d.NextAttempt.Format(time.DateTime))
+	}
+
+	if err := o.save(d); err != nil {
+		log.Error().Err(err).Msgf("save webhook delivery %s failed", d.ID)
+	}
+	if d.Status != DeliveryPending {
+		d.Body = nil
+		o.prune()
+	}
+}
+
+func (o *Outbox) post(ctx context.Context, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range o.conf.Headers {
+		if strings.EqualFold(k, "Host") {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+	// 签名头最后设置，不允许被自定义请求头覆盖
+	if o.conf.Secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(HeaderTimestamp, ts)
+		req.Header.Set(HeaderSignature, Sign(o.conf.Secret, ts, body))
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign 计算请求签名：HMAC-SHA256(secret, timestamp + "." + body)，格式为 sha256=<hex>
+// 接收方应校验时间戳与本地时间的偏差（建议不超过 5 分钟）以防止重放
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// requestTimeout 单次请求超时
+func requestTimeout(item *conf.WebhookItem) time.Duration {
+	if item.TimeoutMs > 0 {
+		return time.Duration(item.TimeoutMs) * time.Millisecond
+	}
+	return defaultRequestTimeout
+}
+
+// retryable 网络错误、5xx、408、429 可以重试，其他 4xx 直接进入死信
+func retryable(statusCode int) bool {
+	switch {
+	case statusCode == 0:
+		return true
+	case statusCode == http.StatusRequestTimeout, statusCode == http.StatusTooManyRequests:
+		return true
+	case statusCode >= 400 && statusCode < 500:
+		return false
+	}
+	return true
+}
+
+// backoff 第 n 次失败后的等待时间：2s、4s、8s … 最长 10 分钟，附加 ±20% 抖动
+func backoff(attempts int) time.Duration {
+	delay := retryMaxDelay
+	if attempts <= 20 {
+		delay = min(retryBaseDelay<<(attempts-1), retryMaxDelay)
+	}
+	jitter := time.Duration(rand.Int63n(int64(delay)/5*2+1)) - delay/5
+	return delay + jitter
+}
+
+// save 原子写入投递记录，工作目录已加密时加密整条记录
+func (o *Outbox) save(d *Delivery) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	if o.sealKey != nil {
+		if b, err = o.sealKey.Seal(b); err != nil {
+			return err
+		}
+	}
+	path := filepath.Join(o.dir, d.ID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// read 读取投递记录，sealed 表示记录是加密保存的
+func (o *Outbox) read(id string) (d *Delivery, sealed bool, err error) {
+	b, err := os.ReadFile(filepath.Join(o.dir, id+".json"))
+	if err != nil {
+		return nil, false, err
+	}
+	if sealed = atrest.IsSealedBlob(b); sealed {
+		if o.sealKey == nil {
+			return nil, true, errors.ErrWorkDirPassphraseRequired
+		}
+		if b, err = o.sealKey.Unseal(b); err != nil {
+			return nil, true, err
+		}
+	}
+	d = &Delivery{}
+	if err := json.Unmarshal(b, d); err != nil {
+		return nil, sealed, err
+	}
+	return d, sealed, nil
+}
+
+// prune 清理超出保留数量的已投递和死信记录，调用方需持有锁
+func (o *Outbox) prune() {
+	delivered, dead := 0, 0
+	for i := len(o.deliveries) - 1; i >= 0; i-- {
+		switch o.deliveries[i].Status {
+		case DeliveryDelivered:
+			delivered++
+		case DeliveryDead:
+			dead++
+		}
+	}
+
+	kept := o.deliveries[:0]
+	for _, d := range o.deliveries {
+		remove := false
+		switch d.Status {
+		case DeliveryDelivered:
+			remove = delivered > outboxKeepDelivered
+			if remove {
+				delivered--
+			}
+		case DeliveryDead:
+			remove = dead > outboxKeepDead
+			if remove {
+				dead--
+			}
+		}
+		if remove {
+			os.Remove(filepath.Join(o.dir, d.ID+".json"))
+			continue
+		}
+		kept = append(kept, d)
+	}
+	o.deliveries = kept
+}
+
+func (o *Outbox) count(status string) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n := 0
+	for _, d := range o.deliveries {
+		if d.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Status 返回投递统计及最近的投递记录（按时间倒序），status 为空时返回全部状态
+func (o *Outbox) Status(status string, limit int, withBody bool) *OutboxStatus {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	s := &OutboxStatus{
+		Item:        o.key,
+		Type:        o.conf.Type,
+		Description: o.conf.Description,
+		URL:         o.conf.URL,
+		Talker:      o.conf.Talker,
+		Sender:      o.conf.Sender,
+		Keyword:     o.conf.Keyword,
+		Deliveries:  make([]*Delivery, 0),
+	}
+	for i := len(o.deliveries) - 1; i >= 0; i-- {
+		d := o.deliveries[i]
+		switch d.Status {
+		case DeliveryPending:
+			s.Pending++
+		case DeliveryDelivered:
+			s.Delivered++
+		case DeliveryDead:
+			s.Dead++
+		}
+		if status != "" && d.Status != status {
+			continue
+		}
+		if limit > 0 && len(s.Deliveries) >= limit {
+			continue
+		}
+		copied := *d
+		if !withBody {
+			copied.Body = nil
+		} else if copied.Body == nil {
+			// 已完成的投递不在内存中保留请求体
+			if stored, _, err := o.read(d.ID); err == nil {
+				copied.Body = stored.Body
+			}
+		}
+		s.Deliveries = append(s.Deliveries, &copied)
+	}
+	return s
+}
+
+// Wait 等待投递协程退出
+func (o *Outbox) Wait() {
+	<-o.done
+}
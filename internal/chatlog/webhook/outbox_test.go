@@ -0,0 +1,181 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/chatlog/conf"
+)
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      string
+		want      string
+	}{
+		{
+			name:      "known vector",
+			secret:    "secret",
+			timestamp: "1700000000",
+			body:      `{"hello":"world"}`,
+			want:      "sha256=654f06c856baf080af3fa272934823257a542d35cf1f88099338f850a60601a4",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sign(tt.secret, tt.timestamp, []byte(tt.body)); got != tt.want {
+				t.Errorf("Sign() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestOutbox 创建不启动投递协程的发件箱，由测试直接调用 attempt
+func newTestOutbox(t *testing.T, item *conf.WebhookItem, maxAttempts int) *Outbox {
+	t.Helper()
+	return &Outbox{
+		key:         outboxKey(item),
+		dir:         t.TempDir(),
+		conf:        item,
+		client:      &http.Client{Timeout: requestTimeout(item)},
+		maxAttempts: maxAttempts,
+		notify:      make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+}
+
+func TestPostHeaders(t *testing.T) {
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer server.Close()
+
+	item := &conf.WebhookItem{
+		URL:    server.URL,
+		Secret: "secret",
+		Headers: map[string]string{
+			"X-Api-Key":     "key",
+			HeaderSignature: "forged",
+			HeaderTimestamp: "0",
+		},
+	}
+	o := newTestOutbox(t, item, DefaultMaxAttempts)
+	body := []byte(`{"hello":"world"}`)
+	if _, err := o.post(context.Background(), body); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Get("X-Api-Key") != "key" {
+		t.Errorf("X-Api-Key = %q, want key", got.Get("X-Api-Key"))
+	}
+	ts := got.Get(HeaderTimestamp)
+	if ts == "0" {
+		t.Errorf("%s was overridden by custom headers", HeaderTimestamp)
+	}
+	if want := Sign("secret", ts, body); got.Get(HeaderSignature) != want {
+		t.Errorf("%s = %q, want %q", HeaderSignature, got.Get(HeaderSignature), want)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{5, 32 * time.Second},
+		{9, 512 * time.Second},
+		{10, retryMaxDelay},
+		{64, retryMaxDelay},
+	}
+	for _, tt := range tests {
+		for range 20 {
+			got := backoff(tt.attempts)
+			if got < tt.want-tt.want/5 || got > tt.want+tt.want/5 {
+				t.Errorf("backoff(%d) = %v, want %v ±20%%", tt.attempts, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestAttempt(t *testing.T) {
+	tests := []struct {
+		name        string
+		statusCode  int
+		maxAttempts int
+		want        []string // 每次尝试后的状态
+	}{
+		{"delivered", http.StatusOK, 3, []string{DeliveryDelivered}},
+		{"client error is dead", http.StatusBadRequest, 3, []string{DeliveryDead}},
+		{"retry on server error", http.StatusInternalServerError, 2, []string{DeliveryPending, DeliveryDead}},
+		{"retry on rate limit", http.StatusTooManyRequests, 3, []string{DeliveryPending, DeliveryPending, DeliveryDead}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statusCode)
+			}))
+			defer server.Close()
+
+			o := newTestOutbox(t, &conf.WebhookItem{URL: server.URL}, tt.maxAttempts)
+			deliveries, err := o.Enqueue([][]byte{[]byte(`{}`)}, []int{1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := deliveries[0]
+			for i, want := range tt.want {
+				o.attempt(context.Background(), d)
+				if d.Status != want || d.Attempts != i+1 {
+					t.Fatalf("attempt %d: status = %s attempts = %d, want %s", i+1, d.Status, d.Attempts, want)
+				}
+				if want == DeliveryPending && !d.NextAttempt.After(time.Now()) {
+					t.Errorf("attempt %d: next attempt %v is not in the future", i+1, d.NextAttempt)
+				}
+			}
+
+			// 投递记录持久化，重新加载后状态一致
+			saved, _, err := o.read(d.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved.Status != d.Status || saved.Attempts != d.Attempts {
+				t.Errorf("saved status = %s attempts = %d, want %s %d", saved.Status, saved.Attempts, d.Status, d.Attempts)
+			}
+		})
+	}
+}
+
+func TestDead(t *testing.T) {
+	o := newTestOutbox(t, &conf.WebhookItem{URL: "http://127.0.0.1:0"}, DefaultMaxAttempts)
+	if _, err := o.Enqueue([][]byte{[]byte(`{}`)}, []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	d, err := o.Dead([]byte(`{"raw":true}`), 2, errors.New("render failed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 死信排在已入队的投递之后，不影响待投递的请求
+	if head := o.head(); head == nil || head.ID >= d.ID {
+		t.Fatalf("head = %v, want the pending delivery before dead letter %s", head, d.ID)
+	}
+	if got := o.count(DeliveryDead); got != 1 {
+		t.Errorf("dead count = %d, want 1", got)
+	}
+
+	saved, _, err := o.read(d.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Status != DeliveryDead || saved.LastError != "render failed" || string(saved.Body) != `{"raw":true}` {
+		t.Errorf("saved dead letter = %+v", saved)
+	}
+}
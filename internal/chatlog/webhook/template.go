@@ -0,0 +1,203 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/chatlog/conf"
+	"github.com/sjzar/chatlog/internal/chatlog/messageview"
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// 投递模式
+const (
+	ModeBatch   = "batch"   // 每次触发的所有消息合并为一个请求（默认）
+	ModeMessage = "message" // 每条消息（事件）一个请求
+)
+
+// 内置请求体模板，直接对接各 IM 机器人
+const (
+	PresetDefault  = "default"
+	PresetFeishu   = "feishu"
+	PresetDingTalk = "dingtalk"
+	PresetWeCom    = "wecom"
+	PresetSlack    = "slack"
+)
+
+var presets = map[string]string{
+	PresetFeishu:   `{"msg_type":"text","content":{"text":{{json (text .)}}}}`,
+	PresetDingTalk: `{"msgtype":"text","text":{"content":{{json (text .)}}}}`,
+	PresetWeCom:    `{"msgtype":"text","text":{"content":{{json (text .)}}}}`,
+	PresetSlack:    `{"text":{{json (text .)}}}`,
+}
+
+// Batch 一次触发产生的请求数据，逐条投递时按消息（事件）拆分
+type Batch interface {
+	Len() int
+	Split() []Batch
+}
+
+// Payload message 类型的模板数据，默认请求体即 Payload 的 JSON 序列化
+type Payload struct {
+	Talker        string           `json:"talker"`
+	Sender        string           `json:"sender"`
+	Keyword       string           `json:"keyword"`
+	FilterTalker  string           `json:"filter_talker"`
+	FilterSender  string           `json:"filter_sender"`
+	FilterKeyword string           `json:"filter_keyword"`
+	LastTime      string           `json:"lastTime"`
+	Length        int              `json:"length"`
+	Messages      []*model.Message `json:"messages"`
+
+	// Message 逐条投递时为当前消息，合并投递时为 nil
+	Message *model.Message `json:"-"`
+}
+
+func (p *Payload) Len() int {
+	return p.Length
+}
+
+func (p *Payload) Split() []Batch {
+	ret := make([]Batch, 0, len(p.Messages))
+	for _, message := range p.Messages {
+		single := *p
+		single.Talker = messageview.TalkerName(message)
+		single.Sender = messageview.SenderName(message)
+		single.Length = 1
+		single.Messages = []*model.Message{message}
+		single.Message = message
+		ret = append(ret, &single)
+	}
+	return ret
+}
+
+// Renderer 将过滤后的消息渲染为请求体
+type Renderer struct {
+	mode string
+	tpl  *template.Template
+}
+
+var templateFuncs = template.FuncMap{
+	"json":   templateJSON,
+	"text":   payloadText,
+	"talker": messageview.TalkerName,
+	"sender": messageview.SenderName,
+	"join":   strings.Join,
+	"now":    time.Now,
+}
+
+// NewRenderer 根据 webhook 配置创建渲染器，template 优先于 preset
+func NewRenderer(item *conf.WebhookItem) (*Renderer, error) {
+	r := &Renderer{mode: item.Mode}
+	switch r.mode {
+	case "":
+		r.mode = ModeBatch
+	case ModeBatch, ModeMessage:
+	default:
+		return nil, fmt.Errorf("unknown webhook mode: %s", item.Mode)
+	}
+
+	text := item.Template
+	if text == "" {
+		switch item.Preset {
+		case "", PresetDefault:
+			return r, nil
+		default:
+			var ok bool
+			if text, ok = presets[item.Preset]; !ok {
+				return nil, fmt.Errorf("unknown webhook preset: %s", item.Preset)
+			}
+		}
+	}
+
+	tpl, err := template.New("webhook").Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse webhook template failed: %w", err)
+	}
+	r.tpl = tpl
+	return r, nil
+}
+
+// Rendered 一个请求的渲染结果
+// Err 不为空时渲染失败（如模板输出不是合法 JSON），Body 为请求数据的默认 JSON 序列化，用于保存死信
+type Rendered struct {
+	Body   []byte
+	Length int
+	Err    error
+}
+
+// Render 按投递模式渲染请求体，返回每个请求的渲染结果
+// 单个请求渲染失败不影响其他请求
+func (r *Renderer) Render(b Batch) []*Rendered {
+	batches := []Batch{b}
+	if r.mode == ModeMessage {
+		batches = b.Split()
+	}
+
+	ret := make([]*Rendered, 0, len(batches))
+	for _, batch := range batches {
+		body, err := r.render(batch)
+		if err != nil {
+			body, _ = json.Marshal(batch)
+		}
+		ret = append(ret, &Rendered{Body: body, Length: batch.Len(), Err: err})
+	}
+	return ret
+}
+
+func (r *Renderer) render(p Batch) ([]byte, error) {
+	if r.tpl == nil {
+		return json.Marshal(p)
+	}
+	buf := &bytes.Buffer{}
+	if err := r.tpl.Execute(buf, p); err != nil {
+		return nil, err
+	}
+	// 请求以 application/json 发送，投递记录中也以 JSON 保存
+	body := bytes.TrimSpace(buf.Bytes())
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("webhook template output is not valid json: %s", body)
+	}
+	return body, nil
+}
+
+// templateJSON 将值编码为 JSON，用于在模板中安全地嵌入字符串
+func templateJSON(v any) (string, error) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(buf.String(), "\n"), nil
+}
+
+// payloadText 消息（事件）的纯文本摘要，内置模板使用
+//
+//	[会话] 发送人 15:04:05
+//	消息内容
+func payloadText(b Batch) string {
+	var parts []string
+	switch p := b.(type) {
+	case *Payload:
+		for _, message := range p.Messages {
+			var line strings.Builder
+			talker := messageview.TalkerName(message)
+			sender := messageview.SenderName(message)
+			if message.IsChatRoom && talker != "" {
+				fmt.Fprintf(&line, "[%s] ", talker)
+			}
+			fmt.Fprintf(&line, "%s %s\n%s", sender, message.Time.Format(time.TimeOnly), message.Content)
+			parts = append(parts, line.String())
+		}
+	case *EventPayload:
+		for _, event := range p.Events {
+			parts = append(parts, event.String())
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
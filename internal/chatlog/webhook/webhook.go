@@ -1,12 +1,10 @@
 package webhook
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"net/http"
 	"regexp"
+	"slices"
 	"sync"
 	"time"
 
@@ -21,6 +19,7 @@ import (
 )
 
 type Config interface {
+	GetWorkDir() string
 	GetWebhook() *conf.Webhook
 }
 
@@ -29,8 +28,12 @@ type Webhook interface {
 }
 
 type Service struct {
-	config *conf.Webhook
-	hooks  map[string][]*conf.WebhookItem
+	config  *conf.Webhook
+	workDir string
+	hooks   map[string][]*conf.WebhookItem
+
+	mu       sync.Mutex
+	outboxes []*Outbox
 }
 
 var processedMessages = messageview.NewDedupStore(5 * time.Minute)
@@ -38,7 +41,8 @@ var observedMessages = messageview.NewDedupStore(5 * time.Minute)
 
 func New(config Config) *Service {
 	s := &Service{
-		config: config.GetWebhook(),
+		config:  config.GetWebhook(),
+		workDir: config.GetWorkDir(),
 	}
 
 	if s.config == nil {
@@ -52,7 +56,7 @@ func New(config Config) *Service {
 			continue
 		}
 		if item.Type == "" {
-			item.Type = "message"
+			item.Type = TypeMessage
 		}
 		signature := webhookItemSignature(item)
 		if _, ok := seen[signature]; ok {
@@ -61,11 +65,13 @@ func New(config Config) *Service {
 		}
 		seen[signature] = struct{}{}
 		switch item.Type {
-		case "message":
-			if hooks["message"] == nil {
-				hooks["message"] = make([]*conf.WebhookItem, 0)
+		case TypeMessage, TypeContact, TypeChatRoom, TypeSession:
+			for _, event := range item.Events {
+				if !slices.Contains(TypeEvents[item.Type], event) {
+					log.Warn().Msgf("unknown %s webhook event: %s", item.Type, event)
+				}
 			}
-			hooks["message"] = append(hooks["message"], item)
+			hooks[item.Type] = append(hooks[item.Type], item)
 		default:
 			log.Error().Msgf("unknown webhook type: %s", item.Type)
 		}
@@ -85,18 +91,75 @@ func (s *Service) GetHooks(ctx context.Context, db *wechatdb.DB) []*Group {
 		return nil
 	}
 
-	groups := make([]*Group, 0)
-	for group, items := range s.hooks {
-		hooks := make([]Webhook, 0)
-		for _, item := range items {
-			hooks = append(hooks, NewMessageWebhook(item, db, s.config.Host))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 按数据库分组注册回调，群公告来自消息数据库
+	byGroup := make(map[string][]Webhook)
+	for _, _type := range sortedKeys(s.hooks) {
+		for _, item := range s.hooks[_type] {
+			renderer, err := NewRenderer(item)
+			if err != nil {
+				log.Error().Err(err).Msgf("skip webhook %s", item.URL)
+				continue
+			}
+			outbox, err := NewOutbox(ctx, s.workDir, item, s.config.MaxAttempts)
+			if err != nil {
+				log.Error().Err(err).Msgf("open webhook outbox for %s failed", item.URL)
+				continue
+			}
+			s.outboxes = append(s.outboxes, outbox)
+
+			switch _type {
+			case TypeMessage:
+				byGroup[TypeMessage] = append(byGroup[TypeMessage], NewMessageWebhook(item, db, s.config.Host, renderer, outbox))
+			case TypeContact:
+				byGroup[TypeContact] = append(byGroup[TypeContact], NewEventWebhook(item, db, &contactDetector{}, renderer, outbox))
+			case TypeChatRoom:
+				if wantEvent(item, EventMemberJoined) || wantEvent(item, EventMemberLeft) || wantEvent(item, EventOwnerChanged) {
+					byGroup[TypeChatRoom] = append(byGroup[TypeChatRoom], NewEventWebhook(item, db, &chatRoomDetector{}, renderer, outbox))
+				}
+				if wantEvent(item, EventAnnouncement) {
+					byGroup[TypeMessage] = append(byGroup[TypeMessage], NewEventWebhook(item, db, newNoticeDetector(s.config.Host), renderer, outbox))
+				}
+			case TypeSession:
+				byGroup[TypeSession] = append(byGroup[TypeSession], NewEventWebhook(item, db, &sessionDetector{}, renderer, outbox))
+			}
 		}
-		groups = append(groups, NewGroup(ctx, group, hooks, s.config.DelayMs))
+	}
+
+	groups := make([]*Group, 0, len(byGroup))
+	for _, group := range sortedKeys(byGroup) {
+		groups = append(groups, NewGroup(ctx, group, byGroup[group], s.config.DelayMs))
 	}
 
 	return groups
 }
 
+// Deliveries 返回各 webhook 的投递统计及最近的投递记录
+func (s *Service) Deliveries(status string, limit int, withBody bool) []*OutboxStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ret := make([]*OutboxStatus, 0, len(s.outboxes))
+	for _, outbox := range s.outboxes {
+		ret = append(ret, outbox.Status(status, limit, withBody))
+	}
+	return ret
+}
+
+// Wait 等待所有投递协程退出，在取消 GetHooks 传入的 context 后调用
+func (s *Service) Wait() {
+	s.mu.Lock()
+	outboxes := s.outboxes
+	s.outboxes = nil
+	s.mu.Unlock()
+
+	for _, outbox := range outboxes {
+		outbox.Wait()
+	}
+}
+
 type Group struct {
 	ctx     context.Context
 	group   string
@@ -195,18 +258,20 @@ func (g *Group) do(event fsnotify.Event) {
 type MessageWebhook struct {
 	host     string
 	conf     *conf.WebhookItem
-	client   *http.Client
+	renderer *Renderer
+	outbox   *Outbox
 	db       *wechatdb.DB
 	lastTime time.Time
 	lastSeq  int64
 	mu       sync.Mutex
 }
 
-func NewMessageWebhook(conf *conf.WebhookItem, db *wechatdb.DB, host string) *MessageWebhook {
+func NewMessageWebhook(conf *conf.WebhookItem, db *wechatdb.DB, host string, renderer *Renderer, outbox *Outbox) *MessageWebhook {
 	m := &MessageWebhook{
 		host:     host,
 		conf:     conf,
-		client:   &http.Client{Timeout: time.Second * 10},
+		renderer: renderer,
+		outbox:   outbox,
 		db:       db,
 		lastTime: time.Now().Add(-5 * time.Second),
 	}
@@ -217,7 +282,7 @@ func (m *MessageWebhook) Do(event fsnotify.Event) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	messages, err := m.db.GetMessages(m.lastTime, time.Now().Add(time.Minute*10), m.conf.Talker, "", "", 0, 0)
+	messages, err := m.db.GetMessages(m.lastTime, time.Now().Add(time.Minute*10), m.conf.Talker, "", "", "", 0, 0)
 	if err != nil {
 		log.Error().Err(err).Msgf("webhook get messages failed")
 		return
@@ -241,7 +306,7 @@ func (m *MessageWebhook) Do(event fsnotify.Event) {
 		if m.lastSeq != 0 && message.Seq <= m.lastSeq {
 			continue
 		}
-		if observedMessages.Seen(fmt.Sprintf("talker:%s#%d", m.conf.Talker, message.Seq)) {
+		if observedMessages.Contains(fmt.Sprintf("talker:%s#%d", m.conf.Talker, message.Seq)) {
 			continue
 		}
 		observed = append(observed, message)
@@ -257,8 +322,12 @@ func (m *MessageWebhook) Do(event fsnotify.Event) {
 		return
 	}
 
-	m.lastTime = observed[len(observed)-1].Time.Time()
-	m.lastSeq = observed[len(observed)-1].Seq
+	lastTime := observed[len(observed)-1].Time.Time()
+	lastSeq := observed[len(observed)-1].Seq
+	observedKeys := make([]string, 0, len(observed))
+	for _, message := range observed {
+		observedKeys = append(observedKeys, fmt.Sprintf("talker:%s#%d", m.conf.Talker, message.Seq))
+	}
 
 	for _, message := range observed {
 		message.SetContent("host", m.host)
@@ -286,12 +355,21 @@ func (m *MessageWebhook) Do(event fsnotify.Event) {
 		if !keywordMatched {
 			continue
 		}
-		if processedMessages.Seen(fmt.Sprintf("%s#%d", webhookItemSignature(m.conf), message.Seq)) {
+		if processedMessages.Contains(processedKey(m.conf, message)) {
 			continue
 		}
 		filtered = append(filtered, message)
 	}
 
+	// 全部请求写入 outbox（渲染失败的保存为死信）后才推进游标并记录已处理的消息，写入失败时下次触发重新处理
+	advance := func() {
+		m.lastTime, m.lastSeq = lastTime, lastSeq
+		observedMessages.Add(observedKeys...)
+		for _, message := range filtered {
+			processedMessages.Add(processedKey(m.conf, message))
+		}
+	}
+
 	if len(filtered) == 0 {
 		log.Debug().Msgf(
 			"🔎 webhook match: cfgTalker=%s cfgSender=%s cfgKeyword=%s matched=0",
@@ -299,6 +377,7 @@ func (m *MessageWebhook) Do(event fsnotify.Event) {
 			m.conf.Sender,
 			m.conf.Keyword,
 		)
+		advance()
 		return
 	}
 
@@ -309,33 +388,67 @@ func (m *MessageWebhook) Do(event fsnotify.Event) {
 		return messageview.SenderName(message)
 	})
 
-	ret := map[string]any{
-		"talker":         actualTalker,
-		"sender":         actualSender,
-		"keyword":        m.conf.Keyword,
-		"filter_talker":  m.conf.Talker,
-		"filter_sender":  m.conf.Sender,
-		"filter_keyword": m.conf.Keyword,
-		"lastTime":       m.lastTime.Format(time.DateTime),
-		"length":         len(filtered),
-		"messages":       filtered,
-	}
-	body, _ := json.Marshal(ret)
-	req, _ := http.NewRequest("POST", m.conf.URL, bytes.NewBuffer(body))
-	req.Header.Set("Content-Type", "application/json")
-
-	log.Info().Msgf("⚡ webhook %s, length=%d", m.conf.URL, len(filtered))
-	log.Info().Msgf("⚡ body: %s", string(body))
-	resp, err := m.client.Do(req)
-	if err != nil {
-		log.Error().Err(err).Msgf("post messages failed")
+	payload := &Payload{
+		Talker:        actualTalker,
+		Sender:        actualSender,
+		Keyword:       m.conf.Keyword,
+		FilterTalker:  m.conf.Talker,
+		FilterSender:  m.conf.Sender,
+		FilterKeyword: m.conf.Keyword,
+		LastTime:      lastTime.Format(time.DateTime),
+		Length:        len(filtered),
+		Messages:      filtered,
+	}
+	if err := deliver(m.conf, m.renderer, m.outbox, payload); err != nil {
 		return
 	}
-	defer resp.Body.Close()
+	advance()
+}
+
+// processedKey 已推送消息的去重标识
+func processedKey(item *conf.WebhookItem, message *model.Message) string {
+	return fmt.Sprintf("%s#%d", webhookItemSignature(item), message.Seq)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		log.Error().Msgf("post messages failed, status code: %d", resp.StatusCode)
+// deliver 渲染请求体并写入 outbox，由 outbox 负责投递和重试
+// 渲染失败的请求重试也不会成功，直接作为死信保存，不影响调用方推进游标
+// 返回错误时请求体未能写入 outbox，调用方应保留状态，在下次触发时重新处理
+func deliver(item *conf.WebhookItem, renderer *Renderer, outbox *Outbox, batch Batch) error {
+	bodies := make([][]byte, 0)
+	lengths := make([]int, 0)
+	failed := make([]*Rendered, 0)
+	for _, r := range renderer.Render(batch) {
+		if r.Err != nil {
+			log.Error().Err(r.Err).Msgf("render webhook body failed")
+			failed = append(failed, r)
+			continue
+		}
+		bodies = append(bodies, r.Body)
+		lengths = append(lengths, r.Length)
 	}
+
+	// 先写入 outbox 再投递，接收端不可用时消息不会丢失
+	if len(bodies) != 0 {
+		deliveries, err := outbox.Enqueue(bodies, lengths)
+		if err != nil {
+			log.Error().Err(err).Msgf("enqueue webhook delivery failed")
+			return err
+		}
+		for i, delivery := range deliveries {
+			log.Info().Msgf("⚡ webhook %s, length=%d, id=%s", item.URL, lengths[i], delivery.ID)
+			log.Info().Msgf("⚡ body: %s", string(bodies[i]))
+		}
+	}
+
+	for _, r := range failed {
+		delivery, err := outbox.Dead(r.Body, r.Length, r.Err)
+		if err != nil {
+			log.Error().Err(err).Msgf("save webhook dead letter failed")
+			continue
+		}
+		log.Warn().Msgf("⚡ webhook %s, length=%d, id=%s: render failed, saved as dead letter", item.URL, r.Length, delivery.ID)
+	}
+	return nil
 }
 
 func uniqueJoined(messages []*model.Message, selector func(*model.Message) string) string {
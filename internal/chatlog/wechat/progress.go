@@ -0,0 +1,84 @@
+package wechat
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/wechat/decrypt"
+)
+
+// progressInterval 汇总进度回调的最小间隔
+const progressInterval = 500 * time.Millisecond
+
+// batchProgress 汇总多个数据库文件的解密进度
+// 增量解密、已解密的文件在完成时直接计入，不计入解密速度
+type batchProgress struct {
+	mu         sync.Mutex
+	p          decrypt.BatchProgress
+	pageSize   int
+	totals     map[string]int64 // 每个文件的页数
+	reported   map[string]int64 // 每个文件已计入的页数
+	decrypted  int64            // 实际解密的页数
+	start      time.Time
+	last       time.Time
+	onProgress func(decrypt.BatchProgress)
+}
+
+func newBatchProgress(dbFiles []string, pageSize int, onProgress func(decrypt.BatchProgress)) *batchProgress {
+	b := &batchProgress{
+		pageSize:   pageSize,
+		totals:     make(map[string]int64, len(dbFiles)),
+		reported:   make(map[string]int64, len(dbFiles)),
+		start:      time.Now(),
+		onProgress: onProgress,
+	}
+	b.p.TotalFiles = len(dbFiles)
+	for _, dbFile := range dbFiles {
+		if info, err := os.Stat(dbFile); err == nil {
+			b.totals[dbFile] = info.Size() / int64(pageSize)
+			b.p.TotalPages += b.totals[dbFile]
+		}
+	}
+	return b
+}
+
+// update 记录文件 dbFile 已解密的页数
+func (b *batchProgress) update(dbFile string, p decrypt.Progress) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delta := min(p.Pages, b.totals[dbFile]) - b.reported[dbFile]
+	if delta <= 0 {
+		return
+	}
+	b.reported[dbFile] += delta
+	b.decrypted += delta
+	b.p.Pages += delta
+	if time.Since(b.last) >= progressInterval {
+		b.report()
+	}
+}
+
+// done 文件 dbFile 处理结束（包括失败），剩余的页一并计入
+func (b *batchProgress) done(dbFile string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.p.Pages += b.totals[dbFile] - b.reported[dbFile]
+	b.reported[dbFile] = b.totals[dbFile]
+	b.p.Files++
+	b.report()
+}
+
+func (b *batchProgress) report() {
+	b.last = time.Now()
+	elapsed := time.Since(b.start).Seconds()
+	b.p.Elapsed = elapsed
+	if elapsed > 0 {
+		b.p.Throughput = float64(b.decrypted*int64(b.pageSize)) / elapsed
+	}
+	if b.onProgress != nil {
+		b.onProgress(b.p)
+	}
+}
@@ -1,6 +1,7 @@
 package wechat
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"io"
@@ -18,6 +19,7 @@ import (
 	"github.com/sjzar/chatlog/internal/errors"
 	"github.com/sjzar/chatlog/internal/wechat"
 	"github.com/sjzar/chatlog/internal/wechat/decrypt"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/atrest"
 	"github.com/sjzar/chatlog/pkg/filemonitor"
 	"github.com/sjzar/chatlog/pkg/util"
 )
@@ -38,6 +40,7 @@ type Service struct {
 	lastEvents     map[string]time.Time
 	pendingActions map[string]bool
 	mutex          sync.Mutex
+	fileLocks      sync.Map // 解密结果路径 -> *sync.Mutex，同一文件的解密串行执行
 	fm             *filemonitor.FileMonitor
 	dbController   DBController
 }
@@ -47,6 +50,7 @@ type Config interface {
 	GetDataDir() string
 	GetWorkDir() string
 	GetPlatform() string
+	GetEncryptWorkDir() bool
 }
 
 func NewService(conf Config) *Service {
@@ -215,6 +219,10 @@ func (s *Service) waitAndProcess(dbFile string) {
 	}
 }
 func (s *Service) DecryptDBFile(dbFile string) error {
+	return s.decryptDBFile(context.Background(), dbFile)
+}
+
+func (s *Service) decryptDBFile(ctx context.Context, dbFile string) error {
 	decryptor, err := decrypt.NewDecryptor(s.conf.GetPlatform())
 	if err != nil {
 		return err
@@ -230,73 +238,201 @@ func (s *Service) DecryptDBFile(dbFile string) error {
 		return err
 	}
 
+	// 增量解密会原地修改解密结果和清单，同一文件不能并发处理
+	lock, _ := s.fileLocks.LoadOrStore(output, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	key, err := s.WorkDirKey()
+	if err != nil {
+		return err
+	}
+
+	// 已有页清单时只解密变化的页，清单失效时完整解密
+	err = s.patchDB(ctx, decryptor, key, dbFile, output)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, errors.ErrDecryptFullRequired) {
+		log.Debug().Err(err).Msgf("incremental decrypt %s failed, fallback to full decrypt", dbFile)
+	}
+
+	info, err := os.Stat(dbFile)
+	if err != nil {
+		return err
+	}
+
 	// 使用带纳秒级随机性的临时文件名，避免并发解密同一文件时发生冲突
 	tmp := fmt.Sprintf("%s.%d.tmp", output, time.Now().UnixNano())
 
-	f, err := os.Create(tmp)
+	f, err := createOutput(tmp, key)
 	if err != nil {
 		return err
 	}
 
-	err = decryptor.Decrypt(context.Background(), dbFile, s.conf.GetDataKey(), f)
-	f.Close()
-
+	err = decryptor.Decrypt(ctx, dbFile, s.conf.GetDataKey(), f)
+	decrypted := err == nil
+	if err == errors.ErrAlreadyDecrypted {
+		err = copyFileStream(dbFile, f)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		if err == errors.ErrAlreadyDecrypted {
-			if copyErr := copyFileStream(dbFile, tmp); copyErr != nil {
-				_ = os.Remove(tmp)
-				return copyErr
-			}
-		} else {
-			_ = os.Remove(tmp)
-			return err
-		}
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	var manifest *decrypt.Manifest
+	if decrypted {
+		manifest = s.applyWAL(ctx, decryptor, key, dbFile, info, tmp, f.fingerprints)
 	}
 
+	// 替换前删除旧清单，避免替换后清单与解密结果不一致
+	manifestPath := decrypt.ManifestPath(output)
+	_ = os.Remove(manifestPath)
+
 	if err := s.replaceDB(tmp, output); err != nil {
 		log.Logger.Error().Err(err).Msgf("failed to replace db %s", output)
 
 		return err
 	}
 
+	if manifest != nil {
+		if err := manifest.Save(manifestPath); err != nil {
+			log.Debug().Err(err).Msgf("failed to save page manifest %s", manifestPath)
+		}
+	}
+
 	// 清理工作目录下残留的 WAL/SHM 文件，防止 SQLite 读取加密的 WAL 导致失败
 	s.removeWalFiles(output)
 	return nil
 }
 
-func (s *Service) replaceDB(tmp, target string) error {
-	// 在替换之前清理目标目录的 WAL 文件，防止 SQLite 在替换后立即读取旧的加密 WAL
-	s.removeWalFiles(target)
+// patchDB 对比页清单，只解密发生变化的页并写入已解密的数据库
+// 解密在锁定数据库之前完成，锁定期间只写入变化的页
+func (s *Service) patchDB(ctx context.Context, decryptor decrypt.Decryptor, key *atrest.Key, dbFile, output string) error {
+	manifestPath := decrypt.ManifestPath(output)
+	base, err := decrypt.LoadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	if base == nil {
+		return errors.ErrDecryptFullRequired
+	}
+	if err := base.CheckOutput(output); err != nil {
+		return err
+	}
+	// 启用或关闭工作目录加密、重新设置口令后，已有的解密结果需要完整重写
+	sealed, err := atrest.IsSealed(output)
+	if err != nil || sealed != (key != nil) || (sealed && !key.Owns(output)) {
+		return errors.ErrDecryptFullRequired
+	}
+	sealer, err := pageSealer(key, output)
+	if err != nil {
+		return err
+	}
+
+	patch, err := decrypt.PlanPatch(ctx, decryptor, dbFile, s.conf.GetDataKey(), base)
+	if err != nil {
+		return err
+	}
+
+	if !patch.Empty() {
+		err = s.withDBLocked(output, func() error {
+			s.removeWalFiles(output)
+			return patch.Apply(output, sealer)
+		})
+		if err != nil {
+			// 写入中断后解密结果与清单不再一致，下次完整解密
+			_ = os.Remove(manifestPath)
+			return err
+		}
+		log.Debug().Msgf("patched %d pages of %s", len(patch.Pages), output)
+	}
 
+	return patch.Manifest.Save(manifestPath)
+}
+
+// applyWAL 为完整解密的结果生成页清单，并写入 WAL 中已提交的页，失败时返回 nil（下次继续完整解密）
+// fingerprints 为解密结果中每一页的指纹，为 nil 时从解密结果读取
+func (s *Service) applyWAL(ctx context.Context, decryptor decrypt.Decryptor, key *atrest.Key, dbFile string, info os.FileInfo, tmp string, fingerprints []uint64) *decrypt.Manifest {
+	var err error
+	if fingerprints == nil {
+		if fingerprints, err = decrypt.ReadFingerprints(tmp, decryptor.GetPageSize(), decryptor.GetReserve()); err != nil {
+			log.Debug().Err(err).Msgf("failed to read page fingerprints of %s", tmp)
+			return nil
+		}
+	}
+	manifest, err := decrypt.NewManifest(decryptor, dbFile, info, fingerprints)
+	if err != nil {
+		log.Debug().Err(err).Msgf("failed to build page manifest for %s", dbFile)
+		return nil
+	}
+	patch, err := decrypt.PlanPatch(ctx, decryptor, dbFile, s.conf.GetDataKey(), manifest)
+	if err == nil && !patch.Empty() {
+		var sealer decrypt.PageSealer
+		if sealer, err = pageSealer(key, tmp); err == nil {
+			err = patch.Apply(tmp, sealer)
+		}
+	}
+	if err != nil {
+		log.Debug().Err(err).Msgf("failed to apply wal of %s", dbFile)
+		return nil
+	}
+	return patch.Manifest
+}
+
+// withDBLocked 锁定数据库并关闭已有连接后执行 fn，避免查询读取到写入一半的文件
+func (s *Service) withDBLocked(target string, fn func() error) error {
 	if s.dbController != nil {
 		s.dbController.LockDB(target)
 		defer s.dbController.UnlockDB(target)
 		s.dbController.CloseDB(target)
 		time.Sleep(100 * time.Millisecond)
 	}
+	return fn()
+}
 
-	// Windows 下重试 50 次，每次 200ms，总计 10s 窗口
-	// 这可以容纳绝大多数长耗时查询完成并释放句柄
-	var err error
-	for i := 0; i < 50; i++ {
-		err = os.Rename(tmp, target)
-		if err == nil {
-			return nil
-		}
+func (s *Service) replaceDB(tmp, target string) error {
+	// 在替换之前清理目标目录的 WAL 文件，防止 SQLite 在替换后立即读取旧的加密 WAL
+	s.removeWalFiles(target)
 
-		if errors.Is(err, fs.ErrPermission) {
-			// 在 Windows 上，如果目标文件已存在且被锁定，Rename 会返回 Permission 错误
-			// 尝试删除（尽管如果被锁定删除也会失败，但对于非锁定的 Permission 问题有效）
-			_ = os.Remove(target)
-		}
+	return s.withDBLocked(target, func() error {
+		// Windows 下重试 50 次，每次 200ms，总计 10s 窗口
+		// 这可以容纳绝大多数长耗时查询完成并释放句柄
+		var err error
+		for i := 0; i < 50; i++ {
+			err = os.Rename(tmp, target)
+			if err == nil {
+				return nil
+			}
 
-		time.Sleep(200 * time.Millisecond)
-	}
+			if errors.Is(err, fs.ErrPermission) {
+				// 在 Windows 上，如果目标文件已存在且被锁定，Rename 会返回 Permission 错误
+				// 尝试删除（尽管如果被锁定删除也会失败，但对于非锁定的 Permission 问题有效）
+				_ = os.Remove(target)
+			}
 
-	return fmt.Errorf("failed to replace db %s within 10s: %w", target, err)
+			time.Sleep(200 * time.Millisecond)
+		}
+
+		return fmt.Errorf("failed to replace db %s within 10s: %w", target, err)
+	})
 }
 
 func (s *Service) DecryptDBFiles() error {
+	return s.DecryptDBFilesWithProgress(context.Background(), nil)
+}
+
+// DecryptDBFilesWithProgress 并发解密所有数据库文件，onProgress 按间隔接收汇总进度
+// ctx 取消后正在解密的文件会中止（保留原有的解密结果），并返回 errors.ErrDecryptOperationCanceled
+func (s *Service) DecryptDBFilesWithProgress(ctx context.Context, onProgress func(decrypt.BatchProgress)) error {
+	decryptor, err := decrypt.NewDecryptor(s.conf.GetPlatform())
+	if err != nil {
+		return err
+	}
+
 	dbGroup, err := filemonitor.NewFileGroup("wechat", s.conf.GetDataDir(), `.*\.db$`, []string{"fts"})
 	if err != nil {
 		return err
@@ -311,6 +447,8 @@ func (s *Service) DecryptDBFiles() error {
 		return nil
 	}
 
+	progress := newBatchProgress(dbFiles, decryptor.GetPageSize(), onProgress)
+
 	workers := runtime.NumCPU()
 	if workers < 2 {
 		workers = 2
@@ -326,9 +464,16 @@ func (s *Service) DecryptDBFiles() error {
 		go func() {
 			defer workerWG.Done()
 			for dbFile := range jobs {
-				if err := s.DecryptDBFile(dbFile); err != nil {
+				if ctx.Err() != nil {
+					continue
+				}
+				fileCtx := decrypt.WithProgress(ctx, func(p decrypt.Progress) {
+					progress.update(dbFile, p)
+				})
+				if err := s.decryptDBFile(fileCtx, dbFile); err != nil {
 					log.Debug().Msgf("DecryptDBFile %s failed: %v", dbFile, err)
 				}
+				progress.done(dbFile)
 			}
 		}()
 	}
@@ -339,31 +484,85 @@ func (s *Service) DecryptDBFiles() error {
 	close(jobs)
 	workerWG.Wait()
 
+	if ctx.Err() != nil {
+		return errors.ErrDecryptOperationCanceled
+	}
 	return nil
 }
 
-func copyFileStream(src, dst string) error {
+func copyFileStream(src string, dst io.Writer) error {
 	in, err := os.Open(src)
 	if err != nil {
 		return err
 	}
 	defer in.Close()
 
-	out, err := os.Create(dst)
+	_, err = io.Copy(dst, in)
+	return err
+}
+
+// WorkDirKey 返回工作目录的密钥，未启用工作目录加密时返回 nil
+// 配置开启加密时首次调用会为工作目录设置口令；工作目录已加密时即使配置未开启也继续加密，避免明文与密文混杂
+func (s *Service) WorkDirKey() (*atrest.Key, error) {
+	if len(s.conf.GetWorkDir()) == 0 {
+		return nil, nil
+	}
+	if s.conf.GetEncryptWorkDir() {
+		return atrest.Init(s.conf.GetWorkDir())
+	}
+	return atrest.Open(s.conf.GetWorkDir())
+}
+
+// outputFile 完整解密的临时文件，启用工作目录加密时写入的内容逐页加密
+type outputFile struct {
+	io.Writer
+	f      *os.File
+	bw     *bufio.Writer
+	sealer *atrest.Writer
+
+	// fingerprints 加密写入时记录的每页指纹，Close 之后有效，未加密时为 nil
+	fingerprints []uint64
+}
+
+func createOutput(path string, key *atrest.Key) (*outputFile, error) {
+	f, err := os.Create(path)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if key == nil {
+		return &outputFile{Writer: f, f: f}, nil
 	}
 
-	if _, err = io.Copy(out, in); err != nil {
-		out.Close()
-		return err
+	bw := bufio.NewWriterSize(f, 256*atrest.PageSize)
+	sealer, err := key.NewWriter(bw, path)
+	if err != nil {
+		f.Close()
+		return nil, err
 	}
+	return &outputFile{Writer: sealer, f: f, bw: bw, sealer: sealer}, nil
+}
 
-	if err = out.Close(); err != nil {
-		return err
+func (o *outputFile) Close() error {
+	var err error
+	if o.sealer != nil {
+		err = o.sealer.Close()
+		if err == nil {
+			err = o.bw.Flush()
+		}
+		o.fingerprints = o.sealer.Fingerprints()
+	}
+	if closeErr := o.f.Close(); err == nil {
+		err = closeErr
 	}
+	return err
+}
 
-	return nil
+// pageSealer 返回加密写入解密结果 path 的页面加密器，未启用工作目录加密时返回 nil
+func pageSealer(key *atrest.Key, path string) (decrypt.PageSealer, error) {
+	if key == nil {
+		return nil, nil
+	}
+	return key.CipherOf(path)
 }
 
 // removeWalFiles 删除数据库对应的 WAL 和 SHM 文件
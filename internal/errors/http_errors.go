@@ -9,3 +9,27 @@ func InvalidArg(arg string) error {
 func HTTPShutDown(cause error) error {
 	return Newf(cause, http.StatusInternalServerError, "http server shut down")
 }
+
+func Unauthorized() error {
+	return Newf(nil, http.StatusUnauthorized, "unauthorized: missing or invalid token")
+}
+
+func Forbidden(scope string) error {
+	return Newf(nil, http.StatusForbidden, "forbidden: token has no %s scope", scope)
+}
+
+func TalkerForbidden(talker string) error {
+	return Newf(nil, http.StatusForbidden, "forbidden: token is not allowed to access talker %s", talker)
+}
+
+func MediaForbidden(path string) error {
+	return Newf(nil, http.StatusForbidden, "forbidden: token is restricted to talkers and media %s does not belong to them", path)
+}
+
+func TalkerDenied(talker string) error {
+	return Newf(nil, http.StatusForbidden, "forbidden: talker %s is not exposed by policy", talker)
+}
+
+func MediaDenied(path string) error {
+	return Newf(nil, http.StatusForbidden, "forbidden: media %s is not exposed by policy", path)
+}
@@ -7,6 +7,7 @@ var (
 	ErrDecryptHashVerificationFailed = New(nil, http.StatusBadRequest, "hash verification failed during decryption")
 	ErrDecryptIncorrectKey           = New(nil, http.StatusBadRequest, "incorrect decryption key")
 	ErrDecryptOperationCanceled      = New(nil, http.StatusBadRequest, "decryption operation was canceled")
+	ErrDecryptFullRequired           = New(nil, http.StatusBadRequest, "page manifest is stale, full decryption required")
 	ErrNoMemoryRegionsFound          = New(nil, http.StatusBadRequest, "no memory regions found")
 	ErrReadMemoryTimeout             = New(nil, http.StatusInternalServerError, "read memory timeout")
 	ErrWeChatOffline                 = New(nil, http.StatusBadRequest, "WeChat is offline")
@@ -14,6 +15,8 @@ var (
 	ErrValidatorNotSet               = New(nil, http.StatusBadRequest, "validator not set")
 	ErrNoValidKey                    = New(nil, http.StatusBadRequest, "no valid key found")
 	ErrWeChatDLLNotFound             = New(nil, http.StatusBadRequest, "WeChatWin.dll module not found")
+	ErrWorkDirPassphraseRequired     = New(nil, http.StatusInternalServerError, "work dir is encrypted, passphrase required")
+	ErrWorkDirIncorrectPassphrase    = New(nil, http.StatusInternalServerError, "incorrect work dir passphrase")
 )
 
 func PlatformUnsupported(platform string) *Error {
@@ -24,6 +27,10 @@ func DecryptCreateCipherFailed(cause error) *Error {
 	return New(cause, http.StatusInternalServerError, "failed to create cipher").WithStack()
 }
 
+func WorkDirUnsupportedDB(path string) *Error {
+	return Newf(nil, http.StatusInternalServerError, "cannot encrypt %s: page size must be 4096 with at least 80 reserved bytes", path).WithStack()
+}
+
 func DecodeKeyFailed(cause error) *Error {
 	return New(cause, http.StatusBadRequest, "failed to decode hex key").WithStack()
 }
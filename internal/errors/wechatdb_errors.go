@@ -8,6 +8,7 @@ import (
 var (
 	ErrTalkerEmpty     = New(nil, http.StatusBadRequest, "talker empty").WithStack()
 	ErrKeyEmpty        = New(nil, http.StatusBadRequest, "key empty").WithStack()
+	ErrKeywordEmpty    = New(nil, http.StatusBadRequest, "keyword empty").WithStack()
 	ErrMediaNotFound   = New(nil, http.StatusNotFound, "media not found").WithStack()
 	ErrKeyLengthMust32 = New(nil, http.StatusBadRequest, "key length must be 32 bytes").WithStack()
 )
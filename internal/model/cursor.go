@@ -0,0 +1,62 @@
+package model
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MessageCursor 消息分页游标，指向上一页的最后一条消息
+// 消息按 (Seq, Talker) 升序排列，下一页从游标之后的第一条消息开始
+type MessageCursor struct {
+	Seq    int64
+	Talker string
+}
+
+const cursorVersion = "v1"
+
+// Encode 将游标编码为不透明的字符串，客户端只需原样传回
+func (c *MessageCursor) Encode() string {
+	if c == nil {
+		return ""
+	}
+	raw := fmt.Sprintf("%s:%d:%s", cursorVersion, c.Seq, c.Talker)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// After 判断消息是否位于游标之后
+func (c *MessageCursor) After(seq int64, talker string) bool {
+	if c == nil {
+		return true
+	}
+	return seq > c.Seq || (seq == c.Seq && talker > c.Talker)
+}
+
+// CursorOf 返回指向指定消息的游标
+func CursorOf(m *Message) *MessageCursor {
+	if m == nil {
+		return nil
+	}
+	return &MessageCursor{Seq: m.Seq, Talker: m.Talker}
+}
+
+// ParseMessageCursor 解析客户端传入的游标，空字符串返回 nil
+func ParseMessageCursor(s string) (*MessageCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+	parts := strings.SplitN(string(raw), ":", 3)
+	if len(parts) != 3 || parts[0] != cursorVersion {
+		return nil, fmt.Errorf("invalid cursor: %s", s)
+	}
+	seq, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+	return &MessageCursor{Seq: seq, Talker: parts[2]}, nil
+}
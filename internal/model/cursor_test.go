@@ -0,0 +1,123 @@
+package model
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParseMessageCursor(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    *MessageCursor
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		{"round trip", (&MessageCursor{Seq: 1700000000123, Talker: "wxid_a"}).Encode(), &MessageCursor{Seq: 1700000000123, Talker: "wxid_a"}, false},
+		{"talker with colon", (&MessageCursor{Seq: 1, Talker: "a:b"}).Encode(), &MessageCursor{Seq: 1, Talker: "a:b"}, false},
+		{"not base64", "!!!", nil, true},
+		{"wrong version", base64.RawURLEncoding.EncodeToString([]byte("v0:1:wxid_a")), nil, true},
+		{"bad seq", base64.RawURLEncoding.EncodeToString([]byte("v1:x:wxid_a")), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMessageCursor(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMessageCursor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseMessageCursor() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageCursorAfter(t *testing.T) {
+	c := &MessageCursor{Seq: 100, Talker: "wxid_b"}
+	tests := []struct {
+		name   string
+		cursor *MessageCursor
+		seq    int64
+		talker string
+		want   bool
+	}{
+		{"nil cursor", nil, 1, "wxid_a", true},
+		{"larger seq", c, 101, "wxid_a", true},
+		{"smaller seq", c, 99, "wxid_c", false},
+		{"same seq larger talker", c, 100, "wxid_c", true},
+		{"same seq smaller talker", c, 100, "wxid_a", false},
+		{"cursor itself", c, 100, "wxid_b", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cursor.After(tt.seq, tt.talker); got != tt.want {
+				t.Errorf("After(%d, %s) = %v, want %v", tt.seq, tt.talker, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSearchCursor(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    *SearchCursor
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		{"round trip", (&SearchCursor{Matches: 3, Seq: 1700000000123, Talker: "123@chatroom"}).Encode(), &SearchCursor{Matches: 3, Seq: 1700000000123, Talker: "123@chatroom"}, false},
+		{"not base64", "!!!", nil, true},
+		{"bad matches", base64.RawURLEncoding.EncodeToString([]byte("v1:x:1:wxid_a")), nil, true},
+		{"bad seq", base64.RawURLEncoding.EncodeToString([]byte("v1:1:x:wxid_a")), nil, true},
+		{"message cursor", (&MessageCursor{Seq: 1, Talker: "wxid_a"}).Encode(), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSearchCursor(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSearchCursor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSearchCursor() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPageSearchHits(t *testing.T) {
+	hits := []*SearchHit{
+		{Message: &Message{Seq: 5, Talker: "a"}, Matches: 3},
+		{Message: &Message{Seq: 9, Talker: "a"}, Matches: 1},
+		{Message: &Message{Seq: 7, Talker: "a"}, Matches: 1},
+		{Message: &Message{Seq: 7, Talker: "b"}, Matches: 1},
+		{Message: &Message{Seq: 1, Talker: "c"}, Matches: 2},
+	}
+	SortSearchHits(hits)
+
+	// 按游标逐页读取，结果与一次读取全部相同
+	want := []string{"a:5", "c:1", "a:9", "a:7", "b:7"}
+	got := make([]string, 0, len(hits))
+	var cursor *SearchCursor
+	for page := 0; page < len(hits); page++ {
+		items, next := PageSearchHits(hits, cursor, 2, 0)
+		for _, h := range items {
+			got = append(got, fmt.Sprintf("%s:%d", h.Talker, h.Seq))
+		}
+		if next == "" {
+			break
+		}
+		var err error
+		if cursor, err = ParseSearchCursor(next); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("paged hits = %v, want %v", got, want)
+	}
+
+	if items, next := PageSearchHits(hits, nil, 2, 4); len(items) != 1 || next != "" {
+		t.Errorf("PageSearchHits(offset=4) = %d items, next %q, want 1 item and no cursor", len(items), next)
+	}
+}
@@ -1,9 +1,22 @@
 package model
 
 import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
 	"path/filepath"
+	"strings"
 )
 
+// MediaURL 返回 HTTP 服务中媒体文件的链接
+// host 可以带协议（如 "https://example.com:5030"），未带协议时使用 http
+func MediaURL(host, _type, key string) string {
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return fmt.Sprintf("%s/%s/%s", host, _type, key)
+}
+
 type Media struct {
 	Type       string `json:"type"` // 媒体类型：image, video, voice, file
 	Key        string `json:"key"`  // MD5
@@ -43,3 +56,24 @@ func (m *MediaV3) Wrap() *Media {
 		Name:       m.Name,
 	}
 }
+
+// TalkerHash 图片目录中使用的会话标识，即会话 ID 的 MD5
+func TalkerHash(talker string) string {
+	sum := md5.Sum([]byte(talker))
+	return hex.EncodeToString(sum[:])
+}
+
+// MediaTalkerHash 返回媒体文件所属会话的 TalkerHash
+// 只有图片按会话保存（v4 为 msg/attach/<hash>/，v3 为 FileStorage/MsgAttach/<hash>/），视频、文件和语音无法确定所属会话
+func MediaTalkerHash(path string) (string, bool) {
+	parts := strings.Split(filepath.ToSlash(filepath.Clean(path)), "/")
+	for i := 0; i+2 < len(parts); i++ {
+		if (parts[i] == "msg" && parts[i+1] == "attach") || (parts[i] == "FileStorage" && parts[i+1] == "MsgAttach") {
+			if hash := strings.ToLower(parts[i+2]); len(hash) == 32 {
+				return hash, true
+			}
+			return "", false
+		}
+	}
+	return "", false
+}
@@ -264,17 +264,17 @@ func (r *RecordInfo) String(_type, title, host string) string {
 		switch item.DataType {
 		case "2":
 			// 图片
-			buf.WriteString(fmt.Sprintf("  ![图片](http://%s/image/%s)\n", host, item.FullMD5))
+			buf.WriteString(fmt.Sprintf("  ![图片](%s)\n", MediaURL(host, "image", item.FullMD5)))
 		case "4":
 			//视频
-			buf.WriteString(fmt.Sprintf("  ![视频](http://%s/video/%s)\n", host, item.FullMD5))
+			buf.WriteString(fmt.Sprintf("  ![视频](%s)\n", MediaURL(host, "video", item.FullMD5)))
 		case "8":
 			// 文件
 			// FIXME 笔记的第一条是 htm 数据，暂时跳过处理
 			if item.DataFmt == ".htm" {
 				continue
 			}
-			buf.WriteString(fmt.Sprintf("  [文件|%s](http://%s/file/%s)\n", item.DataTitle, host, item.FullMD5))
+			buf.WriteString(fmt.Sprintf("  [文件|%s](%s)\n", item.DataTitle, MediaURL(host, "file", item.FullMD5)))
 		case "5":
 			// Link
 			buf.WriteString(fmt.Sprintf("  [链接|%s](%s)\n", item.DataTitle, item.Link))
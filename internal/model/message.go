@@ -124,6 +124,33 @@ type Message struct {
 	SysMsg   *SysMsg   `json:"sysMsg,omitempty"`   // 原始系统消息，XML 格式
 }
 
+// MessageFilter 数据源读取消息时附加的过滤条件，字段为 nil 时不做对应的处理
+type MessageFilter struct {
+	// Talker 返回会话是否可以读取，不可读取的会话在查询前跳过，不占用搜索的命中与扫描上限
+	Talker func(talker string) bool
+
+	// Redact 在匹配关键词之前处理每条消息（如脱敏），关键词只匹配处理后的内容
+	// 全文索引中保存的是原始内容，设置后关键词查询不使用索引
+	Redact func(m *Message)
+}
+
+// Allow 判断会话是否可以读取
+func (f *MessageFilter) Allow(talker string) bool {
+	return f == nil || f.Talker == nil || f.Talker(talker)
+}
+
+// Apply 对消息执行 Redact
+func (f *MessageFilter) Apply(m *Message) {
+	if f != nil && f.Redact != nil {
+		f.Redact(m)
+	}
+}
+
+// Redacting 判断是否需要在匹配关键词之前处理消息内容
+func (f *MessageFilter) Redacting() bool {
+	return f != nil && f.Redact != nil
+}
+
 func (m *Message) ParseMediaInfo(data string) error {
 
 	m.Type, m.SubType = util.SplitInt64ToTwoInt32(m.Type)
@@ -295,6 +322,7 @@ func (m *Message) SetContent(key string, value interface{}) {
 	m.Contents[key] = value
 }
 
+// host 为媒体链接使用的 HTTP 服务地址，可以带协议，见 MediaURL
 func (m *Message) PlainText(showChatRoom bool, timeFormat string, host string) string {
 
 	if timeFormat == "" {
@@ -362,10 +390,10 @@ func (m *Message) PlainTextContent() string {
 				keylist = append(keylist, thumbpath)
 			}
 		}
-		return fmt.Sprintf("![图片](http://%s/image/%s)", m.Contents["host"], strings.Join(keylist, ","))
+		return fmt.Sprintf("![图片](%s)", MediaURL(m.host(), "image", strings.Join(keylist, ",")))
 	case MessageTypeVoice:
 		if voice, ok := m.Contents["voice"]; ok {
-			return fmt.Sprintf("[语音](http://%s/voice/%s)", m.Contents["host"], voice)
+			return fmt.Sprintf("[语音](%s)", MediaURL(m.host(), "voice", fmt.Sprint(voice)))
 		}
 		return "[语音]"
 	case MessageTypeCard:
@@ -387,7 +415,7 @@ func (m *Message) PlainTextContent() string {
 				keylist = append(keylist, path)
 			}
 		}
-		return fmt.Sprintf("![视频](http://%s/video/%s)", m.Contents["host"], strings.Join(keylist, ","))
+		return fmt.Sprintf("![视频](%s)", MediaURL(m.host(), "video", strings.Join(keylist, ",")))
 	case MessageTypeAnimation:
 		if m.Contents["cdnurl"] != nil {
 			if cdnURL, ok := m.Contents["cdnurl"].(string); ok {
@@ -412,7 +440,7 @@ func (m *Message) PlainTextContent() string {
 		case MessageSubTypeLink, MessageSubTypeLink2:
 			return fmt.Sprintf("[链接|%s](%s)", m.Contents["title"], m.Contents["url"])
 		case MessageSubTypeFile:
-			return fmt.Sprintf("[文件|%s](http://%s/file/%s)", m.Contents["title"], m.Contents["host"], m.Contents["md5"])
+			return fmt.Sprintf("[文件|%s](%s)", m.Contents["title"], MediaURL(m.host(), "file", fmt.Sprint(m.Contents["md5"])))
 		case MessageSubTypeGIF:
 			return "[GIF表情]"
 		case MessageSubTypeMergeForward:
@@ -424,10 +452,7 @@ func (m *Message) PlainTextContent() string {
 			if !ok {
 				return "[合并转发]"
 			}
-			host := ""
-			if m.Contents["host"] != nil {
-				host = m.Contents["host"].(string)
-			}
+			host := m.host()
 			return recordInfo.String("合并转发", "", host)
 		case MessageSubTypeNote:
 			_recordInfo, ok := m.Contents["recordInfo"]
@@ -438,10 +463,7 @@ func (m *Message) PlainTextContent() string {
 			if !ok {
 				return "[笔记]"
 			}
-			host := ""
-			if m.Contents["host"] != nil {
-				host = m.Contents["host"].(string)
-			}
+			host := m.host()
 			return recordInfo.String("笔记", "", host)
 		case MessageSubTypeMiniProgram, MessageSubTypeMiniProgram2:
 			if m.Contents["title"] == "" {
@@ -470,10 +492,7 @@ func (m *Message) PlainTextContent() string {
 				return "> [引用]\n" + m.Content
 			}
 			buf := strings.Builder{}
-			host := ""
-			if m.Contents["host"] != nil {
-				host = m.Contents["host"].(string)
-			}
+			host := m.host()
 			referContent := refer.PlainText(false, "", host)
 			for _, line := range strings.Split(referContent, "\n") {
 				if line == "" {
@@ -501,10 +520,7 @@ func (m *Message) PlainTextContent() string {
 			if !ok {
 				return "[群公告]"
 			}
-			host := ""
-			if m.Contents["host"] != nil {
-				host = m.Contents["host"].(string)
-			}
+			host := m.host()
 			return recordInfo.String("群公告", "", host)
 		case MessageSubTypeMusic:
 			return fmt.Sprintf("[音乐|%s](%s)", m.Contents["title"], m.Contents["url"])
@@ -530,6 +546,12 @@ func (m *Message) PlainTextContent() string {
 	}
 }
 
+// host 返回 PlainText/CSV 设置的媒体链接地址
+func (m *Message) host() string {
+	host, _ := m.Contents["host"].(string)
+	return host
+}
+
 func (m *Message) CSV(host string) []string {
 	m.SetContent("host", host)
 	return []string{
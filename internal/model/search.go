@@ -0,0 +1,132 @@
+package model
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SearchHit 全局搜索命中的消息
+type SearchHit struct {
+	*Message
+	Snippet string `json:"snippet"` // 命中位置附近的内容摘要
+	Matches int    `json:"matches"` // 查询词的命中次数
+}
+
+// SortSearchHits 按 (Matches, Seq, Talker) 排序搜索结果：命中次数多的在前，次数相同时较新的消息在前
+// 排序只依赖消息本身，顺序稳定，游标可以据此翻页
+func SortSearchHits(hits []*SearchHit) {
+	sort.Slice(hits, func(i, j int) bool {
+		return searchLess(hits[i].Matches, hits[i].Seq, hits[i].Talker, hits[j].Matches, hits[j].Seq, hits[j].Talker)
+	})
+}
+
+func searchLess(matches1 int, seq1 int64, talker1 string, matches2 int, seq2 int64, talker2 string) bool {
+	if matches1 != matches2 {
+		return matches1 > matches2
+	}
+	if seq1 != seq2 {
+		return seq1 > seq2
+	}
+	return talker1 < talker2
+}
+
+// SearchCursor 搜索结果的分页游标，指向上一页的最后一条命中
+type SearchCursor struct {
+	Matches int
+	Seq     int64
+	Talker  string
+}
+
+// Encode 将游标编码为不透明的字符串，客户端只需原样传回
+func (c *SearchCursor) Encode() string {
+	if c == nil {
+		return ""
+	}
+	raw := fmt.Sprintf("%s:%d:%d:%s", cursorVersion, c.Matches, c.Seq, c.Talker)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// After 判断命中结果是否位于游标之后
+func (c *SearchCursor) After(h *SearchHit) bool {
+	if c == nil {
+		return true
+	}
+	return searchLess(c.Matches, c.Seq, c.Talker, h.Matches, h.Seq, h.Talker)
+}
+
+// ParseSearchCursor 解析客户端传入的游标，空字符串返回 nil
+func ParseSearchCursor(s string) (*SearchCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+	parts := strings.SplitN(string(raw), ":", 4)
+	if len(parts) != 4 || parts[0] != cursorVersion {
+		return nil, fmt.Errorf("invalid cursor: %s", s)
+	}
+	matches, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+	seq, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+	return &SearchCursor{Matches: matches, Seq: seq, Talker: parts[3]}, nil
+}
+
+// PageSearchHits 从已排序的搜索结果中取出游标之后、跳过 offset 条后的 limit 条结果
+// 还有下一页时返回指向本页最后一条的游标
+func PageSearchHits(hits []*SearchHit, cursor *SearchCursor, limit, offset int) ([]*SearchHit, string) {
+	start := 0
+	if cursor != nil {
+		start = sort.Search(len(hits), func(i int) bool { return cursor.After(hits[i]) })
+	}
+	start = min(start+max(offset, 0), len(hits))
+	end := len(hits)
+	if limit > 0 && start+limit < end {
+		end = start + limit
+	}
+	page := hits[start:end]
+
+	nextCursor := ""
+	if end < len(hits) && len(page) > 0 {
+		last := page[len(page)-1]
+		nextCursor = (&SearchCursor{Matches: last.Matches, Seq: last.Seq, Talker: last.Talker}).Encode()
+	}
+	return page, nextCursor
+}
+
+// PlainText 返回搜索结果的文本格式
+// 格式："[TalkerName(Talker)] SenderName(Sender) 时间 seq=消息序号\n摘要"
+func (h *SearchHit) PlainText() string {
+	buf := strings.Builder{}
+
+	buf.WriteString("[")
+	if h.TalkerName != "" {
+		buf.WriteString(fmt.Sprintf("%s(%s)", h.TalkerName, h.Talker))
+	} else {
+		buf.WriteString(h.Talker)
+	}
+	buf.WriteString("] ")
+
+	if h.SenderName != "" {
+		buf.WriteString(fmt.Sprintf("%s(%s)", h.SenderName, h.Sender))
+	} else {
+		buf.WriteString(h.Sender)
+	}
+	buf.WriteString(" ")
+	buf.WriteString(h.Time.Format("2006-01-02 15:04:05"))
+	buf.WriteString(fmt.Sprintf(" seq=%d", h.Seq))
+	buf.WriteString("\n")
+	buf.WriteString(h.Snippet)
+	buf.WriteString("\n")
+
+	return buf.String()
+}
@@ -16,6 +16,7 @@ type Session struct {
 	PersonID       string   `json:"personID"`
 	IsSelf         bool     `json:"isSelf"`
 	IsMentionMe    bool     `json:"isMentionMe"`
+	UnreadCount    int      `json:"unreadCount"`
 	LastMsgLocalID int      `json:"-"` // 内部使用，用于查询发送人
 }
 
@@ -34,9 +34,9 @@ type SessionV4 struct {
 	LastMsgType           int    `json:"last_msg_type"`
 	LastMsgSubType        int    `json:"last_msg_sub_type"`
 	Status                int    `json:"status"`
+	UnreadCount           int    `json:"unread_count"`
 
 	// Type                     int    `json:"type"`
-	// UnreadCount              int    `json:"unread_count"`
 	// UnreadFirstMsgSrvID      int    `json:"unread_first_msg_srv_id"`
 	// IsHidden                 int    `json:"is_hidden"`
 	// Draft                    string `json:"draft"`
@@ -98,6 +98,7 @@ func (s *SessionV4) Wrap() *Session {
 		IsChatroom:     isChatroom,
 		PersonID:       s.LastMsgSender,
 		PersonName:     s.LastSenderDisplayName,
+		UnreadCount:    s.UnreadCount,
 		LastMsgLocalID: s.LastMsgLocaldID,
 	}
 	if res.TopicName == "" {
@@ -0,0 +1,180 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MessageStats 会话在时间范围内的消息统计
+type MessageStats struct {
+	Talker     string        `json:"talker"`
+	TalkerName string        `json:"talkerName"`
+	Total      int           `json:"total"`
+	FirstTime  JSONTime      `json:"firstTime"` // 第一条消息的时间，没有消息时为零值
+	LastTime   JSONTime      `json:"lastTime"`  // 最后一条消息的时间
+	Senders    []*SenderStat `json:"senders"`   // 按消息数量降序
+	Days       []*CountStat  `json:"days"`      // 按日期升序，只包含有消息的日期
+	Hours      [24]int       `json:"hours"`     // 每个小时（本地时间）的消息数量
+	Types      []*TypeStat   `json:"types"`     // 按消息数量降序
+	Links      []*ShareStat  `json:"links"`     // 分享次数最多的链接
+	Files      []*ShareStat  `json:"files"`     // 分享次数最多的文件
+}
+
+// SenderStat 发送人的消息统计，系统消息不计入
+type SenderStat struct {
+	Sender     string   `json:"sender"`
+	SenderName string   `json:"senderName"`
+	IsSelf     bool     `json:"isSelf"`
+	Count      int      `json:"count"`
+	FirstTime  JSONTime `json:"firstTime"`
+	LastTime   JSONTime `json:"lastTime"`
+}
+
+// CountStat 按日期等维度分组的消息数量
+type CountStat struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// TypeStat 按消息类型分组的消息数量
+type TypeStat struct {
+	Type    int64  `json:"type"`
+	SubType int64  `json:"subType"`
+	Name    string `json:"name"`
+	Count   int    `json:"count"`
+}
+
+// ShareStat 分享的链接或文件，链接按 URL 合并，文件按 MD5（没有时按标题）合并
+type ShareStat struct {
+	Title    string   `json:"title"`
+	URL      string   `json:"url,omitempty"`
+	MD5      string   `json:"md5,omitempty"`
+	Count    int      `json:"count"`
+	LastTime JSONTime `json:"lastTime"`
+}
+
+// MessageTypeName 返回消息类型的名称，与 PlainTextContent 中的描述保持一致
+func MessageTypeName(_type, subType int64) string {
+	switch _type {
+	case MessageTypeText:
+		return "文本"
+	case MessageTypeImage:
+		return "图片"
+	case MessageTypeVoice:
+		return "语音"
+	case MessageTypeCard:
+		return "名片"
+	case MessageTypeVideo:
+		return "视频"
+	case MessageTypeAnimation:
+		return "动画表情"
+	case MessageTypeLocation:
+		return "位置"
+	case MessageTypeVOIP:
+		return "语音通话"
+	case MessageTypeSystem:
+		return "系统消息"
+	case MessageTypeShare:
+		switch subType {
+		case MessageSubTypeText, MessageSubTypeLink, MessageSubTypeLink2:
+			return "链接"
+		case MessageSubTypeFile:
+			return "文件"
+		case MessageSubTypeGIF:
+			return "GIF表情"
+		case MessageSubTypeMergeForward:
+			return "合并转发"
+		case MessageSubTypeNote:
+			return "笔记"
+		case MessageSubTypeMiniProgram, MessageSubTypeMiniProgram2:
+			return "小程序"
+		case MessageSubTypeChannel:
+			return "视频号"
+		case MessageSubTypeQuote:
+			return "引用"
+		case MessageSubTypePat:
+			return "拍一拍"
+		case MessageSubTypeChannelLive:
+			return "视频号直播"
+		case MessageSubTypeChatRoomNotice:
+			return "群公告"
+		case MessageSubTypeMusic:
+			return "音乐"
+		case MessageSubTypePay:
+			return "转账"
+		case MessageSubTypeRedEnvelope:
+			return "红包"
+		case MessageSubTypeRedEnvelopeCover:
+			return "红包封面"
+		}
+		return fmt.Sprintf("分享(%d)", subType)
+	}
+	return fmt.Sprintf("未知类型(%d)", _type)
+}
+
+// PlainText 返回统计结果的文本格式，top 限制发送人、链接和文件的数量，0 表示不限制
+func (s *MessageStats) PlainText(top int) string {
+	buf := strings.Builder{}
+
+	if s.TalkerName != "" {
+		buf.WriteString(fmt.Sprintf("%s(%s)", s.TalkerName, s.Talker))
+	} else {
+		buf.WriteString(s.Talker)
+	}
+	buf.WriteString(fmt.Sprintf(" 共 %d 条消息\n", s.Total))
+	if s.Total == 0 {
+		return buf.String()
+	}
+	buf.WriteString(fmt.Sprintf("首条消息：%s\n最后消息：%s\n", s.FirstTime, s.LastTime))
+
+	buf.WriteString("\n发送人：\n")
+	for i, sender := range s.Senders {
+		if top > 0 && i >= top {
+			buf.WriteString(fmt.Sprintf("...（共 %d 人）\n", len(s.Senders)))
+			break
+		}
+		name := sender.Sender
+		if sender.SenderName != "" {
+			name = fmt.Sprintf("%s(%s)", sender.SenderName, sender.Sender)
+		}
+		buf.WriteString(fmt.Sprintf("%s %d 条，%s ~ %s\n", name, sender.Count, sender.FirstTime, sender.LastTime))
+	}
+
+	buf.WriteString("\n消息类型：\n")
+	for _, t := range s.Types {
+		buf.WriteString(fmt.Sprintf("%s %d 条\n", t.Name, t.Count))
+	}
+
+	buf.WriteString("\n每日消息：\n")
+	for _, day := range s.Days {
+		buf.WriteString(fmt.Sprintf("%s %d\n", day.Key, day.Count))
+	}
+
+	buf.WriteString("\n每小时消息：\n")
+	for hour, count := range s.Hours {
+		if count > 0 {
+			buf.WriteString(fmt.Sprintf("%02d:00 %d\n", hour, count))
+		}
+	}
+
+	writeShares := func(title string, shares []*ShareStat) {
+		if len(shares) == 0 {
+			return
+		}
+		buf.WriteString("\n" + title + "：\n")
+		for i, share := range shares {
+			if top > 0 && i >= top {
+				break
+			}
+			buf.WriteString(fmt.Sprintf("%s %d 次", share.Title, share.Count))
+			if share.URL != "" {
+				buf.WriteString(" " + share.URL)
+			}
+			buf.WriteString("\n")
+		}
+	}
+	writeShares("分享的链接", s.Links)
+	writeShares("分享的文件", s.Files)
+
+	return buf.String()
+}
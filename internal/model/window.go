@@ -0,0 +1,18 @@
+package model
+
+// MessageWindow 会话中目标消息及其前后的消息，多个目标消息的上下文重叠时合并为一个窗口
+type MessageWindow struct {
+	Talker   string     `json:"talker"`
+	Seqs     []int64    `json:"seqs"`     // 窗口中的目标消息序号
+	Messages []*Message `json:"messages"` // 按 Seq 升序
+}
+
+// IsTarget 判断消息是否为窗口中的目标消息
+func (w *MessageWindow) IsTarget(m *Message) bool {
+	for _, seq := range w.Seqs {
+		if m.Seq == seq {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,183 @@
+// Package atrest 加密工作目录中的解密结果
+//
+// 工作目录中的数据库使用与 SQLCipher 4 相同的页格式重新加密（AES-256-CBC，HMAC-SHA512，每页 80 字节保留区），
+// 页的位置与明文一一对应，增量解密时可以只重新加密变化的页。
+// 密钥由口令通过 PBKDF2-SHA512 派生，作为 SQLCipher 的原始密钥（raw key）使用，口令的盐与校验值保存在工作目录的标记文件中。
+package atrest
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/common"
+)
+
+const (
+	// EnvPassphrase 工作目录口令的环境变量
+	EnvPassphrase = "CHATLOG_WORK_DIR_PASSPHRASE"
+
+	// MarkerFile 加密工作目录的标记文件，记录口令的盐与校验值
+	MarkerFile = ".chatlog-encrypted"
+
+	markerVersion = 1
+	iterCount     = 256000
+	checkMessage  = "chatlog work dir"
+)
+
+// Prompt 未设置环境变量时用于输入口令，confirm 为 true 表示首次设置口令，需要再次确认
+// 为 nil 时（如 GUI、stdio MCP）只能通过环境变量提供口令
+var Prompt func(workDir string, confirm bool) (string, error)
+
+type marker struct {
+	Version    int    `json:"version"`
+	Iterations int    `json:"iterations"`
+	Salt       string `json:"salt"`
+	Check      string `json:"check"`
+}
+
+var (
+	keys   = make(map[string]*Key) // 工作目录绝对路径 + 标记文件中的盐 -> 密钥
+	keysMu sync.Mutex
+)
+
+// Enabled 工作目录已启用加密（存在标记文件）
+func Enabled(workDir string) bool {
+	_, err := os.Stat(filepath.Join(workDir, MarkerFile))
+	return err == nil
+}
+
+// Open 读取标记文件并校验口令，返回工作目录的密钥，未启用加密时返回 nil
+// 派生的密钥按工作目录缓存，进程内只需要输入一次口令
+func Open(workDir string) (*Key, error) {
+	return unlock(workDir, false)
+}
+
+// Init 为工作目录启用加密，已启用时与 Open 相同
+func Init(workDir string) (*Key, error) {
+	return unlock(workDir, true)
+}
+
+func unlock(workDir string, create bool) (*Key, error) {
+	abs, err := filepath.Abs(workDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// 并发打开数据库时只提示一次口令
+	keysMu.Lock()
+	defer keysMu.Unlock()
+
+	path := filepath.Join(abs, MarkerFile)
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.ReadFileFailed(path, err)
+	}
+	if os.IsNotExist(err) {
+		if !create {
+			return nil, nil
+		}
+		key, salt, err := initMarker(abs, path)
+		if err != nil {
+			return nil, err
+		}
+		keys[abs+salt] = key
+		return key, nil
+	}
+
+	m := &marker{}
+	if err := json.Unmarshal(b, m); err != nil || m.Version != markerVersion {
+		return nil, errors.ReadFileFailed(path, err)
+	}
+	// 以盐区分缓存，标记文件被删除或重新创建后需要重新输入口令
+	if key, ok := keys[abs+m.Salt]; ok {
+		return key, nil
+	}
+	salt, err := hex.DecodeString(m.Salt)
+	if err != nil {
+		return nil, errors.ReadFileFailed(path, err)
+	}
+	passphrase, err := passphrase(abs, false)
+	if err != nil {
+		return nil, err
+	}
+	key := deriveKey(passphrase, salt, m.Iterations)
+	if !hmac.Equal([]byte(key.check()), []byte(m.Check)) {
+		return nil, errors.ErrWorkDirIncorrectPassphrase
+	}
+	keys[abs+m.Salt] = key
+	return key, nil
+}
+
+// initMarker 设置口令并写入标记文件，返回密钥与十六进制编码的盐
+func initMarker(workDir, path string) (*Key, string, error) {
+	passphrase, err := passphrase(workDir, true)
+	if err != nil {
+		return nil, "", err
+	}
+	salt := make([]byte, common.SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, "", err
+	}
+	key := deriveKey(passphrase, salt, iterCount)
+
+	m := &marker{
+		Version:    markerVersion,
+		Iterations: iterCount,
+		Salt:       hex.EncodeToString(salt),
+		Check:      key.check(),
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, "", err
+	}
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return nil, "", err
+	}
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		return nil, "", errors.WriteOutputFailed(err)
+	}
+	return key, m.Salt, nil
+}
+
+func passphrase(workDir string, confirm bool) (string, error) {
+	if p := os.Getenv(EnvPassphrase); len(p) != 0 {
+		return p, nil
+	}
+	if Prompt == nil {
+		return "", errors.ErrWorkDirPassphraseRequired
+	}
+	p, err := Prompt(workDir, confirm)
+	if err != nil {
+		return "", err
+	}
+	if len(p) == 0 {
+		return "", errors.ErrWorkDirPassphraseRequired
+	}
+	return p, nil
+}
+
+// Key 工作目录的密钥，即 SQLCipher 的原始密钥
+type Key struct {
+	key []byte
+}
+
+func deriveKey(passphrase string, salt []byte, iter int) *Key {
+	return &Key{key: pbkdf2.Key([]byte(passphrase), salt, iter, common.KeySize, sha512.New)}
+}
+
+// check 口令校验值，不泄露密钥本身
+func (k *Key) check() string {
+	mac := hmac.New(sha256.New, k.key)
+	mac.Write([]byte(checkMessage))
+	return hex.EncodeToString(mac.Sum(nil))
+}
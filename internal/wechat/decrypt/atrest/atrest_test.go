@@ -0,0 +1,81 @@
+package atrest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	// VFS 需要链接 SQLite
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/sjzar/chatlog/internal/errors"
+)
+
+// testKey 使用较少的迭代次数派生密钥，避免测试过慢
+func testKey(passphrase string) *Key {
+	return deriveKey(passphrase, []byte("0123456789abcdef"), 2)
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		name       string
+		init       bool // 是否先使用口令 "right" 启用加密
+		passphrase string
+		wantNil    bool
+		wantErr    error
+	}{
+		{"not enabled", false, "right", true, nil},
+		{"right passphrase", true, "right", false, nil},
+		{"wrong passphrase", true, "wrong", true, errors.ErrWorkDirIncorrectPassphrase},
+		{"no passphrase", true, "", true, errors.ErrWorkDirPassphraseRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.init {
+				t.Setenv(EnvPassphrase, "right")
+				if _, err := Init(dir); err != nil {
+					t.Fatal(err)
+				}
+				if !Enabled(dir) {
+					t.Fatalf("Enabled() = false after Init")
+				}
+				// 清除缓存的密钥，重新校验口令
+				keysMu.Lock()
+				clear(keys)
+				keysMu.Unlock()
+			}
+
+			t.Setenv(EnvPassphrase, tt.passphrase)
+			key, err := Open(dir)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Open() error = %v, want %v", err, tt.wantErr)
+			}
+			if (key == nil) != tt.wantNil {
+				t.Errorf("Open() key = %v, want nil %v", key, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestOpenMarkerRecreated(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(EnvPassphrase, "first")
+	first, err := Init(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 标记文件重新创建后不使用缓存的密钥
+	if err := os.Remove(filepath.Join(dir, MarkerFile)); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvPassphrase, "second")
+	second, err := Init(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(first.key) == string(second.key) {
+		t.Errorf("Init() returned the cached key after the marker was recreated")
+	}
+}
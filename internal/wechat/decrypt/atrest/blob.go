@@ -0,0 +1,63 @@
+package atrest
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+
+	"github.com/sjzar/chatlog/internal/errors"
+)
+
+// blobMagic 加密的小文件（webhook 投递记录、TLS 私钥等）的前缀
+const blobMagic = "chatlog-sealed\x01"
+
+// IsSealedBlob data 是 Seal 加密的数据
+func IsSealedBlob(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(blobMagic))
+}
+
+// Seal 使用 AES-256-GCM 加密工作目录中数据库以外的数据，密钥由工作目录的密钥派生
+func (k *Key) Seal(plain []byte) ([]byte, error) {
+	aead, err := k.blobAEAD()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(blobMagic)+aead.NonceSize(), len(blobMagic)+aead.NonceSize()+len(plain)+aead.Overhead())
+	copy(out, blobMagic)
+	nonce := out[len(blobMagic):]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, nonce, plain, []byte(blobMagic)), nil
+}
+
+// Unseal 解密 Seal 加密的数据，口令不正确或数据被修改时返回 ErrWorkDirIncorrectPassphrase
+func (k *Key) Unseal(data []byte) ([]byte, error) {
+	aead, err := k.blobAEAD()
+	if err != nil {
+		return nil, err
+	}
+	if !IsSealedBlob(data) || len(data) < len(blobMagic)+aead.NonceSize() {
+		return nil, errors.ErrWorkDirIncorrectPassphrase
+	}
+	data = data[len(blobMagic):]
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(blobMagic))
+	if err != nil {
+		return nil, errors.ErrWorkDirIncorrectPassphrase
+	}
+	return plain, nil
+}
+
+// blobAEAD 与数据库页加密使用不同的子密钥
+func (k *Key) blobAEAD() (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, k.key)
+	mac.Write([]byte("chatlog blob"))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, errors.DecryptCreateCipherFailed(err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,47 @@
+package atrest
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/sjzar/chatlog/internal/errors"
+)
+
+func TestSeal(t *testing.T) {
+	key := testKey("right")
+	sealed, err := key.Seal([]byte(`{"hello":"world"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name    string
+		key     *Key
+		data    []byte
+		want    string
+		wantErr error
+	}{
+		{"round trip", key, sealed, `{"hello":"world"}`, nil},
+		{"wrong key", testKey("wrong"), sealed, "", errors.ErrWorkDirIncorrectPassphrase},
+		{"tampered", key, tampered, "", errors.ErrWorkDirIncorrectPassphrase},
+		{"not sealed", key, []byte(`{"hello":"world"}`), "", errors.ErrWorkDirIncorrectPassphrase},
+		{"truncated", key, sealed[:len(blobMagic)+4], "", errors.ErrWorkDirIncorrectPassphrase},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.key.Unseal(tt.data)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Unseal() error = %v, want %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Unseal() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if !IsSealedBlob(sealed) || IsSealedBlob([]byte("{}")) {
+		t.Errorf("IsSealedBlob() does not match Seal output")
+	}
+}
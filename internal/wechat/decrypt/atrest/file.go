@@ -0,0 +1,193 @@
+package atrest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/common"
+)
+
+const (
+	// PageSize 与 Reserve 与微信 v4 数据库相同，明文页可以原位重新加密
+	PageSize = 4096
+	Reserve  = common.IVSize + hmacSize
+
+	hmacSize = 64
+)
+
+// Cipher 单个加密文件的页面加解密器，HMAC 密钥由文件的盐派生
+type Cipher struct {
+	salt []byte
+	pc   *common.PageCipher
+}
+
+// NewCipher 返回使用盐 salt 的页面加解密器
+func (k *Key) NewCipher(salt []byte) *Cipher {
+	macKey := pbkdf2.Key(k.key, common.XorBytes(salt, 0x3a), 2, common.KeySize, sha512.New)
+	return &Cipher{
+		salt: salt,
+		pc: &common.PageCipher{
+			EncKey:   k.key,
+			MacKey:   macKey,
+			HashFunc: sha512.New,
+			HMACSize: hmacSize,
+			Reserve:  Reserve,
+			PageSize: PageSize,
+		},
+	}
+}
+
+// CipherOf 读取加密文件 path 的盐，返回对应的页面加解密器
+func (k *Key) CipherOf(path string) (*Cipher, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.OpenFileFailed(path, err)
+	}
+	defer f.Close()
+
+	salt := make([]byte, common.SaltSize)
+	if _, err := io.ReadFull(f, salt); err != nil {
+		return nil, errors.ReadFileFailed(path, err)
+	}
+	return k.NewCipher(salt), nil
+}
+
+// SealPage 加密第 n 页（从 0 开始）明文
+func (c *Cipher) SealPage(page []byte, n int64) ([]byte, error) {
+	return c.pc.EncryptPage(page, n, c.salt)
+}
+
+// Owns 加密文件 path 的第一页能通过当前密钥的 HMAC 校验，重新设置口令后旧文件不再属于当前密钥
+func (k *Key) Owns(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	page := make([]byte, PageSize)
+	if _, err := io.ReadFull(f, page); err != nil {
+		return false
+	}
+	return k.NewCipher(page[:common.SaltSize]).pc.VerifyPage(page, 0)
+}
+
+// IsSealed 文件不以 SQLite 文件头开头，即已加密
+func IsSealed(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, errors.OpenFileFailed(path, err)
+	}
+	defer f.Close()
+
+	header := make([]byte, len(common.SQLiteHeader))
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false, errors.ReadFileFailed(path, err)
+	}
+	return string(header) != common.SQLiteHeader, nil
+}
+
+// ReadFile 解密整个文件，返回明文数据库
+func (k *Key) ReadFile(path string) ([]byte, error) {
+	c, err := k.CipherOf(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.OpenFileFailed(path, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, errors.StatFileFailed(path, err)
+	}
+	var buf bytes.Buffer
+	buf.Grow(int(info.Size()))
+	if err := common.DecryptPages(context.Background(), f, info.Size()/PageSize, c.pc, &buf); err != nil {
+		if errors.Is(err, errors.ErrDecryptHashVerificationFailed) {
+			return nil, errors.ErrWorkDirIncorrectPassphrase
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Writer 将明文数据库逐页加密后写入，并记录每页加密前的指纹（见 common.PageFingerprint），用于生成增量解密的页清单
+type Writer struct {
+	name         string
+	w            io.Writer
+	c            *Cipher
+	buf          []byte
+	pages        int64
+	fingerprints []uint64
+}
+
+// NewWriter 使用新生成的盐加密写入 w，name 用于错误信息
+func (k *Key) NewWriter(w io.Writer, name string) (*Writer, error) {
+	salt := make([]byte, common.SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return &Writer{
+		name: name,
+		w:    w,
+		c:    k.NewCipher(salt),
+		buf:  make([]byte, 0, PageSize),
+	}, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		n := min(PageSize-len(w.buf), len(p))
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		if len(w.buf) < PageSize {
+			break
+		}
+
+		if w.pages == 0 && !supported(w.buf) {
+			return 0, errors.WorkDirUnsupportedDB(w.name)
+		}
+		page, err := w.c.SealPage(w.buf, w.pages)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := w.w.Write(page); err != nil {
+			return 0, errors.WriteOutputFailed(err)
+		}
+		w.fingerprints = append(w.fingerprints, common.PageFingerprint(w.buf, PageSize, Reserve))
+		w.pages++
+		w.buf = w.buf[:0]
+	}
+	return written, nil
+}
+
+// Close 检查是否写入了完整的页，不关闭底层的 io.Writer
+func (w *Writer) Close() error {
+	if len(w.buf) != 0 {
+		return errors.IncompleteRead(io.ErrUnexpectedEOF)
+	}
+	return nil
+}
+
+// Fingerprints 已写入的每一页加密前的指纹
+func (w *Writer) Fingerprints() []uint64 {
+	return w.fingerprints
+}
+
+// supported 页大小为 4096 且保留区不小于 Reserve 的数据库才能原位加密，否则加密会覆盖页面内容
+func supported(page1 []byte) bool {
+	return binary.BigEndian.Uint16(page1[16:18]) == PageSize && int(page1[20]) >= Reserve
+}
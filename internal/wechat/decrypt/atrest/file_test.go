@@ -0,0 +1,127 @@
+package atrest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/common"
+)
+
+// testPlain 生成 pages 页明文数据库，第一页带有 SQLite 文件头，第二页为全零页
+func testPlain(pages int) []byte {
+	data := make([]byte, pages*PageSize)
+	for n := 0; n < pages; n++ {
+		if n == 1 {
+			continue
+		}
+		rand.Read(data[n*PageSize : (n+1)*PageSize-Reserve])
+	}
+	copy(data, common.SQLiteHeader)
+	binary.BigEndian.PutUint16(data[16:18], PageSize)
+	data[20] = Reserve
+	return data
+}
+
+// writeSealed 使用 Writer 加密 plain 并写入文件
+func writeSealed(t *testing.T, key *Key, plain []byte) (string, *Writer) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "message_0.db")
+	var buf bytes.Buffer
+	w, err := key.NewWriter(&buf, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, w
+}
+
+func TestReadFile(t *testing.T) {
+	key := testKey("right")
+	plain := testPlain(3)
+	path, w := writeSealed(t, key, plain)
+
+	tests := []struct {
+		name     string
+		key      *Key
+		wantOwns bool
+		wantErr  error
+	}{
+		{"right key", key, true, nil},
+		{"wrong key", testKey("wrong"), false, errors.ErrWorkDirIncorrectPassphrase},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.key.Owns(path); got != tt.wantOwns {
+				t.Errorf("Owns() = %v, want %v", got, tt.wantOwns)
+			}
+			got, err := tt.key.ReadFile(path)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadFile() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			// 保留区被 IV 与 HMAC 覆盖，只比较页面内容
+			for n := 0; n < len(plain)/PageSize; n++ {
+				lo, hi := n*PageSize, (n+1)*PageSize-Reserve
+				if !bytes.Equal(got[lo:hi], plain[lo:hi]) {
+					t.Errorf("ReadFile() page %d differs from plaintext", n)
+				}
+			}
+		})
+	}
+
+	if sealed, err := IsSealed(path); err != nil || !sealed {
+		t.Errorf("IsSealed() = %v, %v, want true", sealed, err)
+	}
+	if fps := w.Fingerprints(); len(fps) != 3 || fps[1] != 0 {
+		t.Errorf("Fingerprints() = %v, want 3 pages with a zero page", fps)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	unsupported := testPlain(1)
+	unsupported[20] = 0
+
+	tests := []struct {
+		name    string
+		plain   []byte
+		wantErr bool
+	}{
+		{"full pages", testPlain(2), false},
+		{"partial page", testPlain(2)[:PageSize+100], true},
+		{"no reserve", unsupported, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := testKey("right").NewWriter(&bytes.Buffer{}, "message_0.db")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 分多次写入，跨越页边界
+			_, err = w.Write(tt.plain[:100])
+			if err == nil {
+				_, err = w.Write(tt.plain[100:])
+			}
+			if err == nil {
+				err = w.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Write() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package atrest
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/psanford/sqlite3vfs"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+)
+
+// vfsName 读取加密数据库的 SQLite VFS，按页解密，明文不落盘
+const vfsName = "chatlog-atrest"
+
+var (
+	registerOnce sync.Once
+	registerErr  error
+
+	vfsKeys sync.Map // 数据库绝对路径 -> *Key
+)
+
+// OpenDB 以只读方式打开工作目录中的加密数据库
+// SQLite 通过 VFS 按需读取并解密单个页面，不会将整个数据库载入内存，可以使用普通的连接池
+func (k *Key) OpenDB(path string) (*sql.DB, error) {
+	registerOnce.Do(func() {
+		registerErr = sqlite3vfs.RegisterVFS(vfsName, &vfs{})
+	})
+	if registerErr != nil {
+		return nil, errors.DBConnectFailed(path, registerErr)
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, errors.DBConnectFailed(path, err)
+	}
+	vfsKeys.Store(abs, k)
+
+	// 文件只会被整体替换或由增量解密原位修补，dbm 在文件变化后重新打开连接，因此按不可变文件读取，不加锁
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?vfs=%s&mode=ro&immutable=1", filepath.ToSlash(abs), vfsName))
+	if err != nil {
+		return nil, errors.DBConnectFailed(path, err)
+	}
+	return db, nil
+}
+
+// vfs 只读 VFS，主数据库按页解密，临时文件（排序、临时表）只保存在内存中
+type vfs struct{}
+
+func (v *vfs) Open(name string, flags sqlite3vfs.OpenFlag) (sqlite3vfs.File, sqlite3vfs.OpenFlag, error) {
+	if flags&sqlite3vfs.OpenMainDB == 0 {
+		if flags&(sqlite3vfs.OpenTempDB|sqlite3vfs.OpenTransientDB|sqlite3vfs.OpenTempJournal|sqlite3vfs.OpenSubJournal) != 0 {
+			return &memFile{}, flags, nil
+		}
+		return nil, 0, sqlite3vfs.CantOpenError
+	}
+
+	path := filepath.Clean(name)
+	v1, ok := vfsKeys.Load(path)
+	if !ok {
+		return nil, 0, sqlite3vfs.CantOpenError
+	}
+	c, err := v1.(*Key).CipherOf(path)
+	if err != nil {
+		log.Debug().Err(err).Msgf("open sealed db %s failed", path)
+		return nil, 0, sqlite3vfs.CantOpenError
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, sqlite3vfs.CantOpenError
+	}
+	return &sealedFile{f: f, c: c}, sqlite3vfs.OpenReadOnly, nil
+}
+
+func (v *vfs) Delete(name string, dirSync bool) error {
+	return sqlite3vfs.ReadOnlyError
+}
+
+func (v *vfs) Access(name string, flags sqlite3vfs.AccessFlag) (bool, error) {
+	if flags == sqlite3vfs.AccessReadWrite {
+		return false, nil
+	}
+	_, err := os.Stat(name)
+	return err == nil, nil
+}
+
+func (v *vfs) FullPathname(name string) string {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return name
+	}
+	return abs
+}
+
+// sealedFile 加密数据库文件，读取时按页校验 HMAC 并解密
+type sealedFile struct {
+	f *os.File
+	c *Cipher
+}
+
+func (s *sealedFile) ReadAt(p []byte, off int64) (int, error) {
+	page := make([]byte, PageSize)
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		pageNum := pos / PageSize
+		if _, err := s.f.ReadAt(page, pageNum*PageSize); err != nil {
+			if err == io.EOF {
+				return n, io.EOF
+			}
+			return n, sqlite3vfs.IOError
+		}
+		data, err := s.c.pc.DecryptPage(page, pageNum)
+		if err != nil {
+			log.Debug().Err(err).Msgf("decrypt page %d of %s failed", pageNum, s.f.Name())
+			return n, sqlite3vfs.CorruptError
+		}
+		if pageNum == 0 {
+			// 不提供共享内存，将文件头中的读写版本改回回滚日志模式，避免 SQLite 按 WAL 模式打开
+			data[18], data[19] = 1, 1
+		}
+		n += copy(p[n:], data[pos-pageNum*PageSize:])
+	}
+	return n, nil
+}
+
+func (s *sealedFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, sqlite3vfs.ReadOnlyError
+}
+
+func (s *sealedFile) Truncate(size int64) error {
+	return sqlite3vfs.ReadOnlyError
+}
+
+func (s *sealedFile) Sync(flag sqlite3vfs.SyncType) error {
+	return nil
+}
+
+func (s *sealedFile) FileSize() (int64, error) {
+	info, err := s.f.Stat()
+	if err != nil {
+		return 0, sqlite3vfs.IOError
+	}
+	return info.Size(), nil
+}
+
+func (s *sealedFile) Lock(elock sqlite3vfs.LockType) error {
+	return nil
+}
+
+func (s *sealedFile) Unlock(elock sqlite3vfs.LockType) error {
+	return nil
+}
+
+func (s *sealedFile) CheckReservedLock() (bool, error) {
+	return false, nil
+}
+
+func (s *sealedFile) SectorSize() int64 {
+	return PageSize
+}
+
+func (s *sealedFile) DeviceCharacteristics() sqlite3vfs.DeviceCharacteristic {
+	return sqlite3vfs.IocapImmutable
+}
+
+func (s *sealedFile) Close() error {
+	return s.f.Close()
+}
+
+// memFile 内存中的临时文件
+type memFile struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (m *memFile) ReadAt(p []byte, off int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *memFile) WriteAt(p []byte, off int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if end := off + int64(len(p)); end > int64(len(m.data)) {
+		m.data = append(m.data, make([]byte, end-int64(len(m.data)))...)
+	}
+	return copy(m.data[off:], p), nil
+}
+
+func (m *memFile) Truncate(size int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if size < int64(len(m.data)) {
+		m.data = m.data[:size]
+	}
+	return nil
+}
+
+func (m *memFile) FileSize() (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return int64(len(m.data)), nil
+}
+
+func (m *memFile) Sync(flag sqlite3vfs.SyncType) error                    { return nil }
+func (m *memFile) Lock(elock sqlite3vfs.LockType) error                   { return nil }
+func (m *memFile) Unlock(elock sqlite3vfs.LockType) error                 { return nil }
+func (m *memFile) CheckReservedLock() (bool, error)                       { return false, nil }
+func (m *memFile) SectorSize() int64                                      { return 0 }
+func (m *memFile) DeviceCharacteristics() sqlite3vfs.DeviceCharacteristic { return 0 }
+func (m *memFile) Close() error                                           { return nil }
@@ -5,6 +5,7 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/hmac"
+	"crypto/rand"
 	"encoding/binary"
 	"fmt"
 	"hash"
@@ -103,19 +104,7 @@ func DecryptPage(pageBuf []byte, encKey []byte, macKey []byte, pageNum int64, ha
 		offset = SaltSize
 	}
 
-	mac := hmac.New(hashFunc, macKey)
-	mac.Write(pageBuf[offset : pageSize-reserve+IVSize])
-
-	pageNoBytes := make([]byte, 4)
-	binary.LittleEndian.PutUint32(pageNoBytes, uint32(pageNum+1))
-	mac.Write(pageNoBytes)
-
-	hashMac := mac.Sum(nil)
-
-	hashMacStartOffset := pageSize - reserve + IVSize
-	hashMacEndOffset := hashMacStartOffset + hmacSize
-
-	if !bytes.Equal(hashMac, pageBuf[hashMacStartOffset:hashMacEndOffset]) {
+	if !verifyPageHMAC(pageBuf, macKey, pageNum, hashFunc, hmacSize, reserve, pageSize) {
 		return nil, errors.ErrDecryptHashVerificationFailed
 	}
 
@@ -136,3 +125,107 @@ func DecryptPage(pageBuf []byte, encKey []byte, macKey []byte, pageNum int64, ha
 
 	return decryptedPage, nil
 }
+
+// verifyPageHMAC 校验第 pageNum 页（从 0 开始）尾部保存的 HMAC
+func verifyPageHMAC(pageBuf []byte, macKey []byte, pageNum int64, hashFunc func() hash.Hash, hmacSize int, reserve int, pageSize int) bool {
+	offset := 0
+	if pageNum == 0 {
+		offset = SaltSize
+	}
+
+	mac := hmac.New(hashFunc, macKey)
+	mac.Write(pageBuf[offset : pageSize-reserve+IVSize])
+
+	pageNoBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(pageNoBytes, uint32(pageNum+1))
+	mac.Write(pageNoBytes)
+
+	hashMacStartOffset := pageSize - reserve + IVSize
+	hashMacEndOffset := hashMacStartOffset + hmacSize
+
+	return bytes.Equal(mac.Sum(nil), pageBuf[hashMacStartOffset:hashMacEndOffset])
+}
+
+// PageCipher 已派生密钥的页面解密器，用于按页解密（增量解密、WAL 帧）
+type PageCipher struct {
+	EncKey   []byte
+	MacKey   []byte
+	HashFunc func() hash.Hash
+	HMACSize int
+	Reserve  int
+	PageSize int
+}
+
+// DecryptPage 解密第 pageNum 页（从 0 开始），返回与完整解密结果相同的明文页
+// 第一页以 SQLite 文件头开头，全零页原样返回
+func (c *PageCipher) DecryptPage(pageBuf []byte, pageNum int64) ([]byte, error) {
+	if IsZeroPage(pageBuf) {
+		return make([]byte, c.PageSize), nil
+	}
+	data, err := DecryptPage(pageBuf, c.EncKey, c.MacKey, pageNum, c.HashFunc, c.HMACSize, c.Reserve, c.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	if pageNum == 0 {
+		data = append([]byte(SQLiteHeader), data...)
+	}
+	return data, nil
+}
+
+// VerifyPage 只校验第 pageNum 页的 HMAC，不解密，全零页视为有效
+func (c *PageCipher) VerifyPage(pageBuf []byte, pageNum int64) bool {
+	if IsZeroPage(pageBuf) {
+		return true
+	}
+	return verifyPageHMAC(pageBuf, c.MacKey, pageNum, c.HashFunc, c.HMACSize, c.Reserve, c.PageSize)
+}
+
+// EncryptPage 加密第 pageNum 页（从 0 开始），是 DecryptPage 的逆过程，用于重新加密解密结果
+// 明文页末尾的保留区被 IV 与 HMAC 覆盖，第一页的 SQLite 文件头被 salt 替换，全零页原样返回
+func (c *PageCipher) EncryptPage(pageBuf []byte, pageNum int64, salt []byte) ([]byte, error) {
+	encrypted := make([]byte, c.PageSize)
+	if IsZeroPage(pageBuf) {
+		return encrypted, nil
+	}
+
+	offset := 0
+	if pageNum == 0 {
+		offset = SaltSize
+		copy(encrypted, salt)
+	}
+
+	iv := encrypted[c.PageSize-c.Reserve : c.PageSize-c.Reserve+IVSize]
+	if _, err := rand.Read(iv); err != nil {
+		return nil, errors.DecryptCreateCipherFailed(err)
+	}
+	block, err := aes.NewCipher(c.EncKey)
+	if err != nil {
+		return nil, errors.DecryptCreateCipherFailed(err)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted[offset:c.PageSize-c.Reserve], pageBuf[offset:c.PageSize-c.Reserve])
+
+	mac := hmac.New(c.HashFunc, c.MacKey)
+	mac.Write(encrypted[offset : c.PageSize-c.Reserve+IVSize])
+	pageNoBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(pageNoBytes, uint32(pageNum+1))
+	mac.Write(pageNoBytes)
+	copy(encrypted[c.PageSize-c.Reserve+IVSize:], mac.Sum(nil))
+
+	return encrypted, nil
+}
+
+// PageFingerprint 页面指纹，取页尾保存的 HMAC 前 8 字节，全零页为 0
+// 解密后的页保留了原始的 IV 与 HMAC，因此加密页与对应的明文页指纹相同
+func PageFingerprint(pageBuf []byte, pageSize, reserve int) uint64 {
+	offset := pageSize - reserve + IVSize
+	return binary.LittleEndian.Uint64(pageBuf[offset : offset+8])
+}
+
+func IsZeroPage(pageBuf []byte) bool {
+	for _, b := range pageBuf {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,211 @@
+package common
+
+import (
+	"context"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/errors"
+)
+
+const (
+	// batchPages 每个任务包含的页数，减少 goroutine 间传递的次数
+	batchPages = 64
+
+	// progressInterval 进度回调的最小间隔
+	progressInterval = 200 * time.Millisecond
+)
+
+// Progress 单个数据库的解密进度
+type Progress struct {
+	Pages      int64
+	TotalPages int64
+	PageSize   int
+	Elapsed    time.Duration
+}
+
+// Throughput 解密速度，单位为字节/秒
+func (p Progress) Throughput() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Pages*int64(p.PageSize)) / p.Elapsed.Seconds()
+}
+
+type progressKey struct{}
+
+// WithProgress 返回携带进度回调的 context，Decrypt 解密时按间隔调用 fn，结束时再调用一次
+func WithProgress(ctx context.Context, fn func(Progress)) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+func progressFrom(ctx context.Context) func(Progress) {
+	fn, _ := ctx.Value(progressKey{}).(func(Progress))
+	return fn
+}
+
+// pageBatch 一组连续的页，读取、解密、写入复用同一个缓冲区
+type pageBatch struct {
+	first int64 // 第一页的页号
+	pages int
+	in    []byte
+	out   []byte
+	err   error
+}
+
+// DecryptPages 从 f 顺序读取最多 totalPages 页，并行解密后按顺序写入 output
+// 读取、解密、写入流水线执行，同时在途的页数有上限，内存占用与文件大小无关
+// 末尾不完整的页被忽略
+func DecryptPages(ctx context.Context, f *os.File, totalPages int64, c *PageCipher, output io.Writer) error {
+	workers := runtime.GOMAXPROCS(0)
+	if n := int((totalPages + batchPages - 1) / batchPages); n < workers {
+		workers = max(n, 1)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// free 中的缓冲区数量限制了在途的任务数
+	inflight := workers * 2
+	free := make(chan *pageBatch, inflight)
+	for i := 0; i < inflight; i++ {
+		free <- &pageBatch{
+			in:  make([]byte, batchPages*c.PageSize),
+			out: make([]byte, batchPages*c.PageSize),
+		}
+	}
+	jobs := make(chan *pageBatch, inflight)
+	results := make(chan *pageBatch, inflight)
+
+	var readErr error
+	go func() {
+		defer close(jobs)
+		readErr = readBatches(ctx, f, totalPages, c.PageSize, free, jobs)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range jobs {
+				b.err = c.decryptBatch(b)
+				select {
+				case results <- b:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	err := writeBatches(ctx, results, free, output, c.PageSize, totalPages, progressFrom(ctx))
+	if err != nil {
+		cancel()
+		// 等待读取与解密退出后再返回，避免调用方关闭文件时仍在读取
+		for range results {
+		}
+		return err
+	}
+	if readErr != nil {
+		return readErr
+	}
+	if ctx.Err() != nil {
+		return errors.ErrDecryptOperationCanceled
+	}
+	return nil
+}
+
+func readBatches(ctx context.Context, f *os.File, totalPages int64, pageSize int, free <-chan *pageBatch, jobs chan<- *pageBatch) error {
+	for page := int64(0); page < totalPages; {
+		var b *pageBatch
+		select {
+		case b = <-free:
+		case <-ctx.Done():
+			return nil
+		}
+
+		n := min(int64(batchPages), totalPages-page)
+		read, err := io.ReadFull(f, b.in[:n*int64(pageSize)])
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return errors.ReadFileFailed(f.Name(), err)
+		}
+		b.first, b.pages = page, read/pageSize
+		if b.pages == 0 {
+			return nil
+		}
+
+		select {
+		case jobs <- b:
+		case <-ctx.Done():
+			return nil
+		}
+		if err != nil {
+			return nil
+		}
+		page += int64(b.pages)
+	}
+	return nil
+}
+
+// decryptBatch 解密一组页
+func (c *PageCipher) decryptBatch(b *pageBatch) error {
+	for i := 0; i < b.pages; i++ {
+		lo, hi := i*c.PageSize, (i+1)*c.PageSize
+		data, err := c.DecryptPage(b.in[lo:hi], b.first+int64(i))
+		if err != nil {
+			return err
+		}
+		copy(b.out[lo:hi], data)
+	}
+	return nil
+}
+
+// writeBatches 按页号顺序写入解密结果，提前完成的任务暂存等待
+func writeBatches(ctx context.Context, results <-chan *pageBatch, free chan<- *pageBatch, output io.Writer, pageSize int, totalPages int64, onProgress func(Progress)) error {
+	start := time.Now()
+	last := start
+	report := func(pages, total int64) {
+		if onProgress != nil {
+			onProgress(Progress{Pages: pages, TotalPages: total, PageSize: pageSize, Elapsed: time.Since(start)})
+		}
+	}
+
+	pending := make(map[int64]*pageBatch)
+	next := int64(0)
+	for b := range results {
+		if b.err != nil {
+			return b.err
+		}
+		pending[b.first] = b
+		for {
+			b, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			if _, err := output.Write(b.out[:b.pages*pageSize]); err != nil {
+				return errors.WriteOutputFailed(err)
+			}
+			next += int64(b.pages)
+			free <- b
+		}
+
+		if time.Since(last) >= progressInterval {
+			last = time.Now()
+			report(next, totalPages)
+		}
+	}
+	if ctx.Err() == nil {
+		// 末尾不完整的页被忽略，以实际写入的页数作为总页数
+		report(next, next)
+	}
+	return nil
+}
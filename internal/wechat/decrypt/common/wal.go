@@ -0,0 +1,114 @@
+package common
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/sjzar/chatlog/internal/errors"
+)
+
+const (
+	WALHeaderSize      = 32
+	WALFrameHeaderSize = 24
+
+	walMagicLE = 0x377f0682
+	walMagicBE = 0x377f0683
+)
+
+// WAL 数据库 WAL 文件中已提交的帧，帧头不加密，页面内容与主数据库使用相同的方式加密
+type WAL struct {
+	// Pages 每个页号（从 0 开始）最后一次提交的加密页面
+	Pages map[int64][]byte
+	// DBPages 最后一次提交后数据库的页数，没有已提交的帧时为 0
+	DBPages int64
+}
+
+// ReadWAL 读取 WAL 文件中已提交的帧
+// 按 SQLite 的规则校验盐与累计校验和，遇到第一个无效的帧即停止，未提交的帧被忽略
+// WAL 文件不存在或为空时返回空的结果
+func ReadWAL(path string, pageSize int) (*WAL, error) {
+	wal := &WAL{Pages: make(map[int64][]byte)}
+
+	fp, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return wal, nil
+		}
+		return nil, errors.OpenFileFailed(path, err)
+	}
+	defer fp.Close()
+
+	header := make([]byte, WALHeaderSize)
+	if _, err := io.ReadFull(fp, header); err != nil {
+		// 空文件或只写入了部分文件头
+		return wal, nil
+	}
+
+	var order binary.ByteOrder
+	switch binary.BigEndian.Uint32(header[0:4]) {
+	case walMagicLE:
+		order = binary.LittleEndian
+	case walMagicBE:
+		order = binary.BigEndian
+	default:
+		return wal, nil
+	}
+	if int(binary.BigEndian.Uint32(header[8:12])) != pageSize {
+		return wal, nil
+	}
+	s0, s1 := walChecksum(order, header[:24], 0, 0)
+	if s0 != binary.BigEndian.Uint32(header[24:28]) || s1 != binary.BigEndian.Uint32(header[28:32]) {
+		return wal, nil
+	}
+	salt := header[16:24]
+
+	pending := make(map[int64][]byte)
+	frame := make([]byte, WALFrameHeaderSize+pageSize)
+	for {
+		if _, err := io.ReadFull(fp, frame); err != nil {
+			break
+		}
+		frameHeader, page := frame[:WALFrameHeaderSize], frame[WALFrameHeaderSize:]
+		if string(frameHeader[8:16]) != string(salt) {
+			break
+		}
+		s0, s1 = walChecksum(order, frameHeader[:8], s0, s1)
+		s0, s1 = walChecksum(order, page, s0, s1)
+		if s0 != binary.BigEndian.Uint32(frameHeader[16:20]) || s1 != binary.BigEndian.Uint32(frameHeader[20:24]) {
+			break
+		}
+
+		pgno := int64(binary.BigEndian.Uint32(frameHeader[0:4]))
+		if pgno == 0 {
+			break
+		}
+		pending[pgno-1] = append([]byte(nil), page...)
+
+		// 提交帧记录了提交后数据库的页数
+		if dbPages := binary.BigEndian.Uint32(frameHeader[4:8]); dbPages > 0 {
+			for n, p := range pending {
+				wal.Pages[n] = p
+			}
+			clear(pending)
+			wal.DBPages = int64(dbPages)
+		}
+	}
+
+	// 截断数据库后，超出页数的帧不再有效
+	for n := range wal.Pages {
+		if n >= wal.DBPages {
+			delete(wal.Pages, n)
+		}
+	}
+	return wal, nil
+}
+
+// walChecksum SQLite WAL 的累计校验和，按 WAL 文件头指定的字节序读取 32 位整数
+func walChecksum(order binary.ByteOrder, data []byte, s0, s1 uint32) (uint32, uint32) {
+	for i := 0; i+8 <= len(data); i += 8 {
+		s0 += order.Uint32(data[i:]) + s1
+		s1 += order.Uint32(data[i+4:]) + s0
+	}
+	return s0, s1
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 
 	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/common"
 	"github.com/sjzar/chatlog/internal/wechat/decrypt/darwin"
 	"github.com/sjzar/chatlog/internal/wechat/decrypt/windows"
 )
@@ -17,6 +18,9 @@ type Decryptor interface {
 	// Validate 验证密钥是否有效
 	Validate(page1 []byte, key []byte) bool
 
+	// NewPageCipher 使用数据库的盐派生密钥，返回按页解密的解密器
+	NewPageCipher(key []byte, salt []byte) *common.PageCipher
+
 	// GetPageSize 返回页面大小
 	GetPageSize() int
 
@@ -42,3 +46,21 @@ func NewDecryptor(platform string) (Decryptor, error) {
 		return nil, errors.PlatformUnsupported(platform)
 	}
 }
+
+// Progress 单个数据库的解密进度
+type Progress = common.Progress
+
+// WithProgress 返回携带进度回调的 context，Decrypt 解密过程中按间隔调用 fn 报告进度
+func WithProgress(ctx context.Context, fn func(Progress)) context.Context {
+	return common.WithProgress(ctx, fn)
+}
+
+// BatchProgress 批量解密多个数据库的进度
+type BatchProgress struct {
+	Files      int     `json:"files"`
+	TotalFiles int     `json:"totalFiles"`
+	Pages      int64   `json:"pages"`
+	TotalPages int64   `json:"totalPages"`
+	Elapsed    float64 `json:"elapsed"`    // 已用时间，单位为秒
+	Throughput float64 `json:"throughput"` // 解密速度，单位为字节/秒
+}
@@ -0,0 +1,356 @@
+package decrypt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/hex"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/common"
+)
+
+const (
+	manifestSuffix  = ".manifest"
+	manifestVersion = 1
+
+	// MaxPatchPages 变化的页超过该数量时改为完整解密，避免在内存中保存过多页面
+	MaxPatchPages = 16384
+)
+
+// Manifest 记录解密结果中每一页对应的加密页指纹，用于增量解密
+// 指纹取自加密页尾部的 HMAC，见 common.PageFingerprint
+type Manifest struct {
+	Version  int
+	Salt     []byte
+	PageSize int
+
+	// 生成清单时加密数据库文件的大小与修改时间，未变化时无需重新读取指纹
+	MainSize    int64
+	MainModTime int64
+	// Main 加密数据库文件中每一页的指纹
+	Main []uint64
+	// WAL 解密结果中来自 WAL 的页及其指纹，这些页与主数据库文件中的页不同
+	WAL map[int64]uint64
+	// Pages 解密结果的页数
+	Pages int64
+}
+
+// ManifestPath 返回解密结果对应的清单文件路径
+func ManifestPath(output string) string {
+	return output + manifestSuffix
+}
+
+// LoadManifest 读取清单，文件不存在时返回 nil
+func LoadManifest(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.OpenFileFailed(path, err)
+	}
+	defer f.Close()
+
+	m := &Manifest{}
+	if err := gob.NewDecoder(bufio.NewReader(f)).Decode(m); err != nil {
+		return nil, errors.ReadFileFailed(path, err)
+	}
+	if m.Version != manifestVersion {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// Save 先写入临时文件再替换，避免中断时留下不完整的清单
+func (m *Manifest) Save(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return errors.OpenFileFailed(tmp, err)
+	}
+	w := bufio.NewWriter(f)
+	if err := gob.NewEncoder(w).Encode(m); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return errors.WriteOutputFailed(err)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return errors.WriteOutputFailed(err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return errors.WriteOutputFailed(err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// fingerprint 解密结果中第 n 页的指纹，ok 为 false 表示清单中没有该页
+func (m *Manifest) fingerprint(n int64) (uint64, bool) {
+	if n >= m.Pages {
+		return 0, false
+	}
+	if fp, ok := m.WAL[n]; ok {
+		return fp, true
+	}
+	if n < int64(len(m.Main)) {
+		return m.Main[n], true
+	}
+	return 0, true
+}
+
+// NewManifest 根据完整解密的结果生成清单，info 为解密前加密数据库文件的状态
+// 解密结果保留了每页的 HMAC，main 为解密结果中每一页的指纹，见 ReadFingerprints
+func NewManifest(d Decryptor, dbfile string, info os.FileInfo, main []uint64) (*Manifest, error) {
+	page1, err := readPage(dbfile, d.GetPageSize(), 0)
+	if err != nil {
+		return nil, err
+	}
+	return &Manifest{
+		Version:     manifestVersion,
+		Salt:        slices.Clone(page1[:common.SaltSize]),
+		PageSize:    d.GetPageSize(),
+		MainSize:    info.Size(),
+		MainModTime: info.ModTime().UnixNano(),
+		Main:        main,
+		Pages:       int64(len(main)),
+	}, nil
+}
+
+// PageSealer 加密写入解密结果的页，工作目录启用加密时使用，见 atrest.Cipher
+type PageSealer interface {
+	SealPage(page []byte, n int64) ([]byte, error)
+}
+
+// Patch 增量解密的结果，Apply 将变化的页写入解密结果
+type Patch struct {
+	// Pages 需要写入的明文页，页号从 0 开始
+	Pages    map[int64][]byte
+	Manifest *Manifest
+
+	pageSize int
+	resize   bool
+}
+
+// Empty 解密结果无需修改
+func (p *Patch) Empty() bool {
+	return len(p.Pages) == 0 && !p.resize
+}
+
+// PlanPatch 对比加密数据库（包括 WAL 中已提交的帧）与清单，解密发生变化的页
+// 清单不存在、盐或页大小变化、页数减少（如 VACUUM）或变化的页过多时返回 errors.ErrDecryptFullRequired
+// 数据库增长时新增的页按变化的页处理
+func PlanPatch(ctx context.Context, d Decryptor, dbfile string, hexKey string, base *Manifest) (*Patch, error) {
+	if base == nil {
+		return nil, errors.ErrDecryptFullRequired
+	}
+	key, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return nil, errors.DecodeKeyFailed(err)
+	}
+	pageSize, reserve := d.GetPageSize(), d.GetReserve()
+
+	info, err := os.Stat(dbfile)
+	if err != nil {
+		return nil, errors.StatFileFailed(dbfile, err)
+	}
+	page1, err := readPage(dbfile, pageSize, 0)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(page1, []byte(common.SQLiteHeader)) {
+		return nil, errors.ErrAlreadyDecrypted
+	}
+	if base.PageSize != pageSize || !bytes.Equal(base.Salt, page1[:common.SaltSize]) {
+		return nil, errors.ErrDecryptFullRequired
+	}
+	if !d.Validate(page1, key) {
+		return nil, errors.ErrDecryptIncorrectKey
+	}
+
+	// 主数据库文件未变化时（新消息通常只写入 WAL）沿用清单中的指纹
+	main := base.Main
+	if info.Size() != base.MainSize || info.ModTime().UnixNano() != base.MainModTime {
+		if main, err = ReadFingerprints(dbfile, pageSize, reserve); err != nil {
+			return nil, err
+		}
+	}
+
+	wal, err := common.ReadWAL(dbfile+"-wal", pageSize)
+	if err != nil {
+		return nil, err
+	}
+	pages := int64(len(main))
+	if wal.DBPages > 0 {
+		pages = wal.DBPages
+	}
+	if pages < base.Pages {
+		return nil, errors.ErrDecryptFullRequired
+	}
+
+	next := &Manifest{
+		Version:     manifestVersion,
+		Salt:        base.Salt,
+		PageSize:    pageSize,
+		MainSize:    info.Size(),
+		MainModTime: info.ModTime().UnixNano(),
+		Main:        main,
+		WAL:         make(map[int64]uint64, len(wal.Pages)),
+		Pages:       pages,
+	}
+	for n, page := range wal.Pages {
+		next.WAL[n] = common.PageFingerprint(page, pageSize, reserve)
+	}
+
+	// 找出变化的页，来自 WAL 的页直接使用帧中的内容，其余页从主数据库文件读取
+	var changed []int64
+	for n := int64(0); n < pages; n++ {
+		want, _ := next.fingerprint(n)
+		if have, ok := base.fingerprint(n); !ok || have != want {
+			changed = append(changed, n)
+		}
+	}
+	if len(changed) > MaxPatchPages {
+		return nil, errors.ErrDecryptFullRequired
+	}
+
+	patch := &Patch{
+		Pages:    make(map[int64][]byte, len(changed)),
+		Manifest: next,
+		pageSize: pageSize,
+		resize:   pages != base.Pages,
+	}
+	if len(changed) == 0 {
+		return patch, nil
+	}
+
+	cipher := d.NewPageCipher(key, page1[:common.SaltSize])
+	f, err := os.Open(dbfile)
+	if err != nil {
+		return nil, errors.OpenFileFailed(dbfile, err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, pageSize)
+	for _, n := range changed {
+		select {
+		case <-ctx.Done():
+			return nil, errors.ErrDecryptOperationCanceled
+		default:
+		}
+
+		page, ok := wal.Pages[n]
+		if !ok {
+			if n >= int64(len(main)) {
+				// 已分配但尚未写入的页
+				patch.Pages[n] = make([]byte, pageSize)
+				continue
+			}
+			if _, err := f.ReadAt(buf, n*int64(pageSize)); err != nil {
+				return nil, errors.ReadFileFailed(dbfile, err)
+			}
+			page = buf
+		}
+		data, err := cipher.DecryptPage(page, n)
+		if err != nil {
+			return nil, err
+		}
+		patch.Pages[n] = data
+	}
+	return patch, nil
+}
+
+// Apply 将变化的页写入解密结果 path，并调整文件大小，sealer 不为 nil 时先加密再写入
+func (p *Patch) Apply(path string, sealer PageSealer) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return errors.OpenFileFailed(path, err)
+	}
+
+	keys := make([]int64, 0, len(p.Pages))
+	for n := range p.Pages {
+		keys = append(keys, n)
+	}
+	slices.Sort(keys)
+	for _, n := range keys {
+		page := p.Pages[n]
+		if sealer != nil {
+			if page, err = sealer.SealPage(page, n); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		if _, err := f.WriteAt(page, n*int64(p.pageSize)); err != nil {
+			f.Close()
+			return errors.WriteOutputFailed(err)
+		}
+	}
+	if err := f.Truncate(p.Manifest.Pages * int64(p.pageSize)); err != nil {
+		f.Close()
+		return errors.WriteOutputFailed(err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return errors.WriteOutputFailed(err)
+	}
+	return f.Close()
+}
+
+// CheckOutput 检查解密结果的大小是否与清单一致，不一致说明文件被替换或修改过
+func (m *Manifest) CheckOutput(output string) error {
+	info, err := os.Stat(output)
+	if err != nil {
+		return errors.ErrDecryptFullRequired
+	}
+	if info.Size() != m.Pages*int64(m.PageSize) {
+		return errors.ErrDecryptFullRequired
+	}
+	return nil
+}
+
+func readPage(path string, pageSize int, n int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.OpenFileFailed(path, err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, pageSize)
+	if _, err := f.ReadAt(buf, n*int64(pageSize)); err != nil {
+		return nil, errors.ReadFileFailed(path, err)
+	}
+	return buf, nil
+}
+
+// ReadFingerprints 顺序读取文件中每个完整页的指纹，末尾不完整的页被忽略（与完整解密一致）
+func ReadFingerprints(path string, pageSize, reserve int) ([]uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.OpenFileFailed(path, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, errors.StatFileFailed(path, err)
+	}
+	ret := make([]uint64, 0, info.Size()/int64(pageSize))
+	r := bufio.NewReaderSize(f, 256*pageSize)
+	buf := make([]byte, pageSize)
+	for {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return nil, errors.ReadFileFailed(path, err)
+		}
+		ret = append(ret, common.PageFingerprint(buf, pageSize, reserve))
+	}
+	return ret, nil
+}
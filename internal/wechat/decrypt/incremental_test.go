@@ -0,0 +1,247 @@
+package decrypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	// atrest 的 VFS 需要链接 SQLite
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/atrest"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/common"
+)
+
+// testDB 使用 Windows v4 格式加密的测试数据库，明文页内容随机
+type testDB struct {
+	t     *testing.T
+	d     Decryptor
+	path  string
+	key   string
+	salt  []byte
+	pc    *common.PageCipher
+	pages [][]byte
+	// encrypted 已加密的页，为 nil 的页在 write 时重新加密
+	encrypted [][]byte
+}
+
+func newTestDB(t *testing.T, pages int) *testDB {
+	t.Helper()
+	d, err := NewDecryptor("windows")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := make([]byte, common.KeySize)
+	salt := make([]byte, common.SaltSize)
+	rand.Read(key)
+	rand.Read(salt)
+	db := &testDB{
+		t:    t,
+		d:    d,
+		path: filepath.Join(t.TempDir(), "message_0.db"),
+		key:  hex.EncodeToString(key),
+		salt: salt,
+		pc:   d.NewPageCipher(key, salt),
+	}
+	for range pages {
+		db.set(len(db.pages), db.newPage(len(db.pages)))
+	}
+	db.write()
+	return db
+}
+
+// newPage 生成第 n 页的明文，第一页带有 SQLite 文件头，页大小与保留区满足 atrest.Writer 的要求
+func (db *testDB) newPage(n int) []byte {
+	page := make([]byte, db.d.GetPageSize())
+	rand.Read(page[:len(page)-db.d.GetReserve()])
+	if n == 0 {
+		copy(page, common.SQLiteHeader)
+		binary.BigEndian.PutUint16(page[16:18], uint16(db.d.GetPageSize()))
+		page[20] = byte(db.d.GetReserve())
+	}
+	return page
+}
+
+// set 替换或追加第 n 页的明文
+func (db *testDB) set(n int, page []byte) {
+	if n == len(db.pages) {
+		db.pages = append(db.pages, nil)
+		db.encrypted = append(db.encrypted, nil)
+	}
+	db.pages[n], db.encrypted[n] = page, nil
+}
+
+// write 加密变化的页并写入数据库文件，未变化的页保持原有的 IV 与 HMAC，修改时间前移以便 PlanPatch 发现变化
+func (db *testDB) write() {
+	var buf bytes.Buffer
+	for n, page := range db.pages {
+		if db.encrypted[n] == nil {
+			encrypted, err := db.pc.EncryptPage(page, int64(n), db.salt)
+			if err != nil {
+				db.t.Fatal(err)
+			}
+			db.encrypted[n] = encrypted
+		}
+		buf.Write(db.encrypted[n])
+	}
+	if err := os.WriteFile(db.path, buf.Bytes(), 0644); err != nil {
+		db.t.Fatal(err)
+	}
+	mtime := time.Now().Add(time.Duration(len(db.pages)) * time.Second)
+	if err := os.Chtimes(db.path, mtime, mtime); err != nil {
+		db.t.Fatal(err)
+	}
+}
+
+// decrypt 完整解密，返回解密结果与清单
+func (db *testDB) decrypt() ([]byte, *Manifest) {
+	var buf bytes.Buffer
+	if err := db.d.Decrypt(context.Background(), db.path, db.key, &buf); err != nil {
+		db.t.Fatal(err)
+	}
+	output := filepath.Join(db.t.TempDir(), "full.db")
+	if err := os.WriteFile(output, buf.Bytes(), 0644); err != nil {
+		db.t.Fatal(err)
+	}
+	main, err := ReadFingerprints(output, db.d.GetPageSize(), db.d.GetReserve())
+	if err != nil {
+		db.t.Fatal(err)
+	}
+	info, err := os.Stat(db.path)
+	if err != nil {
+		db.t.Fatal(err)
+	}
+	m, err := NewManifest(db.d, db.path, info, main)
+	if err != nil {
+		db.t.Fatal(err)
+	}
+	return buf.Bytes(), m
+}
+
+func TestPlanPatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		sealed    bool // 解密结果使用 atrest 加密
+		change    func(db *testDB)
+		wantPages int // 需要写入的页数
+		wantErr   error
+	}{
+		{"unchanged", false, func(db *testDB) {}, 0, nil},
+		{"page changed", false, func(db *testDB) { db.set(2, db.newPage(2)) }, 1, nil},
+		{"page changed sealed", true, func(db *testDB) { db.set(2, db.newPage(2)) }, 1, nil},
+		{"grown", false, func(db *testDB) {
+			db.set(1, db.newPage(1))
+			db.set(4, db.newPage(4))
+			db.set(5, db.newPage(5))
+		}, 3, nil},
+		{"grown sealed", true, func(db *testDB) {
+			db.set(1, db.newPage(1))
+			db.set(4, db.newPage(4))
+		}, 2, nil},
+		{"shrunk", false, func(db *testDB) {
+			db.pages, db.encrypted = db.pages[:3], db.encrypted[:3]
+		}, 0, errors.ErrDecryptFullRequired},
+		{"salt changed", false, func(db *testDB) {
+			rand.Read(db.salt)
+			key, _ := hex.DecodeString(db.key)
+			db.pc = db.d.NewPageCipher(key, db.salt)
+			clear(db.encrypted)
+		}, 0, errors.ErrDecryptFullRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, 4)
+			plain, base := db.decrypt()
+
+			// 解密结果，工作目录启用加密时使用 atrest.Writer 写入
+			output := filepath.Join(t.TempDir(), "message_0.db")
+			var key *atrest.Key
+			if tt.sealed {
+				t.Setenv(atrest.EnvPassphrase, "passphrase")
+				var err error
+				if key, err = atrest.Init(t.TempDir()); err != nil {
+					t.Fatal(err)
+				}
+				var buf bytes.Buffer
+				w, err := key.NewWriter(&buf, output)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := w.Write(plain); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+				plain = buf.Bytes()
+			}
+			if err := os.WriteFile(output, plain, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			tt.change(db)
+			db.write()
+			patch, err := PlanPatch(context.Background(), db.d, db.path, db.key, base)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("PlanPatch() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(patch.Pages) != tt.wantPages {
+				t.Errorf("PlanPatch() changed pages = %d, want %d", len(patch.Pages), tt.wantPages)
+			}
+
+			var sealer PageSealer
+			if tt.sealed {
+				c, err := key.CipherOf(output)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sealer = c
+			}
+			if err := patch.Apply(output, sealer); err != nil {
+				t.Fatal(err)
+			}
+
+			// 增量更新后的结果与重新完整解密的结果相同
+			var got []byte
+			if tt.sealed {
+				got, err = key.ReadFile(output)
+			} else {
+				got, err = os.ReadFile(output)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, manifest := db.decrypt()
+			if tt.sealed {
+				// 重新加密后保留区中的 IV 与 HMAC 与原数据库不同
+				got, want = stripReserve(got, db.d), stripReserve(want, db.d)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("patched output differs from full decrypt")
+			}
+			if patch.Manifest.Pages != manifest.Pages {
+				t.Errorf("manifest pages = %d, want %d", patch.Manifest.Pages, manifest.Pages)
+			}
+		})
+	}
+}
+
+// stripReserve 去掉每页的保留区
+func stripReserve(data []byte, d Decryptor) []byte {
+	pageSize, reserve := d.GetPageSize(), d.GetReserve()
+	ret := make([]byte, 0, len(data))
+	for off := 0; off+pageSize <= len(data); off += pageSize {
+		ret = append(ret, data[off:off+pageSize-reserve]...)
+	}
+	return ret
+}
@@ -21,8 +21,13 @@ func NewValidator(platform string, dataDir string) (*Validator, error) {
 }
 
 func NewValidatorWithFile(platform string, dataDir string) (*Validator, error) {
-	dbFile := GetSimpleDBFile(platform)
-	dbPath := filepath.Join(dataDir, dbFile)
+	dbPath := filepath.Join(dataDir, GetSimpleDBFile(platform))
+	return NewDBValidator(platform, dbPath, dataDir)
+}
+
+// NewDBValidator 使用指定的加密数据库校验数据密钥，imgDir 不为空时使用其中的 .dat 文件校验图片密钥
+// 用于离线搜索内存转储文件，数据库与图片可以从其他机器复制
+func NewDBValidator(platform string, dbPath string, imgDir string) (*Validator, error) {
 	decryptor, err := NewDecryptor(platform)
 	if err != nil {
 		return nil, err
@@ -39,11 +44,18 @@ func NewValidatorWithFile(platform string, dataDir string) (*Validator, error) {
 		dbFile:    d,
 	}
 
-	validator.imgKeyValidator = dat2img.NewImgKeyValidator(dataDir)
+	if len(imgDir) != 0 {
+		validator.imgKeyValidator = dat2img.NewImgKeyValidator(imgDir)
+	}
 
 	return validator, nil
 }
 
+// HasImgKeyValidator 找到了用于校验图片密钥的 .dat 文件
+func (v *Validator) HasImgKeyValidator() bool {
+	return v.imgKeyValidator != nil
+}
+
 func (v *Validator) Validate(key []byte) bool {
 	return v.decryptor.Validate(v.dbFile.FirstPage, key)
 }
@@ -0,0 +1,74 @@
+package decrypt
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"io"
+	"os"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/common"
+)
+
+// MaxCorruptPages 校验结果中最多列出的损坏页数，超出的只计数
+const MaxCorruptPages = 1000
+
+// PageReport 加密数据库逐页校验的结果
+type PageReport struct {
+	Pages        int64
+	CorruptCount int64
+	// Corrupt HMAC 校验失败的页号（从 0 开始），最多 MaxCorruptPages 个
+	Corrupt []int64
+}
+
+// VerifyPages 校验密钥与加密数据库每一页的 HMAC，不解密页面内容
+// 密钥错误时返回 errors.ErrDecryptIncorrectKey，文件未加密时返回 errors.ErrAlreadyDecrypted
+func VerifyPages(ctx context.Context, d Decryptor, dbfile string, hexKey string) (*PageReport, error) {
+	key, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return nil, errors.DecodeKeyFailed(err)
+	}
+
+	dbInfo, err := common.OpenDBFile(dbfile, d.GetPageSize())
+	if err != nil {
+		return nil, err
+	}
+	if !d.Validate(dbInfo.FirstPage, key) {
+		return nil, errors.ErrDecryptIncorrectKey
+	}
+	cipher := d.NewPageCipher(key, dbInfo.Salt)
+
+	f, err := os.Open(dbfile)
+	if err != nil {
+		return nil, errors.OpenFileFailed(dbfile, err)
+	}
+	defer f.Close()
+
+	report := &PageReport{}
+	r := bufio.NewReaderSize(f, 256*d.GetPageSize())
+	buf := make([]byte, d.GetPageSize())
+	for n := int64(0); ; n++ {
+		select {
+		case <-ctx.Done():
+			return nil, errors.ErrDecryptOperationCanceled
+		default:
+		}
+
+		if _, err := io.ReadFull(r, buf); err != nil {
+			// 末尾不完整的页与解密时一样被忽略
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return nil, errors.ReadFileFailed(dbfile, err)
+		}
+		report.Pages++
+		if !cipher.VerifyPage(buf, n) {
+			report.CorruptCount++
+			if len(report.Corrupt) < MaxCorruptPages {
+				report.Corrupt = append(report.Corrupt, n)
+			}
+		}
+	}
+	return report, nil
+}
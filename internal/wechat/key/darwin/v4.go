@@ -38,12 +38,10 @@ var V4ImgKeyPatterns = []KeyPatternInfo{
 }
 
 type V4Extractor struct {
-	validator         *decrypt.Validator
-	progress          func(string)
-	dataKeyPatterns   []KeyPatternInfo
-	imgKeyPatterns    []KeyPatternInfo
-	processedDataKeys sync.Map // Thread-safe map for processed data keys
-	processedImgKeys  sync.Map // Thread-safe map for processed image keys
+	validator       *decrypt.Validator
+	progress        func(string)
+	dataKeyPatterns []KeyPatternInfo
+	imgKeyPatterns  []KeyPatternInfo
 }
 
 func NewV4Extractor() *V4Extractor {
@@ -216,7 +214,10 @@ func (e *V4Extractor) worker(ctx context.Context, memoryChannel <-chan []byte, r
 	}
 }
 
+// SearchKey 在一块内存中搜索数据库密钥
+// 已校验过的候选密钥只在本次搜索中去重，避免在搜索整个进程或内存转储的过程中无限增长
 func (e *V4Extractor) SearchKey(ctx context.Context, memory []byte) (string, bool) {
+	processed := make(map[string]struct{})
 	for _, keyPattern := range e.dataKeyPatterns {
 		index := len(memory)
 		zeroPattern := bytes.Equal(keyPattern.Pattern, []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
@@ -261,10 +262,11 @@ func (e *V4Extractor) SearchKey(ctx context.Context, memory []byte) (string, boo
 				keyData := memory[keyOffset : keyOffset+32]
 				keyHex := hex.EncodeToString(keyData)
 
-				// Skip if we've already processed this key (thread-safe check)
-				if _, loaded := e.processedDataKeys.LoadOrStore(keyHex, true); loaded {
+				// Skip if we've already processed this key
+				if _, ok := processed[keyHex]; ok {
 					continue
 				}
+				processed[keyHex] = struct{}{}
 
 				// Validate key against database header
 				if e.validator.Validate(keyData) {
@@ -287,7 +289,9 @@ func (e *V4Extractor) SearchKey(ctx context.Context, memory []byte) (string, boo
 	return "", false
 }
 
+// SearchImgKey 在一块内存中搜索图片密钥，候选密钥的去重范围与 SearchKey 相同
 func (e *V4Extractor) SearchImgKey(ctx context.Context, memory []byte) (string, bool) {
+	processed := make(map[string]struct{})
 
 	for _, keyPattern := range e.imgKeyPatterns {
 		index := len(memory)
@@ -332,10 +336,11 @@ func (e *V4Extractor) SearchImgKey(ctx context.Context, memory []byte) (string,
 				keyData := memory[keyOffset : keyOffset+16]
 				keyHex := hex.EncodeToString(keyData)
 
-				// Skip if we've already processed this key (thread-safe check)
-				if _, loaded := e.processedImgKeys.LoadOrStore(keyHex, true); loaded {
+				// Skip if we've already processed this key
+				if _, ok := processed[keyHex]; ok {
 					continue
 				}
+				processed[keyHex] = struct{}{}
 
 				// Validate key using image key validator
 				if e.validator.ValidateImgKey(keyData) {
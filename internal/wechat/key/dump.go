@@ -0,0 +1,174 @@
+package key
+
+import (
+	"context"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt"
+)
+
+const (
+	// dumpChunkSize 每次读取的内存转储大小
+	dumpChunkSize = 16 << 20
+
+	// dumpOverlap 相邻分块重叠的字节数，避免特征与密钥被分块边界截断
+	dumpOverlap = 4096
+
+	maxDumpWorkers = 8
+)
+
+// DumpProgress 搜索内存转储文件的进度
+type DumpProgress struct {
+	Scanned int64
+	Total   int64
+}
+
+// imgKeySearcher 支持搜索图片密钥的提取器
+type imgKeySearcher interface {
+	SearchImgKey(ctx context.Context, memory []byte) (string, bool)
+}
+
+// SearchDump 分块读取内存转储文件 path，离线搜索数据库密钥，needImgKey 为 true 时同时搜索图片密钥
+// validator 使用复制出来的加密数据库（以及 .dat 文件）校验候选密钥，见 decrypt.NewDBValidator
+// 未找到数据库密钥时返回 errors.ErrNoValidKey，图片密钥未找到时为空
+// 目前只有 macOS 的密钥特征经过验证，其他平台返回 errors.PlatformUnsupported
+func SearchDump(ctx context.Context, platform string, path string, validator *decrypt.Validator, needImgKey bool, onProgress func(DumpProgress)) (string, string, error) {
+	if platform != "darwin" {
+		return "", "", errors.PlatformUnsupported(platform)
+	}
+	if validator == nil {
+		return "", "", errors.ErrValidatorNotSet
+	}
+	extractor, err := NewExtractor(platform)
+	if err != nil {
+		return "", "", err
+	}
+	extractor.SetValidate(validator)
+	imgSearcher, ok := extractor.(imgKeySearcher)
+	needImgKey = needImgKey && ok && validator.HasImgKeyValidator()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", errors.OpenFileFailed(path, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", "", errors.StatFileFailed(path, err)
+	}
+
+	searchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu      sync.Mutex
+		dataKey string
+		imgKey  string
+		scanned atomic.Int64
+		readErr error
+	)
+	// found 记录找到的密钥，全部找到后停止读取
+	found := func(data, img string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(data) != 0 && len(dataKey) == 0 {
+			dataKey = data
+		}
+		if len(img) != 0 && len(imgKey) == 0 {
+			imgKey = img
+		}
+		if len(dataKey) != 0 && (!needImgKey || len(imgKey) != 0) {
+			cancel()
+		}
+	}
+	pending := func() (bool, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(dataKey) == 0, needImgKey && len(imgKey) == 0
+	}
+
+	workers := min(runtime.NumCPU(), maxDumpWorkers)
+	// dumpChunk 一块内存转储，data 的开头包含上一块末尾的 dumpOverlap 字节，n 为新读取的字节数
+	type dumpChunk struct {
+		data []byte
+		n    int
+	}
+	chunks := make(chan dumpChunk, workers)
+	go func() {
+		defer close(chunks)
+		var tail []byte
+		for {
+			buf := make([]byte, len(tail)+dumpChunkSize)
+			copy(buf, tail)
+			n, err := io.ReadFull(f, buf[len(tail):])
+			if n > 0 {
+				chunk := buf[:len(tail)+n]
+				select {
+				case chunks <- dumpChunk{data: chunk, n: n}:
+				case <-searchCtx.Done():
+					return
+				}
+				tail = chunk[max(0, len(chunk)-dumpOverlap):]
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return
+			}
+			if err != nil {
+				mu.Lock()
+				readErr = errors.ReadFileFailed(path, err)
+				mu.Unlock()
+				cancel()
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range chunks {
+				needData, needImg := pending()
+				if needData {
+					if key, ok := extractor.SearchKey(searchCtx, chunk.data); ok {
+						found(key, "")
+					}
+				}
+				if needImg {
+					if key, ok := imgSearcher.SearchImgKey(searchCtx, chunk.data); ok {
+						found("", key)
+					}
+				}
+				if onProgress != nil {
+					// 串行回调，保证进度单调递增
+					mu.Lock()
+					onProgress(DumpProgress{
+						Scanned: scanned.Add(int64(chunk.n)),
+						Total:   info.Size(),
+					})
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dataKey) == 0 {
+		if readErr != nil {
+			return "", "", readErr
+		}
+		if ctx.Err() != nil {
+			return "", "", ctx.Err()
+		}
+		return "", imgKey, errors.ErrNoValidKey
+	}
+	return dataKey, imgKey, nil
+}
@@ -14,8 +14,20 @@ import (
 type DataSource interface {
 
 	// 消息
-	GetMessages(ctx context.Context, startTime, endTime time.Time, speakerto string, talker string, sender string, keyword string, limit, offset int) ([]*model.Message, error)
-	GetMessagesCount(ctx context.Context, startTime, endTime time.Time, speakerto string, talker string, sender string, keyword string) (int, error)
+	// cursor 不为空时从游标之后开始读取（keyset 分页），filter 为 nil 时不做额外过滤
+	GetMessages(ctx context.Context, startTime, endTime time.Time, speakerto string, talker string, sender string, keyword string, filter *model.MessageFilter, cursor *model.MessageCursor, limit, offset int) ([]*model.Message, error)
+	StreamMessages(ctx context.Context, startTime, endTime time.Time, speakerto string, talker string, sender string, keyword string, filter *model.MessageFilter, cursor *model.MessageCursor, limit, offset int, fn func(*model.Message) error) error
+	// 消息数量，只按时间、talker 与 sender 统计；按关键词过滤需要逐条匹配内容，不提供数量
+	GetMessagesCount(ctx context.Context, startTime, endTime time.Time, speakerto string, talker string, sender string) (int, error)
+
+	// 单个会话中 seq 前后的消息（包括 seq 本身），按 seq 升序
+	GetMessageContext(ctx context.Context, speakerto string, talker string, seq int64, before, after int) ([]*model.Message, error)
+
+	// 单个会话在时间范围内的消息统计，通过 SQL 聚合计算
+	GetMessageStats(ctx context.Context, startTime, endTime time.Time, speakerto string, talker string) (*model.MessageStats, error)
+
+	// 跨会话搜索消息，返回按命中次数与时间排序的命中结果，truncated 表示达到搜索上限提前结束
+	SearchMessages(ctx context.Context, startTime, endTime time.Time, speakerto string, keyword string, sender string, filter *model.MessageFilter) (hits []*model.SearchHit, truncated bool, err error)
 
 	// 联系人
 	GetContacts(ctx context.Context, key string, limit, offset int) ([]*model.Contact, error)
@@ -54,12 +66,28 @@ type DataSource interface {
 	Close() error
 }
 
-func New(path string, platform string) (DataSource, error) {
+// Options 数据源选项
+type Options struct {
+	// NoIndex 不建立消息全文索引，用于导出等一次性读取的命令，关键词查询回退为全表扫描
+	NoIndex bool
+}
+
+func New(path string, platform string, opts Options) (DataSource, error) {
 	switch platform {
 	case "windows":
-		return v4.New(path)
+		return v4.New(path, !opts.NoIndex)
 	case "darwin":
-		return v4.New(path)
+		return v4.New(path, !opts.NoIndex)
+	default:
+		return nil, errors.PlatformUnsupported(platform)
+	}
+}
+
+// RequiredTables 返回数据库文件 name（不含路径）必须包含的表，用于校验解密结果
+func RequiredTables(platform string, name string) ([]string, error) {
+	switch platform {
+	case "windows", "darwin":
+		return v4.RequiredTables(name), nil
 	default:
 		return nil, errors.PlatformUnsupported(platform)
 	}
@@ -14,6 +14,7 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/atrest"
 	"github.com/sjzar/chatlog/pkg/filemonitor"
 )
 
@@ -216,7 +217,10 @@ func (d *DBManager) OpenDB(path string) (*sql.DB, error) {
 	db, ok := d.dbs[path]
 	d.mutex.RUnlock()
 	if ok {
-		return db, nil
+		// 调用方可能已经 Close 了缓存的连接，此时需要重新打开
+		if err := db.Ping(); err == nil {
+			return db, nil
+		}
 	}
 
 	db, err := d.openDB(path)
@@ -232,6 +236,17 @@ func (d *DBManager) OpenDB(path string) (*sql.DB, error) {
 }
 
 func (d *DBManager) openDB(path string) (*sql.DB, error) {
+	if isSealed(path) {
+		key, err := atrest.Open(d.path)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			return nil, errors.ErrWorkDirPassphraseRequired
+		}
+		return key.OpenDB(path)
+	}
+
 	// 构建连接字符串
 	var connStr string
 	if runtime.GOOS == "windows" {
@@ -260,6 +275,12 @@ func (d *DBManager) openDB(path string) (*sql.DB, error) {
 	return db, nil
 }
 
+// isSealed 数据库位于加密的工作目录中，见 atrest 包
+func isSealed(path string) bool {
+	sealed, err := atrest.IsSealed(path)
+	return err == nil && sealed
+}
+
 func (d *DBManager) Callback(event fsnotify.Event) error {
 	// 监听 Create, Write 和 Rename 事件，当文件变化时关闭旧连接
 	// 这样下次访问时会重新打开，读取最新数据
@@ -0,0 +1,88 @@
+package v4
+
+import (
+	"cmp"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"slices"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// GetMessageContext 按 sort_seq 顺序返回 talker 会话中 seq 之前 before 条、之后 after 条消息，包括 seq 本身
+// 会话的消息可能分布在多个消息库中，每个库分别读取前后各 before/after 条后合并截取
+func (ds *DataSource) GetMessageContext(ctx context.Context, selfID string, talker string, seq int64, before, after int) ([]*model.Message, error) {
+	if talker == "" {
+		return nil, errors.ErrTalkerEmpty
+	}
+
+	_talkerMd5Bytes := md5.Sum([]byte(talker))
+	tableName := "Msg_" + hex.EncodeToString(_talkerMd5Bytes[:])
+	query := fmt.Sprintf(`
+		SELECT * FROM (%s WHERE m.sort_seq < ? ORDER BY m.sort_seq DESC LIMIT ?)
+		UNION ALL
+		SELECT * FROM (%s WHERE m.sort_seq >= ? ORDER BY m.sort_seq ASC LIMIT ?)`,
+		fmt.Sprintf(messageSelect, tableName), fmt.Sprintf(messageSelect, tableName))
+
+	messages := make([]*model.Message, 0, before+after+1)
+	for _, dbInfo := range ds.messageDBs() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		tables, err := ds.existingTables(ctx, dbInfo.FilePath, []string{tableName})
+		if err != nil {
+			log.Err(err).Msgf("数据库 %s 查询消息表失败", dbInfo.FilePath)
+			continue
+		}
+		if len(tables) == 0 {
+			continue
+		}
+
+		db, err := ds.dbm.OpenDB(dbInfo.FilePath)
+		if err != nil {
+			log.Error().Msgf("数据库 %s 未打开", dbInfo.FilePath)
+			continue
+		}
+		rows, err := db.QueryContext(ctx, query, talker, seq, before, talker, seq, after+1)
+		if err != nil {
+			releaseDB(db)
+			return nil, errors.QueryFailed(query, err)
+		}
+		for rows.Next() {
+			message, err := scanMessage(rows, selfID, ds.contactCache)
+			if err != nil {
+				rows.Close()
+				releaseDB(db)
+				return nil, err
+			}
+			messages = append(messages, message)
+		}
+		err = rows.Err()
+		rows.Close()
+		releaseDB(db)
+		if err != nil {
+			return nil, errors.QueryFailed(query, err)
+		}
+	}
+
+	slices.SortFunc(messages, func(a, b *model.Message) int {
+		return cmp.Compare(a.Seq, b.Seq)
+	})
+	messages = slices.CompactFunc(messages, func(a, b *model.Message) bool {
+		return a.Seq == b.Seq
+	})
+
+	i, found := slices.BinarySearchFunc(messages, seq, func(m *model.Message, seq int64) int {
+		return cmp.Compare(m.Seq, seq)
+	})
+	end := i + after
+	if found {
+		end++
+	}
+	return messages[max(0, i-before):min(len(messages), end)], nil
+}
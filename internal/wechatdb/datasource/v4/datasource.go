@@ -9,6 +9,7 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -57,6 +58,33 @@ var Groups = []*dbm.Group{
 	},
 }
 
+// requiredTables 各类数据库查询时依赖的表，用于校验解密结果
+var requiredTables = map[string][]string{
+	Message: {"Timestamp", "Name2Id"},
+	Contact: {"contact", "chat_room"},
+	Session: {"SessionTable"},
+	Voice:   {"VoiceInfo"},
+}
+
+// groupPatterns 预编译的 Groups 文件名正则，与 Groups 一一对应
+var groupPatterns = func() []*regexp.Regexp {
+	patterns := make([]*regexp.Regexp, len(Groups))
+	for i, g := range Groups {
+		patterns[i] = regexp.MustCompile(g.Pattern)
+	}
+	return patterns
+}()
+
+// RequiredTables 返回文件名 name 对应的数据库必须包含的表，不属于任何分组时返回 nil
+func RequiredTables(name string) []string {
+	for i, re := range groupPatterns {
+		if re.MatchString(name) {
+			return requiredTables[Groups[i].Name]
+		}
+	}
+	return nil
+}
+
 // MessageDBInfo 存储消息数据库的信息
 type MessageDBInfo struct {
 	FilePath  string
@@ -68,14 +96,19 @@ type DataSource struct {
 	path string
 	dbm  *dbm.DBManager
 
-	// 消息数据库信息
+	// 消息数据库信息，文件变化时整体替换，读取时使用 messageDBs 获取快照
 	messageInfos []MessageDBInfo
+	mutex        sync.RWMutex
 
 	// 联系人缓存
 	contactCache map[string]string
+
+	// 消息全文索引
+	fts *ftsIndex
 }
 
-func New(path string) (*DataSource, error) {
+// New 打开工作目录中的数据源，index 为 true 时在后台建立并增量同步消息全文索引
+func New(path string, index bool) (*DataSource, error) {
 
 	ds := &DataSource{
 		path:         path,
@@ -100,6 +133,14 @@ func New(path string) (*DataSource, error) {
 		log.Err(err).Msg("Failed to initialize contact cache")
 	}
 
+	if index {
+		if fts, err := newFTSIndex(ds); err != nil {
+			log.Err(err).Msg("Failed to initialize full-text index")
+		} else {
+			ds.fts = fts
+		}
+	}
+
 	ds.dbm.AddCallback(Message, func(event fsnotify.Event) error {
 		if !(event.Op.Has(fsnotify.Create) || event.Op.Has(fsnotify.Write) || event.Op.Has(fsnotify.Rename)) {
 			return nil
@@ -107,6 +148,9 @@ func New(path string) (*DataSource, error) {
 		if err := ds.initMessageDbs(); err != nil {
 			log.Err(err).Msgf("Failed to reinitialize message DBs: %s", event.Name)
 		}
+		if ds.fts != nil {
+			ds.fts.Notify()
+		}
 		return nil
 	})
 
@@ -141,7 +185,9 @@ func (ds *DataSource) initMessageDbs() error {
 	dbPaths, err := ds.dbm.GetDBPath(Message)
 	if err != nil {
 		if strings.Contains(err.Error(), "db file not found") {
+			ds.mutex.Lock()
 			ds.messageInfos = make([]MessageDBInfo, 0)
+			ds.mutex.Unlock()
 			return nil
 		}
 		return err
@@ -192,6 +238,8 @@ func (ds *DataSource) initMessageDbs() error {
 			infos[i].EndTime = infos[i+1].StartTime
 		}
 	}
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
 	if len(ds.messageInfos) > 0 && len(infos) < len(ds.messageInfos) {
 		log.Warn().Msgf("message db count decreased from %d to %d, skip init", len(ds.messageInfos), len(infos))
 		return nil
@@ -200,6 +248,14 @@ func (ds *DataSource) initMessageDbs() error {
 	return nil
 }
 
+// messageDBs 返回当前消息数据库信息的快照
+// messageInfos 只会整体替换，不会原地修改，返回的切片可以在锁外使用
+func (ds *DataSource) messageDBs() []MessageDBInfo {
+	ds.mutex.RLock()
+	defer ds.mutex.RUnlock()
+	return ds.messageInfos
+}
+
 func (ds *DataSource) initContactCache() error {
 	db, err := ds.dbm.GetDB(Contact)
 	if err != nil {
@@ -228,7 +284,7 @@ func (ds *DataSource) initContactCache() error {
 // getDBInfosForTimeRange 获取时间范围内的数据库信息
 func (ds *DataSource) getDBInfosForTimeRange(startTime, endTime time.Time) []MessageDBInfo {
 	var dbs []MessageDBInfo
-	for _, info := range ds.messageInfos {
+	for _, info := range ds.messageDBs() {
 		if info.StartTime.Before(endTime) && info.EndTime.After(startTime) {
 			dbs = append(dbs, info)
 		}
@@ -236,196 +292,51 @@ func (ds *DataSource) getDBInfosForTimeRange(startTime, endTime time.Time) []Mes
 	return dbs
 }
 
-func (ds *DataSource) GetMessages(ctx context.Context, startTime, endTime time.Time, selfID string, talker string, sender string, keyword string, limit, offset int) ([]*model.Message, error) {
-	if talker == "" {
-		return nil, errors.ErrTalkerEmpty
-	}
-
-	// 解析talker参数，支持多个talker（以英文逗号分隔）
-	talkers := util.Str2List(talker, ",")
-	if len(talkers) == 0 {
-		return nil, errors.ErrTalkerEmpty
-	}
-
-	// 找到时间范围内的数据库文件
-	dbInfos := ds.getDBInfosForTimeRange(startTime, endTime)
-	if len(dbInfos) == 0 {
-		return nil, errors.TimeRangeNotFound(startTime, endTime)
+// GetMessages 按 (sort_seq, talker) 升序查询消息
+// cursor 不为空时从游标之后开始读取（keyset 分页），offset 在游标之后继续生效
+// filter 设置了 Redact 时每条消息先经过 Redact 再匹配关键词，返回的也是处理后的消息
+func (ds *DataSource) GetMessages(ctx context.Context, startTime, endTime time.Time, selfID string, talker string, sender string, keyword string, filter *model.MessageFilter, cursor *model.MessageCursor, limit, offset int) ([]*model.Message, error) {
+	messages := []*model.Message{}
+	err := ds.StreamMessages(ctx, startTime, endTime, selfID, talker, sender, keyword, filter, cursor, limit, offset, func(msg *model.Message) error {
+		messages = append(messages, msg)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return messages, nil
+}
 
-	// 解析sender参数，支持多个发送者（以英文逗号分隔）
-	senders := util.Str2List(sender, ",")
-
-	log.Debug().Msgf("talkers: %+v", talkers)
-	log.Debug().Msgf("senders: %+v", senders)
-	log.Debug().Msgf("keyword: %+v", keyword)
-	log.Debug().Msgf("limit: %+v", limit)
-	log.Debug().Msgf("offset: %+v", offset)
-	log.Debug().Msgf("selfID: %+v", selfID)
-
-	// 预编译正则表达式（如果有keyword）
-	var regex *regexp.Regexp
-	if keyword != "" {
-		var err error
-		regex, err = regexp.Compile(keyword)
-		if err != nil {
-			return nil, errors.QueryFailed("invalid regex pattern", err)
-		}
+// StreamMessages 与 GetMessages 条件相同，但按顺序逐条回调消息，fn 返回错误时停止并返回该错误
+// 各消息库的结果以流的方式多路归并，读满 limit 条后立即停止，不会加载整个时间范围的消息
+func (ds *DataSource) StreamMessages(ctx context.Context, startTime, endTime time.Time, selfID string, talker string, sender string, keyword string, filter *model.MessageFilter, cursor *model.MessageCursor, limit, offset int, fn func(*model.Message) error) error {
+	it, err := ds.newMessageIterator(ctx, startTime, endTime, selfID, talker, sender, keyword, filter, cursor)
+	if err != nil {
+		return err
 	}
+	defer it.Close()
 
-	// 从每个相关数据库中查询消息，并在读取时进行过滤
-	filteredMessages := []*model.Message{}
-
-	for _, dbInfo := range dbInfos {
-		// 检查上下文是否已取消
-		if err := ctx.Err(); err != nil {
-			return nil, err
-		}
-
-		db, err := ds.dbm.OpenDB(dbInfo.FilePath)
-		if err != nil {
-			log.Error().Msgf("数据库 %s 未打开", dbInfo.FilePath)
+	skipped, count := 0, 0
+	for it.Next() {
+		if skipped < offset {
+			skipped++
 			continue
 		}
-
-		// 使用匿名函数确保每轮循环的数据库和行都能及时关闭
-		func() {
-			defer db.Close()
-			// 对每个talker进行查询
-			for _, talkerItem := range talkers {
-				// 构建表名
-				_talkerMd5Bytes := md5.Sum([]byte(talkerItem))
-				talkerMd5 := hex.EncodeToString(_talkerMd5Bytes[:])
-				tableName := "Msg_" + talkerMd5
-
-				log.Debug().Msgf("tableName: %+v", tableName)
-
-				// 检查表是否存在
-				var exists bool
-				err = db.QueryRowContext(ctx,
-					"SELECT 1 FROM sqlite_master WHERE type='table' AND name=?",
-					tableName).Scan(&exists)
-
-				if err != nil {
-					if err == sql.ErrNoRows {
-						// 表不存在，继续下一个talker
-						log.Debug().Msgf("table %s not found", tableName)
-						continue
-					}
-					log.Debug().Err(err).Msgf("Check table %s exists failed", tableName)
-					continue
-				}
-
-				// 构建查询条件
-				conditions := []string{"create_time >= ? AND create_time <= ?"}
-				args := []interface{}{startTime.Unix(), endTime.Unix()}
-
-				query := fmt.Sprintf(`
-					SELECT m.sort_seq, m.server_id, m.local_type, IFNULL(n.user_name, ''), m.create_time, m.message_content, m.packed_info_data, m.status
-					FROM %s m
-					LEFT JOIN Name2Id n ON m.real_sender_id = n.rowid
-					WHERE %s 
-					ORDER BY m.sort_seq ASC
-				`, tableName, strings.Join(conditions, " AND "))
-
-				// 执行查询
-				rows, err := db.QueryContext(ctx, query, args...)
-				if err != nil {
-					log.Err(err).Msgf("从数据库 %s 查询消息失败 %v", dbInfo.FilePath, err)
-					// 如果表不存在，SQLite 会返回错误
-					if strings.Contains(err.Error(), "no such table") {
-						continue
-					}
-					continue
-				}
-
-				// 处理查询结果，在读取时进行过滤
-				for rows.Next() {
-					var msg model.MessageV4
-					err := rows.Scan(
-						&msg.SortSeq,
-						&msg.ServerID,
-						&msg.LocalType,
-						&msg.UserName,
-						&msg.CreateTime,
-						&msg.MessageContent,
-						&msg.PackedInfoData,
-						&msg.Status,
-					)
-					if err != nil {
-						log.Error().Err(err).Msg("Scan message failed")
-						rows.Close()
-						return
-					}
-
-					// 将消息转换为标准格式
-					msg.SelfID = selfID
-					msg.SenderName = ds.contactCache[msg.UserName]
-					message := msg.Wrap(talkerItem)
-
-					log.Debug().Msgf("message: %+v", message)
-
-					// 应用sender过滤
-					if len(senders) > 0 {
-						senderMatch := false
-						for _, s := range senders {
-							if message.Sender == s {
-								senderMatch = true
-								break
-							}
-						}
-						if !senderMatch {
-							log.Debug().Msgf("sender %s not match", message.Sender)
-							continue
-						}
-					}
-
-					// 应用keyword过滤
-					if regex != nil {
-						if !regex.MatchString(message.PlainTextContent()) {
-							log.Debug().Msgf("keyword not match: %s", message.PlainTextContent())
-							continue
-						}
-					}
-
-					// 通过所有过滤条件，保留此消息
-					filteredMessages = append(filteredMessages, message)
-
-					// 检查是否已经满足分页处理数量（注意：这里是在多库查询，提前限制可能导致结果不全，但为了性能暂时保持逻辑）
-					// 不过用户说慢点没关系，我们可以等所有查询结束后再排序分页。
-					// 为保持用户原有逻辑的一致性，这里仅做修正。
-				}
-				rows.Close()
-			}
-		}()
-	}
-
-	// 对所有消息按时间排序
-	sort.Slice(filteredMessages, func(i, j int) bool {
-		return filteredMessages[i].Seq < filteredMessages[j].Seq
-	})
-
-	// 处理分页
-	if limit > 0 {
-		if offset >= len(filteredMessages) {
-			return []*model.Message{}, nil
+		if err := fn(it.Message()); err != nil {
+			return err
 		}
-		end := offset + limit
-		if end > len(filteredMessages) {
-			end = len(filteredMessages)
+		count++
+		if limit > 0 && count >= limit {
+			break
 		}
-		return filteredMessages[offset:end], nil
 	}
-
-	return filteredMessages, nil
+	return it.Err()
 }
 
-func (ds *DataSource) GetMessagesCount(ctx context.Context, startTime, endTime time.Time, speakerto string, talker string, sender string, keyword string) (int, error) {
-	messages, err := ds.GetMessages(ctx, startTime, endTime, speakerto, talker, sender, keyword, 0, 0)
-	if err != nil {
-		return 0, err
-	}
-	return len(messages), nil
+// GetMessagesCount 统计符合条件的消息数量
+// 时间、talker 与 sender 条件直接使用 SQL COUNT，sender 通过 Name2Id 转换为 real_sender_id 过滤
+func (ds *DataSource) GetMessagesCount(ctx context.Context, startTime, endTime time.Time, speakerto string, talker string, sender string) (int, error) {
+	return ds.countMessages(ctx, startTime, endTime, talker, util.Str2List(sender, ","))
 }
 
 // 联系人
@@ -749,7 +660,7 @@ func (ds *DataSource) GetSessions(ctx context.Context, key string, limit, offset
 
 	if key != "" {
 		// 按照关键字查询
-		query = `SELECT username, summary, last_timestamp, last_msg_sender, last_sender_display_name, last_msg_type, last_msg_sub_type, status, IFNULL(last_msg_locald_id, 0)
+		query = `SELECT username, summary, last_timestamp, last_msg_sender, last_sender_display_name, last_msg_type, last_msg_sub_type, status, IFNULL(last_msg_locald_id, 0), IFNULL(unread_count, 0)
 				FROM SessionTable 
 				WHERE username LIKE '%' || ? || '%' 
 				   OR last_sender_display_name LIKE '%' || ? || '%' 
@@ -758,7 +669,7 @@ func (ds *DataSource) GetSessions(ctx context.Context, key string, limit, offset
 		args = []interface{}{key, key, key}
 	} else {
 		// 查询所有会话
-		query = `SELECT username, summary, last_timestamp, last_msg_sender, last_sender_display_name, last_msg_type, last_msg_sub_type, status, IFNULL(last_msg_locald_id, 0)
+		query = `SELECT username, summary, last_timestamp, last_msg_sender, last_sender_display_name, last_msg_type, last_msg_sub_type, status, IFNULL(last_msg_locald_id, 0), IFNULL(unread_count, 0)
 				FROM SessionTable 
 				ORDER BY sort_timestamp DESC`
 	}
@@ -796,6 +707,7 @@ func (ds *DataSource) GetSessions(ctx context.Context, key string, limit, offset
 			&sessionV4.LastMsgSubType,
 			&sessionV4.Status,
 			&sessionV4.LastMsgLocaldID,
+			&sessionV4.UnreadCount,
 		)
 
 		if err != nil {
@@ -993,6 +905,11 @@ func (ds *DataSource) GetVoice(ctx context.Context, key string) (*model.Media, e
 }
 
 func (ds *DataSource) Close() error {
+	if ds.fts != nil {
+		if err := ds.fts.Close(); err != nil {
+			log.Debug().Err(err).Msg("close fts index failed")
+		}
+	}
 	return ds.dbm.Close()
 }
 
@@ -1041,7 +958,7 @@ func (ds *DataSource) GetSendersByLocalIDs(ctx context.Context, requests []model
 
 	// 找到 message_0.db
 	var targetDBPath string
-	for _, dbInfo := range ds.messageInfos {
+	for _, dbInfo := range ds.messageDBs() {
 		if strings.Contains(dbInfo.FilePath, "message_0.db") {
 			targetDBPath = dbInfo.FilePath
 			break
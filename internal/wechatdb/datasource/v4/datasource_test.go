@@ -0,0 +1,80 @@
+package v4
+
+import (
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testMessage 测试数据中的一条文本消息
+type testMessage struct {
+	talker  string
+	seq     int64
+	content string
+}
+
+// testStart 测试消息库的开始时间，消息的 create_time 为 testStart + seq 秒
+var testStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+
+// newTestDataSource 在临时目录中创建只包含一个消息库的数据源
+func newTestDataSource(t *testing.T, index bool, messages []testMessage) (*DataSource, string) {
+	t.Helper()
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db_storage", "message", "message_0.db")
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	exec(t, db, "CREATE TABLE Timestamp (timestamp INTEGER)")
+	exec(t, db, "INSERT INTO Timestamp VALUES (?)", testStart.Unix())
+	exec(t, db, "CREATE TABLE Name2Id (user_name TEXT)")
+	for _, m := range messages {
+		insertTestMessage(t, db, m)
+	}
+	db.Close()
+
+	ds, err := New(dir, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ds.Close() })
+	return ds, dbPath
+}
+
+// insertTestMessage 写入一条消息，消息表与 Name2Id 中的会话不存在时创建
+func insertTestMessage(t *testing.T, db *sql.DB, m testMessage) {
+	t.Helper()
+	sum := md5.Sum([]byte(m.talker))
+	table := "Msg_" + hex.EncodeToString(sum[:])
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM Name2Id WHERE user_name = ?", m.talker).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		exec(t, db, "INSERT INTO Name2Id (user_name) VALUES (?)", m.talker)
+		exec(t, db, fmt.Sprintf(`CREATE TABLE %s (
+			local_id INTEGER PRIMARY KEY AUTOINCREMENT, server_id INTEGER, local_type INTEGER, sort_seq INTEGER,
+			real_sender_id INTEGER, create_time INTEGER, status INTEGER, message_content TEXT, packed_info_data BLOB
+		)`, table))
+	}
+	exec(t, db, fmt.Sprintf(`INSERT INTO %s (server_id, local_type, sort_seq, real_sender_id, create_time, status, message_content)
+		VALUES (?, 1, ?, (SELECT rowid FROM Name2Id WHERE user_name = ?), ?, 0, ?)`, table),
+		m.seq, m.seq, m.talker, testStart.Unix()+m.seq, m.content)
+}
+
+func exec(t *testing.T, db *sql.DB, query string, args ...interface{}) {
+	t.Helper()
+	if _, err := db.Exec(query, args...); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+}
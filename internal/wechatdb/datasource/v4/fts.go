@@ -0,0 +1,706 @@
+package v4
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/internal/wechat/decrypt/atrest"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+const (
+	// ftsSchemaVersion 索引结构版本，变更后会重建索引
+	// 2: 拉丁单词额外索引三元组，支持单词中间的子串
+	// 3: index_state 记录已索引消息的行数、最大 rowid 与内容校验和，用于发现水位线之前的变化
+	ftsSchemaVersion = 3
+
+	// ftsBatchSize 每批写入索引的消息数量
+	ftsBatchSize = 2000
+
+	// ftsMaxHits 单表命中数量上限，超过后回退为全表扫描
+	ftsMaxHits = 20000
+
+	// ftsSyncDelay 文件变化后延迟同步，合并短时间内的多次写入
+	ftsSyncDelay = 2 * time.Second
+
+	// ftsVerifyInterval 校验已索引消息内容的间隔，校验需要读取整个消息表，不在每次同步时进行
+	ftsVerifyInterval = time.Hour
+)
+
+// ftsIndex 消息全文索引
+// 索引保存在工作目录下的独立数据库中（index/message_fts.db，工作目录加密时保存在内存中），不修改解密后的消息库
+// 每个消息表记录已索引的最大 sort_seq 作为水位线，增量同步只处理水位线之后的新消息
+// 水位线之前插入、删除或修改（如撤回）的消息通过 reconcile 发现，发现后重建该消息表的索引
+type ftsIndex struct {
+	ds   *DataSource
+	path string
+	db   *sql.DB
+
+	// lastVerify 上一次校验已索引消息内容的时间，只在同步协程中访问
+	lastVerify time.Time
+
+	notify chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newFTSIndex(ds *DataSource) (*ftsIndex, error) {
+	dir := filepath.Join(ds.path, "index")
+	path := filepath.Join(dir, "message_fts.db")
+
+	var db *sql.DB
+	var err error
+	if atrest.Enabled(ds.path) {
+		// 工作目录加密时索引中的分词同样是明文，只保存在内存中，每次启动重建，并清理之前残留的索引文件
+		for _, file := range []string{path, path + "-wal", path + "-shm"} {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				log.Debug().Err(err).Msgf("remove fts index %s failed", file)
+			}
+		}
+		if db, err = sql.Open("sqlite3", ":memory:"); err != nil {
+			return nil, err
+		}
+		// 内存数据库只存在于单个连接中
+		db.SetMaxOpenConns(1)
+		db.SetMaxIdleConns(1)
+		db.SetConnMaxLifetime(0)
+		db.SetConnMaxIdleTime(0)
+	} else {
+		if err := util.PrepareDir(dir); err != nil {
+			return nil, err
+		}
+		if db, err = sql.Open("sqlite3", path+"?_journal_mode=WAL&_busy_timeout=5000"); err != nil {
+			return nil, err
+		}
+	}
+
+	f := &ftsIndex{
+		ds:     ds,
+		path:   path,
+		db:     db,
+		notify: make(chan struct{}, 1),
+	}
+	if err := f.init(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+	f.wg.Add(1)
+	go f.loop()
+	f.Notify()
+
+	return f, nil
+}
+
+func (f *ftsIndex) init() error {
+	var version int
+	if err := f.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version != 0 && version != ftsSchemaVersion {
+		log.Info().Msgf("fts index schema changed (%d -> %d), rebuilding", version, ftsSchemaVersion)
+		for _, stmt := range []string{
+			"DROP TABLE IF EXISTS msg_fts",
+			"DROP TABLE IF EXISTS msg_index",
+			"DROP TABLE IF EXISTS index_state",
+		} {
+			if _, err := f.db.Exec(stmt); err != nil {
+				return err
+			}
+		}
+	}
+
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS msg_index (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			db TEXT NOT NULL,
+			tbl TEXT NOT NULL,
+			seq INTEGER NOT NULL,
+			create_time INTEGER NOT NULL,
+			UNIQUE(db, tbl, seq)
+		)`,
+		`CREATE VIRTUAL TABLE IF NOT EXISTS msg_fts USING fts4(tokens, tokenize=simple)`,
+		`CREATE TABLE IF NOT EXISTS index_state (
+			db TEXT NOT NULL,
+			tbl TEXT NOT NULL,
+			seq INTEGER NOT NULL,
+			rows INTEGER NOT NULL,
+			max_rowid INTEGER NOT NULL,
+			checksum INTEGER NOT NULL,
+			PRIMARY KEY(db, tbl)
+		)`,
+		fmt.Sprintf("PRAGMA user_version = %d", ftsSchemaVersion),
+	}
+	for _, stmt := range stmts {
+		if _, err := f.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Notify 触发一次增量同步，不会阻塞调用方
+func (f *ftsIndex) Notify() {
+	select {
+	case f.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (f *ftsIndex) loop() {
+	defer f.wg.Done()
+	for {
+		select {
+		case <-f.notify:
+			select {
+			case <-time.After(ftsSyncDelay):
+			case <-f.ctx.Done():
+				return
+			}
+			// 清空延迟期间积累的通知
+			select {
+			case <-f.notify:
+			default:
+			}
+			if err := f.sync(f.ctx); err != nil && f.ctx.Err() == nil {
+				log.Err(err).Msg("fts index sync failed")
+			}
+		case <-f.ctx.Done():
+			return
+		}
+	}
+}
+
+func (f *ftsIndex) Close() error {
+	f.cancel()
+	f.wg.Wait()
+	return f.db.Close()
+}
+
+// dbKey 返回消息库相对于数据目录的路径，作为索引中的库标识
+func (f *ftsIndex) dbKey(filePath string) string {
+	if rel, err := filepath.Rel(f.ds.path, filePath); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.Base(filePath)
+}
+
+func (f *ftsIndex) sync(ctx context.Context) error {
+	start := time.Now()
+	verify := start.Sub(f.lastVerify) >= ftsVerifyInterval
+	total := 0
+	for _, info := range f.ds.messageDBs() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := f.syncDB(ctx, info.FilePath, verify)
+		if err != nil {
+			log.Err(err).Msgf("fts index sync %s failed", info.FilePath)
+			continue
+		}
+		total += n
+	}
+	if verify {
+		f.lastVerify = start
+	}
+	if total > 0 {
+		log.Info().Msgf("fts index synced %d messages in %s", total, time.Since(start).Round(time.Millisecond))
+	}
+	return nil
+}
+
+// syncDB 同步单个消息库的索引，verify 为 true 时同时校验已索引消息的内容
+func (f *ftsIndex) syncDB(ctx context.Context, filePath string, verify bool) (int, error) {
+	if _, err := os.Stat(filePath); err != nil {
+		return 0, err
+	}
+
+	db, err := f.ds.dbm.OpenDB(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer releaseDB(db)
+
+	talkers, err := messageTables(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+
+	key := f.dbKey(filePath)
+	total := 0
+	for table, talker := range talkers {
+		if err := f.reconcile(ctx, db, key, table, verify); err != nil {
+			return total, err
+		}
+		for {
+			n, err := f.syncTable(ctx, db, key, table, talker)
+			if err != nil {
+				return total, err
+			}
+			total += n
+			if n < ftsBatchSize {
+				break
+			}
+		}
+	}
+	return total, nil
+}
+
+// syncTable 将单个消息表水位线之后的一批消息写入索引，返回处理的消息数量
+func (f *ftsIndex) syncTable(ctx context.Context, db *sql.DB, key, table, talker string) (int, error) {
+	state, err := f.state(ctx, key, table)
+	if err != nil {
+		return 0, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT m.sort_seq, m.server_id, m.local_type, IFNULL(n.user_name, ''), m.create_time, m.message_content, m.packed_info_data, m.status,
+			m.rowid, %s
+		FROM %s m
+		LEFT JOIN Name2Id n ON m.real_sender_id = n.rowid
+		WHERE m.sort_seq > ?
+		ORDER BY m.sort_seq ASC
+		LIMIT %d
+	`, ftsChecksum, table, ftsBatchSize)
+
+	rows, err := db.QueryContext(ctx, query, state.seq)
+	if err != nil {
+		return 0, err
+	}
+
+	type entry struct {
+		seq        int64
+		createTime int64
+		tokens     string
+	}
+	entries := make([]entry, 0, ftsBatchSize)
+	for rows.Next() {
+		var msg model.MessageV4
+		var rowid, checksum int64
+		if err := rows.Scan(
+			&msg.SortSeq,
+			&msg.ServerID,
+			&msg.LocalType,
+			&msg.UserName,
+			&msg.CreateTime,
+			&msg.MessageContent,
+			&msg.PackedInfoData,
+			&msg.Status,
+			&rowid,
+			&checksum,
+		); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		message := msg.Wrap(talker)
+		entries = append(entries, entry{
+			seq:        msg.SortSeq,
+			createTime: msg.CreateTime,
+			tokens:     indexTokens(message.PlainTextContent()),
+		})
+		state.rows++
+		state.maxRowID = max(state.maxRowID, rowid)
+		state.checksum += checksum
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	state.seq = entries[len(entries)-1].seq
+
+	tx, err := f.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, e := range entries {
+		if e.tokens == "" {
+			continue
+		}
+		res, err := tx.ExecContext(ctx,
+			"INSERT OR IGNORE INTO msg_index (db, tbl, seq, create_time) VALUES (?, ?, ?, ?)",
+			key, table, e.seq, e.createTime)
+		if err != nil {
+			return 0, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO msg_fts (docid, tokens) VALUES (?, ?)", id, e.tokens); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"INSERT OR REPLACE INTO index_state (db, tbl, seq, rows, max_rowid, checksum) VALUES (?, ?, ?, ?, ?, ?)",
+		key, table, state.seq, state.rows, state.maxRowID, state.checksum); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// ftsChecksum 单条消息的内容校验值，消息被撤回或修改时类型或内容长度会发生变化
+const ftsChecksum = "m.local_type + LENGTH(CAST(m.message_content AS BLOB))"
+
+// indexState 消息表的索引状态，rows、maxRowID 与 checksum 统计 sort_seq 不超过水位线的消息
+type indexState struct {
+	seq      int64
+	rows     int64
+	maxRowID int64
+	checksum int64
+}
+
+func (f *ftsIndex) state(ctx context.Context, key, table string) (indexState, error) {
+	var state indexState
+	err := f.db.QueryRowContext(ctx,
+		"SELECT seq, rows, max_rowid, checksum FROM index_state WHERE db = ? AND tbl = ?", key, table,
+	).Scan(&state.seq, &state.rows, &state.maxRowID, &state.checksum)
+	if err == sql.ErrNoRows {
+		return indexState{}, nil
+	}
+	return state, err
+}
+
+// reconcile 检查消息表中水位线之前的消息是否与建立索引时一致，不一致时删除该表的索引，之后的同步从头重建
+// 行数与最大 rowid 可以发现插入到水位线之前和被删除的消息，通过 sort_seq 上的索引统计，每次同步都检查
+// verify 为 true 时还比较内容校验和以发现原地修改的消息（如撤回），需要读取整个消息表
+func (f *ftsIndex) reconcile(ctx context.Context, db *sql.DB, key, table string, verify bool) error {
+	state, err := f.state(ctx, key, table)
+	if err != nil || state.seq == 0 {
+		return err
+	}
+
+	var cur indexState
+	if verify {
+		err = db.QueryRowContext(ctx, fmt.Sprintf(
+			"SELECT COUNT(*), IFNULL(MAX(m.rowid), 0), IFNULL(SUM(%s), 0) FROM %s m WHERE m.sort_seq <= ?", ftsChecksum, table),
+			state.seq).Scan(&cur.rows, &cur.maxRowID, &cur.checksum)
+	} else {
+		err = db.QueryRowContext(ctx, fmt.Sprintf(
+			"SELECT COUNT(*), IFNULL(MAX(rowid), 0) FROM %s WHERE sort_seq <= ?", table),
+			state.seq).Scan(&cur.rows, &cur.maxRowID)
+		cur.checksum = state.checksum
+	}
+	if err != nil {
+		return err
+	}
+	if cur.rows == state.rows && cur.maxRowID == state.maxRowID && cur.checksum == state.checksum {
+		return nil
+	}
+
+	log.Info().Msgf("fts index of %s %s is stale (rows %d -> %d), rebuilding", key, table, state.rows, cur.rows)
+	tx, err := f.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range []string{
+		"DELETE FROM msg_fts WHERE docid IN (SELECT id FROM msg_index WHERE db = ? AND tbl = ?)",
+		"DELETE FROM msg_index WHERE db = ? AND tbl = ?",
+		"DELETE FROM index_state WHERE db = ? AND tbl = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, stmt, key, table); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (f *ftsIndex) watermark(ctx context.Context, key, table string) (int64, error) {
+	var seq int64
+	err := f.db.QueryRowContext(ctx, "SELECT seq FROM index_state WHERE db = ? AND tbl = ?", key, table).Scan(&seq)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return seq, err
+}
+
+// Lookup 查询消息表中可能包含关键词的消息序号
+// 返回的 seqs 仅是候选集合，调用方仍需对消息内容进行校验；
+// 水位线之后的消息尚未建立索引，调用方需要额外查询 sort_seq > watermark 的消息
+// ok 为 false 时表示索引不可用（关键词无法转换、命中过多等），调用方应回退为全表扫描
+func (f *ftsIndex) Lookup(ctx context.Context, filePath, table, match string, startTime, endTime time.Time) (seqs []int64, watermark int64, ok bool) {
+	if f == nil || match == "" {
+		return nil, 0, false
+	}
+
+	key := f.dbKey(filePath)
+	// 先读取水位线再查询命中，保证水位线之前的消息一定已经在索引中
+	watermark, err := f.watermark(ctx, key, table)
+	if err != nil {
+		log.Debug().Err(err).Msg("fts index watermark failed")
+		return nil, 0, false
+	}
+	if watermark == 0 {
+		return nil, 0, false
+	}
+
+	rows, err := f.db.QueryContext(ctx, `
+		SELECT i.seq
+		FROM msg_fts f
+		JOIN msg_index i ON i.id = f.docid
+		WHERE f.tokens MATCH ? AND i.db = ? AND i.tbl = ? AND i.create_time >= ? AND i.create_time <= ?
+		LIMIT ?
+	`, match, key, table, startTime.Unix(), endTime.Unix(), ftsMaxHits+1)
+	if err != nil {
+		log.Debug().Err(err).Msg("fts index query failed")
+		return nil, 0, false
+	}
+	defer rows.Close()
+
+	seqs = make([]int64, 0)
+	for rows.Next() {
+		var seq int64
+		if err := rows.Scan(&seq); err != nil {
+			return nil, 0, false
+		}
+		seqs = append(seqs, seq)
+	}
+	if rows.Err() != nil || len(seqs) > ftsMaxHits {
+		return nil, 0, false
+	}
+	return seqs, watermark, true
+}
+
+// Search 在单个消息库中查询命中的消息，按时间倒序返回各消息表的候选序号
+// watermarks 为各消息表的水位线，未出现在其中的消息表尚未建立索引
+// tables 不为 nil 时只查询其中的消息表，候选数量上限不会被其他会话占用
+// ok 为 false 时表示索引不可用，调用方应回退为全表扫描
+func (f *ftsIndex) Search(ctx context.Context, filePath, match string, tables []string, startTime, endTime time.Time, limit int) (hits map[string][]int64, watermarks map[string]int64, ok bool) {
+	if f == nil || match == "" {
+		return nil, nil, false
+	}
+
+	key := f.dbKey(filePath)
+	rows, err := f.db.QueryContext(ctx, "SELECT tbl, seq FROM index_state WHERE db = ?", key)
+	if err != nil {
+		log.Debug().Err(err).Msg("fts index watermark failed")
+		return nil, nil, false
+	}
+	watermarks = make(map[string]int64)
+	for rows.Next() {
+		var table string
+		var seq int64
+		if err := rows.Scan(&table, &seq); err != nil {
+			rows.Close()
+			return nil, nil, false
+		}
+		watermarks[table] = seq
+	}
+	rows.Close()
+
+	// 消息表名为 Msg_ 加十六进制 md5，可以直接拼接到查询中
+	tableCond := ""
+	if tables != nil {
+		tableCond = fmt.Sprintf("AND i.tbl IN ('%s')", strings.Join(tables, "','"))
+	}
+	rows, err = f.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT i.tbl, i.seq
+		FROM msg_fts f
+		JOIN msg_index i ON i.id = f.docid
+		WHERE f.tokens MATCH ? AND i.db = ? AND i.create_time >= ? AND i.create_time <= ? %s
+		ORDER BY i.create_time DESC
+		LIMIT ?
+	`, tableCond), match, key, startTime.Unix(), endTime.Unix(), limit)
+	if err != nil {
+		log.Debug().Err(err).Msg("fts index query failed")
+		return nil, nil, false
+	}
+	defer rows.Close()
+
+	hits = make(map[string][]int64)
+	for rows.Next() {
+		var table string
+		var seq int64
+		if err := rows.Scan(&table, &seq); err != nil {
+			return nil, nil, false
+		}
+		hits[table] = append(hits[table], seq)
+	}
+	if rows.Err() != nil {
+		return nil, nil, false
+	}
+	return hits, watermarks, true
+}
+
+// messageTables 返回消息库中的消息表及其对应的 talker
+// 消息表名为 Msg_md5(talker)，通过 Name2Id 还原 talker，无法还原的表会被忽略
+func messageTables(ctx context.Context, db *sql.DB) (map[string]string, error) {
+	names := make(map[string]string)
+	rows, err := db.QueryContext(ctx, "SELECT user_name FROM Name2Id")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var userName string
+		if err := rows.Scan(&userName); err != nil {
+			continue
+		}
+		_talkerMd5Bytes := md5.Sum([]byte(userName))
+		names["Msg_"+hex.EncodeToString(_talkerMd5Bytes[:])] = userName
+	}
+	rows.Close()
+
+	tables := make(map[string]string)
+	rows, err = db.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type='table' AND name LIKE 'Msg_%'")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			continue
+		}
+		if talker, ok := names[name]; ok {
+			tables[name] = talker
+		}
+	}
+	return tables, nil
+}
+
+// releaseDB 释放 OpenDB 获取的连接
+// 非 Windows 平台 OpenDB 返回的是共享的缓存连接，关闭它会影响其他正在进行的查询，交由 dbm 统一管理
+func releaseDB(db *sql.DB) {
+	if runtime.GOOS == "windows" {
+		db.Close()
+	}
+}
+
+const (
+	runSep = iota
+	runWord
+	runCJK
+)
+
+// textRun 文本中连续的同类字符片段
+type textRun struct {
+	kind  int
+	text  []rune
+	start int // 片段在原文中的 rune 偏移
+}
+
+// splitRuns 将文本切分为单词、CJK 和分隔符片段，与 util.Segment 的切分规则保持一致
+func splitRuns(s string) []textRun {
+	kindOf := func(r rune) int {
+		switch {
+		case util.IsCJK(r):
+			return runCJK
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			return runWord
+		default:
+			return runSep
+		}
+	}
+
+	runes := []rune(s)
+	runs := make([]textRun, 0)
+	for i := 0; i < len(runes); {
+		kind := kindOf(runes[i])
+		j := i
+		for j < len(runes) && kindOf(runes[j]) == kind {
+			j++
+		}
+		runs = append(runs, textRun{kind: kind, text: runes[i:j], start: i})
+		i = j
+	}
+	return runs
+}
+
+// indexTokens 返回写入索引的词元
+// 在 util.Segment 的基础上，长度超过 3 的拉丁单词额外写入其全部三元组（"invoice" => "inv" "nvo" "voi" "oic" "ice"），
+// 使关键词位于原文单词中间时（如 "voice" 之于 "invoice"）也能通过索引查找
+func indexTokens(text string) string {
+	buf := strings.Builder{}
+	write := func(token string) {
+		if buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(token)
+	}
+	for _, token := range util.Segment(text) {
+		write(token.Text)
+		if token.Kind != util.TokenWord {
+			continue
+		}
+		runes := []rune(token.Text)
+		if len(runes) <= 3 {
+			continue
+		}
+		for k := 0; k+3 <= len(runes); k++ {
+			write(string(runes[k : k+3]))
+		}
+	}
+	return buf.String()
+}
+
+// buildMatchQuery 将关键词转换为 FTS MATCH 表达式
+// 生成的表达式是 "消息文本包含该关键词" 的必要条件，因此走索引不会改变原有的匹配语义：
+// CJK 片段使用全部二元组；拉丁单词两侧均为边界时精确匹配，仅左侧为边界时前缀匹配，
+// 左侧不是边界的单词（如位于关键词开头，可能是原文中某个单词的后缀或中间部分）使用全部三元组，见 indexTokens
+// 关键词包含正则元字符或无法生成任何词元时返回空字符串
+func buildMatchQuery(keyword string) string {
+	if keyword == "" || regexpMeta(keyword) {
+		return ""
+	}
+
+	runs := splitRuns(keyword)
+	terms := make([]string, 0)
+	for i, run := range runs {
+		switch run.kind {
+		case runCJK:
+			for k := 0; k+1 < len(run.text); k++ {
+				terms = append(terms, string(run.text[k:k+2]))
+			}
+		case runWord:
+			leftBoundary := i > 0
+			rightBoundary := i < len(runs)-1
+			word := []rune(strings.ToLower(string(run.text)))
+			switch {
+			case leftBoundary && rightBoundary:
+				terms = append(terms, string(word))
+			case leftBoundary:
+				terms = append(terms, string(word)+"*")
+			default:
+				// 少于 3 个字符时无法生成三元组，不参与匹配
+				for k := 0; k+3 <= len(word); k++ {
+					terms = append(terms, string(word[k:k+3]))
+				}
+			}
+		}
+	}
+
+	return strings.Join(terms, " ")
+}
+
+// regexpMeta 判断关键词中是否包含正则元字符
+func regexpMeta(s string) bool {
+	return strings.ContainsAny(s, `\.+*?()|[]{}^$`)
+}
@@ -0,0 +1,118 @@
+package v4
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestFTSReconcile(t *testing.T) {
+	table := func(talker string) string {
+		sum := md5.Sum([]byte(talker))
+		return "Msg_" + hex.EncodeToString(sum[:])
+	}
+
+	tests := []struct {
+		name   string
+		change func(t *testing.T, db *sql.DB)
+		verify bool // 是否需要校验内容才能发现变化
+		want   []int64
+	}{
+		{
+			name: "insert below watermark",
+			change: func(t *testing.T, db *sql.DB) {
+				insertTestMessage(t, db, testMessage{talker: "wxid_a", seq: 15, content: "apple pie"})
+			},
+			want: []int64{15},
+		},
+		{
+			name: "delete below watermark",
+			change: func(t *testing.T, db *sql.DB) {
+				exec(t, db, "DELETE FROM "+table("wxid_a")+" WHERE sort_seq = 10")
+				insertTestMessage(t, db, testMessage{talker: "wxid_a", seq: 10, content: "apple"})
+			},
+			want: []int64{10},
+		},
+		{
+			name: "edit below watermark",
+			change: func(t *testing.T, db *sql.DB) {
+				exec(t, db, "UPDATE "+table("wxid_a")+" SET message_content = 'apple revoked' WHERE sort_seq = 20")
+			},
+			verify: true,
+			want:   []int64{20},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds, dbPath := newTestDataSource(t, true, []testMessage{
+				{talker: "wxid_a", seq: 10, content: "hello world"},
+				{talker: "wxid_a", seq: 20, content: "foo bar"},
+			})
+			ctx := context.Background()
+			if err := ds.fts.sync(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if _, watermark, _ := ds.fts.Lookup(ctx, dbPath, table("wxid_a"), "apple*", testStart, testStart.Add(time.Hour)); watermark != 20 {
+				t.Fatalf("watermark = %d, want 20", watermark)
+			}
+
+			db, err := sql.Open("sqlite3", dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.change(t, db)
+			db.Close()
+
+			// 不校验内容的同步只能发现行数与 rowid 的变化
+			if err := ds.fts.sync(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if tt.verify {
+				ds.fts.lastVerify = time.Time{}
+				if err := ds.fts.sync(ctx); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			messages, err := ds.GetMessages(ctx, testStart, testStart.Add(time.Hour), "", "wxid_a", "", "apple", nil, nil, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]int64, 0, len(messages))
+			for _, m := range messages {
+				got = append(got, m.Seq)
+			}
+			if len(got) != len(tt.want) || (len(got) > 0 && got[0] != tt.want[0]) {
+				t.Errorf("GetMessages(apple) = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildMatchQuery(t *testing.T) {
+	tests := []struct {
+		keyword string
+		want    string
+	}{
+		{"", ""},
+		{"a.b", ""},
+		{"foo|bar", ""},
+		{"中文消息", "中文 文消 消息"},
+		{"hello", "hel ell llo"},
+		{"hi", ""},
+		{"Hello World", "hel ell llo world*"},
+		{"说hello吧", "hello"},
+		{"发票invoice", "发票 invoice*"},
+		{"a b c", "b c*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.keyword, func(t *testing.T) {
+			if got := buildMatchQuery(tt.keyword); got != tt.want {
+				t.Errorf("buildMatchQuery(%q) = %q, want %q", tt.keyword, got, tt.want)
+			}
+		})
+	}
+}
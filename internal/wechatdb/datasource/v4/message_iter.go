@@ -0,0 +1,417 @@
+package v4
+
+import (
+	"container/heap"
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+// maxCompoundSelect 单条 UNION ALL 查询包含的消息表数量上限（SQLite 默认限制为 500）
+const maxCompoundSelect = 400
+
+// messageSelect 读取消息的查询，第一个参数为 talker，与 scanMessage 的字段顺序一致
+const messageSelect = `
+	SELECT ? AS talker, m.sort_seq, m.server_id, m.local_type, IFNULL(n.user_name, ''), m.create_time, m.message_content, m.packed_info_data, m.status
+	FROM %s m
+	LEFT JOIN Name2Id n ON m.real_sender_id = n.rowid`
+
+// messageIterator 按 (sort_seq, talker) 升序遍历多个消息库中的消息
+// 每个消息库（talker 较多时按批拆分）对应一个有序的结果流，通过最小堆进行多路归并
+type messageIterator struct {
+	ctx     context.Context
+	selfID  string
+	senders []string
+	regex   *regexp.Regexp
+	filter  *model.MessageFilter
+	names   map[string]string
+
+	sources sourceHeap
+	last    *messageSource
+	cur     *model.Message
+	err     error
+}
+
+// messageSource 单个有序结果流
+type messageSource struct {
+	db   *sql.DB
+	rows *sql.Rows
+	head *model.Message
+}
+
+func (ds *DataSource) newMessageIterator(ctx context.Context, startTime, endTime time.Time, selfID string, talker string, sender string, keyword string, filter *model.MessageFilter, cursor *model.MessageCursor) (*messageIterator, error) {
+	if talker == "" {
+		return nil, errors.ErrTalkerEmpty
+	}
+
+	// 解析talker参数，支持多个talker（以英文逗号分隔）
+	talkers := util.Str2List(talker, ",")
+	if len(talkers) == 0 {
+		return nil, errors.ErrTalkerEmpty
+	}
+
+	// 找到时间范围内的数据库文件
+	dbInfos := ds.getDBInfosForTimeRange(startTime, endTime)
+	if len(dbInfos) == 0 {
+		return nil, errors.TimeRangeNotFound(startTime, endTime)
+	}
+
+	it := &messageIterator{
+		ctx:     ctx,
+		selfID:  selfID,
+		senders: util.Str2List(sender, ","),
+		filter:  filter,
+		names:   ds.contactCache,
+		sources: make(sourceHeap, 0),
+	}
+
+	// 预编译正则表达式（如果有keyword）
+	var match string
+	if keyword != "" {
+		var err error
+		it.regex, err = regexp.Compile(keyword)
+		if err != nil {
+			return nil, errors.QueryFailed("invalid regex pattern", err)
+		}
+		// 普通关键词可以通过全文索引缩小扫描范围，正则表达式仍然全表扫描
+		// 关键词需要匹配 Redact 处理后的内容时，索引中的原始内容不能用于筛选
+		if !filter.Redacting() {
+			match = buildMatchQuery(keyword)
+		}
+	}
+
+	log.Debug().Msgf("talkers: %+v, senders: %+v, keyword: %+v, cursor: %+v", talkers, it.senders, keyword, cursor)
+
+	tableTalkers := make(map[string]string, len(talkers))
+	tableNames := make([]string, 0, len(talkers))
+	for _, talkerItem := range talkers {
+		_talkerMd5Bytes := md5.Sum([]byte(talkerItem))
+		tableName := "Msg_" + hex.EncodeToString(_talkerMd5Bytes[:])
+		if _, ok := tableTalkers[tableName]; ok || !filter.Allow(talkerItem) {
+			continue
+		}
+		tableTalkers[tableName] = talkerItem
+		tableNames = append(tableNames, tableName)
+	}
+
+	for _, dbInfo := range dbInfos {
+		if err := ctx.Err(); err != nil {
+			it.Close()
+			return nil, err
+		}
+
+		tables, err := ds.existingTables(ctx, dbInfo.FilePath, tableNames)
+		if err != nil {
+			log.Err(err).Msgf("数据库 %s 查询消息表失败", dbInfo.FilePath)
+			continue
+		}
+
+		for start := 0; start < len(tables); start += maxCompoundSelect {
+			chunk := tables[start:min(start+maxCompoundSelect, len(tables))]
+
+			arms := make([]string, 0, len(chunk))
+			args := make([]interface{}, 0)
+			for _, tableName := range chunk {
+				talkerItem := tableTalkers[tableName]
+				conditions := []string{"m.create_time >= ? AND m.create_time <= ?"}
+				armArgs := []interface{}{talkerItem, startTime.Unix(), endTime.Unix()}
+
+				// 游标条件：(sort_seq, talker) > (cursor.Seq, cursor.Talker)
+				if cursor != nil {
+					if talkerItem > cursor.Talker {
+						conditions = append(conditions, "m.sort_seq >= ?")
+					} else {
+						conditions = append(conditions, "m.sort_seq > ?")
+					}
+					armArgs = append(armArgs, cursor.Seq)
+				}
+
+				// 只读取索引命中的消息和尚未建立索引的新消息
+				if seqs, watermark, ok := ds.fts.Lookup(ctx, dbInfo.FilePath, tableName, match, startTime, endTime); ok {
+					cond := "m.sort_seq > ?"
+					if len(seqs) > 0 {
+						list := make([]string, len(seqs))
+						for i, seq := range seqs {
+							list[i] = strconv.FormatInt(seq, 10)
+						}
+						cond = fmt.Sprintf("(m.sort_seq > ? OR m.sort_seq IN (%s))", strings.Join(list, ","))
+					}
+					conditions = append(conditions, cond)
+					armArgs = append(armArgs, watermark)
+				}
+
+				arms = append(arms, fmt.Sprintf(messageSelect+"\n\tWHERE %s", tableName, strings.Join(conditions, " AND ")))
+				args = append(args, armArgs...)
+			}
+			query := strings.Join(arms, "\nUNION ALL") + "\nORDER BY 2 ASC, 1 ASC"
+
+			if err := it.addSource(ds, dbInfo.FilePath, query, args); err != nil {
+				log.Err(err).Msgf("从数据库 %s 查询消息失败", dbInfo.FilePath)
+				continue
+			}
+		}
+	}
+
+	return it, nil
+}
+
+// existingTables 返回消息库中实际存在的消息表，保持传入的顺序
+func (ds *DataSource) existingTables(ctx context.Context, filePath string, tableNames []string) ([]string, error) {
+	db, err := ds.dbm.OpenDB(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseDB(db)
+
+	rows, err := db.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type='table' AND name LIKE 'Msg_%'")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exists := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		exists[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	tables := make([]string, 0, len(tableNames))
+	for _, tableName := range tableNames {
+		if exists[tableName] {
+			tables = append(tables, tableName)
+		} else {
+			log.Debug().Msgf("table %s not found in %s", tableName, filePath)
+		}
+	}
+	return tables, nil
+}
+
+func (it *messageIterator) addSource(ds *DataSource, filePath, query string, args []interface{}) error {
+	// Windows 上每次 OpenDB 都是独立的单连接，每个结果流需要单独打开，避免相互阻塞
+	db, err := ds.dbm.OpenDB(filePath)
+	if err != nil {
+		return err
+	}
+	rows, err := db.QueryContext(it.ctx, query, args...)
+	if err != nil {
+		releaseDB(db)
+		return err
+	}
+
+	src := &messageSource{db: db, rows: rows}
+	if it.advance(src) {
+		heap.Push(&it.sources, src)
+	} else {
+		src.close()
+	}
+	return it.err
+}
+
+// advance 读取结果流中下一条满足过滤条件的消息
+func (it *messageIterator) advance(src *messageSource) bool {
+	src.head = nil
+	for src.rows.Next() {
+		message, err := scanMessage(src.rows, it.selfID, it.names)
+		if err != nil {
+			it.err = err
+			return false
+		}
+
+		// 应用sender过滤
+		if len(it.senders) > 0 && !slices.Contains(it.senders, message.Sender) {
+			continue
+		}
+
+		// 先处理内容再匹配关键词，关键词无法匹配到被处理掉的内容
+		it.filter.Apply(message)
+
+		// 应用keyword过滤
+		if it.regex != nil && !it.regex.MatchString(message.PlainTextContent()) {
+			continue
+		}
+
+		src.head = message
+		return true
+	}
+	if err := src.rows.Err(); err != nil {
+		it.err = err
+	}
+	return false
+}
+
+// scanMessage 读取 messageSelect 查询的一行并转换为标准格式
+func scanMessage(rows *sql.Rows, selfID string, names map[string]string) (*model.Message, error) {
+	var talker string
+	var msg model.MessageV4
+	if err := rows.Scan(
+		&talker,
+		&msg.SortSeq,
+		&msg.ServerID,
+		&msg.LocalType,
+		&msg.UserName,
+		&msg.CreateTime,
+		&msg.MessageContent,
+		&msg.PackedInfoData,
+		&msg.Status,
+	); err != nil {
+		return nil, errors.ScanRowFailed(err)
+	}
+	msg.SelfID = selfID
+	msg.SenderName = names[msg.UserName]
+	return msg.Wrap(talker), nil
+}
+
+// Next 前进到下一条消息，没有更多消息或发生错误时返回 false
+func (it *messageIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	// 上一条消息所在的结果流前进一步后重新入堆
+	if it.last != nil {
+		if it.advance(it.last) {
+			heap.Push(&it.sources, it.last)
+		} else {
+			it.last.close()
+		}
+		it.last = nil
+		if it.err != nil {
+			return false
+		}
+	}
+
+	if err := it.ctx.Err(); err != nil {
+		it.err = err
+		return false
+	}
+	if it.sources.Len() == 0 {
+		return false
+	}
+
+	it.last = heap.Pop(&it.sources).(*messageSource)
+	it.cur = it.last.head
+	return true
+}
+
+func (it *messageIterator) Message() *model.Message {
+	return it.cur
+}
+
+func (it *messageIterator) Err() error {
+	return it.err
+}
+
+func (it *messageIterator) Close() {
+	if it.last != nil {
+		it.last.close()
+		it.last = nil
+	}
+	for _, src := range it.sources {
+		src.close()
+	}
+	it.sources = it.sources[:0]
+}
+
+func (src *messageSource) close() {
+	src.rows.Close()
+	releaseDB(src.db)
+}
+
+// sourceHeap 按当前消息的 (Seq, Talker) 排序的最小堆
+type sourceHeap []*messageSource
+
+func (h sourceHeap) Len() int { return len(h) }
+func (h sourceHeap) Less(i, j int) bool {
+	a, b := h[i].head, h[j].head
+	if a.Seq != b.Seq {
+		return a.Seq < b.Seq
+	}
+	return a.Talker < b.Talker
+}
+func (h sourceHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *sourceHeap) Push(x interface{}) { *h = append(*h, x.(*messageSource)) }
+func (h *sourceHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// countMessages 使用 SQL COUNT 统计时间范围内的消息数量，不读取消息内容，senders 不为空时只统计这些发送者的消息
+func (ds *DataSource) countMessages(ctx context.Context, startTime, endTime time.Time, talker string, senders []string) (int, error) {
+	talkers := util.Str2List(talker, ",")
+	if len(talkers) == 0 {
+		return 0, errors.ErrTalkerEmpty
+	}
+
+	dbInfos := ds.getDBInfosForTimeRange(startTime, endTime)
+	if len(dbInfos) == 0 {
+		return 0, errors.TimeRangeNotFound(startTime, endTime)
+	}
+
+	tableNames := make([]string, 0, len(talkers))
+	for _, talkerItem := range talkers {
+		_talkerMd5Bytes := md5.Sum([]byte(talkerItem))
+		tableName := "Msg_" + hex.EncodeToString(_talkerMd5Bytes[:])
+		if !slices.Contains(tableNames, tableName) {
+			tableNames = append(tableNames, tableName)
+		}
+	}
+
+	total := 0
+	for _, dbInfo := range dbInfos {
+		tables, err := ds.existingTables(ctx, dbInfo.FilePath, tableNames)
+		if err != nil {
+			log.Err(err).Msgf("数据库 %s 查询消息表失败", dbInfo.FilePath)
+			continue
+		}
+		if len(tables) == 0 {
+			continue
+		}
+
+		db, err := ds.dbm.OpenDB(dbInfo.FilePath)
+		if err != nil {
+			log.Error().Msgf("数据库 %s 未打开", dbInfo.FilePath)
+			continue
+		}
+		for _, tableName := range tables {
+			var count int
+			query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE create_time >= ? AND create_time <= ?", tableName)
+			args := []interface{}{startTime.Unix(), endTime.Unix()}
+			if len(senders) > 0 {
+				query += fmt.Sprintf(" AND real_sender_id IN (SELECT rowid FROM Name2Id WHERE user_name IN (%s))", strings.TrimSuffix(strings.Repeat("?,", len(senders)), ","))
+				for _, sender := range senders {
+					args = append(args, sender)
+				}
+			}
+			if err := db.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+				releaseDB(db)
+				return 0, errors.QueryFailed(query, err)
+			}
+			total += count
+		}
+		releaseDB(db)
+	}
+
+	return total, nil
+}
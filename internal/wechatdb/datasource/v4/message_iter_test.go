@@ -0,0 +1,60 @@
+package v4
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+// testRedact 将手机号替换为 [手机号]，与 policy 的 mobile 检测器效果相同
+var testRedact = &model.MessageFilter{
+	Redact: func(m *model.Message) {
+		m.Content = regexp.MustCompile(`1[3-9]\d{9}`).ReplaceAllString(m.Content, "[手机号]")
+	},
+}
+
+func TestGetMessagesFilter(t *testing.T) {
+	ds, _ := newTestDataSource(t, false, []testMessage{
+		{talker: "wxid_a", seq: 1, content: "我的手机号是 13800001234"},
+		{talker: "wxid_a", seq: 2, content: "hello"},
+		{talker: "wxid_b", seq: 3, content: "13800001234"},
+	})
+
+	tests := []struct {
+		name    string
+		talker  string
+		keyword string
+		filter  *model.MessageFilter
+		want    []string
+	}{
+		{"no filter", "wxid_a", `1380000\d{4}`, nil, []string{"我的手机号是 13800001234"}},
+		{"redacted value", "wxid_a", `1380000\d{4}`, testRedact, nil},
+		{"redacted prefix", "wxid_a", `13800001[0-4]`, testRedact, nil},
+		{"replacement", "wxid_a", `手机号\]`, testRedact, []string{"我的手机号是 [手机号]"}},
+		{"redact without keyword", "wxid_a", "", testRedact, []string{"我的手机号是 [手机号]", "hello"}},
+		{"talker denied", "wxid_a,wxid_b", "", &model.MessageFilter{Talker: func(talker string) bool { return talker != "wxid_b" }}, []string{"我的手机号是 13800001234", "hello"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := ds.GetMessages(context.Background(), testStart, testStart.Add(time.Hour), "", tt.talker, "", tt.keyword, tt.filter, nil, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(messages))
+			for _, m := range messages {
+				got = append(got, m.Content)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,372 @@
+package v4
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+const (
+	// searchMaxHits 单次搜索最多收集的命中数量，超过后停止搜索
+	searchMaxHits = 1000
+
+	// searchMaxCandidates 单个消息库从索引中读取的候选数量上限
+	searchMaxCandidates = 5000
+
+	// searchMaxScanRows 单次搜索最多扫描的消息行数（未走索引的部分）
+	searchMaxScanRows = 200000
+
+	// searchTimeout 单次搜索的时间上限
+	searchTimeout = 15 * time.Second
+
+	// snippetBefore/snippetAfter 摘要中命中位置前后保留的字符数
+	snippetBefore = 20
+	snippetAfter  = 60
+)
+
+// SearchMessages 跨会话搜索消息
+// 关键词以空白分隔，多个词需要同时命中；拉丁单词按词首前缀匹配（不区分大小写），CJK 文本按子串匹配
+// 从最新的消息库开始、库内从最近有消息的会话开始搜索，命中数量、扫描行数或耗时达到上限后停止，此时 truncated 为 true
+// 搜索顺序固定，数据不变时多次搜索得到相同的结果，可以按游标翻页；提前结束时新消息会改变收集到的命中，结果不支持翻页
+// filter 不允许的会话不参与搜索；设置了 Redact 时匹配处理后的内容，摘要也取自处理后的内容，此时不使用全文索引
+// 返回的结果已按命中次数与时间排序，见 model.SortSearchHits
+func (ds *DataSource) SearchMessages(ctx context.Context, startTime, endTime time.Time, selfID string, keyword string, sender string, filter *model.MessageFilter) ([]*model.SearchHit, bool, error) {
+	q := parseSearchQuery(keyword)
+	if q == nil {
+		return nil, false, errors.ErrKeywordEmpty
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, searchTimeout)
+	defer cancel()
+
+	s := &searcher{
+		ds:        ds,
+		query:     q,
+		selfID:    selfID,
+		senders:   util.Str2List(sender, ","),
+		filter:    filter,
+		startTime: startTime,
+		endTime:   endTime,
+		hits:      make([]*model.SearchHit, 0),
+	}
+
+	dbInfos := ds.getDBInfosForTimeRange(startTime, endTime)
+	for i := len(dbInfos) - 1; i >= 0 && !s.full(); i-- {
+		if err := s.searchDB(ctx, dbInfos[i]); err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				s.truncated = true
+				break
+			}
+			if ctx.Err() != nil {
+				return nil, false, ctx.Err()
+			}
+			log.Err(err).Msgf("search messages in %s failed", dbInfos[i].FilePath)
+		}
+	}
+
+	model.SortSearchHits(s.hits)
+
+	return s.hits, s.truncated, nil
+}
+
+type searcher struct {
+	ds        *DataSource
+	query     *searchQuery
+	selfID    string
+	senders   []string
+	filter    *model.MessageFilter
+	startTime time.Time
+	endTime   time.Time
+
+	hits      []*model.SearchHit
+	scanned   int
+	truncated bool
+}
+
+func (s *searcher) full() bool {
+	if len(s.hits) >= searchMaxHits || s.scanned >= searchMaxScanRows {
+		s.truncated = true
+		return true
+	}
+	return false
+}
+
+func (s *searcher) searchDB(ctx context.Context, info MessageDBInfo) error {
+	db, err := s.ds.dbm.OpenDB(info.FilePath)
+	if err != nil {
+		return err
+	}
+	defer releaseDB(db)
+
+	tables, err := messageTables(ctx, db)
+	if err != nil {
+		return err
+	}
+	// 不允许的会话直接跳过，不占用候选、命中与扫描上限
+	var allowed []string
+	if s.filter != nil && s.filter.Talker != nil {
+		allowed = make([]string, 0, len(tables))
+		for table, talker := range tables {
+			if s.filter.Allow(talker) {
+				allowed = append(allowed, table)
+			} else {
+				delete(tables, table)
+			}
+		}
+	}
+
+	match := s.query.match
+	if s.filter.Redacting() {
+		match = ""
+	}
+	candidates, watermarks, indexed := s.ds.fts.Search(ctx, info.FilePath, match, allowed, s.startTime, s.endTime, searchMaxCandidates)
+	if indexed {
+		total := 0
+		for _, seqs := range candidates {
+			total += len(seqs)
+		}
+		if total >= searchMaxCandidates {
+			s.truncated = true
+		}
+	}
+
+	for _, table := range recentTables(ctx, db, tables) {
+		if s.full() {
+			return nil
+		}
+		talker := tables[table]
+
+		// 已建立索引的消息表只读取候选消息和水位线之后的新消息，否则全表扫描
+		cond := ""
+		var args []interface{}
+		if indexed {
+			if watermark, ok := watermarks[table]; ok {
+				cond = "m.sort_seq > ?"
+				args = append(args, watermark)
+				if seqs := candidates[table]; len(seqs) > 0 {
+					list := make([]string, len(seqs))
+					for i, seq := range seqs {
+						list[i] = strconv.FormatInt(seq, 10)
+					}
+					cond = fmt.Sprintf("(m.sort_seq > ? OR m.sort_seq IN (%s))", strings.Join(list, ","))
+				}
+			}
+		}
+
+		if err := s.searchTable(ctx, db, table, talker, cond, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recentTables 按最后一条消息的 sort_seq 降序返回消息表，相同时按表名排序
+// 命中数量达到上限时优先保留最近活跃的会话，且每次搜索的顺序相同
+func recentTables(ctx context.Context, db *sql.DB, tables map[string]string) []string {
+	lastSeq := make(map[string]int64, len(tables))
+	names := make([]string, 0, len(tables))
+	for table := range tables {
+		var seq sql.NullInt64
+		// sort_seq 上有索引，MAX 只需读取索引的最后一项
+		if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT MAX(sort_seq) FROM %s", table)).Scan(&seq); err != nil {
+			log.Debug().Err(err).Msgf("query last sort_seq of %s failed", table)
+		}
+		lastSeq[table] = seq.Int64
+		names = append(names, table)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if lastSeq[names[i]] != lastSeq[names[j]] {
+			return lastSeq[names[i]] > lastSeq[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+func (s *searcher) searchTable(ctx context.Context, db *sql.DB, table, talker, cond string, args []interface{}) error {
+	conditions := []string{"m.create_time >= ? AND m.create_time <= ?"}
+	args = append([]interface{}{s.startTime.Unix(), s.endTime.Unix()}, args...)
+	if cond != "" {
+		conditions = append(conditions, cond)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT m.sort_seq, m.server_id, m.local_type, IFNULL(n.user_name, ''), m.create_time, m.message_content, m.packed_info_data, m.status
+		FROM %s m
+		LEFT JOIN Name2Id n ON m.real_sender_id = n.rowid
+		WHERE %s
+		ORDER BY m.sort_seq DESC
+	`, table, strings.Join(conditions, " AND "))
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if s.full() {
+			return nil
+		}
+		s.scanned++
+
+		var msg model.MessageV4
+		if err := rows.Scan(
+			&msg.SortSeq,
+			&msg.ServerID,
+			&msg.LocalType,
+			&msg.UserName,
+			&msg.CreateTime,
+			&msg.MessageContent,
+			&msg.PackedInfoData,
+			&msg.Status,
+		); err != nil {
+			return err
+		}
+
+		msg.SelfID = s.selfID
+		msg.SenderName = s.ds.contactCache[msg.UserName]
+		message := msg.Wrap(talker)
+
+		if len(s.senders) > 0 && !slices.Contains(s.senders, message.Sender) {
+			continue
+		}
+
+		s.filter.Apply(message)
+		text := message.PlainTextContent()
+		count, pos := s.query.Match(text)
+		if count == 0 {
+			continue
+		}
+
+		s.hits = append(s.hits, &model.SearchHit{
+			Message: message,
+			Snippet: snippet(text, pos, len(s.query.first)),
+			Matches: count,
+		})
+	}
+	return rows.Err()
+}
+
+// searchQuery 全局搜索的查询条件
+type searchQuery struct {
+	match   string   // FTS MATCH 表达式，为空时无法使用索引
+	words   []string // 拉丁单词，按词首前缀匹配
+	phrases []string // CJK 片段，按子串匹配
+	first   []rune   // 第一个查询词，用于定位摘要
+}
+
+// parseSearchQuery 解析搜索关键词，关键词为空时返回 nil
+func parseSearchQuery(keyword string) *searchQuery {
+	q := &searchQuery{}
+	terms := make([]string, 0)
+	for _, field := range strings.Fields(keyword) {
+		for _, run := range splitRuns(field) {
+			text := strings.ToLower(string(run.text))
+			switch run.kind {
+			case runWord:
+				q.words = append(q.words, text)
+				terms = append(terms, text+"*")
+			case runCJK:
+				q.phrases = append(q.phrases, text)
+				for k := 0; k+1 < len(run.text); k++ {
+					terms = append(terms, string(run.text[k:k+2]))
+				}
+			default:
+				continue
+			}
+			if q.first == nil {
+				q.first = []rune(text)
+			}
+		}
+	}
+	if len(q.words) == 0 && len(q.phrases) == 0 {
+		return nil
+	}
+	q.match = strings.Join(terms, " ")
+	return q
+}
+
+// Match 判断文本是否命中全部查询词
+// 返回命中次数（未命中时为 0）及第一个查询词在文本中的 rune 偏移
+func (q *searchQuery) Match(text string) (count int, pos int) {
+	lower := strings.ToLower(text)
+
+	for _, phrase := range q.phrases {
+		n := strings.Count(lower, phrase)
+		if n == 0 {
+			return 0, 0
+		}
+		count += n
+	}
+
+	if len(q.words) > 0 {
+		runs := splitRuns(lower)
+		for _, word := range q.words {
+			n := 0
+			for _, run := range runs {
+				if run.kind == runWord && strings.HasPrefix(string(run.text), word) {
+					n++
+				}
+			}
+			if n == 0 {
+				return 0, 0
+			}
+			count += n
+		}
+	}
+
+	return count, runeIndex([]rune(lower), q.first)
+}
+
+// runeIndex 返回 sub 在 s 中第一次出现的 rune 偏移，不存在时返回 0
+func runeIndex(s, sub []rune) int {
+	for i := 0; i+len(sub) <= len(s); i++ {
+		match := true
+		for j := range sub {
+			if s[i+j] != sub[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return 0
+}
+
+// snippet 截取命中位置附近的文本作为摘要，换行等空白字符替换为空格
+func snippet(text string, pos, length int) string {
+	runes := []rune(text)
+	for i, r := range runes {
+		if unicode.IsSpace(r) {
+			runes[i] = ' '
+		}
+	}
+
+	start := max(pos-snippetBefore, 0)
+	end := min(pos+length+snippetAfter, len(runes))
+
+	buf := strings.Builder{}
+	if start > 0 {
+		buf.WriteString("…")
+	}
+	buf.WriteString(strings.TrimSpace(string(runes[start:end])))
+	if end < len(runes) {
+		buf.WriteString("…")
+	}
+	return buf.String()
+}
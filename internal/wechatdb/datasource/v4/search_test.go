@@ -0,0 +1,49 @@
+package v4
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sjzar/chatlog/internal/model"
+)
+
+func TestSearchMessagesFilter(t *testing.T) {
+	ds, _ := newTestDataSource(t, false, []testMessage{
+		{talker: "wxid_a", seq: 1, content: "我的手机号是 13800001234"},
+		{talker: "wxid_b", seq: 2, content: "手机号 13800001234"},
+	})
+
+	tests := []struct {
+		name    string
+		keyword string
+		filter  *model.MessageFilter
+		want    []string // 命中的 talker 与摘要
+	}{
+		{"no filter", "13800001234", nil, []string{"wxid_b", "手机号 13800001234", "wxid_a", "我的手机号是 13800001234"}},
+		{"redacted value", "13800001234", testRedact, nil},
+		{"redacted prefix", "1380000", testRedact, nil},
+		{"replacement", "手机号", testRedact, []string{"wxid_b", "手机号 [手机号]", "wxid_a", "我的手机号是 [手机号]"}},
+		{"talker denied", "13800001234", &model.MessageFilter{Talker: func(talker string) bool { return talker == "wxid_a" }}, []string{"wxid_a", "我的手机号是 13800001234"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits, _, err := ds.SearchMessages(context.Background(), testStart, testStart.Add(time.Hour), "", tt.keyword, "", tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(hits)*2)
+			for _, hit := range hits {
+				got = append(got, hit.Talker, hit.Snippet)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package v4
+
+import (
+	"cmp"
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sjzar/chatlog/internal/errors"
+	"github.com/sjzar/chatlog/internal/model"
+	"github.com/sjzar/chatlog/pkg/util"
+)
+
+// 消息统计的聚合查询，local_type 低 32 位为消息类型，高 32 位为子类型
+const (
+	statsTypeQuery = `
+		SELECT m.local_type, COUNT(*), MIN(m.create_time), MAX(m.create_time)
+		FROM %s m
+		WHERE m.create_time >= ? AND m.create_time <= ?
+		GROUP BY m.local_type`
+
+	statsSenderQuery = `
+		SELECT IFNULL(n.user_name, ''), COUNT(*), MIN(m.create_time), MAX(m.create_time)
+		FROM %s m
+		LEFT JOIN Name2Id n ON m.real_sender_id = n.rowid
+		WHERE m.create_time >= ? AND m.create_time <= ? AND (m.local_type & 4294967295) != 10000
+		GROUP BY n.user_name`
+
+	statsHourQuery = `
+		SELECT strftime('%%Y-%%m-%%d %%H', m.create_time, 'unixepoch', 'localtime') AS hour, COUNT(*)
+		FROM %s m
+		WHERE m.create_time >= ? AND m.create_time <= ?
+		GROUP BY hour`
+
+	// 只读取链接和文件消息，相同内容的消息在查询中合并
+	statsShareQuery = `
+		SELECT m.local_type, m.message_content, COUNT(*), MAX(m.create_time)
+		FROM %s m
+		WHERE m.create_time >= ? AND m.create_time <= ?
+			AND (m.local_type & 4294967295) = 49 AND (m.local_type >> 32) IN (4, 5, 6)
+		GROUP BY m.local_type, m.message_content`
+)
+
+// messageStats 合并多个消息库的聚合结果
+type messageStats struct {
+	talker  string
+	types   map[int64]*model.TypeStat
+	senders map[string]*model.SenderStat
+	days    map[string]int
+	hours   [24]int
+	shares  map[string]*model.ShareStat // key 为 link:URL 或 file:MD5
+
+	total       int
+	first, last int64
+}
+
+// GetMessageStats 统计 talker 会话在时间范围内的消息，通过 SQL 聚合计算，不读取消息内容（链接和文件消息除外）
+func (ds *DataSource) GetMessageStats(ctx context.Context, startTime, endTime time.Time, selfID string, talker string) (*model.MessageStats, error) {
+	if talker == "" {
+		return nil, errors.ErrTalkerEmpty
+	}
+
+	dbInfos := ds.getDBInfosForTimeRange(startTime, endTime)
+	if len(dbInfos) == 0 {
+		return nil, errors.TimeRangeNotFound(startTime, endTime)
+	}
+
+	_talkerMd5Bytes := md5.Sum([]byte(talker))
+	tableName := "Msg_" + hex.EncodeToString(_talkerMd5Bytes[:])
+
+	stats := &messageStats{
+		talker:  talker,
+		types:   make(map[int64]*model.TypeStat),
+		senders: make(map[string]*model.SenderStat),
+		days:    make(map[string]int),
+		shares:  make(map[string]*model.ShareStat),
+	}
+	for _, dbInfo := range dbInfos {
+		tables, err := ds.existingTables(ctx, dbInfo.FilePath, []string{tableName})
+		if err != nil {
+			log.Err(err).Msgf("数据库 %s 查询消息表失败", dbInfo.FilePath)
+			continue
+		}
+		if len(tables) == 0 {
+			continue
+		}
+
+		db, err := ds.dbm.OpenDB(dbInfo.FilePath)
+		if err != nil {
+			log.Error().Msgf("数据库 %s 未打开", dbInfo.FilePath)
+			continue
+		}
+		err = stats.collect(ctx, db, tableName, startTime.Unix(), endTime.Unix())
+		releaseDB(db)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return stats.result(selfID, ds.contactCache), nil
+}
+
+func (s *messageStats) collect(ctx context.Context, db *sql.DB, tableName string, start, end int64) error {
+	queries := []struct {
+		query string
+		scan  func(rows *sql.Rows) error
+	}{
+		{statsTypeQuery, s.scanType},
+		{statsSenderQuery, s.scanSender},
+		{statsHourQuery, s.scanHour},
+		{statsShareQuery, s.scanShare},
+	}
+	for _, q := range queries {
+		query := fmt.Sprintf(q.query, tableName)
+		rows, err := db.QueryContext(ctx, query, start, end)
+		if err != nil {
+			return errors.QueryFailed(query, err)
+		}
+		for rows.Next() {
+			if err := q.scan(rows); err != nil {
+				rows.Close()
+				return errors.ScanRowFailed(err)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return errors.QueryFailed(query, err)
+		}
+	}
+	return nil
+}
+
+func (s *messageStats) scanType(rows *sql.Rows) error {
+	var localType int64
+	var count int
+	var first, last int64
+	if err := rows.Scan(&localType, &count, &first, &last); err != nil {
+		return err
+	}
+
+	_type, subType := util.SplitInt64ToTwoInt32(localType)
+	if _type != model.MessageTypeShare {
+		subType = 0
+	}
+	key := subType<<32 | _type
+	stat, ok := s.types[key]
+	if !ok {
+		stat = &model.TypeStat{Type: _type, SubType: subType, Name: model.MessageTypeName(_type, subType)}
+		s.types[key] = stat
+	}
+	stat.Count += count
+
+	s.total += count
+	if s.first == 0 || first < s.first {
+		s.first = first
+	}
+	s.last = max(s.last, last)
+	return nil
+}
+
+func (s *messageStats) scanSender(rows *sql.Rows) error {
+	var sender string
+	var count int
+	var first, last int64
+	if err := rows.Scan(&sender, &count, &first, &last); err != nil {
+		return err
+	}
+
+	stat, ok := s.senders[sender]
+	if !ok {
+		stat = &model.SenderStat{Sender: sender, FirstTime: model.JSONTime(time.Unix(first, 0))}
+		s.senders[sender] = stat
+	}
+	stat.Count += count
+	if t := model.JSONTime(time.Unix(first, 0)); t.Before(stat.FirstTime) {
+		stat.FirstTime = t
+	}
+	if t := model.JSONTime(time.Unix(last, 0)); t.After(stat.LastTime) {
+		stat.LastTime = t
+	}
+	return nil
+}
+
+func (s *messageStats) scanHour(rows *sql.Rows) error {
+	var key string
+	var count int
+	if err := rows.Scan(&key, &count); err != nil {
+		return err
+	}
+
+	day, hour, ok := strings.Cut(key, " ")
+	if !ok {
+		return nil
+	}
+	s.days[day] += count
+	var h int
+	if _, err := fmt.Sscanf(hour, "%d", &h); err == nil && h >= 0 && h < 24 {
+		s.hours[h] += count
+	}
+	return nil
+}
+
+func (s *messageStats) scanShare(rows *sql.Rows) error {
+	var msg model.MessageV4
+	var count int
+	if err := rows.Scan(&msg.LocalType, &msg.MessageContent, &count, &msg.CreateTime); err != nil {
+		return err
+	}
+
+	m := msg.Wrap(s.talker)
+	title, _ := m.Contents["title"].(string)
+	var key string
+	var stat model.ShareStat
+	switch m.SubType {
+	case model.MessageSubTypeLink, model.MessageSubTypeLink2:
+		url, _ := m.Contents["url"].(string)
+		if url == "" {
+			return nil
+		}
+		key, stat = "link:"+url, model.ShareStat{Title: title, URL: url}
+	case model.MessageSubTypeFile:
+		md5, _ := m.Contents["md5"].(string)
+		key, stat = "file:"+cmp.Or(md5, title), model.ShareStat{Title: title, MD5: md5}
+		if key == "file:" {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	share, ok := s.shares[key]
+	if !ok {
+		share = &stat
+		s.shares[key] = share
+	}
+	share.Count += count
+	if t := model.JSONTime(time.Unix(msg.CreateTime, 0)); t.After(share.LastTime) {
+		share.LastTime = t
+		if title != "" {
+			share.Title = title
+		}
+	}
+	return nil
+}
+
+func (s *messageStats) result(selfID string, names map[string]string) *model.MessageStats {
+	stats := &model.MessageStats{
+		Talker:  s.talker,
+		Total:   s.total,
+		Hours:   s.hours,
+		Senders: make([]*model.SenderStat, 0, len(s.senders)),
+		Days:    make([]*model.CountStat, 0, len(s.days)),
+		Types:   make([]*model.TypeStat, 0, len(s.types)),
+		Links:   make([]*model.ShareStat, 0),
+		Files:   make([]*model.ShareStat, 0),
+	}
+	if s.total > 0 {
+		stats.FirstTime = model.JSONTime(time.Unix(s.first, 0))
+		stats.LastTime = model.JSONTime(time.Unix(s.last, 0))
+	}
+
+	for _, sender := range s.senders {
+		sender.SenderName = names[sender.Sender]
+		sender.IsSelf = selfID != "" && sender.Sender != "" && strings.Contains(selfID, sender.Sender)
+		stats.Senders = append(stats.Senders, sender)
+	}
+	slices.SortFunc(stats.Senders, func(a, b *model.SenderStat) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Sender, b.Sender))
+	})
+
+	for day, count := range s.days {
+		stats.Days = append(stats.Days, &model.CountStat{Key: day, Count: count})
+	}
+	slices.SortFunc(stats.Days, func(a, b *model.CountStat) int {
+		return cmp.Compare(a.Key, b.Key)
+	})
+
+	for _, t := range s.types {
+		stats.Types = append(stats.Types, t)
+	}
+	slices.SortFunc(stats.Types, func(a, b *model.TypeStat) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Type, b.Type), cmp.Compare(a.SubType, b.SubType))
+	})
+
+	for key, share := range s.shares {
+		if strings.HasPrefix(key, "link:") {
+			stats.Links = append(stats.Links, share)
+		} else {
+			stats.Files = append(stats.Files, share)
+		}
+	}
+	byCount := func(a, b *model.ShareStat) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), b.LastTime.Time().Compare(a.LastTime.Time()))
+	}
+	slices.SortFunc(stats.Links, byCount)
+	slices.SortFunc(stats.Files, byCount)
+
+	return stats
+}
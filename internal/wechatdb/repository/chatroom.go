@@ -145,6 +145,18 @@ func (r *Repository) GetChatRoom(ctx context.Context, key string) (*model.ChatRo
 	return chatRoom, nil
 }
 
+// ListChatRooms 直接从数据库读取全部群聊，不使用缓存
+func (r *Repository) ListChatRooms(ctx context.Context) ([]*model.ChatRoom, error) {
+	chatRooms, err := r.ds.GetChatRooms(ctx, "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, chatRoom := range chatRooms {
+		r.enrichChatRoom(chatRoom)
+	}
+	return chatRooms, nil
+}
+
 // enrichChatRoom 从联系人信息中补充群聊信息
 func (r *Repository) enrichChatRoom(chatRoom *model.ChatRoom) {
 	if contact, ok := r.contactCache[chatRoom.Name]; ok {
@@ -120,6 +120,20 @@ func (r *Repository) GetContact(ctx context.Context, key string) (*model.Contact
 	return contact, nil
 }
 
+// ListContacts 直接从数据库读取全部联系人（包括已删除的联系人），不使用缓存
+func (r *Repository) ListContacts(ctx context.Context) ([]*model.Contact, error) {
+	return r.ds.GetContacts(ctx, "", 0, 0)
+}
+
+// GetContactByUserName 按微信 ID 精确查找联系人，包括群聊成员
+func (r *Repository) GetContactByUserName(ctx context.Context, userName string) (*model.Contact, error) {
+	contact := r.getFullContact(userName)
+	if contact == nil {
+		return nil, errors.ContactNotFound(userName)
+	}
+	return contact, nil
+}
+
 func (r *Repository) GetContacts(ctx context.Context, key string, limit, offset int) (int, []*model.Contact, error) {
 	ret := make([]*model.Contact, 0)
 	if key != "" {
@@ -1,10 +1,13 @@
 package repository
 
 import (
+	"cmp"
 	"context"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/sjzar/chatlog/internal/errors"
 	"github.com/sjzar/chatlog/internal/model"
 	"github.com/sjzar/chatlog/pkg/util"
 
@@ -12,17 +15,29 @@ import (
 )
 
 // GetMessages 实现 Repository 接口的 GetMessages 方法
-func (r *Repository) GetMessages(ctx context.Context, startTime, endTime time.Time, talker string, sender string, keyword string, limit, offset int) (int, []*model.Message, error) {
+// cursor 为上一页返回的游标，返回值中的 nextCursor 在还有下一页时不为空
+// 按关键词分页查询时不统计总数，total 为 nil，调用方通过 nextCursor 判断是否还有下一页
+// filter 为 nil 时不做额外过滤，见 model.MessageFilter
+func (r *Repository) GetMessages(ctx context.Context, startTime, endTime time.Time, talker string, sender string, keyword string, filter *model.MessageFilter, cursor string, limit, offset int) (*int, []*model.Message, string, error) {
 
-	talker, sender = r.parseTalkerAndSender(ctx, talker, sender)
-	total, err := r.ds.GetMessagesCount(ctx, startTime, endTime, r.SelfID, talker, sender, keyword)
+	c, err := model.ParseMessageCursor(cursor)
 	if err != nil {
-		log.Debug().Msgf("GetMessagesCount failed: %v", err)
+		return nil, nil, "", errors.InvalidArg("cursor")
+	}
+
+	talker, sender = r.parseTalkerAndSender(ctx, talker, sender)
+	var total *int
+	if keyword == "" {
+		count, err := r.ds.GetMessagesCount(ctx, startTime, endTime, r.SelfID, talker, sender)
+		if err != nil {
+			log.Debug().Msgf("GetMessagesCount failed: %v", err)
+		}
+		total = &count
 	}
 
-	messages, err := r.ds.GetMessages(ctx, startTime, endTime, r.SelfID, talker, sender, keyword, limit, offset)
+	messages, err := r.ds.GetMessages(ctx, startTime, endTime, r.SelfID, talker, sender, keyword, filter, c, limit, offset)
 	if err != nil {
-		return 0, nil, err
+		return nil, nil, "", err
 	}
 
 	// 补充消息信息
@@ -30,7 +45,182 @@ func (r *Repository) GetMessages(ctx context.Context, startTime, endTime time.Ti
 		log.Debug().Msgf("EnrichMessages failed: %v", err)
 	}
 
-	return total, messages, nil
+	// 未分页时返回的就是全部消息
+	if total == nil && limit <= 0 && offset <= 0 && c == nil {
+		count := len(messages)
+		total = &count
+	}
+
+	nextCursor := ""
+	if limit > 0 && len(messages) == limit {
+		nextCursor = model.CursorOf(messages[len(messages)-1]).Encode()
+	}
+
+	return total, messages, nextCursor, nil
+}
+
+// StreamMessages 按顺序逐条回调消息（已补充消息信息），返回回调的消息数量和下一页游标
+func (r *Repository) StreamMessages(ctx context.Context, startTime, endTime time.Time, talker string, sender string, keyword string, filter *model.MessageFilter, cursor string, limit, offset int, fn func(*model.Message) error) (int, string, error) {
+
+	c, err := model.ParseMessageCursor(cursor)
+	if err != nil {
+		return 0, "", errors.InvalidArg("cursor")
+	}
+
+	talker, sender = r.parseTalkerAndSender(ctx, talker, sender)
+
+	count := 0
+	var last *model.Message
+	err = r.ds.StreamMessages(ctx, startTime, endTime, r.SelfID, talker, sender, keyword, filter, c, limit, offset, func(msg *model.Message) error {
+		r.enrichMessage(msg)
+		if err := fn(msg); err != nil {
+			return err
+		}
+		count++
+		last = msg
+		return nil
+	})
+	if err != nil {
+		return count, "", err
+	}
+
+	nextCursor := ""
+	if limit > 0 && count == limit {
+		nextCursor = model.CursorOf(last).Encode()
+	}
+
+	return count, nextCursor, nil
+}
+
+// GetMessagesCount 统计符合条件的消息数量，不支持按关键词过滤
+func (r *Repository) GetMessagesCount(ctx context.Context, startTime, endTime time.Time, talker string, sender string) (int, error) {
+	talker, sender = r.parseTalkerAndSender(ctx, talker, sender)
+	return r.ds.GetMessagesCount(ctx, startTime, endTime, r.SelfID, talker, sender)
+}
+
+// GetMessageStats 统计单个会话在时间范围内的消息，链接和文件只保留分享次数最多的 top 个
+func (r *Repository) GetMessageStats(ctx context.Context, startTime, endTime time.Time, talker string, top int) (*model.MessageStats, error) {
+	talker, _ = r.parseTalkerAndSender(ctx, talker, "")
+	if talker == "" || strings.Contains(talker, ",") {
+		return nil, errors.InvalidArg("talker")
+	}
+
+	stats, err := r.ds.GetMessageStats(ctx, startTime, endTime, r.SelfID, talker)
+	if err != nil {
+		return nil, err
+	}
+
+	chatRoom := r.chatRoomCache[talker]
+	if chatRoom != nil {
+		stats.TalkerName = chatRoom.DisplayName()
+	} else if contact := r.getFullContact(talker); contact != nil {
+		stats.TalkerName = contact.DisplayName()
+	}
+
+	// 与 enrichMessage 相同的顺序补充发送人名称
+	for _, sender := range stats.Senders {
+		if chatRoom != nil {
+			if displayName, ok := chatRoom.User2DisplayName[sender.Sender]; ok {
+				sender.SenderName = displayName
+			}
+		}
+		if sender.IsSelf && sender.SenderName == "" {
+			sender.SenderName = cmp.Or(r.SelfName, "我")
+		}
+		if sender.SenderName == "" && !sender.IsSelf {
+			if contact := r.getFullContact(sender.Sender); contact != nil {
+				sender.SenderName = contact.DisplayName()
+			}
+		}
+	}
+
+	if top > 0 {
+		stats.Links = stats.Links[:min(top, len(stats.Links))]
+		stats.Files = stats.Files[:min(top, len(stats.Files))]
+	}
+	return stats, nil
+}
+
+// GetMessageContext 获取单个会话中每个 seq 前后的消息，上下文重叠的 seq 合并为一个窗口
+// 找不到的 seq 在 missing 中返回
+func (r *Repository) GetMessageContext(ctx context.Context, talker string, seqs []int64, before, after int) ([]*model.MessageWindow, []int64, error) {
+	talker, _ = r.parseTalkerAndSender(ctx, talker, "")
+	if talker == "" || strings.Contains(talker, ",") {
+		return nil, nil, errors.InvalidArg("talker")
+	}
+
+	seqs = slices.Clone(seqs)
+	slices.Sort(seqs)
+	seqs = slices.Compact(seqs)
+
+	windows := make([]*model.MessageWindow, 0, len(seqs))
+	missing := make([]int64, 0)
+	for _, seq := range seqs {
+		messages, err := r.ds.GetMessageContext(ctx, r.SelfID, talker, seq, before, after)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !slices.ContainsFunc(messages, func(m *model.Message) bool { return m.Seq == seq }) {
+			missing = append(missing, seq)
+			continue
+		}
+
+		// seq 升序处理，只需要与上一个窗口比较
+		if n := len(windows); n > 0 {
+			last := windows[n-1]
+			if last.Messages[len(last.Messages)-1].Seq >= messages[0].Seq {
+				last.Seqs = append(last.Seqs, seq)
+				for _, m := range messages {
+					if m.Seq > last.Messages[len(last.Messages)-1].Seq {
+						last.Messages = append(last.Messages, m)
+					}
+				}
+				continue
+			}
+		}
+		windows = append(windows, &model.MessageWindow{
+			Talker:   talker,
+			Seqs:     []int64{seq},
+			Messages: messages,
+		})
+	}
+
+	for _, window := range windows {
+		if err := r.EnrichMessages(ctx, window.Messages); err != nil {
+			log.Debug().Msgf("EnrichMessages failed: %v", err)
+		}
+	}
+	return windows, missing, nil
+}
+
+// SearchMessages 跨会话搜索消息，返回命中总数、是否达到搜索上限、当前页的结果以及下一页游标
+// cursor 为上一页返回的游标，命中结果的顺序固定，按游标翻页不会重复或遗漏；truncated 为 true 时不返回下一页游标
+func (r *Repository) SearchMessages(ctx context.Context, startTime, endTime time.Time, keyword string, sender string, filter *model.MessageFilter, cursor string, limit, offset int) (int, bool, []*model.SearchHit, string, error) {
+
+	c, err := model.ParseSearchCursor(cursor)
+	if err != nil {
+		return 0, false, nil, "", errors.InvalidArg("cursor")
+	}
+
+	_, sender = r.parseTalkerAndSender(ctx, "", sender)
+	hits, truncated, err := r.ds.SearchMessages(ctx, startTime, endTime, r.SelfID, keyword, sender, filter)
+	if err != nil {
+		return 0, false, nil, "", err
+	}
+
+	page, nextCursor := model.PageSearchHits(hits, c, limit, offset)
+
+	// 搜索提前结束时，再次搜索收集到的命中会随新消息变化，按游标翻页可能重复或遗漏，不提供下一页
+	if truncated {
+		nextCursor = ""
+	}
+
+	// 仅补充当前页的消息信息
+	for _, hit := range page {
+		r.enrichMessage(hit.Message)
+	}
+
+	return len(hits), truncated, page, nextCursor, nil
 }
 
 // EnrichMessages 补充消息的额外信息
@@ -100,20 +290,26 @@ func (r *Repository) enrichMessage(msg *model.Message) {
 	}
 }
 
+// ResolveTalkers 将逗号分隔的会话（ID、昵称或备注名）转换为会话 ID，无法识别的保持不变
+func (r *Repository) ResolveTalkers(ctx context.Context, talker string) []string {
+	talkers := util.Str2List(talker, ",")
+	for i := 0; i < len(talkers); i++ {
+		if contact, _ := r.GetContact(ctx, talkers[i]); contact != nil {
+			talkers[i] = contact.UserName
+		} else if chatRoom, _ := r.GetChatRoom(ctx, talkers[i]); chatRoom != nil {
+			talkers[i] = chatRoom.Name
+		}
+	}
+	return talkers
+}
+
 func (r *Repository) parseTalkerAndSender(ctx context.Context, talker, sender string) (string, string) {
 	originalTalker, originalSender := talker, sender
 	displayName2User := make(map[string]string)
 	users := make(map[string]bool)
 
-	talkers := util.Str2List(talker, ",")
+	talkers := r.ResolveTalkers(ctx, talker)
 	if len(talkers) > 0 {
-		for i := 0; i < len(talkers); i++ {
-			if contact, _ := r.GetContact(ctx, talkers[i]); contact != nil {
-				talkers[i] = contact.UserName
-			} else if chatRoom, _ := r.GetChatRoom(ctx, talkers[i]); chatRoom != nil {
-				talkers[i] = chatRoom.Name
-			}
-		}
 		// 获取群聊的用户列表
 		for i := 0; i < len(talkers); i++ {
 			if chatRoom, _ := r.GetChatRoom(ctx, talkers[i]); chatRoom != nil {
@@ -19,16 +19,23 @@ var ErrDBUnavailable = errors.New("wechatdb unavailable")
 type DB struct {
 	path     string
 	platform string
+	opts     datasource.Options
 	SelfID   string
 	ds       datasource.DataSource
 	repo     *repository.Repository
 }
 
 func New(path string, platform string) (*DB, error) {
+	return NewWithOptions(path, platform, datasource.Options{})
+}
+
+// NewWithOptions 使用指定的数据源选项打开数据库，如一次性读取时不建立全文索引
+func NewWithOptions(path string, platform string, opts datasource.Options) (*DB, error) {
 
 	w := &DB{
 		path:     path,
 		platform: platform,
+		opts:     opts,
 	}
 
 	// 初始化，加载数据库文件信息
@@ -60,7 +67,7 @@ func (w *DB) UnlockDB(path string) error {
 
 func (w *DB) Initialize() error {
 	var err error
-	w.ds, err = datasource.New(w.path, w.platform)
+	w.ds, err = datasource.New(w.path, w.platform, w.opts)
 	if err != nil {
 		return err
 	}
@@ -75,23 +82,114 @@ func (w *DB) Initialize() error {
 	return nil
 }
 
+// GetMessagesResp 消息查询结果，按关键词分页查询时不统计总数，Total 为 nil（JSON 中省略 total）
 type GetMessagesResp struct {
-	Total int              `json:"total"`
-	Items []*model.Message `json:"items"`
+	Total      *int             `json:"total,omitempty"`
+	Items      []*model.Message `json:"items"`
+	NextCursor string           `json:"nextCursor,omitempty"`
 }
 
-func (w *DB) GetMessages(start, end time.Time, talker string, sender string, keyword string, limit, offset int) (*GetMessagesResp, error) {
+func (w *DB) GetMessages(start, end time.Time, talker string, sender string, keyword string, cursor string, limit, offset int) (*GetMessagesResp, error) {
+	return w.GetMessagesWithFilter(start, end, talker, sender, keyword, nil, cursor, limit, offset)
+}
+
+// GetMessagesWithFilter 与 GetMessages 相同，读取时附加 filter，见 model.MessageFilter
+func (w *DB) GetMessagesWithFilter(start, end time.Time, talker string, sender string, keyword string, filter *model.MessageFilter, cursor string, limit, offset int) (*GetMessagesResp, error) {
 	ctx := context.Background()
 
 	// 使用 repository 获取消息
-	total, messages, err := w.repo.GetMessages(ctx, start, end, talker, sender, keyword, limit, offset)
+	total, messages, nextCursor, err := w.repo.GetMessages(ctx, start, end, talker, sender, keyword, filter, cursor, limit, offset)
 	if err != nil {
 		return nil, err
 	}
 
 	return &GetMessagesResp{
-		Total: total,
-		Items: messages,
+		Total:      total,
+		Items:      messages,
+		NextCursor: nextCursor,
+	}, nil
+}
+
+type StreamMessagesResp struct {
+	Count      int    `json:"count"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// StreamMessages 逐条回调消息，ctx 取消或 fn 返回错误时停止
+func (w *DB) StreamMessages(ctx context.Context, start, end time.Time, talker string, sender string, keyword string, cursor string, limit, offset int, fn func(*model.Message) error) (*StreamMessagesResp, error) {
+	return w.StreamMessagesWithFilter(ctx, start, end, talker, sender, keyword, nil, cursor, limit, offset, fn)
+}
+
+// StreamMessagesWithFilter 与 StreamMessages 相同，读取时附加 filter，见 model.MessageFilter
+func (w *DB) StreamMessagesWithFilter(ctx context.Context, start, end time.Time, talker string, sender string, keyword string, filter *model.MessageFilter, cursor string, limit, offset int, fn func(*model.Message) error) (*StreamMessagesResp, error) {
+	count, nextCursor, err := w.repo.StreamMessages(ctx, start, end, talker, sender, keyword, filter, cursor, limit, offset, fn)
+	resp := &StreamMessagesResp{
+		Count:      count,
+		NextCursor: nextCursor,
+	}
+	return resp, err
+}
+
+// GetMessagesCount 统计符合条件的消息数量，不支持按关键词过滤
+func (w *DB) GetMessagesCount(ctx context.Context, start, end time.Time, talker string, sender string) (int, error) {
+	return w.repo.GetMessagesCount(ctx, start, end, talker, sender)
+}
+
+// GetMessageStats 统计单个会话在时间范围内的消息
+func (w *DB) GetMessageStats(start, end time.Time, talker string, top int) (*model.MessageStats, error) {
+	return w.repo.GetMessageStats(context.Background(), start, end, talker, top)
+}
+
+// ResolveTalkers 将逗号分隔的会话（ID、昵称或备注名）转换为会话 ID
+func (w *DB) ResolveTalkers(talker string) []string {
+	return w.repo.ResolveTalkers(context.Background(), talker)
+}
+
+type GetMessageContextResp struct {
+	Items   []*model.MessageWindow `json:"items"`
+	Missing []int64                `json:"missing,omitempty"`
+}
+
+// GetMessageContext 获取单个会话中每个 seq 前后 before/after 条消息，上下文重叠时合并
+func (w *DB) GetMessageContext(talker string, seqs []int64, before, after int) (*GetMessageContextResp, error) {
+	ctx := context.Background()
+
+	windows, missing, err := w.repo.GetMessageContext(ctx, talker, seqs, before, after)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GetMessageContextResp{
+		Items:   windows,
+		Missing: missing,
+	}, nil
+}
+
+type SearchMessagesResp struct {
+	Total      int                `json:"total"`
+	Truncated  bool               `json:"truncated"`
+	Items      []*model.SearchHit `json:"items"`
+	NextCursor string             `json:"nextCursor,omitempty"`
+}
+
+func (w *DB) SearchMessages(start, end time.Time, keyword string, sender string, cursor string, limit, offset int) (*SearchMessagesResp, error) {
+	return w.SearchMessagesWithFilter(start, end, keyword, sender, nil, cursor, limit, offset)
+}
+
+// SearchMessagesWithFilter 与 SearchMessages 相同，搜索时附加 filter，见 model.MessageFilter
+func (w *DB) SearchMessagesWithFilter(start, end time.Time, keyword string, sender string, filter *model.MessageFilter, cursor string, limit, offset int) (*SearchMessagesResp, error) {
+	ctx := context.Background()
+
+	total, truncated, hits, nextCursor, err := w.repo.SearchMessages(ctx, start, end, keyword, sender, filter, cursor, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SearchMessagesResp{
+		Total:      total,
+		Truncated:  truncated,
+		Items:      hits,
+		NextCursor: nextCursor,
 	}, nil
 }
 
@@ -114,6 +212,16 @@ func (w *DB) GetContacts(key string, isInChatRoom, limit, offset int) (*GetConta
 	}, nil
 }
 
+// GetContact 按微信 ID 精确查找联系人，包括群聊成员
+func (w *DB) GetContact(userName string) (*model.Contact, error) {
+	return w.repo.GetContactByUserName(context.Background(), userName)
+}
+
+// GetAllContacts 直接从数据库读取全部联系人（包括已删除的联系人），用于对比联系人变化
+func (w *DB) GetAllContacts() ([]*model.Contact, error) {
+	return w.repo.ListContacts(context.Background())
+}
+
 type GetChatRoomsResp struct {
 	Total int               `json:"total"`
 	Items []*model.ChatRoom `json:"items"`
@@ -133,6 +241,11 @@ func (w *DB) GetChatRooms(key string, limit, offset int) (*GetChatRoomsResp, err
 	}, nil
 }
 
+// GetAllChatRooms 直接从数据库读取全部群聊，用于对比群成员变化
+func (w *DB) GetAllChatRooms() ([]*model.ChatRoom, error) {
+	return w.repo.ListChatRooms(context.Background())
+}
+
 type GetSessionsResp struct {
 	Total int              `json:"total"`
 	Items []*model.Session `json:"items"`
@@ -23,6 +23,9 @@ const (
 var (
 	FFmpegMode = false
 	FFMpegPath = "ffmpeg"
+
+	// NoTempFiles 转换时不写入临时文件（工作目录加密时开启），动图不再经 ffmpeg 转换为 GIF，直接封装为 MP4
+	NoTempFiles = false
 )
 
 func init() {
@@ -58,7 +61,7 @@ func Wxam2pic(data []byte) ([]byte, string, error) {
 				animeFrames = append(animeFrames, data[partition.Offset:partition.Offset+partition.Size])
 			}
 		}
-		if FFmpegMode {
+		if FFmpegMode && !NoTempFiles {
 			mp4Data, err := ConvertAnime2GIF(animeFrames, maskFrames)
 			if err != nil {
 				return nil, "", err
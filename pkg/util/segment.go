@@ -0,0 +1,97 @@
+package util
+
+import (
+	"strings"
+	"unicode"
+)
+
+// TokenKind 词元类型
+type TokenKind int
+
+const (
+	// TokenWord 拉丁字母/数字组成的单词
+	TokenWord TokenKind = iota
+
+	// TokenBigram 连续 CJK 字符切分出的二元组
+	TokenBigram
+
+	// TokenUnigram 孤立的单个 CJK 字符
+	TokenUnigram
+)
+
+type Token struct {
+	Text string
+	Kind TokenKind
+}
+
+// Segment 将文本切分为全文索引使用的词元
+// 拉丁字母与数字按连续片段切分并转为小写；
+// 连续的 CJK 字符按重叠二元组切分（"聊天记录" => "聊天" "天记" "记录"），长度为 1 时保留为一元组；
+// 其余字符（空白、标点、表情等）视为分隔符
+func Segment(s string) []Token {
+	tokens := make([]Token, 0)
+
+	var word []rune
+	var cjk []rune
+
+	flushWord := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, Token{Text: strings.ToLower(string(word)), Kind: TokenWord})
+			word = word[:0]
+		}
+	}
+	flushCJK := func() {
+		switch len(cjk) {
+		case 0:
+		case 1:
+			tokens = append(tokens, Token{Text: string(cjk), Kind: TokenUnigram})
+		default:
+			for i := 0; i+1 < len(cjk); i++ {
+				tokens = append(tokens, Token{Text: string(cjk[i : i+2]), Kind: TokenBigram})
+			}
+		}
+		cjk = cjk[:0]
+	}
+
+	for _, r := range s {
+		switch {
+		case IsCJK(r):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushCJK()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+
+	return tokens
+}
+
+// SegmentString 返回以空格连接的词元，用于写入全文索引
+func SegmentString(s string) string {
+	tokens := Segment(s)
+	if len(tokens) == 0 {
+		return ""
+	}
+	buf := strings.Builder{}
+	for i, token := range tokens {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(token.Text)
+	}
+	return buf.String()
+}
+
+// IsCJK 判断字符是否为中日韩文字
+func IsCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) ||
+		unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) ||
+		unicode.Is(unicode.Hangul, r)
+}
@@ -0,0 +1,78 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSegment(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []Token
+	}{
+		{
+			name:  "empty string",
+			input: "",
+			want:  []Token{},
+		},
+		{
+			name:  "latin words",
+			input: "Invoice #4471, please",
+			want: []Token{
+				{Text: "invoice", Kind: TokenWord},
+				{Text: "4471", Kind: TokenWord},
+				{Text: "please", Kind: TokenWord},
+			},
+		},
+		{
+			name:  "cjk bigrams",
+			input: "聊天记录",
+			want: []Token{
+				{Text: "聊天", Kind: TokenBigram},
+				{Text: "天记", Kind: TokenBigram},
+				{Text: "记录", Kind: TokenBigram},
+			},
+		},
+		{
+			name:  "single cjk char",
+			input: "好!",
+			want: []Token{
+				{Text: "好", Kind: TokenUnigram},
+			},
+		},
+		{
+			name:  "mixed",
+			input: "开发票4471号",
+			want: []Token{
+				{Text: "开发", Kind: TokenBigram},
+				{Text: "发票", Kind: TokenBigram},
+				{Text: "4471", Kind: TokenWord},
+				{Text: "号", Kind: TokenUnigram},
+			},
+		},
+		{
+			name:  "punctuation splits cjk runs",
+			input: "你好，世界",
+			want: []Token{
+				{Text: "你好", Kind: TokenBigram},
+				{Text: "世界", Kind: TokenBigram},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Segment(tt.input)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Segment(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSegmentString(t *testing.T) {
+	if got := SegmentString("Hello 世界!"); got != "hello 世界" {
+		t.Errorf("SegmentString() = %q, want %q", got, "hello 世界")
+	}
+}
@@ -0,0 +1,24 @@
+package util
+
+// Filter 返回 items 中 keep 为 true 的元素
+func Filter[T any](items []T, keep func(T) bool) []T {
+	ret := make([]T, 0, len(items))
+	for _, item := range items {
+		if keep(item) {
+			ret = append(ret, item)
+		}
+	}
+	return ret
+}
+
+// Page 按 limit/offset 截取 items，limit 为 0 时不限制数量
+func Page[T any](items []T, limit, offset int) []T {
+	if offset >= len(items) {
+		return items[:0]
+	}
+	items = items[max(offset, 0):]
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}